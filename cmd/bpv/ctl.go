@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hoppxi/bpv/internal/daemon"
+	"github.com/hoppxi/bpv/internal/playback"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes for bpv ctl, so scripts can tell a refused command from a
+// missing daemon.
+const (
+	exitOK       = 0
+	exitFailed   = 1 // the daemon rejected the command
+	exitUsage    = 2 // bad arguments or flags
+	exitNoDaemon = 3 // bpvd is not running or did not answer
+)
+
+const ctlTimeout = 5 * time.Second
+
+// exitError carries the exit code main should use for err.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+func usageError(format string, args ...any) error {
+	return &exitError{code: exitUsage, err: fmt.Errorf(format, args...)}
+}
+
+// exitCode maps an error returned by Execute to a process exit code.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return exitFailed
+}
+
+// usageArgs wraps a cobra argument validator so its errors exit with
+// exitUsage.
+func usageArgs(fn cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := fn(cmd, args); err != nil {
+			return &exitError{code: exitUsage, err: err}
+		}
+		return nil
+	}
+}
+
+// withClient connects to bpvd, runs fn with a deadline, and classifies the
+// resulting error for the exit code.
+func withClient(fn func(ctx context.Context, c *daemon.Client) error) error {
+	c, err := daemon.Connect()
+	if err != nil {
+		return &exitError{code: exitNoDaemon, err: fmt.Errorf("cannot reach bpvd: %w", err)}
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), ctlTimeout)
+	defer cancel()
+
+	err = fn(ctx, c)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return &exitError{code: exitNoDaemon, err: fmt.Errorf("bpvd did not answer within %s", ctlTimeout)}
+	case daemon.IsConnError(err):
+		return &exitError{code: exitNoDaemon, err: fmt.Errorf("lost connection to bpvd: %w", err)}
+	}
+	return err
+}
+
+// playerCmd builds a subcommand that sends one playback action.
+func playerCmd(use, short string, args cobra.PositionalArgs, call func(ctx context.Context, c *daemon.Client, args []string) (*playback.Status, error)) *cobra.Command {
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  usageArgs(args),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withClient(func(ctx context.Context, c *daemon.Client) error {
+				_, err := call(ctx, c, args)
+				return err
+			})
+		},
+	}
+}
+
+var ctlCmd = &cobra.Command{
+	Use:   "ctl",
+	Short: "Control playback in the running daemon",
+	Long: `Control the player running inside bpvd, for key bindings and scripts.
+
+  bpv ctl toggle           Play or pause
+  bpv ctl seek 1:30        Seek to 1:30 (+10 / -10 skips relative)
+  bpv ctl vol +5           Change volume (or set it: vol 70)
+  bpv ctl status --json    Print player status
+  bpv ctl queue add DIR    Append a file or folder to the queue
+  bpv ctl fav toggle       Favorite or unfavorite the current track
+
+Exit codes: 0 success, 1 command failed, 2 bad usage, 3 daemon unreachable.`,
+}
+
+var ctlStatusJSON bool
+
+func init() {
+	ctlCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return &exitError{code: exitUsage, err: err}
+	})
+
+	noArgs := cobra.NoArgs
+	ctlCmd.AddCommand(
+		playerCmd("play", "Start or resume playback", noArgs,
+			func(ctx context.Context, c *daemon.Client, _ []string) (*playback.Status, error) {
+				return c.Play(ctx, nil)
+			}),
+		playerCmd("pause", "Pause playback", noArgs,
+			func(ctx context.Context, c *daemon.Client, _ []string) (*playback.Status, error) { return c.Pause(ctx) }),
+		playerCmd("toggle", "Toggle between playing and paused", noArgs,
+			func(ctx context.Context, c *daemon.Client, _ []string) (*playback.Status, error) {
+				return c.TogglePause(ctx)
+			}),
+		playerCmd("stop", "Stop playback", noArgs,
+			func(ctx context.Context, c *daemon.Client, _ []string) (*playback.Status, error) { return c.Stop(ctx) }),
+		playerCmd("next", "Skip to the next track", noArgs,
+			func(ctx context.Context, c *daemon.Client, _ []string) (*playback.Status, error) { return c.Next(ctx) }),
+		playerCmd("prev", "Go back to the previous track", noArgs,
+			func(ctx context.Context, c *daemon.Client, _ []string) (*playback.Status, error) {
+				return c.Previous(ctx)
+			}),
+		playerCmd("mute", "Toggle mute", noArgs,
+			func(ctx context.Context, c *daemon.Client, _ []string) (*playback.Status, error) {
+				return c.ToggleMute(ctx)
+			}),
+		playerCmd("shuffle [on|off]", "Set or toggle shuffle", cobra.MaximumNArgs(1),
+			func(ctx context.Context, c *daemon.Client, args []string) (*playback.Status, error) {
+				return c.SetShuffle(ctx, optionalArg(args))
+			}),
+		playerCmd("repeat [off|all|one]", "Set or cycle the repeat mode", cobra.MaximumNArgs(1),
+			func(ctx context.Context, c *daemon.Client, args []string) (*playback.Status, error) {
+				return c.SetRepeat(ctx, optionalArg(args))
+			}),
+		ctlSeekCmd,
+		ctlVolCmd,
+		ctlStatusCmd,
+		ctlQueueCmd,
+		ctlFavCmd,
+	)
+
+	ctlStatusCmd.Flags().BoolVar(&ctlStatusJSON, "json", false, "print the status as JSON")
+
+	ctlQueueCmd.AddCommand(ctlQueueAddCmd, ctlQueueClearCmd, ctlQueueListCmd)
+	ctlFavCmd.AddCommand(ctlFavToggleCmd)
+
+	// Errors from a ctl command are about the daemon, not the command line,
+	// so the usage text would only bury the message.
+	var silence func(*cobra.Command)
+	silence = func(cmd *cobra.Command) {
+		cmd.SilenceUsage = true
+		for _, sub := range cmd.Commands() {
+			silence(sub)
+		}
+	}
+	silence(ctlCmd)
+
+	rootCmd.AddCommand(ctlCmd)
+}
+
+func optionalArg(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
+
+// ─── Seek & Volume ──────────────────────────────────────────────────────────
+
+// Seek and volume take values such as -10 that would otherwise be parsed as
+// flags, so they parse their own arguments.
+func wantsHelp(cmd *cobra.Command, args []string) bool {
+	if len(args) == 1 && (args[0] == "-h" || args[0] == "--help") {
+		cmd.Help()
+		return true
+	}
+	return false
+}
+
+var ctlSeekCmd = &cobra.Command{
+	Use:                "seek POSITION",
+	Short:              "Seek to [h:]m:ss or seconds; prefix + or - to seek relatively",
+	Args:               usageArgs(cobra.ExactArgs(1)),
+	DisableFlagParsing: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if wantsHelp(cmd, args) {
+			return nil
+		}
+		value, err := parseSeek(args[0])
+		if err != nil {
+			return usageError("%v", err)
+		}
+		return withClient(func(ctx context.Context, c *daemon.Client) error {
+			_, err := c.Seek(ctx, value)
+			return err
+		})
+	},
+}
+
+// parseSeek converts "1:30", "90", "+10" or "-0:05" to the seconds value the
+// daemon's seek action takes, keeping the sign for relative seeks.
+func parseSeek(s string) (string, error) {
+	sign := ""
+	if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		sign, s = s[:1], s[1:]
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return "", fmt.Errorf("invalid position %q", s)
+	}
+	var secs float64
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 || (i > 0 && n >= 60) {
+			return "", fmt.Errorf("invalid position %q", s)
+		}
+		secs = secs*60 + n
+	}
+	return sign + strconv.FormatFloat(secs, 'f', -1, 64), nil
+}
+
+var ctlVolCmd = &cobra.Command{
+	Use:                "vol LEVEL",
+	Short:              "Set the volume (0-100) or change it with +N / -N",
+	Args:               usageArgs(cobra.ExactArgs(1)),
+	DisableFlagParsing: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if wantsHelp(cmd, args) {
+			return nil
+		}
+		if _, err := strconv.Atoi(strings.TrimPrefix(args[0], "+")); err != nil {
+			return usageError("invalid volume %q", args[0])
+		}
+		return withClient(func(ctx context.Context, c *daemon.Client) error {
+			_, err := c.SetVolume(ctx, args[0])
+			return err
+		})
+	},
+}
+
+// ─── Status ─────────────────────────────────────────────────────────────────
+
+var ctlStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Print the player status",
+	Args:  usageArgs(cobra.NoArgs),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withClient(func(ctx context.Context, c *daemon.Client) error {
+			st, err := c.PlayerStatus(ctx)
+			if err != nil {
+				return err
+			}
+			if ctlStatusJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(st)
+			}
+			printStatus(st)
+			return nil
+		})
+	},
+}
+
+func printStatus(st *playback.Status) {
+	switch {
+	case st.Track == nil:
+		fmt.Println("■ stopped")
+	case st.State == "paused":
+		fmt.Printf("▮▮ %s\n", trackLabel(st))
+	default:
+		fmt.Printf("▶ %s\n", trackLabel(st))
+	}
+
+	volume := fmt.Sprintf("%d%%", st.VolumePct)
+	if st.Muted {
+		volume = "muted"
+	}
+	shuffle := "off"
+	if st.Shuffle {
+		shuffle = "on"
+	}
+	line := fmt.Sprintf("  volume %s  shuffle %s  repeat %s", volume, shuffle, strings.ToLower(st.Repeat.String()))
+	if st.Track != nil {
+		line = fmt.Sprintf("  %s / %s", clock(st.Position), clock(st.Duration)) + line
+	}
+	if st.QueueLen > 0 {
+		line += fmt.Sprintf("  [%d/%d]", st.QueueIndex+1, st.QueueLen)
+	}
+	fmt.Println(line)
+}
+
+func trackLabel(st *playback.Status) string {
+	title := st.Track.Title
+	if title == "" {
+		title = st.Track.FileName
+	}
+	if st.Track.Artist == "" {
+		return title
+	}
+	return st.Track.Artist + " - " + title
+}
+
+func clock(d time.Duration) string {
+	total := int(d.Seconds())
+	if total >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", total/3600, total/60%60, total%60)
+	}
+	return fmt.Sprintf("%d:%02d", total/60, total%60)
+}
+
+// ─── Queue ──────────────────────────────────────────────────────────────────
+
+var ctlQueueCmd = &cobra.Command{
+	Use:   "queue",
+	Short: "Inspect or change the play queue",
+}
+
+var ctlQueueAddCmd = &cobra.Command{
+	Use:   "add PATH...",
+	Short: "Append files or folders to the queue",
+	Args:  usageArgs(cobra.MinimumNArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withClient(func(ctx context.Context, c *daemon.Client) error {
+			for _, arg := range args {
+				path, err := filepath.Abs(arg)
+				if err != nil {
+					return usageError("invalid path %q: %v", arg, err)
+				}
+				if _, err := c.QueueAdd(ctx, path); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	},
+}
+
+var ctlQueueClearCmd = playerCmd("clear", "Stop playback and empty the queue", cobra.NoArgs,
+	func(ctx context.Context, c *daemon.Client, _ []string) (*playback.Status, error) {
+		return c.ClearQueue(ctx)
+	})
+
+var ctlQueueListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Print the queued files, marking the current one",
+	Args:  usageArgs(cobra.NoArgs),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withClient(func(ctx context.Context, c *daemon.Client) error {
+			q, err := c.GetQueue(ctx)
+			if err != nil {
+				return err
+			}
+			for i, path := range q.FilePaths {
+				marker := " "
+				if i == q.CurrentIndex {
+					marker = ">"
+				}
+				fmt.Printf("%s %3d  %s\n", marker, i+1, path)
+			}
+			return nil
+		})
+	},
+}
+
+// ─── Favorites ──────────────────────────────────────────────────────────────
+
+var ctlFavCmd = &cobra.Command{
+	Use:   "fav",
+	Short: "Manage favorites",
+}
+
+var ctlFavToggleCmd = &cobra.Command{
+	Use:   "toggle [PATH]",
+	Short: "Favorite or unfavorite a file, by default the current track",
+	Args:  usageArgs(cobra.MaximumNArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withClient(func(ctx context.Context, c *daemon.Client) error {
+			var path string
+			if len(args) == 1 {
+				abs, err := filepath.Abs(args[0])
+				if err != nil {
+					return usageError("invalid path %q: %v", args[0], err)
+				}
+				path = abs
+			} else {
+				st, err := c.PlayerStatus(ctx)
+				if err != nil {
+					return err
+				}
+				if st.Track == nil {
+					return errors.New("nothing is playing")
+				}
+				path = st.Track.FilePath
+			}
+
+			fav, err := c.IsFavorite(ctx, path)
+			if err != nil {
+				return err
+			}
+			if fav {
+				if err := c.RemoveFavorite(ctx, path); err != nil {
+					return err
+				}
+				fmt.Printf("♡ %s\n", filepath.Base(path))
+				return nil
+			}
+			if err := c.AddFavorite(ctx, path); err != nil {
+				return err
+			}
+			fmt.Printf("♥ %s\n", filepath.Base(path))
+			return nil
+		})
+	},
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
@@ -19,9 +20,10 @@ import (
 const version = "0.1.0"
 
 var (
-	verbose bool
-	port    int
-	client  string
+	verbose     bool
+	port        int
+	client      string
+	noAutostart bool
 )
 
 var rootCmd = &cobra.Command{
@@ -33,13 +35,17 @@ a web interface or a TUI.
 
   bpv ~/Music              Start TUI player (default)
   bpv ~/Music --client web Start web server & open browser
-  bpv                      Re-open last used directory`,
+  bpv                      Re-open last used directory
+  bpv ctl toggle           Control the daemon's player from scripts
+  bpv playlist export NAME Write a playlist as M3U8 or PLS`,
 	Args: cobra.MaximumNArgs(1),
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
 		logger.Init(verbose, true)
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		start := time.Now()
+		ensureDaemon()
+
 		var musicDir string
 
 		if len(args) >= 1 {
@@ -80,6 +86,19 @@ a web interface or a TUI.
 	},
 }
 
+// ensureDaemon starts bpvd in the background when it is not running, unless
+// --no-autostart was given.
+func ensureDaemon() {
+	if noAutostart || daemon.IsRunning() {
+		return
+	}
+	logger.Log.Info("Daemon not running, starting bpvd")
+	if err := daemon.Spawn(verbose); err != nil {
+		logger.Log.FatalP("Daemon", "%v\n  Start it yourself with: bpvd", err)
+	}
+	logger.Log.Success("Daemon started")
+}
+
 func lastUsedDir() string {
 	c, err := daemon.Connect()
 	if err != nil {
@@ -87,7 +106,7 @@ func lastUsedDir() string {
 	}
 	defer c.Close()
 
-	settings, err := c.GetSettings()
+	settings, err := c.GetSettings(context.Background())
 	if err != nil || settings == nil {
 		return ""
 	}
@@ -141,11 +160,12 @@ func init() {
 	rootCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "verbose log")
 	rootCmd.Flags().StringVarP(&client, "client", "c", "tui", "which client to use (web or tui based)")
 	rootCmd.Flags().IntVarP(&port, "port", "p", 8080, "port to run the server on")
+	rootCmd.Flags().BoolVar(&noAutostart, "no-autostart", false, "fail instead of starting bpvd when it is not running")
 }
 
 func main() {
 	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
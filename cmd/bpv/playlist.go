@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/hoppxi/bpv/internal/daemon"
+	"github.com/hoppxi/bpv/internal/playlist"
+	"github.com/spf13/cobra"
+)
+
+var playlistCmd = &cobra.Command{
+	Use:   "playlist",
+	Short: "Import and export playlists",
+	Long: `Move playlists between bpv and M3U, M3U8 or PLS files.
+
+  bpv playlist export Road -o road.m3u8        Write a playlist to a file
+  bpv playlist export Road --format pls        Print it as PLS
+  bpv playlist import ~/old/road.m3u           Save a file's tracks as a playlist
+  bpv playlist smart "Old jazz" "genre = Jazz AND year < 1970" --sort year
+                                               Create a smart playlist
+
+Playlist files inside a library show up as read-only playlists without
+being imported.`,
+}
+
+var (
+	exportFormat   string
+	exportOutput   string
+	exportRelative bool
+	importName     string
+	smartSort      string
+	smartLimit     int
+)
+
+func init() {
+	playlistCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return &exitError{code: exitUsage, err: err}
+	})
+	playlistCmd.AddCommand(playlistExportCmd, playlistImportCmd, playlistSmartCmd)
+
+	playlistExportCmd.Flags().StringVarP(&exportFormat, "format", "f", "m3u8", "file format: m3u8 or pls")
+	playlistExportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "file to write instead of standard output")
+	playlistExportCmd.Flags().BoolVar(&exportRelative, "relative", false,
+		"write paths relative to the output file's folder (or the current folder)")
+	playlistImportCmd.Flags().StringVar(&importName, "name", "", "playlist name (default: the file name)")
+	playlistSmartCmd.Flags().StringVar(&smartSort, "sort", "", `sort order, e.g. "year", "-added", "play count desc" or "random"`)
+	playlistSmartCmd.Flags().IntVar(&smartLimit, "limit", 0, "keep at most this many tracks (0 for all)")
+
+	for _, cmd := range []*cobra.Command{playlistCmd, playlistExportCmd, playlistImportCmd, playlistSmartCmd} {
+		cmd.SilenceUsage = true
+	}
+	rootCmd.AddCommand(playlistCmd)
+}
+
+var playlistExportCmd = &cobra.Command{
+	Use:   "export NAME",
+	Short: "Write a playlist as M3U8 or PLS",
+	Args:  usageArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format := playlist.Format(exportFormat)
+		if format != playlist.M3U8 && format != playlist.PLS {
+			return usageError("unknown format %q, want m3u8 or pls", exportFormat)
+		}
+
+		// Relative paths are relative to where the playlist will live.
+		base, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		if exportOutput != "" {
+			abs, err := filepath.Abs(exportOutput)
+			if err != nil {
+				return usageError("invalid output path %q: %v", exportOutput, err)
+			}
+			exportOutput, base = abs, filepath.Dir(abs)
+		}
+
+		var p *daemon.Playlist
+		if err := withClient(func(ctx context.Context, c *daemon.Client) (err error) {
+			p, err = c.GetPlaylist(ctx, args[0])
+			return err
+		}); err != nil {
+			return err
+		}
+
+		entries := make([]playlist.Entry, len(p.Tracks))
+		for i, t := range p.Tracks {
+			location := t.FilePath
+			if exportRelative {
+				if rel, err := filepath.Rel(base, t.FilePath); err == nil {
+					location = rel
+				}
+			}
+			title := t.Title
+			if t.Artist != "" && title != "" {
+				title = t.Artist + " - " + title
+			}
+			entries[i] = playlist.Entry{Location: location, Title: title, Duration: t.Duration}
+		}
+
+		var w io.Writer = os.Stdout
+		if exportOutput != "" {
+			f, err := os.Create(exportOutput)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		if err := playlist.Write(w, format, entries); err != nil {
+			return err
+		}
+		if exportOutput != "" {
+			fmt.Fprintf(os.Stderr, "Exported %d tracks to %s\n", len(entries), exportOutput)
+		}
+		return nil
+	},
+}
+
+var playlistImportCmd = &cobra.Command{
+	Use:   "import FILE",
+	Short: "Save the library tracks in an M3U, M3U8 or PLS file as a playlist",
+	Args:  usageArgs(cobra.ExactArgs(1)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			return usageError("invalid path %q: %v", args[0], err)
+		}
+		if !playlist.IsPlaylistFile(path) {
+			return usageError("%s is not an M3U, M3U8 or PLS file", args[0])
+		}
+
+		return withClient(func(ctx context.Context, c *daemon.Client) error {
+			p, err := c.ImportPlaylist(ctx, path, importName)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Imported %d tracks into %s\n", p.TrackCount, p.Name)
+			if len(p.Unresolved) > 0 {
+				fmt.Printf("%d entries are not in the library:\n", len(p.Unresolved))
+				for _, entry := range p.Unresolved {
+					fmt.Printf("  %s\n", entry)
+				}
+			}
+			return nil
+		})
+	},
+}
+
+var playlistSmartCmd = &cobra.Command{
+	Use:   "smart NAME RULE",
+	Short: "Create or change a playlist of the tracks that match a rule",
+	Long: `Create a smart playlist, or change the rule of an existing one. Its
+tracks are whatever in the library matches the rule, kept up to date as the
+library, play counts and favorites change.
+
+Rules are conditions joined by AND, OR and NOT:
+
+  genre = Jazz AND year < 1970
+  play count = 0 AND added in last 30 days
+  favorite AND bitrate >= 900`,
+	Args: usageArgs(cobra.ExactArgs(2)),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if smartLimit < 0 {
+			return usageError("--limit cannot be negative")
+		}
+		return withClient(func(ctx context.Context, c *daemon.Client) error {
+			p, err := c.SetPlaylistRule(ctx, args[0], args[1], smartSort, smartLimit)
+			if errors.Is(err, daemon.ErrNotFound) {
+				p, err = c.CreateSmartPlaylist(ctx, args[0], args[1], smartSort, smartLimit)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s matches %d tracks\n", p.Name, p.TrackCount)
+			return nil
+		})
+	},
+}
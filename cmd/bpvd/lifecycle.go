@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hoppxi/bpv/internal/daemon"
+	"github.com/hoppxi/bpv/internal/xdg"
+	"github.com/spf13/cobra"
+)
+
+// exitNotRunning is what bpvd status exits with when no daemon is running,
+// matching the LSB init script convention.
+const exitNotRunning = 3
+
+const (
+	requestTimeout = 3 * time.Second
+	stopTimeout    = 10 * time.Second
+)
+
+var (
+	logLines  int
+	logFollow bool
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether bpvd is running and what it is doing",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pid := daemon.RunningPID()
+		if pid == 0 {
+			fmt.Println("bpvd is not running")
+			os.Exit(exitNotRunning)
+		}
+
+		c, err := daemon.Connect()
+		if err != nil {
+			return fmt.Errorf("bpvd is running (pid %d) but not answering: %w", pid, err)
+		}
+		defer c.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		defer cancel()
+		info, err := c.DaemonStatus(ctx)
+		if err != nil {
+			return fmt.Errorf("bpvd is running (pid %d) but not answering: %w", pid, err)
+		}
+
+		fmt.Printf("bpvd %s is running (pid %d)\n", info.Version, info.PID)
+		fmt.Printf("  Uptime:    %s\n", time.Since(info.StartedAt).Round(time.Second))
+		socket := info.Socket
+		if info.Activated {
+			socket += " (socket activated)"
+		}
+		fmt.Printf("  Socket:    %s\n", socket)
+		if info.MPD != "" {
+			fmt.Printf("  MPD:       %s\n", info.MPD)
+		}
+		fmt.Printf("  Libraries: %s\n", listOrNone(info.Libraries))
+		fmt.Printf("  Scanning:  %s\n", listOrNone(info.Scanning))
+		return nil
+	},
+}
+
+func listOrNone(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, ", ")
+}
+
+var stopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the running bpvd",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stopped, err := stopDaemon()
+		if err != nil {
+			return err
+		}
+		if !stopped {
+			fmt.Println("bpvd is not running")
+		}
+		return nil
+	},
+}
+
+var restartCmd = &cobra.Command{
+	Use:   "restart",
+	Short: "Stop bpvd if it is running and start it again",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if _, err := stopDaemon(); err != nil {
+			return err
+		}
+		startDaemon()
+		return nil
+	},
+}
+
+// stopDaemon asks the daemon to shut down over the socket and falls back to
+// SIGTERM when it does not answer. It waits until the daemon has released
+// its lock and reports whether one was running.
+func stopDaemon() (bool, error) {
+	pid := daemon.RunningPID()
+	if pid == 0 {
+		return false, nil
+	}
+
+	if err := requestShutdown(); err != nil {
+		target := pidFromFile(xdg.PidPath())
+		if target == 0 {
+			target = pid
+		}
+		fmt.Fprintf(os.Stderr, "bpvd did not accept shutdown (%v), sending SIGTERM to pid %d\n", err, target)
+		if err := syscall.Kill(target, syscall.SIGTERM); err != nil && !errors.Is(err, syscall.ESRCH) {
+			return true, fmt.Errorf("failed to signal bpvd (pid %d): %w", target, err)
+		}
+	}
+
+	deadline := time.Now().Add(stopTimeout)
+	for daemon.RunningPID() != 0 {
+		if time.Now().After(deadline) {
+			return true, fmt.Errorf("bpvd (pid %d) did not stop within %s", pid, stopTimeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	fmt.Printf("bpvd stopped (pid %d)\n", pid)
+	return true, nil
+}
+
+func requestShutdown() error {
+	c, err := daemon.Connect()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return c.Shutdown(ctx)
+}
+
+func pidFromFile(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
+
+var logsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Print the end of the daemon log",
+	Long: `Print the end of the daemon log, or keep following it with -f.
+
+Only a daemonized bpvd writes this file. When it runs under systemd
+(--no-daemonize), read the journal instead: journalctl --user -u bpvd`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := xdg.LogPath()
+		f, err := os.Open(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("no log at %s; under systemd use journalctl --user -u bpvd", path)
+			}
+			return err
+		}
+		defer f.Close()
+
+		if err := printTail(f, logLines); err != nil {
+			return err
+		}
+		if !logFollow {
+			return nil
+		}
+		return follow(f, path)
+	},
+}
+
+// printTail prints the last n lines of f and leaves the offset at the end.
+func printTail(f *os.File, n int) error {
+	var lines []string
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	_, err := f.Seek(0, io.SeekEnd)
+	return err
+}
+
+// follow copies whatever is appended to f until interrupted. If the log is
+// truncated or replaced it starts again from the top of the new file.
+func follow(f *os.File, path string) error {
+	for {
+		if _, err := io.Copy(os.Stdout, f); err != nil {
+			return err
+		}
+		time.Sleep(250 * time.Millisecond)
+
+		pos, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		cur, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		latest, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		switch {
+		case !os.SameFile(cur, latest):
+			next, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			f.Close()
+			f = next
+		case latest.Size() < pos:
+			f.Seek(0, io.SeekStart)
+		}
+	}
+}
+
+func init() {
+	logsCmd.Flags().IntVarP(&logLines, "lines", "n", 50, "number of lines to show")
+	logsCmd.Flags().BoolVarP(&logFollow, "follow", "f", false, "keep printing new log lines")
+
+	rootCmd.AddCommand(statusCmd, stopCmd, restartCmd, logsCmd)
+}
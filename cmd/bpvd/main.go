@@ -1,16 +1,20 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 
 	godaemon "github.com/sevlyar/go-daemon"
 
 	"github.com/hoppxi/bpv/internal/daemon"
 	"github.com/hoppxi/bpv/internal/logger"
+	"github.com/hoppxi/bpv/internal/metadata"
 	"github.com/hoppxi/bpv/internal/xdg"
 	"github.com/spf13/cobra"
 )
@@ -20,6 +24,9 @@ const version = "0.1.0"
 var (
 	verbose     bool
 	noDaemonize bool
+	mpdAddr     string
+	scanWorkers int
+	folderArt   []string
 )
 
 var rootCmd = &cobra.Command{
@@ -29,53 +36,102 @@ var rootCmd = &cobra.Command{
 	Long: `bpvd is the BPV daemon that manages your music library.
 
   bpvd                   Start daemon (daemonize by default)
-  bpvd --no-daemonize    Run in foreground (used for services)`,
+  bpvd --no-daemonize    Run in foreground (used for services)
+  bpvd --mpd :6600       Also accept MPD clients (mpc, ncmpcpp) on a TCP address
+
+  bpvd status            Show whether the daemon is running and what it is doing
+  bpvd stop              Stop the daemon
+  bpvd restart           Stop the daemon and start it again
+  bpvd logs -f           Follow the daemon log
+
+Only one bpvd runs per user. When started by systemd with socket activation
+(LISTEN_FDS), bpvd serves the socket it is given instead of creating one.`,
+	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		if noDaemonize {
-			logger.Init(verbose, true)
-			runDaemon()
-			return
-		}
+		startDaemon()
+	},
+}
 
-		logPath := xdg.LogPath()
-		os.MkdirAll(xdg.StateDir(), 0755)
+func startDaemon() {
+	// Checked before forking so the error reaches the terminal instead of
+	// the log file. Start takes the lock for real.
+	if pid := daemon.RunningPID(); pid != 0 {
+		fmt.Fprintf(os.Stderr, "bpvd is already running (pid %d)\n", pid)
+		os.Exit(1)
+	}
 
-		pidPath := xdg.PidPath()
-		os.MkdirAll(xdg.RuntimeDir(), 0755)
+	if noDaemonize {
+		logger.Init(verbose, true)
+		runDaemon()
+		return
+	}
 
-		ctx := &godaemon.Context{
-			PidFileName: pidPath,
-			PidFilePerm: 0644,
-			LogFileName: logPath,
-			LogFilePerm: 0640,
-			WorkDir:     "/",
-			Umask:       027,
-		}
+	logPath := xdg.LogPath()
+	os.MkdirAll(xdg.StateDir(), 0755)
+
+	pidPath := xdg.PidPath()
+	os.MkdirAll(xdg.RuntimeDir(), 0755)
+
+	ctx := &godaemon.Context{
+		PidFileName: pidPath,
+		PidFilePerm: 0644,
+		LogFileName: logPath,
+		LogFilePerm: 0640,
+		WorkDir:     "/",
+		Umask:       027,
+		// The child always runs the root command, even when started by
+		// bpvd restart.
+		Args: daemonArgs(),
+	}
 
-		child, err := ctx.Reborn()
-		if err != nil {
-			log.Fatalf("Failed to daemonize: %v", err)
-		}
+	child, err := ctx.Reborn()
+	if err != nil {
+		log.Fatalf("Failed to daemonize: %v", err)
+	}
 
-		if child != nil {
-			fmt.Printf("bpvd started (pid %d)\n", child.Pid)
-			fmt.Printf("  Log: %s\n", logPath)
-			fmt.Printf("  PID: %s\n", pidPath)
-			return
-		}
+	if child != nil {
+		fmt.Printf("bpvd started (pid %d)\n", child.Pid)
+		fmt.Printf("  Log: %s\n", logPath)
+		fmt.Printf("  PID: %s\n", pidPath)
+		return
+	}
 
-		defer ctx.Release()
+	defer ctx.Release()
 
-		logger.Init(verbose, false)
-		runDaemon()
-	},
+	logger.Init(verbose, false)
+	runDaemon()
+}
+
+// daemonArgs rebuilds the command line for the daemonized child from the
+// flags that affect it.
+func daemonArgs() []string {
+	args := []string{os.Args[0]}
+	if verbose {
+		args = append(args, "--verbose")
+	}
+	if mpdAddr != "" {
+		args = append(args, "--mpd", mpdAddr)
+	}
+	if scanWorkers != 0 {
+		args = append(args, "--scan-workers", strconv.Itoa(scanWorkers))
+	}
+	if len(folderArt) > 0 {
+		args = append(args, "--folder-art", strings.Join(folderArt, ","))
+	}
+	return args
 }
 
 func runDaemon() {
+	daemon.Version = version
 	d, err := daemon.NewDaemon()
 	if err != nil {
 		logger.Log.FatalErr(err, "Failed to create daemon")
 	}
+	if mpdAddr != "" {
+		d.EnableMPD(mpdAddr)
+	}
+	d.SetScanWorkers(scanWorkers)
+	d.SetFolderArt(folderArt)
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -87,6 +143,10 @@ func runDaemon() {
 	}()
 
 	if err := d.Start(); err != nil {
+		var running *daemon.AlreadyRunningError
+		if errors.As(err, &running) {
+			logger.Log.Fatal("%v", err)
+		}
 		logger.Log.FatalErr(err, "Daemon stopped unexpectedly")
 	}
 }
@@ -94,6 +154,12 @@ func runDaemon() {
 func init() {
 	rootCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "verbose log")
 	rootCmd.Flags().BoolVar(&noDaemonize, "no-daemonize", false, "run in foreground without forking")
+	rootCmd.Flags().StringVar(&mpdAddr, "mpd", "", "serve the MPD protocol on this TCP address, e.g. localhost:6600")
+	rootCmd.Flags().IntVar(&scanWorkers, "scan-workers", 0, "files read at once while scanning (default one per CPU)")
+	rootCmd.Flags().StringSliceVar(&folderArt, "folder-art", nil, "image names used as cover art for tracks without embedded art, best first (default "+strings.Join(metadata.DefaultFolderArt, ",")+")")
+
+	// restart starts the daemon again the same way the root command does.
+	restartCmd.Flags().AddFlagSet(rootCmd.Flags())
 }
 
 func main() {
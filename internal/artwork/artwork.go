@@ -0,0 +1,308 @@
+package artwork
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hoppxi/bpv/internal/xdg"
+)
+
+// Sizes a cover can be served at, as the longest side in pixels. Only these
+// are rendered so a client cannot fill the cache with arbitrary sizes.
+var Sizes = []int{64, 300, 600}
+
+const (
+	// DefaultSize is served when no size is asked for.
+	DefaultSize = 300
+	// Original asks for the picture exactly as it was embedded.
+	Original = -1
+)
+
+// jpegQuality is used for every rendered size without transparency. Sizes
+// of pictures with transparent pixels are kept as PNG instead, since JPEG
+// would turn them black.
+const jpegQuality = 88
+
+// idLen is the length of a cover ID in hex digits.
+const idLen = 32
+
+// ErrNotFound is returned by Get for an ID the store does not hold.
+var ErrNotFound = errors.New("artwork not found")
+
+// Image is a cover at one size.
+type Image struct {
+	Data     []byte
+	Mime     string
+	Modified time.Time
+}
+
+// Store keeps cover images on disk, one original per distinct picture plus
+// the sizes rendered from it so far. Images are named after a hash of the
+// embedded picture, so every track of an album shares the same files and an
+// unchanged picture is never stored twice.
+type Store struct {
+	dir string
+	// mu serializes rendering, so a burst of requests for a new size
+	// renders it once.
+	mu sync.Mutex
+}
+
+// NewStore opens the store under xdg.CacheDir().
+func NewStore() (*Store, error) {
+	return NewStoreAt(filepath.Join(xdg.CacheDir(), "artwork"))
+}
+
+func NewStoreAt(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+// ID returns the cover ID for the picture data found in a file.
+func ID(source []byte) string {
+	sum := sha256.Sum256(source)
+	return hex.EncodeToString(sum[:idLen/2])
+}
+
+// IsID reports whether s has the form of a cover ID.
+func IsID(s string) bool {
+	if len(s) != idLen {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// ParseSize parses a size as given in a request: "", "original" or one of
+// Sizes.
+func ParseSize(s string) (int, error) {
+	switch s {
+	case "":
+		return DefaultSize, nil
+	case "original":
+		return Original, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err == nil {
+		for _, size := range Sizes {
+			if n == size {
+				return n, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("invalid size %q: want 64, 300, 600 or original", s)
+}
+
+// FormatSize is the inverse of ParseSize.
+func FormatSize(size int) string {
+	if size == Original {
+		return "original"
+	}
+	return strconv.Itoa(size)
+}
+
+// Fit returns the smallest size with at least px pixels on its longest side.
+func Fit(px int) int {
+	for _, size := range Sizes {
+		if size >= px {
+			return size
+		}
+	}
+	return Original
+}
+
+// path is where the original picture for id is kept. Images are spread over
+// 256 subdirectories so none of them grows too large.
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id[:2], id)
+}
+
+func (s *Store) variantPath(id string, size int, ext string) string {
+	return fmt.Sprintf("%s-%d%s", s.path(id), size, ext)
+}
+
+// readVariant returns the rendered size of id, in whichever format it was
+// rendered in.
+func (s *Store) readVariant(id string, size int) (*Image, error) {
+	img, err := readImage(s.variantPath(id, size, ".jpg"))
+	if errors.Is(err, os.ErrNotExist) {
+		img, err = readImage(s.variantPath(id, size, ".png"))
+	}
+	return img, err
+}
+
+// Has reports whether the store holds a picture for id.
+func (s *Store) Has(id string) bool {
+	if !IsID(id) {
+		return false
+	}
+	_, err := os.Stat(s.path(id))
+	return err == nil
+}
+
+// Put stores data, the picture as embedded in a file, under id.
+func (s *Store) Put(id string, data []byte) error {
+	if !IsID(id) {
+		return fmt.Errorf("invalid cover id: %q", id)
+	}
+	return writeFile(s.path(id), data)
+}
+
+// Get returns the picture for id at size, rendering and caching that size
+// the first time it is asked for. A picture already smaller than size is
+// returned as it is.
+func (s *Store) Get(id string, size int) (*Image, error) {
+	if !IsID(id) {
+		return nil, ErrNotFound
+	}
+	if size == 0 {
+		size = DefaultSize
+	}
+	if size != Original {
+		if img, err := s.readVariant(id, size); err == nil {
+			return img, nil
+		}
+	}
+
+	original, err := readImage(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if size == Original {
+		return original, nil
+	}
+	return s.render(id, size, original)
+}
+
+func (s *Store) render(id string, size int, original *Image) (*Image, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if img, err := s.readVariant(id, size); err == nil {
+		return img, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(original.Data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode cover %s: %w", id, err)
+	}
+	b := src.Bounds()
+	if b.Dx() <= size && b.Dy() <= size {
+		return original, nil
+	}
+
+	w, h := size, b.Dy()*size/b.Dx()
+	if b.Dy() > b.Dx() {
+		w, h = b.Dx()*size/b.Dy(), size
+	}
+	scaled := Scale(src, max(w, 1), max(h, 1))
+	var buf bytes.Buffer
+	path := s.variantPath(id, size, ".jpg")
+	if scaled.Opaque() {
+		err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		path = s.variantPath(id, size, ".png")
+		err = png.Encode(&buf, scaled)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode cover %s: %w", id, err)
+	}
+	if err := writeFile(path, buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return readImage(path)
+}
+
+// Prune removes the originals and rendered sizes of every cover not in
+// keep, and returns how many covers it removed. Files written at or after
+// since are left alone, so covers stored while the keep set was being
+// gathered survive.
+func (s *Store) Prune(keep map[string]bool, since time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := make(map[string]bool)
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || len(d.Name()) < idLen {
+			return nil
+		}
+		id := d.Name()[:idLen]
+		if !IsID(id) || keep[id] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || !info.ModTime().Before(since) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		removed[id] = true
+		return nil
+	})
+	return len(removed), err
+}
+
+func readImage(path string) (*Image, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Image{Data: data, Mime: mimeType(data), Modified: info.ModTime()}, nil
+}
+
+func mimeType(data []byte) string {
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return "image/" + format
+}
+
+// writeFile replaces path atomically, so readers never see half an image.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,104 @@
+package artwork
+
+import (
+	"image"
+	"image/draw"
+)
+
+// contrib is one source pixel's share of a destination pixel.
+type contrib struct {
+	index  int
+	weight float32
+}
+
+// Scale resizes src to w×h by area averaging: each destination pixel is the
+// mean of the source pixels under it, weighted by how much of each it
+// covers. This keeps downscaled covers smooth where sampling one pixel per
+// cell would alias. It is meant for shrinking; enlarging falls back to
+// blocky nearest-pixel results.
+func Scale(src image.Image, w, h int) *image.RGBA {
+	rgba := toRGBA(src)
+	b := rgba.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+
+	xs := contribs(sw, w)
+	ys := contribs(sh, h)
+
+	// Horizontal pass into a w×sh float buffer, then vertical into dst.
+	tmp := make([]float32, w*sh*4)
+	for y := 0; y < sh; y++ {
+		row := rgba.Pix[y*rgba.Stride:]
+		for x, cs := range xs {
+			var r, g, bl, a float32
+			for _, c := range cs {
+				p := row[c.index*4:]
+				r += float32(p[0]) * c.weight
+				g += float32(p[1]) * c.weight
+				bl += float32(p[2]) * c.weight
+				a += float32(p[3]) * c.weight
+			}
+			t := tmp[(y*w+x)*4:]
+			t[0], t[1], t[2], t[3] = r, g, bl, a
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y, cs := range ys {
+		for x := 0; x < w; x++ {
+			var r, g, bl, a float32
+			for _, c := range cs {
+				t := tmp[(c.index*w+x)*4:]
+				r += t[0] * c.weight
+				g += t[1] * c.weight
+				bl += t[2] * c.weight
+				a += t[3] * c.weight
+			}
+			d := dst.Pix[y*dst.Stride+x*4:]
+			d[0], d[1], d[2], d[3] = clamp(r), clamp(g), clamp(bl), clamp(a)
+		}
+	}
+	return dst
+}
+
+// contribs lists, for each of the dst pixels along one axis, the src pixels
+// it overlaps and by how much. Weights for a pixel add up to one.
+func contribs(src, dst int) [][]contrib {
+	out := make([][]contrib, dst)
+	scale := float32(src) / float32(dst)
+	for i := range out {
+		lo := float32(i) * scale
+		hi := lo + scale
+		if scale < 1 {
+			// Enlarging: take the nearest source pixel.
+			out[i] = []contrib{{index: min(int(lo+scale/2), src-1), weight: 1}}
+			continue
+		}
+		for j := int(lo); j < src && float32(j) < hi; j++ {
+			overlap := min(hi, float32(j+1)) - max(lo, float32(j))
+			if overlap > 0 {
+				out[i] = append(out[i], contrib{index: j, weight: overlap / scale})
+			}
+		}
+	}
+	return out
+}
+
+func toRGBA(src image.Image) *image.RGBA {
+	if rgba, ok := src.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	b := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	return rgba
+}
+
+func clamp(v float32) uint8 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 255:
+		return 255
+	}
+	return uint8(v + 0.5)
+}
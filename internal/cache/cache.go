@@ -3,17 +3,32 @@ package cache
 import (
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/hoppxi/bpv/internal/metadata"
+	"github.com/hoppxi/bpv/internal/playlist"
 	"github.com/hoppxi/bpv/internal/xdg"
 )
 
+// FormatVersion is bumped whenever cached files can no longer be reused as
+// they are. Libraries cached by another version are scanned again from
+// scratch. Version 2 moved cover art into the artwork store; version 3 keeps
+// the original pictures there; version 4 records whether a cover is embedded
+// or a folder image; version 5 gives every track a stable ID; version 6
+// adds the playlist files found in the library; version 7 leaves the tags
+// of Ogg, WAV and AIFF files out of track IDs.
+const FormatVersion = 7
+
 type CachedLibrary struct {
+	Version   int                  `json:"version"`
 	Dir       string               `json:"dir"`
 	ScanTime  time.Time            `json:"scan_time"`
 	FileCount int                  `json:"file_count"`
@@ -22,6 +37,7 @@ type CachedLibrary struct {
 	Albums    map[string]int       `json:"albums"`
 	Genres    map[string]int       `json:"genres"`
 	Composers map[string]int       `json:"composers"`
+	Playlists []playlist.File      `json:"playlists,omitempty"`
 	Errors    []string             `json:"errors,omitempty"`
 }
 
@@ -30,6 +46,8 @@ type Cache struct {
 	mu  sync.RWMutex
 
 	hot map[string]*CachedLibrary
+	// generation counts changes to hot; see Generation.
+	generation uint64
 }
 
 func NewCache() (*Cache, error) {
@@ -80,9 +98,13 @@ func (c *Cache) Load(dir string) *CachedLibrary {
 	if err := json.Unmarshal(data, &lib); err != nil {
 		return nil
 	}
+	if lib.Version != FormatVersion {
+		return nil
+	}
 
 	c.mu.Lock()
 	c.hot[dir] = &lib
+	c.generation++
 	c.mu.Unlock()
 
 	return &lib
@@ -92,7 +114,9 @@ func (c *Cache) Save(lib *CachedLibrary) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	lib.Version = FormatVersion
 	c.hot[lib.Dir] = lib
+	c.generation++
 
 	data, err := json.Marshal(lib)
 	if err != nil {
@@ -111,23 +135,148 @@ func (c *Cache) Invalidate(dir string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	delete(c.hot, dir)
+	c.generation++
 	os.Remove(c.cacheFile(dir))
 }
 
+// Generation changes whenever a library is loaded into memory, saved or
+// invalidated, so callers that derive data from the libraries in memory can
+// tell when to bring it up to date.
+func (c *Cache) Generation() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.generation
+}
+
+// Loaded returns the directories of the libraries held in memory, sorted.
+func (c *Cache) Loaded() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	dirs := make([]string, 0, len(c.hot))
+	for dir := range c.hot {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// CoverIDs returns the cover IDs used by any cached library, whether held
+// in memory or only on disk, so covers nothing refers to any more can be
+// removed from the artwork store.
+func (c *Cache) CoverIDs() (map[string]bool, error) {
+	ids := make(map[string]bool)
+	c.mu.RLock()
+	hot := make(map[string]bool, len(c.hot))
+	for dir, lib := range c.hot {
+		hot[c.cacheFile(dir)] = true
+		for _, f := range lib.Files {
+			if f.CoverID != "" {
+				ids[f.CoverID] = true
+			}
+		}
+	}
+	c.mu.RUnlock()
+
+	paths, err := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		if hot[path] {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var lib struct {
+			Files []struct {
+				CoverID string `json:"cover_id"`
+			} `json:"files"`
+		}
+		if err := json.Unmarshal(data, &lib); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		for _, f := range lib.Files {
+			if f.CoverID != "" {
+				ids[f.CoverID] = true
+			}
+		}
+	}
+	return ids, nil
+}
+
+// IsStale reports whether files may have been added or removed anywhere
+// under dir since it was cached, by comparing the modification time of every
+// directory with the scan time. Files edited in place are only noticed by a
+// rescan, which compares each file's size and mtime.
 func (c *Cache) IsStale(dir string) bool {
 	lib := c.Load(dir)
 	if lib == nil {
 		return true
 	}
 
-	info, err := os.Stat(dir)
-	if err != nil {
-		return true
+	errStale := errors.New("stale")
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(lib.ScanTime) {
+			return errStale
+		}
+		return nil
+	})
+	return err != nil
+}
+
+// Lookup returns the cached metadata for the given paths from every library
+// currently held in memory. Paths that are not found are omitted.
+func (c *Cache) Lookup(paths []string) map[string]metadata.AudioFile {
+	want := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		want[p] = true
 	}
 
-	if info.ModTime().After(lib.ScanTime) {
-		return true
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	found := make(map[string]metadata.AudioFile, len(paths))
+	for _, lib := range c.hot {
+		for _, f := range lib.Files {
+			if want[f.FilePath] {
+				found[f.FilePath] = f
+			}
+		}
 	}
+	return found
+}
 
-	return false
+// Under returns every cached track inside dir, from every library currently
+// held in memory, sorted by path.
+func (c *Cache) Under(dir string) []metadata.AudioFile {
+	prefix := strings.TrimSuffix(dir, string(filepath.Separator)) + string(filepath.Separator)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var files []metadata.AudioFile
+	for _, lib := range c.hot {
+		for _, f := range lib.Files {
+			if strings.HasPrefix(f.FilePath, prefix) && !seen[f.FilePath] {
+				seen[f.FilePath] = true
+				files = append(files, f)
+			}
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].FilePath < files[j].FilePath })
+	return files
 }
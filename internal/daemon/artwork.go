@@ -0,0 +1,96 @@
+package daemon
+
+import (
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/hoppxi/bpv/internal/artwork"
+	"github.com/hoppxi/bpv/internal/metadata"
+)
+
+// Cover is an image returned by the cover-art action.
+type Cover struct {
+	ID       string
+	Data     []byte
+	Mime     string
+	Modified time.Time
+}
+
+// newExtractor returns an extractor that saves cover art to the daemon's
+// artwork store, falling back to folder images.
+func (d *Daemon) newExtractor() *metadata.Extractor {
+	e := metadata.NewExtractor()
+	e.SetArtworkStore(d.artwork)
+	e.SetFolderArt(d.folderArt)
+	return e
+}
+
+// handleCoverArt serves an image from the artwork store, by cover ID or for
+// the track at filePath, at the requested size. A track without cover art is
+// not an error; the reply just has no image.
+func (d *Daemon) handleCoverArt(id, filePath, size string) Response {
+	if id == "" && filePath == "" {
+		return Response{OK: false, Error: "cover id or file_path is required", Code: ErrCodeInvalidParams}
+	}
+	px, err := artwork.ParseSize(size)
+	if err != nil {
+		return Response{OK: false, Error: err.Error(), Code: ErrCodeInvalidParams}
+	}
+
+	if id == "" {
+		id, err = d.coverID(filePath)
+		if err != nil {
+			return Response{OK: false, Error: "failed to extract cover art: " + err.Error()}
+		}
+		if id == "" {
+			return Response{OK: true}
+		}
+	}
+
+	img, err := d.artwork.Get(id, px)
+	if errors.Is(err, artwork.ErrNotFound) {
+		return Response{OK: false, Error: "unknown cover id: " + id, Code: ErrCodeInvalidParams}
+	}
+	if err != nil {
+		return Response{OK: false, Error: "failed to read cover art: " + err.Error()}
+	}
+
+	return Response{
+		OK:        true,
+		CoverID:   id,
+		CoverArt:  base64.StdEncoding.EncodeToString(img.Data),
+		CoverMime: img.Mime,
+		CoverTime: img.Modified,
+	}
+}
+
+// coverID finds the cover of a track, from the library cache when the track
+// is in a scanned library. Anything else, or a cover missing from the store,
+// is read from the file once and stored.
+func (d *Daemon) coverID(filePath string) (string, error) {
+	if f, ok := d.cache.Lookup([]string{filePath})[filePath]; ok {
+		if f.CoverID == "" || d.artwork.Has(f.CoverID) {
+			return f.CoverID, nil
+		}
+	}
+
+	audioFile, err := d.newExtractor().ExtractFromFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	return audioFile.CoverID, nil
+}
+
+// handlePictures lists every picture embedded in the track at filePath. Each
+// is saved to the artwork store, so clients fetch them with cover-art by ID.
+func (d *Daemon) handlePictures(filePath string) Response {
+	if filePath == "" {
+		return Response{OK: false, Error: "file_path is required", Code: ErrCodeInvalidParams}
+	}
+	pictures, err := d.newExtractor().Pictures(filePath)
+	if err != nil {
+		return Response{OK: false, Error: "failed to read pictures: " + err.Error()}
+	}
+	return Response{OK: true, Pictures: pictures}
+}
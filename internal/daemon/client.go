@@ -2,77 +2,490 @@ package daemon
 
 import (
 	"bufio"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"slices"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/hoppxi/bpv/internal/artwork"
 	"github.com/hoppxi/bpv/internal/cache"
+	"github.com/hoppxi/bpv/internal/metadata"
+	"github.com/hoppxi/bpv/internal/playback"
 	"github.com/hoppxi/bpv/internal/store"
 )
 
+// ErrUnsupported is returned for actions the connected daemon does not
+// advertise in its hello reply.
+var ErrUnsupported = errors.New("not supported by daemon")
+
+// ErrClosed is returned by calls made after Close.
+var ErrClosed = errors.New("client closed")
+
+// ErrNotFound matches, with errors.Is, the errors of calls whose target the
+// daemon reported missing, such as an unknown playlist.
+var ErrNotFound = errors.New("not found")
+
+// ResponseError is a request the daemon answered with an error.
+type ResponseError struct {
+	Action  string
+	Code    int
+	Message string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("%s error: %s", e.Action, e.Message)
+}
+
+func (e *ResponseError) Is(target error) bool {
+	return target == ErrNotFound && e.Code == ErrCodeNotFound
+}
+
+func responseError(action string, resp *Response) error {
+	return &ResponseError{Action: action, Code: resp.Code, Message: resp.Error}
+}
+
+// errConnLost is returned to calls that were waiting when the connection
+// dropped. The daemon may or may not have run them.
+var errConnLost = errors.New("connection to daemon lost")
+
+// IsConnError reports whether err means the daemon could not be reached or
+// the connection to it failed, as opposed to the daemon refusing a request.
+func IsConnError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, errConnLost) || errors.Is(err, ErrClosed) || errors.As(err, &netErr)
+}
+
+// Reconnect attempts start at minBackoff and double up to maxBackoff.
+const (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 5 * time.Second
+)
+
+// ConnState describes the client's link to the daemon.
+type ConnState int
+
+const (
+	StateConnected ConnState = iota
+	StateReconnecting
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	default:
+		return "closed"
+	}
+}
+
+// Client talks to bpvd over one connection. It is safe for concurrent use:
+// every call gets its own request id, calls are written as soon as they are
+// made and a reader goroutine hands each reply to the call waiting for it,
+// so a slow scan does not hold up anything else.
+//
+// If the connection drops, for example because bpvd restarted, the client
+// redials in the background with backoff. Calls made in the meantime wait
+// for the new connection or for their context.
 type Client struct {
-	conn    net.Conn
 	mu      sync.Mutex
-	scanner *bufio.Scanner
+	conn    net.Conn
+	pending map[string]chan *Response
+	nextID  uint64
+	state   ConnState
+	ready   chan struct{} // closed while connected
+	done    chan struct{} // closed by Close
+	onState []func(ConnState)
+
+	writeMu sync.Mutex
+
+	// legacy serialises calls to protocol 1 daemons, whose replies carry no
+	// id and can only be matched by order.
+	legacy sync.Mutex
+
+	// Filled in by the hello handshake. A daemon that predates hello speaks
+	// protocol 1 with legacy framing and leaves actions nil.
+	protocol int
+	version  string
+	actions  map[string]bool
 }
 
 func Connect() (*Client, error) {
-	sockPath := SocketPath()
+	c := &Client{
+		pending: make(map[string]chan *Response),
+		state:   StateReconnecting,
+		ready:   make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	if !c.setConnected(conn) {
+		conn.Close()
+		return nil, errConnLost
+	}
+	return c, nil
+}
 
-	conn, err := net.DialTimeout("unix", sockPath, 2*time.Second)
+// dial opens a connection, starts its reader and performs the handshake.
+func (c *Client) dial() (net.Conn, error) {
+	conn, err := net.DialTimeout("unix", SocketPath(), 2*time.Second)
 	if err != nil {
-		return nil, fmt.Errorf("daemon not running: %v)", err)
+		return nil, fmt.Errorf("daemon not running: %w", err)
 	}
 
 	sc := bufio.NewScanner(conn)
 	sc.Buffer(make([]byte, 0), 64*1024*1024)
 
-	return &Client{
-		conn:    conn,
-		scanner: sc,
-	}, nil
+	c.mu.Lock()
+	c.conn = conn
+	c.protocol = ProtocolVersion
+	c.mu.Unlock()
+	go c.readLoop(conn, sc)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := c.handshake(ctx, conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// handshake sends hello and records what the daemon supports. Old daemons
+// answer with a legacy "unknown action" error, which drops the client back
+// to protocol 1.
+func (c *Client) handshake(ctx context.Context, conn net.Conn) error {
+	resp, err := c.exchange(ctx, conn, Request{Action: "hello"})
+	if err != nil {
+		return fmt.Errorf("handshake failed: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !resp.OK {
+		c.protocol, c.version, c.actions = 1, "", nil
+		return nil
+	}
+
+	c.protocol = resp.Protocol
+	c.version = resp.Version
+	c.actions = make(map[string]bool, len(resp.Actions))
+	for _, name := range resp.Actions {
+		c.actions[name] = true
+	}
+	return nil
+}
+
+// setConnected makes conn the live connection and releases waiting calls.
+// It fails if conn died during the handshake or the client was closed.
+func (c *Client) setConnected(conn net.Conn) bool {
+	c.mu.Lock()
+	if c.conn != conn || c.state == StateClosed {
+		c.mu.Unlock()
+		return false
+	}
+	c.state = StateConnected
+	close(c.ready)
+	c.mu.Unlock()
+
+	c.notify(StateConnected)
+	return true
+}
+
+// reconnect redials until it succeeds or the client is closed.
+func (c *Client) reconnect() {
+	backoff := minBackoff
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-time.After(backoff):
+		}
+
+		if conn, err := c.dial(); err == nil {
+			if c.setConnected(conn) {
+				return
+			}
+			conn.Close()
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// State returns the current connection state.
+func (c *Client) State() ConnState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// OnStateChange registers fn to run whenever the connection state changes.
+// fn is called from the client's own goroutines and must not block.
+func (c *Client) OnStateChange(fn func(ConnState)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onState = append(c.onState, fn)
+}
+
+func (c *Client) notify(state ConnState) {
+	c.mu.Lock()
+	fns := slices.Clone(c.onState)
+	c.mu.Unlock()
+	for _, fn := range fns {
+		fn(state)
+	}
+}
+
+// Protocol returns the protocol version negotiated with the daemon.
+func (c *Client) Protocol() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.protocol
+}
+
+// DaemonVersion returns the daemon build version, or "" for protocol 1
+// daemons.
+func (c *Client) DaemonVersion() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version
+}
+
+// Supports reports whether the daemon advertised action. Protocol 1 daemons
+// do not advertise anything, so every action is assumed supported.
+func (c *Client) Supports(action string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.actions == nil {
+		return true
+	}
+	return c.actions[action]
 }
 
 func (c *Client) Close() error {
-	if c.conn != nil {
-		return c.conn.Close()
+	c.mu.Lock()
+	if c.state == StateClosed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.state = StateClosed
+	close(c.done)
+	conn := c.conn
+	c.mu.Unlock()
+
+	c.notify(StateClosed)
+	if conn != nil {
+		return conn.Close()
 	}
 	return nil
 }
 
-func (c *Client) send(req Request) (*Response, error) {
+// readLoop delivers replies until conn fails. It then wakes every call still
+// waiting and, unless the client was closed, starts reconnecting.
+func (c *Client) readLoop(conn net.Conn, sc *bufio.Scanner) {
+	for sc.Scan() {
+		id, resp, err := decodeResponse(sc.Bytes())
+		if err != nil {
+			continue
+		}
+		c.deliver(id, resp)
+	}
+	conn.Close()
+
+	c.mu.Lock()
+	if c.conn == conn {
+		c.conn = nil
+	}
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	lost := c.state == StateConnected
+	if lost {
+		c.state = StateReconnecting
+		c.ready = make(chan struct{})
+	}
+	c.mu.Unlock()
+
+	if lost {
+		c.notify(StateReconnecting)
+		go c.reconnect()
+	}
+}
+
+// deliver hands resp to the call waiting on id. Replies without an id come
+// from protocol 1 daemons or from parse errors; they can only belong to the
+// sole outstanding call.
+func (c *Client) deliver(id string, resp *Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch, ok := c.pending[id]
+	if !ok && (id == "" || id == "null") && len(c.pending) == 1 {
+		for id, ch = range c.pending {
+		}
+		ok = true
+	}
+	if !ok {
+		return
+	}
+	delete(c.pending, id)
+	ch <- resp
+}
+
+func (c *Client) forget(id string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	delete(c.pending, id)
+}
+
+func (c *Client) send(ctx context.Context, req Request) (*Response, error) {
+	if !c.Supports(req.Action) {
+		return nil, fmt.Errorf("%s: %w", req.Action, ErrUnsupported)
+	}
+	return c.roundTrip(ctx, req)
+}
+
+// roundTrip sends req on the live connection, waiting for a reconnect first
+// if one is in progress.
+func (c *Client) roundTrip(ctx context.Context, req Request) (*Response, error) {
+	for {
+		c.mu.Lock()
+		state, ready, conn := c.state, c.ready, c.conn
+		c.mu.Unlock()
+
+		switch state {
+		case StateClosed:
+			return nil, ErrClosed
+		case StateConnected:
+			return c.exchange(ctx, conn, req)
+		}
+
+		select {
+		case <-ready:
+		case <-c.done:
+			return nil, ErrClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// exchange writes req to conn and waits for the matching reply.
+func (c *Client) exchange(ctx context.Context, conn net.Conn, req Request) (*Response, error) {
+	c.mu.Lock()
+	protocol := c.protocol
+	c.mu.Unlock()
+	if protocol < 2 {
+		c.legacy.Lock()
+		defer c.legacy.Unlock()
+	}
+
+	ch := make(chan *Response, 1)
+	c.mu.Lock()
+	if c.conn != conn {
+		c.mu.Unlock()
+		return nil, errConnLost
+	}
+	c.nextID++
+	id := strconv.FormatUint(c.nextID, 10)
+	c.pending[id] = ch
+	c.mu.Unlock()
 
-	data, err := json.Marshal(req)
+	data, err := json.Marshal(encode(req, id, protocol))
 	if err != nil {
+		c.forget(id)
 		return nil, err
 	}
 	data = append(data, '\n')
 
-	if _, err := c.conn.Write(data); err != nil {
+	c.writeMu.Lock()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetWriteDeadline(deadline)
+	}
+	_, err = conn.Write(data)
+	conn.SetWriteDeadline(time.Time{})
+	c.writeMu.Unlock()
+	if err != nil {
+		c.forget(id)
 		return nil, fmt.Errorf("write error: %w", err)
 	}
 
-	if !c.scanner.Scan() {
-		if err := c.scanner.Err(); err != nil {
-			return nil, fmt.Errorf("read error: %w", err)
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			if c.State() == StateClosed {
+				return nil, ErrClosed
+			}
+			return nil, errConnLost
+		}
+		return resp, nil
+	case <-ctx.Done():
+		c.forget(id)
+		if protocol < 2 {
+			// The late reply would be taken for the next call's.
+			conn.Close()
 		}
-		return nil, fmt.Errorf("connection closed")
+		return nil, ctx.Err()
 	}
+}
 
-	var resp Response
-	if err := json.Unmarshal(c.scanner.Bytes(), &resp); err != nil {
-		return nil, fmt.Errorf("decode error: %w", err)
+// encode frames req for the given protocol version.
+func encode(req Request, id string, protocol int) any {
+	if protocol < 2 {
+		return req
+	}
+	params, _ := json.Marshal(requestParams{
+		Dir:      req.Dir,
+		FilePath: req.FilePath,
+		Key:      req.Key,
+		Value:    req.Value,
+		Size:     req.Size,
+	})
+	return Request{
+		JSONRPC: jsonrpcVersion,
+		ID:      json.RawMessage(id),
+		Method:  req.Action,
+		Params:  params,
 	}
+}
 
-	return &resp, nil
+// decodeResponse accepts either framing and returns the reply's id along
+// with the legacy shape, JSON-RPC errors folded into Error and Code.
+func decodeResponse(line []byte) (string, *Response, error) {
+	var env rpcResponse
+	if err := json.Unmarshal(line, &env); err != nil {
+		return "", nil, fmt.Errorf("decode error: %w", err)
+	}
+	if env.JSONRPC == "" {
+		var resp Response
+		if err := json.Unmarshal(line, &resp); err != nil {
+			return "", nil, fmt.Errorf("decode error: %w", err)
+		}
+		return "", &resp, nil
+	}
+	id := string(env.ID)
+	if env.Error != nil {
+		return id, &Response{OK: false, Error: env.Error.Message, Code: env.Error.Code}, nil
+	}
+	if env.Result == nil {
+		return id, nil, fmt.Errorf("decode error: response has neither result nor error")
+	}
+	return id, env.Result, nil
 }
 
-func (c *Client) Ping() error {
-	resp, err := c.send(Request{Action: "ping"})
+func (c *Client) Ping(ctx context.Context) error {
+	resp, err := c.send(ctx, Request{Action: "ping"})
 	if err != nil {
 		return err
 	}
@@ -82,8 +495,33 @@ func (c *Client) Ping() error {
 	return nil
 }
 
-func (c *Client) GetLibrary(dir string) (*cache.CachedLibrary, error) {
-	resp, err := c.send(Request{Action: "library", Dir: dir})
+// DaemonStatus reports the daemon's PID, uptime and what it is working on.
+func (c *Client) DaemonStatus(ctx context.Context) (*Info, error) {
+	resp, err := c.send(ctx, Request{Action: "daemon-status"})
+	if err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return nil, fmt.Errorf("daemon status error: %s", resp.Error)
+	}
+	return resp.Daemon, nil
+}
+
+// Shutdown asks the daemon to exit. It returns once the daemon has
+// acknowledged; the daemon stops right after.
+func (c *Client) Shutdown(ctx context.Context) error {
+	resp, err := c.send(ctx, Request{Action: "shutdown"})
+	if err != nil {
+		return err
+	}
+	if !resp.OK {
+		return fmt.Errorf("shutdown error: %s", resp.Error)
+	}
+	return nil
+}
+
+func (c *Client) GetLibrary(ctx context.Context, dir string) (*cache.CachedLibrary, error) {
+	resp, err := c.send(ctx, Request{Action: "library", Dir: dir})
 	if err != nil {
 		return nil, err
 	}
@@ -93,30 +531,95 @@ func (c *Client) GetLibrary(dir string) (*cache.CachedLibrary, error) {
 	return resp.Library, nil
 }
 
-func (c *Client) Scan(dir string) (*cache.CachedLibrary, error) {
-	resp, err := c.send(Request{Action: "scan", Dir: dir})
+// Scan starts rescanning dir and returns the job without waiting for it.
+// Progress and the outcome arrive as scan-progress and scan-finished events.
+func (c *Client) Scan(ctx context.Context, dir string) (*ScanJob, error) {
+	resp, err := c.send(ctx, Request{Action: "scan", Dir: dir})
 	if err != nil {
 		return nil, err
 	}
 	if !resp.OK {
 		return nil, fmt.Errorf("scan error: %s", resp.Error)
 	}
-	return resp.Library, nil
+	if resp.Job == nil && resp.Library != nil {
+		// Daemons before protocol 3 scan synchronously.
+		return &ScanJob{Dir: dir, State: ScanDone, FileCount: resp.Library.FileCount}, nil
+	}
+	return resp.Job, nil
+}
+
+// ScanStatus returns the job with the given ID, or every running and recently
+// finished job when id is empty.
+func (c *Client) ScanStatus(ctx context.Context, id string) ([]ScanJob, error) {
+	resp, err := c.send(ctx, Request{Action: "scan-status", Value: id})
+	if err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return nil, fmt.Errorf("scan status error: %s", resp.Error)
+	}
+	if resp.Job != nil {
+		return []ScanJob{*resp.Job}, nil
+	}
+	return resp.Jobs, nil
 }
 
-func (c *Client) GetCoverArt(filePath string) (string, string, error) {
-	resp, err := c.send(Request{Action: "cover-art", FilePath: filePath})
+// CancelScan stops a running scan job and returns its final state.
+func (c *Client) CancelScan(ctx context.Context, id string) (*ScanJob, error) {
+	resp, err := c.send(ctx, Request{Action: "scan-cancel", Value: id})
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 	if !resp.OK {
-		return "", "", fmt.Errorf("cover art error: %s", resp.Error)
+		return nil, fmt.Errorf("scan cancel error: %s", resp.Error)
 	}
-	return resp.CoverArt, resp.CoverMime, nil
+	return resp.Job, nil
+}
+
+// GetCoverArt returns the cover of the track at filePath at size (see
+// artwork.Sizes), or nil when it has none.
+func (c *Client) GetCoverArt(ctx context.Context, filePath string, size int) (*Cover, error) {
+	return c.coverArt(ctx, Request{Action: "cover-art", FilePath: filePath, Size: artwork.FormatSize(size)})
 }
 
-func (c *Client) GetFavorites() ([]string, error) {
-	resp, err := c.send(Request{Action: "get-favorites"})
+// GetCoverArtByID returns an image from the daemon's artwork store at size.
+func (c *Client) GetCoverArtByID(ctx context.Context, id string, size int) (*Cover, error) {
+	return c.coverArt(ctx, Request{Action: "cover-art", Value: id, Size: artwork.FormatSize(size)})
+}
+
+// GetPictures lists every picture embedded in the track at filePath. Fetch
+// one with GetCoverArtByID.
+func (c *Client) GetPictures(ctx context.Context, filePath string) ([]metadata.Picture, error) {
+	resp, err := c.send(ctx, Request{Action: "pictures", FilePath: filePath})
+	if err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return nil, fmt.Errorf("pictures error: %s", resp.Error)
+	}
+	return resp.Pictures, nil
+}
+
+func (c *Client) coverArt(ctx context.Context, req Request) (*Cover, error) {
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return nil, fmt.Errorf("cover art error: %s", resp.Error)
+	}
+	if resp.CoverArt == "" {
+		return nil, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(resp.CoverArt)
+	if err != nil {
+		return nil, fmt.Errorf("cover art error: %w", err)
+	}
+	return &Cover{ID: resp.CoverID, Data: data, Mime: resp.CoverMime, Modified: resp.CoverTime}, nil
+}
+
+func (c *Client) GetFavorites(ctx context.Context) ([]string, error) {
+	resp, err := c.send(ctx, Request{Action: "get-favorites"})
 	if err != nil {
 		return nil, err
 	}
@@ -126,8 +629,8 @@ func (c *Client) GetFavorites() ([]string, error) {
 	return resp.Favorites, nil
 }
 
-func (c *Client) AddFavorite(filePath string) error {
-	resp, err := c.send(Request{Action: "add-favorite", FilePath: filePath})
+func (c *Client) AddFavorite(ctx context.Context, filePath string) error {
+	resp, err := c.send(ctx, Request{Action: "add-favorite", FilePath: filePath})
 	if err != nil {
 		return err
 	}
@@ -137,8 +640,8 @@ func (c *Client) AddFavorite(filePath string) error {
 	return nil
 }
 
-func (c *Client) RemoveFavorite(filePath string) error {
-	resp, err := c.send(Request{Action: "remove-favorite", FilePath: filePath})
+func (c *Client) RemoveFavorite(ctx context.Context, filePath string) error {
+	resp, err := c.send(ctx, Request{Action: "remove-favorite", FilePath: filePath})
 	if err != nil {
 		return err
 	}
@@ -148,8 +651,8 @@ func (c *Client) RemoveFavorite(filePath string) error {
 	return nil
 }
 
-func (c *Client) IsFavorite(filePath string) (bool, error) {
-	resp, err := c.send(Request{Action: "is-favorite", FilePath: filePath})
+func (c *Client) IsFavorite(ctx context.Context, filePath string) (bool, error) {
+	resp, err := c.send(ctx, Request{Action: "is-favorite", FilePath: filePath})
 	if err != nil {
 		return false, err
 	}
@@ -159,8 +662,8 @@ func (c *Client) IsFavorite(filePath string) (bool, error) {
 	return resp.IsFav, nil
 }
 
-func (c *Client) GetSettings() (*store.Settings, error) {
-	resp, err := c.send(Request{Action: "get-settings"})
+func (c *Client) GetSettings(ctx context.Context) (*store.Settings, error) {
+	resp, err := c.send(ctx, Request{Action: "get-settings"})
 	if err != nil {
 		return nil, err
 	}
@@ -170,12 +673,12 @@ func (c *Client) GetSettings() (*store.Settings, error) {
 	return resp.Settings, nil
 }
 
-func (c *Client) SaveSettings(settings *store.Settings) error {
+func (c *Client) SaveSettings(ctx context.Context, settings *store.Settings) error {
 	data, err := json.Marshal(settings)
 	if err != nil {
 		return err
 	}
-	resp, err := c.send(Request{Action: "save-settings", Value: string(data)})
+	resp, err := c.send(ctx, Request{Action: "save-settings", Value: string(data)})
 	if err != nil {
 		return err
 	}
@@ -185,8 +688,8 @@ func (c *Client) SaveSettings(settings *store.Settings) error {
 	return nil
 }
 
-func (c *Client) GetStats() (map[string]int, error) {
-	resp, err := c.send(Request{Action: "get-stats"})
+func (c *Client) GetStats(ctx context.Context) (map[string]int, error) {
+	resp, err := c.send(ctx, Request{Action: "get-stats"})
 	if err != nil {
 		return nil, err
 	}
@@ -196,8 +699,28 @@ func (c *Client) GetStats() (map[string]int, error) {
 	return resp.Stats, nil
 }
 
-func (c *Client) RecordPlay(filePath string) error {
-	resp, err := c.send(Request{Action: "record-play", FilePath: filePath})
+// Search returns the tracks matching a query such as
+// `artist:"miles davis" year:1955..1965`, best match first, from the
+// library in dir or from every loaded library when dir is empty. offset and
+// limit pick a page of results, limit 0 meaning all; the number of matches
+// in all comes back with them.
+func (c *Client) Search(ctx context.Context, dir, query string, offset, limit int) ([]metadata.AudioFile, int, error) {
+	data, err := json.Marshal(SearchOptions{Offset: offset, Limit: limit})
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := c.send(ctx, Request{Action: "search", Dir: dir, Key: query, Value: string(data)})
+	if err != nil {
+		return nil, 0, err
+	}
+	if !resp.OK {
+		return nil, 0, fmt.Errorf("search error: %s", resp.Error)
+	}
+	return resp.Tracks, resp.Total, nil
+}
+
+func (c *Client) RecordPlay(ctx context.Context, filePath string) error {
+	resp, err := c.send(ctx, Request{Action: "record-play", FilePath: filePath})
 	if err != nil {
 		return err
 	}
@@ -207,8 +730,8 @@ func (c *Client) RecordPlay(filePath string) error {
 	return nil
 }
 
-func (c *Client) GetQueue() (*store.QueueState, error) {
-	resp, err := c.send(Request{Action: "get-queue"})
+func (c *Client) GetQueue(ctx context.Context) (*store.QueueState, error) {
+	resp, err := c.send(ctx, Request{Action: "get-queue"})
 	if err != nil {
 		return nil, err
 	}
@@ -218,12 +741,12 @@ func (c *Client) GetQueue() (*store.QueueState, error) {
 	return resp.Queue, nil
 }
 
-func (c *Client) SaveQueue(q *store.QueueState) error {
+func (c *Client) SaveQueue(ctx context.Context, q *store.QueueState) error {
 	data, err := json.Marshal(q)
 	if err != nil {
 		return err
 	}
-	resp, err := c.send(Request{Action: "save-queue", Value: string(data)})
+	resp, err := c.send(ctx, Request{Action: "save-queue", Value: string(data)})
 	if err != nil {
 		return err
 	}
@@ -233,6 +756,325 @@ func (c *Client) SaveQueue(q *store.QueueState) error {
 	return nil
 }
 
+// Playlists lists every playlist without its tracks.
+func (c *Client) Playlists(ctx context.Context) ([]Playlist, error) {
+	resp, err := c.send(ctx, Request{Action: "playlists"})
+	if err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return nil, fmt.Errorf("playlists error: %s", resp.Error)
+	}
+	return resp.Playlists, nil
+}
+
+// GetPlaylist returns the playlist with ID or name ref and its tracks.
+func (c *Client) GetPlaylist(ctx context.Context, ref string) (*Playlist, error) {
+	resp, err := c.send(ctx, Request{Action: "playlist-get", Key: ref})
+	if err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return nil, responseError("playlist", resp)
+	}
+	return resp.Playlist, nil
+}
+
+// CreatePlaylist creates a playlist of the tracks at paths.
+func (c *Client) CreatePlaylist(ctx context.Context, name string, paths []string) (*Playlist, error) {
+	return c.editPlaylist(ctx, "playlist-create", "", PlaylistEdit{Name: name, FilePaths: paths})
+}
+
+// CreateSmartPlaylist creates a playlist of the library tracks matching
+// rule, sorted by sort and cut to limit tracks when limit is above zero.
+func (c *Client) CreateSmartPlaylist(ctx context.Context, name, rule, sort string, limit int) (*Playlist, error) {
+	return c.editPlaylist(ctx, "playlist-create", "", PlaylistEdit{Name: name, Rule: rule, Sort: sort, Limit: limit})
+}
+
+// SetPlaylistRule changes the rule, sort and limit of a smart playlist.
+func (c *Client) SetPlaylistRule(ctx context.Context, ref, rule, sort string, limit int) (*Playlist, error) {
+	return c.editPlaylist(ctx, "playlist-set-rule", ref, PlaylistEdit{Rule: rule, Sort: sort, Limit: limit})
+}
+
+func (c *Client) RenamePlaylist(ctx context.Context, ref, name string) (*Playlist, error) {
+	return c.editPlaylist(ctx, "playlist-rename", ref, PlaylistEdit{Name: name})
+}
+
+func (c *Client) DeletePlaylist(ctx context.Context, ref string) error {
+	_, err := c.editPlaylist(ctx, "playlist-delete", ref, PlaylistEdit{})
+	return err
+}
+
+// AddToPlaylist appends the tracks at paths to a playlist.
+func (c *Client) AddToPlaylist(ctx context.Context, ref string, paths []string) (*Playlist, error) {
+	return c.editPlaylist(ctx, "playlist-add", ref, PlaylistEdit{FilePaths: paths})
+}
+
+// RemoveFromPlaylist removes the entries at positions from a playlist.
+func (c *Client) RemoveFromPlaylist(ctx context.Context, ref string, positions []int) (*Playlist, error) {
+	return c.editPlaylist(ctx, "playlist-remove", ref, PlaylistEdit{Positions: positions})
+}
+
+// MoveInPlaylist moves the entry at position from to position to.
+func (c *Client) MoveInPlaylist(ctx context.Context, ref string, from, to int) (*Playlist, error) {
+	return c.editPlaylist(ctx, "playlist-move", ref, PlaylistEdit{From: from, To: to})
+}
+
+// ImportPlaylist saves the library tracks listed in the M3U, M3U8 or PLS
+// file at path as a new playlist, named after the file when name is empty.
+// Entries that match no library track are listed in the result's
+// Unresolved.
+func (c *Client) ImportPlaylist(ctx context.Context, path, name string) (*Playlist, error) {
+	resp, err := c.send(ctx, Request{Action: "playlist-import", FilePath: path, Key: name})
+	if err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return nil, fmt.Errorf("playlist import error: %s", resp.Error)
+	}
+	return resp.Playlist, nil
+}
+
+func (c *Client) editPlaylist(ctx context.Context, action, ref string, edit PlaylistEdit) (*Playlist, error) {
+	data, err := json.Marshal(edit)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.send(ctx, Request{Action: action, Key: ref, Value: string(data)})
+	if err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return nil, responseError(action, resp)
+	}
+	return resp.Playlist, nil
+}
+
+func (c *Client) playback(ctx context.Context, req Request) (*playback.Status, error) {
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return resp.Player, fmt.Errorf("%s error: %s", req.Action, resp.Error)
+	}
+	return resp.Player, nil
+}
+
+// Play replaces the daemon's queue with q and starts playing it. A nil q
+// resumes or starts the current queue.
+func (c *Client) Play(ctx context.Context, q *store.QueueState) (*playback.Status, error) {
+	req := Request{Action: "play"}
+	if q != nil {
+		data, err := json.Marshal(q)
+		if err != nil {
+			return nil, err
+		}
+		req.Value = string(data)
+	}
+	return c.playback(ctx, req)
+}
+
+// PlayQueue replaces the queue with q and starts playing. It also returns
+// the queue as the daemon resolved it: files it could not read are left out
+// and CurrentIndex is moved to keep pointing at the same track.
+func (c *Client) PlayQueue(ctx context.Context, q *store.QueueState) (*playback.Status, *store.QueueState, error) {
+	data, err := json.Marshal(q)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := c.send(ctx, Request{Action: "play", Value: string(data)})
+	if err != nil {
+		return nil, nil, err
+	}
+	if !resp.OK {
+		return resp.Player, nil, fmt.Errorf("play error: %s", resp.Error)
+	}
+	return resp.Player, resp.Queue, nil
+}
+
+func (c *Client) Pause(ctx context.Context) (*playback.Status, error) {
+	return c.playback(ctx, Request{Action: "pause"})
+}
+
+func (c *Client) TogglePause(ctx context.Context) (*playback.Status, error) {
+	return c.playback(ctx, Request{Action: "toggle"})
+}
+
+func (c *Client) Stop(ctx context.Context) (*playback.Status, error) {
+	return c.playback(ctx, Request{Action: "stop"})
+}
+
+func (c *Client) Next(ctx context.Context) (*playback.Status, error) {
+	return c.playback(ctx, Request{Action: "next"})
+}
+
+func (c *Client) Previous(ctx context.Context) (*playback.Status, error) {
+	return c.playback(ctx, Request{Action: "prev"})
+}
+
+// Seek takes a position in seconds, or a relative offset prefixed with + or -.
+func (c *Client) Seek(ctx context.Context, value string) (*playback.Status, error) {
+	return c.playback(ctx, Request{Action: "seek", Value: value})
+}
+
+// SetVolume takes a percentage, or a relative change prefixed with + or -.
+func (c *Client) SetVolume(ctx context.Context, value string) (*playback.Status, error) {
+	return c.playback(ctx, Request{Action: "set-volume", Value: value})
+}
+
+func (c *Client) ToggleMute(ctx context.Context) (*playback.Status, error) {
+	return c.playback(ctx, Request{Action: "mute"})
+}
+
+// SetShuffle takes "on" or "off"; an empty value toggles.
+func (c *Client) SetShuffle(ctx context.Context, value string) (*playback.Status, error) {
+	return c.playback(ctx, Request{Action: "shuffle", Value: value})
+}
+
+// SetRepeat takes "off", "all" or "one"; an empty value cycles.
+func (c *Client) SetRepeat(ctx context.Context, value string) (*playback.Status, error) {
+	return c.playback(ctx, Request{Action: "repeat", Value: value})
+}
+
+// QueueAdd appends a file, or every cached track inside a directory, to the
+// end of the queue.
+func (c *Client) QueueAdd(ctx context.Context, path string) (*playback.Status, error) {
+	return c.playback(ctx, Request{Action: "queue-add", FilePath: path})
+}
+
+func (c *Client) ClearQueue(ctx context.Context) (*playback.Status, error) {
+	return c.playback(ctx, Request{Action: "queue-clear"})
+}
+
+func (c *Client) PlayerStatus(ctx context.Context) (*playback.Status, error) {
+	return c.playback(ctx, Request{Action: "status"})
+}
+
+// Subscribe streams daemon events over a dedicated connection. If that
+// connection drops it is re-established with backoff, and an
+// EventResubscribed is delivered because events may have been missed in
+// between. The returned channel is closed when ctx is cancelled or the
+// client is closed.
+func (c *Client) Subscribe(ctx context.Context) <-chan Event {
+	events := make(chan Event, 64)
+
+	go func() {
+		defer close(events)
+
+		backoff := minBackoff
+		subscribed := false
+		for {
+			if conn, sc, err := c.openSubscription(); err == nil {
+				if subscribed {
+					select {
+					case events <- Event{Type: EventResubscribed}:
+					case <-ctx.Done():
+						conn.Close()
+						return
+					}
+				}
+				subscribed = true
+				backoff = minBackoff
+				c.stream(ctx, conn, sc, events)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-c.done:
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, maxBackoff)
+		}
+	}()
+
+	return events
+}
+
+func (c *Client) openSubscription() (net.Conn, *bufio.Scanner, error) {
+	conn, err := net.DialTimeout("unix", SocketPath(), 2*time.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sc := bufio.NewScanner(conn)
+	sc.Buffer(make([]byte, 0), 64*1024*1024)
+
+	data, _ := json.Marshal(encode(Request{Action: "subscribe"}, "0", c.Protocol()))
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if !sc.Scan() {
+		conn.Close()
+		return nil, nil, errConnLost
+	}
+	_, resp, err := decodeResponse(sc.Bytes())
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if !resp.OK {
+		conn.Close()
+		return nil, nil, fmt.Errorf("subscribe error: %s", resp.Error)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, sc, nil
+}
+
+// stream forwards events from conn until it drops, ctx is cancelled or the
+// client is closed.
+func (c *Client) stream(ctx context.Context, conn net.Conn, sc *bufio.Scanner, events chan<- Event) {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-c.done:
+		case <-stop:
+		}
+		conn.Close()
+	}()
+
+	for sc.Scan() {
+		ev, ok := decodeEvent(sc.Bytes())
+		if !ok {
+			continue
+		}
+		select {
+		case events <- ev:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// decodeEvent reads a bare event or a JSON-RPC "event" notification.
+func decodeEvent(line []byte) (Event, bool) {
+	var note struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Method  string          `json:"method"`
+		Params  json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(line, &note); err != nil {
+		return Event{}, false
+	}
+	if note.JSONRPC != "" {
+		if note.Method != "event" {
+			return Event{}, false
+		}
+		line = note.Params
+	}
+	var ev Event
+	if err := json.Unmarshal(line, &ev); err != nil {
+		return Event{}, false
+	}
+	return ev, true
+}
+
 func IsRunning() bool {
 	sockPath := SocketPath()
 	conn, err := net.DialTimeout("unix", sockPath, 500*time.Millisecond)
@@ -10,41 +10,101 @@ import (
 	"sync"
 	"time"
 
+	"github.com/hoppxi/bpv/internal/artwork"
 	"github.com/hoppxi/bpv/internal/cache"
+	"github.com/hoppxi/bpv/internal/index"
 	"github.com/hoppxi/bpv/internal/logger"
 	"github.com/hoppxi/bpv/internal/metadata"
-	"github.com/hoppxi/bpv/internal/scanner"
+	"github.com/hoppxi/bpv/internal/mpd"
+	"github.com/hoppxi/bpv/internal/playback"
+	"github.com/hoppxi/bpv/internal/smart"
 	"github.com/hoppxi/bpv/internal/store"
+	"github.com/hoppxi/bpv/internal/watcher"
 	"github.com/hoppxi/bpv/internal/xdg"
 )
 
-type Request struct {
-	Action   string `json:"action"`
-	Dir      string `json:"dir,omitempty"`
-	FilePath string `json:"file_path,omitempty"`
-	Key      string `json:"key,omitempty"`
-	Value    string `json:"value,omitempty"`
-}
+// Version is the daemon build version reported by hello. cmd/bpvd sets it.
+var Version = "dev"
 
 type Response struct {
 	OK        bool                 `json:"ok"`
 	Error     string               `json:"error,omitempty"`
+	Code      int                  `json:"code,omitempty"`
+	Protocol  int                  `json:"protocol,omitempty"`
+	Version   string               `json:"version,omitempty"`
+	Actions   []string             `json:"actions,omitempty"`
 	Library   *cache.CachedLibrary `json:"library,omitempty"`
 	Favorites []string             `json:"favorites,omitempty"`
 	Settings  *store.Settings      `json:"settings,omitempty"`
 	Stats     map[string]int       `json:"stats,omitempty"`
+	CoverID   string               `json:"cover_id,omitempty"`
 	CoverArt  string               `json:"cover_art,omitempty"`
 	CoverMime string               `json:"cover_mime,omitempty"`
+	CoverTime time.Time            `json:"cover_time,omitzero"`
+	Pictures  []metadata.Picture   `json:"pictures,omitempty"`
 	IsFav     bool                 `json:"is_fav,omitempty"`
 	Queue     *store.QueueState    `json:"queue,omitempty"`
+	Player    *playback.Status     `json:"player,omitempty"`
+	Daemon    *Info                `json:"daemon,omitempty"`
+	Job       *ScanJob             `json:"job,omitempty"`
+	Jobs      []ScanJob            `json:"jobs,omitempty"`
+	Playlists []Playlist           `json:"playlists,omitempty"`
+	Playlist  *Playlist            `json:"playlist,omitempty"`
+	Tracks    []metadata.AudioFile `json:"tracks,omitempty"`
+	Total     int                  `json:"total,omitempty"`
 }
 
 type Daemon struct {
 	store    *store.Store
 	cache    *cache.Cache
+	artwork  *artwork.Store
 	listener net.Listener
-	mu       sync.Mutex
-	scanning map[string]bool
+	// activated is set when the socket came from systemd, which owns the
+	// socket file.
+	activated bool
+	lock      *os.File
+	mu        sync.Mutex
+	// scanning maps a directory to the job scanning it; jobs holds running
+	// and recently finished jobs by ID.
+	scanning  map[string]*scanJob
+	jobs      map[string]*scanJob
+	nextJobID int
+	// scanWorkers is how many files a scan reads at once; 0 means the
+	// scanner's default.
+	scanWorkers int
+	// folderArt lists the image names used as cover art for tracks without
+	// embedded pictures; nil means the extractor's default.
+	folderArt []string
+	// watcher keeps loaded libraries up to date; nil when inotify is not
+	// available.
+	watcher *watcher.Watcher
+
+	// smart holds evaluated smart playlists by ID, and smartLibrary the
+	// tracks they were evaluated against; both are dropped when the
+	// library, play counts or favorites change.
+	smartMu      sync.Mutex
+	smart        map[string]smartResult
+	smartLibrary []smart.Track
+
+	// index serves search; indexGen is the cache generation it was last
+	// brought up to date with.
+	index    *index.Index
+	indexMu  sync.Mutex
+	indexGen uint64
+
+	player *playback.Player
+	playMu sync.Mutex
+
+	started  time.Time
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	events  *eventHub
+	actions map[string]func(Request) Response
+
+	mpdAddr string
+	mpd     *mpd.Server
 }
 
 func SocketPath() string {
@@ -62,51 +122,146 @@ func NewDaemon() (*Daemon, error) {
 		return nil, logger.Log.Error("failed to create cache: %w", err)
 	}
 
-	return &Daemon{
+	art, err := artwork.NewStore()
+	if err != nil {
+		return nil, logger.Log.Error("failed to create artwork store: %w", err)
+	}
+
+	d := &Daemon{
 		store:    st,
 		cache:    ch,
-		scanning: make(map[string]bool),
-	}, nil
+		artwork:  art,
+		scanning: make(map[string]*scanJob),
+		jobs:     make(map[string]*scanJob),
+		player:   playback.NewPlayer(),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		events:   newEventHub(),
+		index:    index.New(),
+	}
+	d.actions = d.actionTable()
+	return d, nil
 }
 
 func (d *Daemon) Start() error {
-	sockPath := SocketPath()
-
-	os.Remove(sockPath)
-	os.MkdirAll(filepath.Dir(sockPath), 0755)
+	lock, err := acquireLock()
+	if err != nil {
+		return err
+	}
+	d.lock = lock
 
-	listener, err := net.Listen("unix", sockPath)
+	listener, err := d.listen()
 	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", sockPath, err)
+		return err
 	}
 	d.listener = listener
-	os.Chmod(sockPath, 0700)
+	d.started = time.Now()
 
 	logger.Log.Info("Daemon started")
-	logger.Log.Info("Socket: %s", sockPath)
+	if d.activated {
+		logger.Log.Info("Socket: %s (socket activated)", listener.Addr())
+	} else {
+		logger.Log.Info("Socket: %s", listener.Addr())
+	}
+
+	d.restoreQueue()
+	go d.watchPlayback()
+	d.startWatcher()
+
+	if d.mpdAddr != "" {
+		if err := d.startMPD(); err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to listen for MPD clients on %s: %w", d.mpdAddr, err)
+		}
+	}
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			select {
+			case <-d.done:
+				// Stop closed the listener; let it finish cleaning up.
+				<-d.stopped
+			default:
+			}
 			return nil
 		}
 		go d.handleConnection(conn)
 	}
 }
 
+// listen uses the socket passed in by systemd if there is one, and otherwise
+// creates bpv.sock. Holding the lock makes it safe to remove a stale socket.
+func (d *Daemon) listen() (net.Listener, error) {
+	listener, err := activationListener()
+	if err != nil {
+		return nil, err
+	}
+	if listener != nil {
+		d.activated = true
+		return listener, nil
+	}
+
+	sockPath := SocketPath()
+	os.Remove(sockPath)
+	os.MkdirAll(filepath.Dir(sockPath), 0755)
+
+	listener, err = net.Listen("unix", sockPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", sockPath, err)
+	}
+	os.Chmod(sockPath, 0700)
+	return listener, nil
+}
+
+// Stop shuts the daemon down. It is safe to call more than once.
 func (d *Daemon) Stop() {
+	d.stopOnce.Do(d.stop)
+}
+
+func (d *Daemon) stop() {
+	defer close(d.stopped)
+	close(d.done)
+	d.cancelScans()
+	d.mu.Lock()
+	if d.watcher != nil {
+		d.watcher.Close()
+	}
+	d.mu.Unlock()
+	d.player.Close()
+	if d.mpd != nil {
+		d.mpd.Close()
+	}
 	if d.listener != nil {
 		d.listener.Close()
-		os.Remove(SocketPath())
+		// An activated socket belongs to systemd, which keeps listening on
+		// it to start us again on the next connection.
+		if !d.activated {
+			os.Remove(SocketPath())
+		}
+	}
+	if d.lock != nil {
+		d.lock.Close()
 	}
 }
 
+// maxInFlight bounds how many requests one connection can have running at
+// once. Reading stops until a slot frees up.
+const maxInFlight = 32
+
 func (d *Daemon) handleConnection(conn net.Conn) {
 	defer conn.Close()
 
 	scanner := bufio.NewScanner(conn)
 	scanner.Buffer(make([]byte, 0), 64*1024*1024)
 
+	// JSON-RPC requests carry an id, so they run concurrently and answer in
+	// whatever order they finish. Legacy requests have nothing to correlate
+	// replies with and are handled in order.
+	var inflight sync.WaitGroup
+	slots := make(chan struct{}, maxInFlight)
+	defer inflight.Wait()
+
 	for scanner.Scan() {
 		line := scanner.Bytes()
 		if len(line) == 0 {
@@ -115,140 +270,161 @@ func (d *Daemon) handleConnection(conn net.Conn) {
 
 		var req Request
 		if err := json.Unmarshal(line, &req); err != nil {
-			d.sendResponse(conn, Response{OK: false, Error: "invalid request: " + err.Error()})
+			if !isNotificationLine(line) {
+				d.writeLine(conn, parseError(line, err))
+			}
+			continue
+		}
+		if rpcErr := req.normalize(); rpcErr != nil {
+			if !req.IsNotification() {
+				d.sendResponse(conn, &req, Response{OK: false, Error: rpcErr.Message, Code: rpcErr.Code})
+			}
 			continue
 		}
 
-		resp := d.handleRequest(req)
-		d.sendResponse(conn, resp)
-	}
-}
+		if req.Action == "subscribe" {
+			inflight.Wait()
+			d.streamEvents(conn, scanner, &req)
+			return
+		}
 
-func (d *Daemon) sendResponse(conn net.Conn, resp Response) {
-	data, err := json.Marshal(resp)
-	if err != nil {
-		return
-	}
-	data = append(data, '\n')
-	conn.Write(data)
-}
+		if !req.IsRPC() {
+			d.reply(conn, &req, d.handleRequest(req))
+			continue
+		}
 
-func (d *Daemon) handleRequest(req Request) Response {
-	switch req.Action {
-	case "ping":
-		return Response{OK: true}
-	case "library":
-		return d.handleLibrary(req.Dir)
-	case "scan":
-		return d.handleScan(req.Dir)
-	case "cover-art":
-		return d.handleCoverArt(req.FilePath)
-	case "get-favorites":
-		return d.handleGetFavorites()
-	case "add-favorite":
-		return d.handleAddFavorite(req.FilePath)
-	case "remove-favorite":
-		return d.handleRemoveFavorite(req.FilePath)
-	case "is-favorite":
-		return d.handleIsFavorite(req.FilePath)
-	case "get-settings":
-		return d.handleGetSettings()
-	case "save-settings":
-		return d.handleSaveSettings(req.Value)
-	case "get-stats":
-		return d.handleGetStats()
-	case "record-play":
-		return d.handleRecordPlay(req.FilePath)
-	case "get-queue":
-		return d.handleGetQueue()
-	case "save-queue":
-		return d.handleSaveQueue(req.Value)
-	default:
-		return Response{OK: false, Error: "unknown action: " + req.Action}
+		slots <- struct{}{}
+		inflight.Add(1)
+		go func(req Request) {
+			defer inflight.Done()
+			defer func() { <-slots }()
+			d.reply(conn, &req, d.handleRequest(req))
+		}(req)
 	}
 }
 
-func (d *Daemon) handleLibrary(dir string) Response {
-	if dir == "" {
-		return Response{OK: false, Error: "dir is required"}
-	}
+// streamEvents turns the connection into a one-way stream of events. It
+// returns when the client hangs up or the daemon stops.
+func (d *Daemon) streamEvents(conn net.Conn, sc *bufio.Scanner, req *Request) {
+	ch := d.events.subscribe()
+	defer d.events.unsubscribe(ch)
 
-	lib := d.cache.Load(dir)
-	if lib != nil {
-		return Response{OK: true, Library: lib}
+	if err := d.sendResponse(conn, req, Response{OK: true}); err != nil {
+		return
 	}
 
-	return d.scanAndCache(dir)
-}
+	// Anything the client sends after subscribing is ignored; reading only
+	// tells us when it disconnects.
+	closed := make(chan struct{})
+	go func() {
+		for sc.Scan() {
+		}
+		close(closed)
+	}()
 
-func (d *Daemon) handleScan(dir string) Response {
-	if dir == "" {
-		return Response{OK: false, Error: "dir is required"}
+	for {
+		select {
+		case ev := <-ch:
+			var msg any = ev
+			if req.IsRPC() {
+				msg = rpcNotification{JSONRPC: jsonrpcVersion, Method: "event", Params: ev}
+			}
+			if err := d.writeLine(conn, msg); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-d.done:
+			return
+		}
 	}
-	d.cache.Invalidate(dir)
-	return d.scanAndCache(dir)
 }
 
-func (d *Daemon) scanAndCache(dir string) Response {
-	d.mu.Lock()
-	if d.scanning[dir] {
-		d.mu.Unlock()
-		return Response{OK: false, Error: "scan already in progress for " + dir}
-	}
-	d.scanning[dir] = true
-	d.mu.Unlock()
-
-	defer func() {
-		d.mu.Lock()
-		delete(d.scanning, dir)
-		d.mu.Unlock()
-	}()
+func (d *Daemon) sendResponse(conn net.Conn, req *Request, resp Response) error {
+	return d.writeLine(conn, frame(req, resp))
+}
 
-	sc := scanner.NewScanner()
-	result, err := sc.ScanLibrary(dir)
+// writeLine sends v as one line. A net.Conn serialises whole Write calls, so
+// concurrent handlers on the same connection never interleave their lines.
+func (d *Daemon) writeLine(conn net.Conn, v any) error {
+	data, err := json.Marshal(v)
 	if err != nil {
-		return Response{OK: false, Error: "scan failed: " + err.Error()}
+		return err
 	}
+	data = append(data, '\n')
+	_, err = conn.Write(data)
+	return err
+}
 
-	lib := &cache.CachedLibrary{
-		Dir:       dir,
-		ScanTime:  time.Now(),
-		FileCount: result.AudioFiles,
-		Files:     result.Files,
-		Artists:   result.Artists,
-		Albums:    result.Albums,
-		Genres:    result.Genres,
-		Composers: result.Composers,
-		Errors:    result.Errors,
+// actionTable maps every action name to its handler. hello reports the keys
+// so clients can tell which actions this daemon supports.
+func (d *Daemon) actionTable() map[string]func(Request) Response {
+	actions := map[string]func(Request) Response{
+		"hello":           d.handleHello,
+		"ping":            func(Request) Response { return Response{OK: true} },
+		"library":         func(r Request) Response { return d.handleLibrary(r.Dir) },
+		"scan":            func(r Request) Response { return d.handleScan(r.Dir) },
+		"scan-status":     func(r Request) Response { return d.handleScanStatus(r.Value) },
+		"scan-cancel":     func(r Request) Response { return d.handleScanCancel(r.Value) },
+		"cover-art":       func(r Request) Response { return d.handleCoverArt(r.Value, r.FilePath, r.Size) },
+		"pictures":        func(r Request) Response { return d.handlePictures(r.FilePath) },
+		"get-favorites":   func(Request) Response { return d.handleGetFavorites() },
+		"add-favorite":    func(r Request) Response { return d.handleAddFavorite(r.FilePath) },
+		"remove-favorite": func(r Request) Response { return d.handleRemoveFavorite(r.FilePath) },
+		"is-favorite":     func(r Request) Response { return d.handleIsFavorite(r.FilePath) },
+		"get-settings":    func(Request) Response { return d.handleGetSettings() },
+		"save-settings":   func(r Request) Response { return d.handleSaveSettings(r.Value) },
+		"get-stats":       func(Request) Response { return d.handleGetStats() },
+		"record-play":     func(r Request) Response { return d.handleRecordPlay(r.FilePath) },
+		"get-queue":       func(Request) Response { return d.handleGetQueue() },
+		"save-queue":      func(r Request) Response { return d.handleSaveQueue(r.Value) },
+		"playlists":       func(Request) Response { return d.handlePlaylists() },
+		"playlist-get":    func(r Request) Response { return d.handleGetPlaylist(r.Key) },
+		"playlist-import": func(r Request) Response { return d.handleImportPlaylist(r.FilePath, r.Key) },
+		"search":          func(r Request) Response { return d.handleSearch(r.Dir, r.Key, r.Value) },
+		"daemon-status":   func(Request) Response { return d.handleDaemonStatus() },
+		"shutdown":        func(Request) Response { return d.handleShutdown() },
 	}
-
-	if err := d.cache.Save(lib); err != nil {
-		logger.Log.Error("Failed to save cache: %v", err)
+	for _, name := range []string{"playlist-create", "playlist-rename", "playlist-delete",
+		"playlist-add", "playlist-remove", "playlist-move", "playlist-set-rule"} {
+		actions[name] = func(r Request) Response { return d.handleEditPlaylist(r.Action, r.Key, r.Value) }
 	}
+	for _, name := range []string{"play", "pause", "toggle", "stop", "next", "prev", "seek",
+		"set-volume", "mute", "shuffle", "repeat", "status", "queue-add", "queue-clear"} {
+		actions[name] = d.handlePlayback
+	}
+	return actions
+}
 
-	settings, _ := d.store.GetSettings()
-	settings.LastDir = dir
-	d.store.SaveSettings(settings)
-
-	return Response{OK: true, Library: lib}
+func (d *Daemon) handleRequest(req Request) Response {
+	handler, ok := d.actions[req.Action]
+	if !ok {
+		return Response{OK: false, Error: "unknown action: " + req.Action, Code: ErrCodeMethodNotFound}
+	}
+	return handler(req)
 }
 
-func (d *Daemon) handleCoverArt(filePath string) Response {
-	if filePath == "" {
-		return Response{OK: false, Error: "file_path is required"}
+func (d *Daemon) handleHello(Request) Response {
+	return Response{
+		OK:       true,
+		Protocol: ProtocolVersion,
+		Version:  Version,
+		Actions:  sortedActions(d.actions),
 	}
+}
 
-	extractor := metadata.NewExtractor()
-	audioFile, err := extractor.ExtractFromFile(filePath)
-	if err != nil {
-		return Response{OK: false, Error: "failed to extract cover art: " + err.Error()}
+func (d *Daemon) handleLibrary(dir string) Response {
+	if dir == "" {
+		return Response{OK: false, Error: "dir is required", Code: ErrCodeInvalidParams}
 	}
 
-	return Response{
-		OK:        true,
-		CoverArt:  audioFile.CoverArt,
-		CoverMime: audioFile.CoverArtMime,
+	lib := d.cache.Load(dir)
+	if lib != nil {
+		d.watchLibrary(dir)
+		return Response{OK: true, Library: lib}
 	}
+
+	return d.scanAndWait(dir)
 }
 
 func (d *Daemon) handleGetFavorites() Response {
@@ -256,28 +432,41 @@ func (d *Daemon) handleGetFavorites() Response {
 	if err != nil {
 		return Response{OK: false, Error: err.Error()}
 	}
-	if favs == nil {
-		favs = []string{}
-	}
-	return Response{OK: true, Favorites: favs}
+	return Response{OK: true, Favorites: favoritePaths(favs)}
 }
 
 func (d *Daemon) handleAddFavorite(filePath string) Response {
-	if err := d.store.AddFavorite(filePath); err != nil {
+	if err := d.store.AddFavorite(d.trackRef(filePath)); err != nil {
 		return Response{OK: false, Error: err.Error()}
 	}
+	d.publishFavorites()
+	if d.refreshSmart() {
+		d.publishPlaylists()
+	}
 	return Response{OK: true}
 }
 
 func (d *Daemon) handleRemoveFavorite(filePath string) Response {
-	if err := d.store.RemoveFavorite(filePath); err != nil {
+	if err := d.store.RemoveFavorite(d.trackRef(filePath)); err != nil {
 		return Response{OK: false, Error: err.Error()}
 	}
+	d.publishFavorites()
+	if d.refreshSmart() {
+		d.publishPlaylists()
+	}
 	return Response{OK: true}
 }
 
+func (d *Daemon) publishFavorites() {
+	favs, err := d.store.GetFavorites()
+	if err != nil {
+		return
+	}
+	d.events.publish(Event{Type: EventFavoritesChanged, Favorites: favoritePaths(favs)})
+}
+
 func (d *Daemon) handleIsFavorite(filePath string) Response {
-	isFav, err := d.store.IsFavorite(filePath)
+	isFav, err := d.store.IsFavorite(d.trackRef(filePath))
 	if err != nil {
 		return Response{OK: false, Error: err.Error()}
 	}
@@ -295,26 +484,36 @@ func (d *Daemon) handleGetSettings() Response {
 func (d *Daemon) handleSaveSettings(value string) Response {
 	var settings store.Settings
 	if err := json.Unmarshal([]byte(value), &settings); err != nil {
-		return Response{OK: false, Error: "invalid settings JSON: " + err.Error()}
+		return Response{OK: false, Error: "invalid settings JSON: " + err.Error(), Code: ErrCodeInvalidParams}
 	}
 	if err := d.store.SaveSettings(&settings); err != nil {
 		return Response{OK: false, Error: err.Error()}
 	}
+	d.events.publish(Event{Type: EventSettingsChanged, Settings: &settings})
 	return Response{OK: true}
 }
 
 func (d *Daemon) handleGetStats() Response {
-	stats, err := d.store.GetPlayStats()
+	counts, err := d.store.GetPlayStats()
 	if err != nil {
 		return Response{OK: false, Error: err.Error()}
 	}
+	// Clients know tracks by path.
+	stats := make(map[string]int, len(counts))
+	for _, c := range counts {
+		stats[c.Path] += c.Count
+	}
 	return Response{OK: true, Stats: stats}
 }
 
 func (d *Daemon) handleRecordPlay(filePath string) Response {
-	if err := d.store.RecordPlay(filePath); err != nil {
+	if err := d.store.RecordPlay(d.trackRef(filePath)); err != nil {
 		return Response{OK: false, Error: err.Error()}
 	}
+	d.events.publish(Event{Type: EventPlayRecorded, FilePath: filePath})
+	if d.refreshSmart() {
+		d.publishPlaylists()
+	}
 	return Response{OK: true}
 }
 
@@ -329,10 +528,14 @@ func (d *Daemon) handleGetQueue() Response {
 func (d *Daemon) handleSaveQueue(value string) Response {
 	var q store.QueueState
 	if err := json.Unmarshal([]byte(value), &q); err != nil {
-		return Response{OK: false, Error: "invalid queue JSON: " + err.Error()}
+		return Response{OK: false, Error: "invalid queue JSON: " + err.Error(), Code: ErrCodeInvalidParams}
+	}
+	if len(q.TrackIDs) != len(q.FilePaths) {
+		q.TrackIDs = d.trackIDs(q.FilePaths)
 	}
 	if err := d.store.SaveQueue(&q); err != nil {
 		return Response{OK: false, Error: err.Error()}
 	}
+	d.events.publish(Event{Type: EventQueueChanged, Queue: &q})
 	return Response{OK: true}
 }
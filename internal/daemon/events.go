@@ -0,0 +1,77 @@
+package daemon
+
+import (
+	"sync"
+
+	"github.com/hoppxi/bpv/internal/playback"
+	"github.com/hoppxi/bpv/internal/scanner"
+	"github.com/hoppxi/bpv/internal/store"
+)
+
+// Event types pushed to subscribers.
+const (
+	EventLibraryUpdated   = "library-updated"
+	EventFavoritesChanged = "favorites-changed"
+	EventQueueChanged     = "queue-changed"
+	EventSettingsChanged  = "settings-changed"
+	EventScanProgress     = "scan-progress"
+	EventScanFinished     = "scan-finished"
+	EventPlayRecorded     = "play-recorded"
+	EventPlayerChanged    = "player-changed"
+	EventPlaylistsChanged = "playlists-changed"
+
+	// EventResubscribed is generated by Client.Subscribe, not the daemon,
+	// after it reconnects. Subscribers should refetch anything they cache.
+	EventResubscribed = "resubscribed"
+)
+
+// Event is a change notification streamed to connections that sent a
+// subscribe request. Only the fields relevant to Type are set.
+type Event struct {
+	Type      string                `json:"event"`
+	Dir       string                `json:"dir,omitempty"`
+	FilePath  string                `json:"file_path,omitempty"`
+	Favorites []string              `json:"favorites,omitempty"`
+	Queue     *store.QueueState     `json:"queue,omitempty"`
+	Settings  *store.Settings       `json:"settings,omitempty"`
+	Progress  *scanner.ScanProgress `json:"progress,omitempty"`
+	Player    *playback.Status      `json:"player,omitempty"`
+	Job       *ScanJob              `json:"job,omitempty"`
+	Playlists []Playlist            `json:"playlists,omitempty"`
+}
+
+// eventHub fans events out to every subscribed connection. Slow subscribers
+// miss events rather than blocking the daemon.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[chan Event]struct{})}
+}
+
+func (h *eventHub) subscribe() chan Event {
+	ch := make(chan Event, 64)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan Event) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+func (h *eventHub) publish(ev Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
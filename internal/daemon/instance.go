@@ -0,0 +1,139 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hoppxi/bpv/internal/xdg"
+)
+
+// ─── Single instance lock ───
+
+// AlreadyRunningError is returned by Start when another bpvd holds the lock.
+type AlreadyRunningError struct {
+	PID int
+}
+
+func (e *AlreadyRunningError) Error() string {
+	if e.PID > 0 {
+		return fmt.Sprintf("bpvd is already running (pid %d)", e.PID)
+	}
+	return "bpvd is already running"
+}
+
+// lockAttempts is how many times acquireLock tries the lock before deciding
+// another daemon holds it, so a RunningPID probe that happens to hold a
+// shared lock at that moment does not stop the daemon from starting.
+const lockAttempts = 5
+
+// acquireLock takes an exclusive lock on xdg.LockPath() and records our PID
+// in it. The lock is released by the kernel when the process exits, so a
+// crashed daemon never leaves a stale lock behind.
+func acquireLock() (*os.File, error) {
+	path := xdg.LockPath()
+	os.MkdirAll(filepath.Dir(path), 0755)
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock %s: %w", path, err)
+	}
+	for attempt := 1; ; attempt++ {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if !errors.Is(err, syscall.EWOULDBLOCK) || attempt == lockAttempts {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		pid := readLockPID(f)
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, &AlreadyRunningError{PID: pid}
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	f.Truncate(0)
+	f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return f, nil
+}
+
+func readLockPID(f *os.File) int {
+	buf := make([]byte, 32)
+	n, _ := f.ReadAt(buf, 0)
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(buf[:n])))
+	return pid
+}
+
+// RunningPID reports the PID of the daemon holding the lock, or 0 when no
+// daemon is running. It only probes the lock with a shared, non-blocking
+// flock on a read-only descriptor, so it never takes the daemon's lock or
+// touches the PID written in the file.
+func RunningPID() int {
+	f, err := os.Open(xdg.LockPath())
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB); err == nil {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		return 0
+	} else if !errors.Is(err, syscall.EWOULDBLOCK) {
+		return 0
+	}
+
+	// A daemon that has only just taken the lock may not have written its
+	// PID yet.
+	for range lockAttempts {
+		if pid := readLockPID(f); pid > 0 {
+			return pid
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return readLockPID(f)
+}
+
+// ─── Socket activation ───
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+// activationListener returns the socket passed in by systemd (or any other
+// supervisor speaking the LISTEN_FDS protocol), or nil when the daemon was
+// started directly.
+func activationListener() (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+
+	// The variables only apply to this process, not to anything it spawns.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	// Only the first socket is used; bpvd listens on a single path.
+	syscall.CloseOnExec(listenFDsStart)
+	for fd := listenFDsStart + 1; fd < listenFDsStart+n; fd++ {
+		syscall.Close(fd)
+	}
+
+	f := os.NewFile(uintptr(listenFDsStart), "LISTEN_FD_3")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid activation socket: %w", err)
+	}
+	return ln, nil
+}
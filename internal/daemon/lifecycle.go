@@ -0,0 +1,62 @@
+package daemon
+
+import (
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/hoppxi/bpv/internal/logger"
+)
+
+// Info describes the running daemon for bpvd status.
+type Info struct {
+	PID       int       `json:"pid"`
+	Version   string    `json:"version"`
+	StartedAt time.Time `json:"started_at"`
+	Socket    string    `json:"socket"`
+	Activated bool      `json:"activated,omitempty"`
+	MPD       string    `json:"mpd,omitempty"`
+	Libraries []string  `json:"libraries"`
+	Scanning  []string  `json:"scanning"`
+}
+
+func (d *Daemon) handleDaemonStatus() Response {
+	d.mu.Lock()
+	scanning := make([]string, 0, len(d.scanning))
+	for dir := range d.scanning {
+		scanning = append(scanning, dir)
+	}
+	d.mu.Unlock()
+	sort.Strings(scanning)
+
+	info := &Info{
+		PID:       os.Getpid(),
+		Version:   Version,
+		StartedAt: d.started,
+		Socket:    d.listener.Addr().String(),
+		Activated: d.activated,
+		MPD:       d.mpdAddr,
+		Libraries: d.cache.Loaded(),
+		Scanning:  scanning,
+	}
+	return Response{OK: true, Daemon: info}
+}
+
+// handleShutdown only acknowledges the request. The connection handler calls
+// Stop once the reply is written, so the client sees it before the socket
+// goes away.
+func (d *Daemon) handleShutdown() Response {
+	return Response{OK: true}
+}
+
+// reply answers req and carries out a shutdown it asked for.
+func (d *Daemon) reply(conn net.Conn, req *Request, resp Response) {
+	if !req.IsNotification() {
+		d.sendResponse(conn, req, resp)
+	}
+	if req.Action == "shutdown" && resp.OK {
+		logger.Log.Info("Shutdown requested by client")
+		go d.Stop()
+	}
+}
@@ -0,0 +1,195 @@
+package daemon
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hoppxi/bpv/internal/cache"
+	"github.com/hoppxi/bpv/internal/logger"
+	"github.com/hoppxi/bpv/internal/metadata"
+	"github.com/hoppxi/bpv/internal/mpd"
+	"github.com/hoppxi/bpv/internal/playback"
+)
+
+// EnableMPD makes Start also listen for MPD protocol clients on addr, for
+// example "localhost:6600".
+func (d *Daemon) EnableMPD(addr string) {
+	d.mpdAddr = addr
+}
+
+func (d *Daemon) startMPD() error {
+	ln, err := net.Listen("tcp", d.mpdAddr)
+	if err != nil {
+		return err
+	}
+	d.mpd = mpd.NewServer(mpdBackend{d})
+	go func() {
+		if err := d.mpd.Serve(ln); err != nil {
+			logger.Log.Error("MPD listener stopped: %v", err)
+		}
+	}()
+	logger.Log.Info("MPD: %s", ln.Addr())
+	return nil
+}
+
+// mpdBackend routes MPD commands through the same handlers as socket
+// requests, so they persist the queue and notify subscribers in the same way.
+type mpdBackend struct {
+	d *Daemon
+}
+
+func (b mpdBackend) do(req Request) error {
+	if resp := b.d.handleRequest(req); !resp.OK {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
+
+func (b mpdBackend) action(action, value string) error {
+	return b.do(Request{Action: action, Value: value})
+}
+
+// Library returns the library of the directory last scanned by a client.
+func (b mpdBackend) Library() *cache.CachedLibrary {
+	settings, err := b.d.store.GetSettings()
+	if err != nil || settings.LastDir == "" {
+		return nil
+	}
+	return b.d.cache.Load(settings.LastDir)
+}
+
+func (b mpdBackend) Status() playback.Status {
+	return b.d.player.Status()
+}
+
+func (b mpdBackend) Queue() []metadata.AudioFile {
+	return b.d.player.Queue()
+}
+
+func (b mpdBackend) Play(index int) error {
+	if index < 0 {
+		return b.action("play", "")
+	}
+	return b.action("play", strconv.Itoa(index))
+}
+
+func (b mpdBackend) SetPaused(paused bool) error {
+	if paused {
+		return b.action("pause", "")
+	}
+	if !b.d.player.IsPaused() {
+		return nil
+	}
+	return b.action("play", "")
+}
+
+func (b mpdBackend) TogglePause() error { return b.action("toggle", "") }
+func (b mpdBackend) Stop() error        { return b.action("stop", "") }
+func (b mpdBackend) Next() error        { return b.action("next", "") }
+func (b mpdBackend) Previous() error    { return b.action("prev", "") }
+
+func (b mpdBackend) Seek(pos time.Duration) error {
+	return b.action("seek", strconv.FormatFloat(pos.Seconds(), 'f', -1, 64))
+}
+
+func (b mpdBackend) SetVolume(pct int) error {
+	return b.action("set-volume", strconv.Itoa(pct))
+}
+
+func (b mpdBackend) SetShuffle(on bool) error {
+	if on {
+		return b.action("shuffle", "on")
+	}
+	return b.action("shuffle", "off")
+}
+
+func (b mpdBackend) SetRepeat(mode playback.RepeatMode) error {
+	return b.action("repeat", strings.ToLower(mode.String()))
+}
+
+func (b mpdBackend) Add(path string) error {
+	return b.do(Request{Action: "queue-add", FilePath: path})
+}
+
+func (b mpdBackend) Clear() error {
+	return b.action("queue-clear", "")
+}
+
+// Watch translates daemon events into MPD subsystems. A player-changed event
+// is split by comparing with the previous status, so a volume change wakes
+// "mixer" idlers and a queue edit only "playlist" ones.
+func (b mpdBackend) Watch() (<-chan string, func()) {
+	events := b.d.events.subscribe()
+	out := make(chan string, 16)
+	stop := make(chan struct{})
+
+	go func() {
+		defer close(out)
+		last := b.d.player.Status()
+		for {
+			var ev Event
+			select {
+			case <-stop:
+				return
+			case <-b.d.done:
+				return
+			case ev = <-events:
+			}
+
+			var changed []string
+			switch ev.Type {
+			case EventLibraryUpdated:
+				changed = append(changed, mpd.SubsystemDatabase)
+			case EventQueueChanged:
+				changed = append(changed, mpd.SubsystemPlaylist)
+			case EventPlayerChanged:
+				if ev.Player == nil {
+					continue
+				}
+				changed = playerSubsystems(last, *ev.Player)
+				last = *ev.Player
+			}
+
+			for _, sub := range changed {
+				select {
+				case out <- sub:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+
+	return out, func() {
+		b.d.events.unsubscribe(events)
+		close(stop)
+	}
+}
+
+func playerSubsystems(prev, cur playback.Status) []string {
+	var changed []string
+	if prev.VolumePct != cur.VolumePct || prev.Muted != cur.Muted {
+		changed = append(changed, mpd.SubsystemMixer)
+	}
+	if prev.Shuffle != cur.Shuffle || prev.Repeat != cur.Repeat {
+		changed = append(changed, mpd.SubsystemOptions)
+	}
+	switch {
+	case prev.State != cur.State || trackPath(prev) != trackPath(cur):
+		changed = append(changed, mpd.SubsystemPlayer)
+	case len(changed) == 0 && prev.QueueVersion == cur.QueueVersion:
+		// Nothing visible changed besides the position, so it was a seek.
+		changed = append(changed, mpd.SubsystemPlayer)
+	}
+	return changed
+}
+
+func trackPath(st playback.Status) string {
+	if st.Track == nil {
+		return ""
+	}
+	return st.Track.FilePath
+}
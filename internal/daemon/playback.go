@@ -0,0 +1,309 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hoppxi/bpv/internal/logger"
+	"github.com/hoppxi/bpv/internal/metadata"
+	"github.com/hoppxi/bpv/internal/playback"
+	"github.com/hoppxi/bpv/internal/store"
+)
+
+// handlePlayback runs a player command and replies with the resulting
+// player status. Commands are serialized so that compound operations such
+// as "replace the queue and start playing" are not interleaved.
+func (d *Daemon) handlePlayback(req Request) Response {
+	d.playMu.Lock()
+	defer d.playMu.Unlock()
+
+	var err error
+	queueChanged := false
+
+	switch req.Action {
+	case "play":
+		queueChanged, err = d.play(req.Value)
+	case "pause":
+		d.player.SetPaused(true)
+	case "toggle":
+		if d.player.HasTrack() {
+			d.player.TogglePause()
+		} else {
+			err = d.player.PlayCurrent()
+		}
+	case "stop":
+		d.player.Stop()
+	case "next":
+		err = d.player.Next()
+		queueChanged = true
+	case "prev":
+		err = d.player.Previous()
+		queueChanged = true
+	case "seek":
+		err = d.seek(req.Value)
+	case "set-volume":
+		err = d.setVolume(req.Value)
+	case "mute":
+		d.player.ToggleMute()
+	case "shuffle":
+		err = d.setShuffle(req.Value)
+		queueChanged = true
+	case "repeat":
+		err = d.setRepeat(req.Value)
+		queueChanged = true
+	case "queue-add":
+		err = d.enqueue(req.FilePath)
+		queueChanged = err == nil
+	case "queue-clear":
+		d.player.Stop()
+		d.player.SetQueue(nil, 0)
+		queueChanged = true
+	}
+
+	// A changed queue is sent back as the daemon holds it, which may be
+	// shorter than the one asked for if some files could not be read.
+	var queue *store.QueueState
+	if queueChanged {
+		queue = d.persistQueue()
+	}
+
+	st := d.player.Status()
+	if req.Action != "status" {
+		d.events.publish(Event{Type: EventPlayerChanged, Player: &st})
+	}
+	if err != nil {
+		return Response{OK: false, Error: err.Error(), Player: &st, Queue: queue}
+	}
+	return Response{OK: true, Player: &st, Queue: queue}
+}
+
+// play optionally replaces the queue with the JSON encoded QueueState in
+// value, or jumps to the queue index in value, then starts or resumes
+// playback.
+func (d *Daemon) play(value string) (bool, error) {
+	if idx, err := strconv.Atoi(value); err == nil {
+		return true, d.player.PlayIndex(idx)
+	}
+	if value != "" {
+		var q store.QueueState
+		if err := json.Unmarshal([]byte(value), &q); err != nil {
+			return false, fmt.Errorf("invalid queue JSON: %w", err)
+		}
+		tracks, start := d.resolveQueue(q.FilePaths, q.CurrentIndex)
+		if len(tracks) == 0 {
+			return false, fmt.Errorf("none of the queued files could be found")
+		}
+		d.player.SetQueue(tracks, start)
+		return true, d.player.PlayCurrent()
+	}
+
+	if d.player.IsPaused() {
+		d.player.SetPaused(false)
+		return false, nil
+	}
+	if d.player.HasTrack() {
+		return false, nil
+	}
+	return true, d.player.PlayCurrent()
+}
+
+// enqueue appends a file, or every cached track inside a directory, to the
+// end of the queue.
+func (d *Daemon) enqueue(path string) error {
+	if path == "" {
+		return fmt.Errorf("file_path is required")
+	}
+
+	var tracks []metadata.AudioFile
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		tracks = d.cache.Under(path)
+	} else {
+		tracks = d.resolveTracks([]string{path})
+	}
+	if len(tracks) == 0 {
+		return fmt.Errorf("nothing to add at %s", path)
+	}
+	d.player.Append(tracks...)
+	return nil
+}
+
+// seek accepts an absolute position in seconds, or a relative offset when
+// prefixed with + or -.
+func (d *Daemon) seek(value string) error {
+	secs, err := strconv.ParseFloat(strings.TrimPrefix(value, "+"), 64)
+	if err != nil {
+		return fmt.Errorf("invalid seek position %q", value)
+	}
+	offset := time.Duration(secs * float64(time.Second))
+
+	switch {
+	case strings.HasPrefix(value, "+"):
+		d.player.SeekForward(offset)
+	case strings.HasPrefix(value, "-"):
+		d.player.SeekBackward(-offset)
+	default:
+		d.player.Seek(offset)
+	}
+	return nil
+}
+
+// setVolume accepts a percentage (0-100), or a relative change when
+// prefixed with + or -.
+func (d *Daemon) setVolume(value string) error {
+	pct, err := strconv.Atoi(strings.TrimPrefix(value, "+"))
+	if err != nil {
+		return fmt.Errorf("invalid volume %q", value)
+	}
+	if strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-") {
+		pct += d.player.VolumePercent()
+	}
+	d.player.SetVolumePercent(max(0, min(100, pct)))
+	return nil
+}
+
+// setShuffle accepts "on" or "off"; an empty value toggles.
+func (d *Daemon) setShuffle(value string) error {
+	switch strings.ToLower(value) {
+	case "":
+		d.player.ToggleShuffle()
+	case "on", "true":
+		d.player.SetShuffle(true)
+	case "off", "false":
+		d.player.SetShuffle(false)
+	default:
+		return fmt.Errorf("invalid shuffle value %q", value)
+	}
+	return nil
+}
+
+// setRepeat accepts "off", "all" or "one"; an empty value cycles.
+func (d *Daemon) setRepeat(value string) error {
+	switch strings.ToLower(value) {
+	case "":
+		d.player.CycleRepeat()
+	case "off":
+		d.player.SetRepeat(playback.RepeatOff)
+	case "all":
+		d.player.SetRepeat(playback.RepeatAll)
+	case "one":
+		d.player.SetRepeat(playback.RepeatOne)
+	default:
+		return fmt.Errorf("invalid repeat mode %q", value)
+	}
+	return nil
+}
+
+// watchPlayback advances the queue when a track finishes, independently of
+// any connected client.
+func (d *Daemon) watchPlayback() {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			d.playMu.Lock()
+			if d.player.CheckTrackEnd() {
+				d.persistQueue()
+				st := d.player.Status()
+				d.events.publish(Event{Type: EventPlayerChanged, Player: &st})
+			}
+			d.playMu.Unlock()
+		}
+	}
+}
+
+// persistQueue saves the player's queue so it survives a daemon restart and
+// can be read by clients through get-queue, and returns what it saved.
+func (d *Daemon) persistQueue() *store.QueueState {
+	q := store.QueueState{
+		FilePaths: []string{},
+		Shuffle:   d.player.Shuffle(),
+		Repeat:    int(d.player.Repeat()),
+	}
+	for _, t := range d.player.Queue() {
+		q.FilePaths = append(q.FilePaths, t.FilePath)
+		q.TrackIDs = append(q.TrackIDs, t.ID)
+	}
+	if idx := d.player.CurrentQueueItemIndex(); idx >= 0 {
+		q.CurrentIndex = idx
+	}
+	if err := d.store.SaveQueue(&q); err != nil {
+		logger.Log.Error("Failed to save queue: %v", err)
+		return &q
+	}
+	d.events.publish(Event{Type: EventQueueChanged, Queue: &q})
+	return &q
+}
+
+// restoreQueue loads the saved queue into the player without starting
+// playback.
+func (d *Daemon) restoreQueue() {
+	q, err := d.store.GetQueue()
+	if err != nil || len(q.FilePaths) == 0 {
+		return
+	}
+
+	if settings, err := d.store.GetSettings(); err == nil && settings.LastDir != "" {
+		d.cache.Load(settings.LastDir)
+	}
+
+	tracks, start := d.resolveQueue(q.FilePaths, q.CurrentIndex)
+	if len(tracks) == 0 {
+		return
+	}
+	d.player.SetQueue(tracks, start)
+	d.player.SetRepeat(playback.RepeatMode(q.Repeat))
+	d.player.SetShuffle(q.Shuffle)
+	logger.Log.Info("Restored queue with %d tracks", len(tracks))
+}
+
+// resolveQueue resolves a queue like resolveTracks and returns the position
+// of its current track, the one at index current in paths, among the tracks
+// that are left. If that track cannot be read the next one that can takes
+// its place.
+func (d *Daemon) resolveQueue(paths []string, current int) ([]metadata.AudioFile, int) {
+	current = max(0, min(current, len(paths)))
+	tracks := d.resolveTracks(paths[:current])
+	start := len(tracks)
+	tracks = append(tracks, d.resolveTracks(paths[current:])...)
+	return tracks, max(0, min(start, len(tracks)-1))
+}
+
+// resolveTracks maps file paths to their metadata, preferring the library
+// cache and falling back to reading tags from disk.
+func (d *Daemon) resolveTracks(paths []string) []metadata.AudioFile {
+	tracks, _ := d.resolveIndexed(paths)
+	return tracks
+}
+
+// resolveIndexed is resolveTracks that also returns, for each track, the
+// index in paths of the path it was read from.
+func (d *Daemon) resolveIndexed(paths []string) ([]metadata.AudioFile, []int) {
+	known := d.cache.Lookup(paths)
+	extractor := d.newExtractor()
+
+	tracks := make([]metadata.AudioFile, 0, len(paths))
+	indexes := make([]int, 0, len(paths))
+	for i, p := range paths {
+		if f, ok := known[p]; ok {
+			tracks = append(tracks, f)
+			indexes = append(indexes, i)
+			continue
+		}
+		f, err := extractor.ExtractFromFile(p)
+		if err != nil {
+			logger.Log.Warn("Skipping queued file %s: %v", p, err)
+			continue
+		}
+		tracks = append(tracks, *f)
+		indexes = append(indexes, i)
+	}
+	return tracks, indexes
+}
@@ -0,0 +1,375 @@
+package daemon
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hoppxi/bpv/internal/metadata"
+	"github.com/hoppxi/bpv/internal/playlist"
+	"github.com/hoppxi/bpv/internal/store"
+)
+
+// Playlist is a playlist as clients see it, with its tracks resolved to
+// their metadata. Listings leave Tracks and Unresolved empty.
+//
+// Playlist files found in a library (M3U, M3U8 and PLS) are listed after
+// the stored playlists. They are read-only and carry the file in Path.
+//
+// Smart playlists carry their rule in Smart, and their tracks are the
+// library tracks that match it.
+type Playlist struct {
+	ID         string               `json:"id"`
+	Name       string               `json:"name"`
+	TrackCount int                  `json:"track_count"`
+	Created    time.Time            `json:"created"`
+	Modified   time.Time            `json:"modified"`
+	ReadOnly   bool                 `json:"read_only,omitempty"`
+	Path       string               `json:"path,omitempty"`
+	Smart      *store.SmartRule     `json:"smart,omitempty"`
+	Tracks     []metadata.AudioFile `json:"tracks,omitempty"`
+	// Unresolved lists the entries, as written, that match no track in the
+	// library.
+	Unresolved []string `json:"unresolved,omitempty"`
+}
+
+// PlaylistEdit is the value of the playlist-* actions. Each action reads
+// only the fields it needs: create takes Name and FilePaths, or Name, Rule,
+// Sort and Limit for a smart playlist; rename takes Name, add FilePaths,
+// remove Positions, move From and To, and set-rule Rule, Sort and Limit.
+type PlaylistEdit struct {
+	Name      string   `json:"name,omitempty"`
+	FilePaths []string `json:"file_paths,omitempty"`
+	Positions []int    `json:"positions,omitempty"`
+	From      int      `json:"from,omitempty"`
+	To        int      `json:"to,omitempty"`
+	Rule      string   `json:"rule,omitempty"`
+	Sort      string   `json:"sort,omitempty"`
+	Limit     int      `json:"limit,omitempty"`
+}
+
+// filePlaylistPrefix starts the IDs of playlist files, which are derived
+// from their path.
+const filePlaylistPrefix = "file-"
+
+func playlistInfo(p *store.Playlist) Playlist {
+	return Playlist{
+		ID:         p.ID,
+		Name:       p.Name,
+		TrackCount: len(p.Tracks),
+		Smart:      p.Smart,
+		Created:    p.Created,
+		Modified:   p.Modified,
+	}
+}
+
+func filePlaylistInfo(f *playlist.File) Playlist {
+	h := sha256.Sum256([]byte(f.Path))
+	return Playlist{
+		ID:       filePlaylistPrefix + hex.EncodeToString(h[:8]),
+		Name:     f.Name,
+		Created:  f.Modified,
+		Modified: f.Modified,
+		ReadOnly: true,
+		Path:     f.Path,
+	}
+}
+
+func (d *Daemon) handlePlaylists() Response {
+	lists, err := d.store.GetPlaylists()
+	if err != nil {
+		return Response{OK: false, Error: err.Error()}
+	}
+	files := d.playlistFiles()
+
+	infos := make([]Playlist, 0, len(lists)+len(files))
+	for i := range lists {
+		info := playlistInfo(&lists[i])
+		if lists[i].Smart != nil {
+			info.TrackCount = len(d.smartTracks(&lists[i]))
+		}
+		infos = append(infos, info)
+	}
+
+	// One lookup for the entries of every file.
+	var paths []string
+	for _, f := range files {
+		for _, e := range f.Entries {
+			if e.Path != "" {
+				paths = append(paths, e.Path)
+			}
+		}
+	}
+	known := d.cache.Lookup(paths)
+	for i := range files {
+		info := filePlaylistInfo(&files[i])
+		for _, e := range files[i].Entries {
+			if _, ok := known[e.Path]; ok {
+				info.TrackCount++
+			}
+		}
+		infos = append(infos, info)
+	}
+	return Response{OK: true, Playlists: infos}
+}
+
+// playlistFiles returns the playlist files of every library in memory.
+func (d *Daemon) playlistFiles() []playlist.File {
+	var files []playlist.File
+	for _, dir := range d.cache.Loaded() {
+		if lib := d.cache.Load(dir); lib != nil {
+			files = append(files, lib.Playlists...)
+		}
+	}
+	return files
+}
+
+// findPlaylistFile returns the playlist file with ID or, failing that,
+// name ref.
+func (d *Daemon) findPlaylistFile(ref string) (*playlist.File, bool) {
+	files := d.playlistFiles()
+	for i := range files {
+		if filePlaylistInfo(&files[i]).ID == ref {
+			return &files[i], true
+		}
+	}
+	for i := range files {
+		if files[i].Name == ref {
+			return &files[i], true
+		}
+	}
+	return nil, false
+}
+
+// resolveEntries matches playlist entries to library tracks by path, in
+// order, and returns the entries that match none as they were written.
+func (d *Daemon) resolveEntries(entries []playlist.Entry) ([]metadata.AudioFile, []string) {
+	paths := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.Path != "" {
+			paths = append(paths, e.Path)
+		}
+	}
+	known := d.cache.Lookup(paths)
+
+	var tracks []metadata.AudioFile
+	var unresolved []string
+	for _, e := range entries {
+		if f, ok := known[e.Path]; ok {
+			tracks = append(tracks, f)
+		} else {
+			unresolved = append(unresolved, e.Location)
+		}
+	}
+	return tracks, unresolved
+}
+
+// handleGetPlaylist returns the playlist with ID or name ref and its
+// tracks. Tracks whose files are gone are left out; the playlist-remove and
+// playlist-move actions take positions among the tracks returned.
+func (d *Daemon) handleGetPlaylist(ref string) Response {
+	p, err := d.store.GetPlaylist(ref)
+	if errors.Is(err, store.ErrPlaylistNotFound) {
+		if f, ok := d.findPlaylistFile(ref); ok {
+			info := filePlaylistInfo(f)
+			info.Tracks, info.Unresolved = d.resolveEntries(f.Entries)
+			info.TrackCount = len(info.Tracks)
+			return Response{OK: true, Playlist: &info}
+		}
+	}
+	if err != nil {
+		return playlistError(err)
+	}
+	info := playlistInfo(p)
+	if p.Smart != nil {
+		info.Tracks = d.smartTracks(p)
+		info.TrackCount = len(info.Tracks)
+		return Response{OK: true, Playlist: &info}
+	}
+	info.Tracks = d.resolveTracks(playlistPaths(p))
+	return Response{OK: true, Playlist: &info}
+}
+
+func playlistPaths(p *store.Playlist) []string {
+	paths := make([]string, len(p.Tracks))
+	for i, t := range p.Tracks {
+		paths[i] = t.Path
+	}
+	return paths
+}
+
+// storedPositions maps positions among the tracks handleGetPlaylist returns
+// for p, which leaves out tracks whose files are gone, to positions among
+// the entries of p.
+func (d *Daemon) storedPositions(p *store.Playlist, shown ...int) ([]int, error) {
+	_, stored := d.resolveIndexed(playlistPaths(p))
+	positions := make([]int, len(shown))
+	for i, pos := range shown {
+		if pos < 0 || pos >= len(stored) {
+			return nil, fmt.Errorf("position %d out of range", pos)
+		}
+		positions[i] = stored[pos]
+	}
+	return positions, nil
+}
+
+// handleEditPlaylist runs one of the playlist-* actions that change a
+// playlist, ref being its ID or name.
+func (d *Daemon) handleEditPlaylist(action, ref, value string) Response {
+	var edit PlaylistEdit
+	if value != "" {
+		if err := json.Unmarshal([]byte(value), &edit); err != nil {
+			return Response{OK: false, Error: "invalid playlist JSON: " + err.Error(), Code: ErrCodeInvalidParams}
+		}
+	}
+	if action != "playlist-create" {
+		if ref == "" {
+			return Response{OK: false, Error: "playlist is required", Code: ErrCodeInvalidParams}
+		}
+		// Edits go by ID, so a rename does not lose track of the playlist.
+		p, err := d.store.GetPlaylist(ref)
+		if errors.Is(err, store.ErrPlaylistNotFound) {
+			if f, ok := d.findPlaylistFile(ref); ok {
+				return Response{OK: false, Error: fmt.Sprintf("playlist %s is read-only, edit %s instead", f.Name, f.Path), Code: ErrCodeInvalidParams}
+			}
+		}
+		if err != nil {
+			return playlistError(err)
+		}
+		ref = p.ID
+
+		// Clients address entries by their position among the tracks
+		// playlist-get returned, which leaves out missing files.
+		switch {
+		case p.Smart != nil:
+		case action == "playlist-remove":
+			edit.Positions, err = d.storedPositions(p, edit.Positions...)
+		case action == "playlist-move":
+			var positions []int
+			if positions, err = d.storedPositions(p, edit.From, edit.To); err == nil {
+				edit.From, edit.To = positions[0], positions[1]
+			}
+		}
+		if err != nil {
+			return Response{OK: false, Error: err.Error(), Code: ErrCodeInvalidParams}
+		}
+	}
+
+	var rule store.SmartRule
+	if action == "playlist-set-rule" || (action == "playlist-create" && edit.Rule != "") {
+		var err error
+		if rule, err = smartRule(edit); err != nil {
+			return Response{OK: false, Error: err.Error(), Code: ErrCodeInvalidParams}
+		}
+	}
+
+	var err error
+	switch action {
+	case "playlist-create":
+		var p *store.Playlist
+		if edit.Rule != "" {
+			p, err = d.store.CreateSmartPlaylist(edit.Name, rule)
+		} else {
+			p, err = d.store.CreatePlaylist(edit.Name, d.trackRefs(edit.FilePaths))
+		}
+		if err == nil {
+			ref = p.ID
+		}
+	case "playlist-rename":
+		err = d.store.RenamePlaylist(ref, edit.Name)
+	case "playlist-delete":
+		err = d.store.DeletePlaylist(ref)
+	case "playlist-add":
+		err = d.store.AddToPlaylist(ref, d.trackRefs(edit.FilePaths))
+	case "playlist-remove":
+		err = d.store.RemoveFromPlaylist(ref, edit.Positions)
+	case "playlist-move":
+		err = d.store.MoveInPlaylist(ref, edit.From, edit.To)
+	case "playlist-set-rule":
+		err = d.store.SetPlaylistRule(ref, rule)
+	}
+	if err != nil {
+		return playlistError(err)
+	}
+
+	d.publishPlaylists()
+	if action == "playlist-delete" {
+		return Response{OK: true}
+	}
+	p, err := d.store.GetPlaylist(ref)
+	if err != nil {
+		return playlistError(err)
+	}
+	info := playlistInfo(p)
+	if p.Smart != nil {
+		info.TrackCount = len(d.smartTracks(p))
+	}
+	return Response{OK: true, Playlist: &info}
+}
+
+// handleImportPlaylist saves the entries of the playlist file at path that
+// match library tracks as a new playlist, named after the file unless name
+// is given. The entries that match nothing come back in Unresolved.
+func (d *Daemon) handleImportPlaylist(path, name string) Response {
+	if path == "" {
+		return Response{OK: false, Error: "playlist file is required", Code: ErrCodeInvalidParams}
+	}
+	f, err := playlist.Load(filepath.Clean(path))
+	if err != nil {
+		return Response{OK: false, Error: err.Error(), Code: ErrCodeInvalidParams}
+	}
+	if strings.TrimSpace(name) == "" {
+		name = f.Name
+	}
+
+	tracks, unresolved := d.resolveEntries(f.Entries)
+	paths := make([]string, len(tracks))
+	for i, t := range tracks {
+		paths[i] = t.FilePath
+	}
+	p, err := d.store.CreatePlaylist(name, d.trackRefs(paths))
+	if err != nil {
+		return playlistError(err)
+	}
+	d.publishPlaylists()
+
+	info := playlistInfo(p)
+	info.Unresolved = unresolved
+	return Response{OK: true, Playlist: &info}
+}
+
+func (d *Daemon) publishPlaylists() {
+	if resp := d.handlePlaylists(); resp.OK {
+		d.events.publish(Event{Type: EventPlaylistsChanged, Playlists: resp.Playlists})
+	}
+}
+
+func playlistError(err error) Response {
+	if errors.Is(err, store.ErrPlaylistNotFound) {
+		return Response{OK: false, Error: err.Error(), Code: ErrCodeNotFound}
+	}
+	if errors.Is(err, store.ErrPlaylistExists) ||
+		errors.Is(err, store.ErrSmartPlaylist) || errors.Is(err, store.ErrNotSmartPlaylist) {
+		return Response{OK: false, Error: err.Error(), Code: ErrCodeInvalidParams}
+	}
+	return Response{OK: false, Error: err.Error()}
+}
+
+// samePlaylistFiles reports whether two scans found the same playlist files,
+// unmodified.
+func samePlaylistFiles(a, b []playlist.File) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Path != b[i].Path || !a[i].Modified.Equal(b[i].Modified) {
+			return false
+		}
+	}
+	return true
+}
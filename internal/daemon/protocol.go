@@ -0,0 +1,190 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// ProtocolVersion is bumped whenever the wire format or the meaning of an
+// existing action changes. Daemons that predate the hello action speak
+// version 1. Version 3 made scan return a job instead of the library.
+const ProtocolVersion = 3
+
+// Error codes follow JSON-RPC 2.0. Codes from -32000 down are daemon
+// specific.
+const (
+	ErrCodeParse          = -32700
+	ErrCodeInvalidRequest = -32600
+	ErrCodeMethodNotFound = -32601
+	ErrCodeInvalidParams  = -32602
+	ErrCodeInternal       = -32603
+	ErrCodeFailed         = -32000
+	ErrCodeBusy           = -32001
+	ErrCodeUnsupported    = -32002
+	ErrCodeNotFound       = -32003
+)
+
+const jsonrpcVersion = "2.0"
+
+// Request accepts both framings on the socket:
+//
+//	{"action": "library", "dir": "/music"}
+//	{"jsonrpc": "2.0", "id": 1, "method": "library", "params": {"dir": "/music"}}
+//
+// JSON-RPC params use the same names as the legacy fields.
+type Request struct {
+	Action   string `json:"action,omitempty"`
+	Dir      string `json:"dir,omitempty"`
+	FilePath string `json:"file_path,omitempty"`
+	Key      string `json:"key,omitempty"`
+	Value    string `json:"value,omitempty"`
+	// Size picks a cover size for cover-art: 64, 300, 600 or original.
+	Size string `json:"size,omitempty"`
+
+	JSONRPC string          `json:"jsonrpc,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type requestParams struct {
+	Dir      string `json:"dir"`
+	FilePath string `json:"file_path"`
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Size     string `json:"size,omitempty"`
+}
+
+// IsRPC reports whether the request used JSON-RPC 2.0 framing.
+func (r *Request) IsRPC() bool {
+	return r.JSONRPC != ""
+}
+
+// IsNotification reports whether the request is a JSON-RPC notification,
+// which gets no response.
+func (r *Request) IsNotification() bool {
+	return r.IsRPC() && len(r.ID) == 0
+}
+
+// normalize folds JSON-RPC method and params into the legacy fields so
+// handlers only deal with one shape.
+func (r *Request) normalize() *RPCError {
+	if !r.IsRPC() {
+		if r.Action == "" {
+			return &RPCError{Code: ErrCodeInvalidRequest, Message: "action is required"}
+		}
+		return nil
+	}
+
+	if r.JSONRPC != jsonrpcVersion {
+		return &RPCError{Code: ErrCodeInvalidRequest, Message: "jsonrpc must be \"2.0\""}
+	}
+	if r.Method == "" {
+		return &RPCError{Code: ErrCodeInvalidRequest, Message: "method is required"}
+	}
+	r.Action = r.Method
+
+	params := bytes.TrimSpace(r.Params)
+	if len(params) == 0 || bytes.Equal(params, []byte("null")) {
+		return nil
+	}
+	if params[0] != '{' {
+		return &RPCError{Code: ErrCodeInvalidParams, Message: "params must be an object"}
+	}
+	var p requestParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return &RPCError{Code: ErrCodeInvalidParams, Message: "invalid params: " + err.Error()}
+	}
+	r.Dir, r.FilePath, r.Key, r.Value, r.Size = p.Dir, p.FilePath, p.Key, p.Value, p.Size
+	return nil
+}
+
+// RPCError is the JSON-RPC 2.0 error object.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// rpcResponse is the JSON-RPC 2.0 envelope. The result is the same object a
+// legacy client receives.
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  *Response       `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// rpcNotification carries subscription events to JSON-RPC clients.
+type rpcNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+// frame wraps resp for the framing the request arrived in.
+func frame(req *Request, resp Response) any {
+	if !resp.OK && resp.Code == 0 {
+		resp.Code = ErrCodeFailed
+	}
+	if !req.IsRPC() {
+		return resp
+	}
+
+	id := req.ID
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	if !resp.OK {
+		return rpcResponse{
+			JSONRPC: jsonrpcVersion,
+			ID:      id,
+			Error:   &RPCError{Code: resp.Code, Message: resp.Error},
+		}
+	}
+	return rpcResponse{JSONRPC: jsonrpcVersion, ID: id, Result: &resp}
+}
+
+// parseError builds the reply for a line that is not valid JSON. JSON-RPC
+// clients are recognised by the jsonrpc member so they get a spec-compliant
+// error with a null id.
+func parseError(line []byte, err error) any {
+	msg := "invalid request: " + err.Error()
+	if bytes.Contains(line, []byte(`"jsonrpc"`)) {
+		return rpcResponse{
+			JSONRPC: jsonrpcVersion,
+			ID:      json.RawMessage("null"),
+			Error:   &RPCError{Code: ErrCodeParse, Message: msg},
+		}
+	}
+	return Response{OK: false, Error: msg, Code: ErrCodeParse}
+}
+
+// isNotificationLine reports whether line is well-formed JSON-RPC without an
+// id even though it does not decode as a Request, for example because a
+// field has the wrong type. Notifications get no reply, not even an error.
+func isNotificationLine(line []byte) bool {
+	var envelope struct {
+		JSONRPC json.RawMessage `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(line, &envelope); err != nil {
+		return false
+	}
+	return len(envelope.JSONRPC) > 0 && len(envelope.ID) == 0
+}
+
+func sortedActions(actions map[string]func(Request) Response) []string {
+	names := make([]string, 0, len(actions)+1)
+	for name := range actions {
+		names = append(names, name)
+	}
+	names = append(names, "subscribe")
+	sort.Strings(names)
+	return names
+}
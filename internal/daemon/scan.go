@@ -0,0 +1,390 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hoppxi/bpv/internal/cache"
+	"github.com/hoppxi/bpv/internal/logger"
+	"github.com/hoppxi/bpv/internal/metadata"
+	"github.com/hoppxi/bpv/internal/playlist"
+	"github.com/hoppxi/bpv/internal/scanner"
+)
+
+// Scan job states.
+const (
+	ScanRunning   = "running"
+	ScanDone      = "done"
+	ScanFailed    = "failed"
+	ScanCancelled = "cancelled"
+)
+
+// maxFinishedJobs is how many finished scan jobs are remembered for
+// scan-status.
+const maxFinishedJobs = 16
+
+// ScanJob is a snapshot of a library scan started by the scan action.
+type ScanJob struct {
+	ID         string                `json:"id"`
+	Dir        string                `json:"dir"`
+	State      string                `json:"state"`
+	Progress   *scanner.ScanProgress `json:"progress,omitempty"`
+	Error      string                `json:"error,omitempty"`
+	FileCount  int                   `json:"file_count,omitempty"`
+	Added      int                   `json:"added,omitempty"`
+	Changed    int                   `json:"changed,omitempty"`
+	Removed    int                   `json:"removed,omitempty"`
+	StartedAt  time.Time             `json:"started_at"`
+	FinishedAt time.Time             `json:"finished_at,omitzero"`
+}
+
+// Finished reports whether the job has stopped, for whatever reason.
+func (j ScanJob) Finished() bool {
+	return j.State != ScanRunning
+}
+
+type scanJob struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu   sync.Mutex
+	info ScanJob
+	lib  *cache.CachedLibrary
+}
+
+func (j *scanJob) snapshot() ScanJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	info := j.info
+	if info.Progress != nil {
+		p := *info.Progress
+		info.Progress = &p
+	}
+	return info
+}
+
+// SetScanWorkers sets how many files a library scan reads at once. Zero
+// means one per CPU.
+func (d *Daemon) SetScanWorkers(n int) {
+	d.scanWorkers = n
+}
+
+// SetFolderArt sets the image names, best first, used as cover art for
+// tracks without embedded pictures. Nil means metadata.DefaultFolderArt.
+func (d *Daemon) SetFolderArt(patterns []string) {
+	d.folderArt = patterns
+}
+
+func (d *Daemon) newScanner() *scanner.Scanner {
+	sc := scanner.NewScanner()
+	sc.SetWorkers(d.scanWorkers)
+	sc.SetArtworkStore(d.artwork)
+	sc.SetFolderArt(d.folderArt)
+	return sc
+}
+
+// startScan starts scanning dir in the background, or returns the job that
+// is already scanning it.
+func (d *Daemon) startScan(dir string) *scanJob {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if job, ok := d.scanning[dir]; ok {
+		return job
+	}
+
+	d.nextJobID++
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &scanJob{
+		cancel: cancel,
+		done:   make(chan struct{}),
+		info: ScanJob{
+			ID:        fmt.Sprintf("scan-%d", d.nextJobID),
+			Dir:       dir,
+			State:     ScanRunning,
+			StartedAt: time.Now(),
+		},
+	}
+	d.scanning[dir] = job
+	d.jobs[job.info.ID] = job
+	d.pruneJobs()
+
+	logger.Log.Info("Scan %s started: %s", job.info.ID, dir)
+	go d.runScan(ctx, job)
+	return job
+}
+
+// pruneJobs forgets the oldest finished jobs beyond maxFinishedJobs. The
+// caller holds d.mu.
+func (d *Daemon) pruneJobs() {
+	var finished []ScanJob
+	for _, job := range d.jobs {
+		if info := job.snapshot(); info.Finished() {
+			finished = append(finished, info)
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+	sort.Slice(finished, func(i, k int) bool {
+		return finished[i].FinishedAt.Before(finished[k].FinishedAt)
+	})
+	for _, info := range finished[:len(finished)-maxFinishedJobs] {
+		delete(d.jobs, info.ID)
+	}
+}
+
+func (d *Daemon) runScan(ctx context.Context, job *scanJob) {
+	defer job.cancel()
+
+	// Files unchanged since the cached scan are reused instead of read again.
+	var previous []metadata.AudioFile
+	if old := d.cache.Load(job.info.Dir); old != nil {
+		previous = old.Files
+	}
+
+	sc := d.newScanner()
+	stopProgress := d.forwardProgress(job, sc.GetProgressChannel())
+	result, err := sc.Rescan(ctx, job.info.Dir, previous)
+	stopProgress()
+
+	var lib *cache.CachedLibrary
+	if err == nil {
+		lib = d.saveLibrary(job.info.Dir, result)
+	}
+	d.finishScan(job, result, lib, err)
+	if err == nil {
+		d.pruneArtwork()
+	}
+}
+
+// pruneArtwork removes covers that no cached library refers to any more,
+// left behind by retagged or deleted files. It waits for a moment when no
+// scan is running, since a scan stores covers before its library is cached.
+func (d *Daemon) pruneArtwork() {
+	d.mu.Lock()
+	scanning := len(d.scanning)
+	d.mu.Unlock()
+	if scanning > 0 {
+		return
+	}
+
+	since := time.Now()
+	keep, err := d.cache.CoverIDs()
+	if err != nil {
+		logger.Log.Error("Failed to list covers in use: %v", err)
+		return
+	}
+	n, err := d.artwork.Prune(keep, since)
+	if err != nil {
+		logger.Log.Error("Failed to prune artwork: %v", err)
+	}
+	if n > 0 {
+		logger.Log.Info("Removed %d unused covers from the artwork store", n)
+	}
+}
+
+func (d *Daemon) finishScan(job *scanJob, result *scanner.ScanResult, lib *cache.CachedLibrary, err error) {
+	job.mu.Lock()
+	job.lib = lib
+	job.info.FinishedAt = time.Now()
+	switch {
+	case errors.Is(err, context.Canceled):
+		job.info.State = ScanCancelled
+	case err != nil:
+		job.info.State = ScanFailed
+		job.info.Error = err.Error()
+	default:
+		job.info.State = ScanDone
+		job.info.FileCount = lib.FileCount
+		job.info.Added = result.Added
+		job.info.Changed = result.Changed
+		job.info.Removed = result.Removed
+	}
+	job.mu.Unlock()
+
+	d.mu.Lock()
+	delete(d.scanning, job.info.Dir)
+	d.mu.Unlock()
+	close(job.done)
+
+	info := job.snapshot()
+	switch info.State {
+	case ScanDone:
+		logger.Log.Success("Scan %s finished: %d audio files in %s (%d added, %d changed, %d removed)",
+			info.ID, info.FileCount, info.Dir, info.Added, info.Changed, info.Removed)
+	case ScanCancelled:
+		logger.Log.Info("Scan %s cancelled: %s", info.ID, info.Dir)
+	default:
+		logger.Log.Error("Scan %s failed: %s", info.ID, info.Error)
+	}
+	d.events.publish(Event{Type: EventScanFinished, Dir: info.Dir, Job: &info})
+}
+
+// saveLibrary caches a finished scan and tells subscribers about it.
+func (d *Daemon) saveLibrary(dir string, result *scanner.ScanResult) *cache.CachedLibrary {
+	lib := &cache.CachedLibrary{
+		Dir:       dir,
+		ScanTime:  time.Now(),
+		FileCount: result.AudioFiles,
+		Files:     result.Files,
+		Artists:   result.Artists,
+		Albums:    result.Albums,
+		Genres:    result.Genres,
+		Composers: result.Composers,
+		Playlists: result.Playlists,
+		Errors:    result.Errors,
+	}
+
+	var oldPlaylists []playlist.File
+	if old := d.cache.Load(dir); old != nil {
+		oldPlaylists = old.Playlists
+	}
+	if err := d.cache.Save(lib); err != nil {
+		logger.Log.Error("Failed to save cache: %v", err)
+	}
+
+	settings, _ := d.store.GetSettings()
+	settings.LastDir = dir
+	if err := d.store.SaveSettings(settings); err == nil {
+		d.events.publish(Event{Type: EventSettingsChanged, Settings: settings})
+	}
+
+	d.relink(lib.Files)
+	if d.refreshSmart() || !samePlaylistFiles(oldPlaylists, lib.Playlists) {
+		d.publishPlaylists()
+	}
+	go d.syncIndex()
+	d.watchLibrary(dir)
+	d.events.publish(Event{Type: EventLibraryUpdated, Dir: dir})
+	return lib
+}
+
+// scanAndWait scans dir, joining a scan already in progress, and waits for
+// the result.
+func (d *Daemon) scanAndWait(dir string) Response {
+	job := d.startScan(dir)
+	select {
+	case <-job.done:
+	case <-d.done:
+		return Response{OK: false, Error: "daemon is shutting down"}
+	}
+
+	info := job.snapshot()
+	switch info.State {
+	case ScanDone:
+		return Response{OK: true, Library: job.lib}
+	case ScanCancelled:
+		return Response{OK: false, Error: "scan cancelled"}
+	default:
+		return Response{OK: false, Error: "scan failed: " + info.Error}
+	}
+}
+
+// forwardProgress publishes scan progress for job until the returned stop
+// function is called. stop drains anything still buffered so subscribers see
+// every progress event before the library-updated event.
+func (d *Daemon) forwardProgress(job *scanJob, progress <-chan scanner.ScanProgress) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	publish := func(p scanner.ScanProgress) {
+		job.mu.Lock()
+		job.info.Progress = &p
+		job.mu.Unlock()
+		info := job.snapshot()
+		d.events.publish(Event{Type: EventScanProgress, Dir: info.Dir, Progress: info.Progress, Job: &info})
+	}
+
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case p := <-progress:
+				publish(p)
+			case <-stop:
+				for {
+					select {
+					case p := <-progress:
+						publish(p)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+func (d *Daemon) handleScan(dir string) Response {
+	if dir == "" {
+		return Response{OK: false, Error: "dir is required", Code: ErrCodeInvalidParams}
+	}
+	info := d.startScan(dir).snapshot()
+	return Response{OK: true, Job: &info}
+}
+
+// handleScanStatus reports one job by ID, or every running and recently
+// finished job when no ID is given.
+func (d *Daemon) handleScanStatus(id string) Response {
+	if id != "" {
+		job := d.lookupJob(id)
+		if job == nil {
+			return Response{OK: false, Error: "unknown scan job: " + id, Code: ErrCodeInvalidParams}
+		}
+		info := job.snapshot()
+		return Response{OK: true, Job: &info}
+	}
+
+	d.mu.Lock()
+	jobs := make([]ScanJob, 0, len(d.jobs))
+	for _, job := range d.jobs {
+		jobs = append(jobs, job.snapshot())
+	}
+	d.mu.Unlock()
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].StartedAt.Before(jobs[k].StartedAt)
+	})
+	return Response{OK: true, Jobs: jobs}
+}
+
+// handleScanCancel stops a running job. Cancelling a finished job is not an
+// error; the reply shows how it ended.
+func (d *Daemon) handleScanCancel(id string) Response {
+	if id == "" {
+		return Response{OK: false, Error: "job id is required", Code: ErrCodeInvalidParams}
+	}
+	job := d.lookupJob(id)
+	if job == nil {
+		return Response{OK: false, Error: "unknown scan job: " + id, Code: ErrCodeInvalidParams}
+	}
+	job.cancel()
+	<-job.done
+
+	info := job.snapshot()
+	return Response{OK: true, Job: &info}
+}
+
+func (d *Daemon) lookupJob(id string) *scanJob {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.jobs[id]
+}
+
+// cancelScans stops every running scan, for shutdown.
+func (d *Daemon) cancelScans() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, job := range d.scanning {
+		job.cancel()
+	}
+}
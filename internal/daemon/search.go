@@ -0,0 +1,85 @@
+package daemon
+
+import (
+	"encoding/json"
+
+	"github.com/hoppxi/bpv/internal/index"
+	"github.com/hoppxi/bpv/internal/logger"
+	"github.com/hoppxi/bpv/internal/metadata"
+	"github.com/hoppxi/bpv/internal/query"
+)
+
+// SearchOptions is the value of the search action: which page of results
+// to return. A Limit of 0 returns every result from Offset on.
+type SearchOptions struct {
+	Offset int `json:"offset,omitempty"`
+	Limit  int `json:"limit,omitempty"`
+}
+
+// handleSearch returns the tracks matching a query such as
+// `artist:"miles davis" year:1955..1965`, best match first, from the
+// library in dir or, when dir is empty, from every loaded library. Words
+// match through the search index, so they may be prefixes or have typos.
+// A query that does not parse is an invalid-params error naming the
+// problem and where it is.
+func (d *Daemon) handleSearch(dir, text, value string) Response {
+	var opts SearchOptions
+	if value != "" {
+		if err := json.Unmarshal([]byte(value), &opts); err != nil {
+			return Response{OK: false, Error: "invalid search options JSON: " + err.Error(), Code: ErrCodeInvalidParams}
+		}
+	}
+	if opts.Offset < 0 || opts.Limit < 0 {
+		return Response{OK: false, Error: "offset and limit cannot be negative", Code: ErrCodeInvalidParams}
+	}
+	q, err := query.Parse(text)
+	if err != nil {
+		return Response{OK: false, Error: "invalid query: " + err.Error(), Code: ErrCodeInvalidParams}
+	}
+	if dir != "" && d.cache.Load(dir) == nil {
+		return Response{OK: false, Error: "library not scanned: " + dir, Code: ErrCodeInvalidParams}
+	}
+
+	d.syncIndex()
+	tracks, total := d.index.Search(q, index.Options{Offset: opts.Offset, Limit: opts.Limit, Under: dir})
+	return Response{OK: true, Tracks: tracks, Total: total}
+}
+
+// syncIndex brings the search index up to date with the libraries in
+// memory, if they changed since it last was. Only tracks that changed are
+// indexed again.
+func (d *Daemon) syncIndex() {
+	d.indexMu.Lock()
+	defer d.indexMu.Unlock()
+
+	gen := d.cache.Generation()
+	if gen == d.indexGen {
+		return
+	}
+	added, changed, removed := d.index.Update(d.loadedFiles())
+	d.indexGen = gen
+	if added+changed+removed > 0 {
+		logger.Log.Debug("Search index updated (%d added, %d changed, %d removed, %d tracks)",
+			added, changed, removed, d.index.Len())
+	}
+}
+
+// loadedFiles returns the tracks of every loaded library. A file in more
+// than one library is returned once.
+func (d *Daemon) loadedFiles() []metadata.AudioFile {
+	var files []metadata.AudioFile
+	seen := make(map[string]bool)
+	for _, dir := range d.cache.Loaded() {
+		lib := d.cache.Load(dir)
+		if lib == nil {
+			continue
+		}
+		for _, f := range lib.Files {
+			if !seen[f.FilePath] {
+				seen[f.FilePath] = true
+				files = append(files, f)
+			}
+		}
+	}
+	return files
+}
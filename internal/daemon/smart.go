@@ -0,0 +1,152 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/hoppxi/bpv/internal/metadata"
+	"github.com/hoppxi/bpv/internal/smart"
+	"github.com/hoppxi/bpv/internal/store"
+)
+
+// smartMaxAge is how long an evaluated smart playlist is reused when
+// nothing changes, so rules such as "added in last 7 days" move with time.
+const smartMaxAge = time.Minute
+
+// smartResult is a smart playlist as evaluated at a point in time.
+type smartResult struct {
+	rule   store.SmartRule
+	at     time.Time
+	tracks []metadata.AudioFile
+}
+
+// smartRule checks the rule, sort and limit of edit and returns them as the
+// store keeps them.
+func smartRule(edit PlaylistEdit) (store.SmartRule, error) {
+	rule, err := smart.Parse(edit.Rule)
+	if err != nil {
+		return store.SmartRule{}, fmt.Errorf("invalid rule: %w", err)
+	}
+	if _, err := smart.ParseOrder(edit.Sort); err != nil {
+		return store.SmartRule{}, err
+	}
+	if edit.Limit < 0 {
+		return store.SmartRule{}, errors.New("limit cannot be negative")
+	}
+	return store.SmartRule{Rule: rule.String(), Sort: edit.Sort, Limit: edit.Limit}, nil
+}
+
+// smartTracks returns the tracks of smart playlist p, evaluating its rule
+// against every loaded library unless a recent result is at hand.
+func (d *Daemon) smartTracks(p *store.Playlist) []metadata.AudioFile {
+	now := time.Now()
+	d.smartMu.Lock()
+	defer d.smartMu.Unlock()
+
+	if r, ok := d.smart[p.ID]; ok && r.rule == *p.Smart && now.Sub(r.at) < smartMaxAge {
+		return r.tracks
+	}
+
+	// Rules were checked when saved; one that no longer parses matches
+	// nothing rather than failing the listing.
+	rule, err := smart.Parse(p.Smart.Rule)
+	if err != nil {
+		return nil
+	}
+	order, _ := smart.ParseOrder(p.Smart.Sort)
+
+	if d.smartLibrary == nil {
+		d.smartLibrary = d.smartTrackList()
+	}
+	tracks := smart.Evaluate(rule, order, p.Smart.Limit, d.smartLibrary, p.ID, now)
+	if d.smart == nil {
+		d.smart = make(map[string]smartResult)
+	}
+	d.smart[p.ID] = smartResult{rule: *p.Smart, at: now, tracks: tracks}
+	return tracks
+}
+
+// smartTrackList gathers the tracks of every loaded library with their play
+// counts and favorite status.
+func (d *Daemon) smartTrackList() []smart.Track {
+	counts, _ := d.store.GetPlayStats()
+	favs, _ := d.store.GetFavorites()
+
+	// Indexes that match the way TrackRef.Matches does: by ID when both
+	// sides have one, by path otherwise.
+	plays := newRefIndex[store.PlayCount]()
+	for _, c := range counts {
+		plays.add(c.TrackRef, c)
+	}
+	favorites := newRefIndex[store.TrackRef]()
+	for _, f := range favs {
+		favorites.add(f, f)
+	}
+
+	files := d.loadedFiles()
+	tracks := make([]smart.Track, len(files))
+	for i, f := range files {
+		ref := store.TrackRef{ID: f.ID, Path: f.FilePath}
+		t := smart.Track{AudioFile: f, Favorite: len(favorites.match(ref)) > 0}
+		for _, c := range plays.match(ref) {
+			t.PlayCount += c.Count
+			if c.LastPlayed.After(t.LastPlayed) {
+				t.LastPlayed = c.LastPlayed
+			}
+		}
+		tracks[i] = t
+	}
+	return tracks
+}
+
+type refIndex[T any] struct {
+	byID, byPath, byPathNoID map[string][]T
+}
+
+func newRefIndex[T any]() *refIndex[T] {
+	return &refIndex[T]{
+		byID:       make(map[string][]T),
+		byPath:     make(map[string][]T),
+		byPathNoID: make(map[string][]T),
+	}
+}
+
+func (x *refIndex[T]) add(ref store.TrackRef, v T) {
+	if ref.ID != "" {
+		x.byID[ref.ID] = append(x.byID[ref.ID], v)
+	} else {
+		x.byPathNoID[ref.Path] = append(x.byPathNoID[ref.Path], v)
+	}
+	x.byPath[ref.Path] = append(x.byPath[ref.Path], v)
+}
+
+// match returns the values added under refs that ref.Matches.
+func (x *refIndex[T]) match(ref store.TrackRef) []T {
+	if ref.ID == "" {
+		return x.byPath[ref.Path]
+	}
+	return append(slices.Clip(x.byID[ref.ID]), x.byPathNoID[ref.Path]...)
+}
+
+// refreshSmart drops evaluated smart playlists once the library, play
+// counts or favorites change, and reports whether there are smart
+// playlists whose tracks may have changed with them.
+func (d *Daemon) refreshSmart() bool {
+	d.smartMu.Lock()
+	d.smart = nil
+	d.smartLibrary = nil
+	d.smartMu.Unlock()
+
+	lists, err := d.store.GetPlaylists()
+	if err != nil {
+		return false
+	}
+	for _, p := range lists {
+		if p.Smart != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SpawnTimeout is how long Spawn waits for a freshly started daemon to
+// accept connections.
+const SpawnTimeout = 5 * time.Second
+
+// Spawn starts bpvd in the background and waits until its socket accepts
+// connections. The daemon inherits our environment, so it uses the same
+// XDG directories.
+func Spawn(verbose bool) error {
+	path, err := findDaemon()
+	if err != nil {
+		return err
+	}
+
+	var args []string
+	if verbose {
+		args = append(args, "--verbose")
+	}
+	// bpvd forks and its first process exits once the daemon is started.
+	out, err := exec.Command(path, args...).CombinedOutput()
+	if err != nil && !IsRunning() {
+		msg := strings.TrimSpace(string(out))
+		if msg == "" {
+			msg = err.Error()
+		}
+		return fmt.Errorf("failed to start %s: %s", path, msg)
+	}
+
+	return WaitForSocket(SpawnTimeout)
+}
+
+// WaitForSocket polls until the daemon accepts connections or timeout passes.
+func WaitForSocket(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for !IsRunning() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("daemon did not open %s within %s", SocketPath(), timeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return nil
+}
+
+// findDaemon prefers a bpvd installed next to the running binary, so a
+// development build starts its matching daemon, and falls back to $PATH.
+func findDaemon() (string, error) {
+	if exe, err := os.Executable(); err == nil {
+		candidate := filepath.Join(filepath.Dir(exe), "bpvd")
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, nil
+		}
+	}
+	path, err := exec.LookPath("bpvd")
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", errors.New("bpvd not found next to this binary or in $PATH")
+		}
+		return "", err
+	}
+	return path, nil
+}
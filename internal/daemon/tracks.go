@@ -0,0 +1,110 @@
+package daemon
+
+import (
+	"github.com/hoppxi/bpv/internal/logger"
+	"github.com/hoppxi/bpv/internal/metadata"
+	"github.com/hoppxi/bpv/internal/store"
+)
+
+// trackRef names the track at filePath for the store, taking its ID from
+// the library cache or, for a file outside every loaded library, from the
+// file itself.
+func (d *Daemon) trackRef(filePath string) store.TrackRef {
+	return d.trackRefs([]string{filePath})[0]
+}
+
+// trackRefs is trackRef for many paths at once.
+func (d *Daemon) trackRefs(paths []string) []store.TrackRef {
+	known := d.cache.Lookup(paths)
+	refs := make([]store.TrackRef, len(paths))
+	for i, p := range paths {
+		id := known[p].ID
+		if id == "" {
+			id = metadata.TrackID(p)
+		}
+		refs[i] = store.TrackRef{ID: id, Path: p}
+	}
+	return refs
+}
+
+// trackIDs returns the ID of each of paths, or "" for paths the library
+// cache does not know.
+func (d *Daemon) trackIDs(paths []string) []string {
+	known := d.cache.Lookup(paths)
+	ids := make([]string, len(paths))
+	for i, p := range paths {
+		ids[i] = known[p].ID
+	}
+	return ids
+}
+
+// relink re-attaches favorites, play counts, playlists and the saved queue to files
+// of a freshly scanned library that have moved since they were saved, and
+// points the player's queue at them too.
+func (d *Daemon) relink(files []metadata.AudioFile) {
+	tracks := make([]store.TrackRef, 0, len(files))
+	for _, f := range files {
+		tracks = append(tracks, store.TrackRef{ID: f.ID, Path: f.FilePath})
+	}
+
+	// The player's queue is persisted under playMu, so holding it keeps a
+	// queue with the old paths from being saved over the relinked one.
+	d.playMu.Lock()
+	defer d.playMu.Unlock()
+
+	changed, err := d.store.Relink(tracks)
+	if err != nil {
+		logger.Log.Error("Failed to relink favorites and play counts: %v", err)
+		return
+	}
+	if changed {
+		logger.Log.Info("Relinked favorites and play counts to moved files")
+		d.relinkQueue(files)
+		d.publishFavorites()
+		d.publishPlaylists()
+	}
+}
+
+// relinkQueue points queued tracks at files the way Store.Relink points the
+// saved queue: a track whose file has moved takes the file of the same ID in
+// files, and one whose file has a new ID takes that file. The queue is saved
+// if any track changed. The caller holds playMu.
+func (d *Daemon) relinkQueue(files []metadata.AudioFile) {
+	byID := make(map[string]metadata.AudioFile, len(files))
+	byPath := make(map[string]metadata.AudioFile, len(files))
+	for _, f := range files {
+		if f.ID != "" {
+			byID[f.ID] = f
+			byPath[f.FilePath] = f
+		}
+	}
+
+	queue := d.player.Queue()
+	moved := false
+	for i, t := range queue {
+		at, ok := byPath[t.FilePath]
+		if ok && at.ID == t.ID {
+			continue
+		}
+		if f, found := byID[t.ID]; found && t.ID != "" {
+			queue[i] = f
+			moved = true
+		} else if ok {
+			queue[i] = at
+			moved = true
+		}
+	}
+	if moved {
+		d.player.ReplaceQueue(queue)
+		d.persistQueue()
+	}
+}
+
+// favoritePaths returns where the favorite tracks are now.
+func favoritePaths(favs []store.TrackRef) []string {
+	paths := make([]string, 0, len(favs))
+	for _, f := range favs {
+		paths = append(paths, f.Path)
+	}
+	return paths
+}
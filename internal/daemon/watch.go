@@ -0,0 +1,105 @@
+package daemon
+
+import (
+	"context"
+	"time"
+
+	"github.com/hoppxi/bpv/internal/cache"
+	"github.com/hoppxi/bpv/internal/logger"
+	"github.com/hoppxi/bpv/internal/watcher"
+)
+
+// startWatcher watches the libraries already in memory and applies changes
+// as they come in. The daemon works without it, it just needs manual rescans.
+func (d *Daemon) startWatcher() {
+	w, err := watcher.New()
+	if err != nil {
+		logger.Log.Warn("Library watching disabled: %v", err)
+		return
+	}
+
+	d.mu.Lock()
+	d.watcher = w
+	d.mu.Unlock()
+
+	for _, dir := range d.cache.Loaded() {
+		d.watchLibrary(dir)
+	}
+	go d.applyChanges(w.Changes())
+}
+
+// watchLibrary starts watching dir if it is not watched yet.
+func (d *Daemon) watchLibrary(dir string) {
+	d.mu.Lock()
+	w := d.watcher
+	d.mu.Unlock()
+	if w == nil {
+		return
+	}
+	if err := w.Add(dir); err != nil {
+		logger.Log.Warn("Not watching %s: %v", dir, err)
+	}
+}
+
+func (d *Daemon) applyChanges(changes <-chan watcher.Change) {
+	for change := range changes {
+		d.applyChange(change)
+	}
+}
+
+// applyChange updates the cached library for change.Root with what is now on
+// disk at the changed paths, and tells subscribers if anything differs.
+func (d *Daemon) applyChange(change watcher.Change) {
+	// A scan in progress may already have seen some of the changes; let it
+	// finish and update its result rather than racing it.
+	d.mu.Lock()
+	job := d.scanning[change.Root]
+	d.mu.Unlock()
+	if job != nil {
+		select {
+		case <-job.done:
+		case <-d.done:
+			return
+		}
+	}
+
+	old := d.cache.Load(change.Root)
+	if old == nil {
+		return
+	}
+
+	result, err := d.newScanner().Update(context.Background(), old.Files, old.Playlists, change.Paths)
+	if err != nil {
+		logger.Log.Error("Failed to update %s: %v", change.Root, err)
+		return
+	}
+	playlistsChanged := !samePlaylistFiles(old.Playlists, result.Playlists)
+	if result.Added+result.Changed+result.Removed == 0 && !playlistsChanged {
+		return
+	}
+
+	lib := &cache.CachedLibrary{
+		Dir:       change.Root,
+		ScanTime:  time.Now(),
+		FileCount: result.AudioFiles,
+		Files:     result.Files,
+		Artists:   result.Artists,
+		Albums:    result.Albums,
+		Genres:    result.Genres,
+		Composers: result.Composers,
+		Playlists: result.Playlists,
+		Errors:    result.Errors,
+	}
+	if err := d.cache.Save(lib); err != nil {
+		logger.Log.Error("Failed to save cache: %v", err)
+	}
+	d.relink(lib.Files)
+	if d.refreshSmart() || playlistsChanged {
+		d.publishPlaylists()
+	}
+
+	logger.Log.Info("Library %s updated from disk (%d added, %d changed, %d removed)",
+		change.Root, result.Added, result.Changed, result.Removed)
+	go d.syncIndex()
+	d.events.publish(Event{Type: EventLibraryUpdated, Dir: change.Root})
+}
@@ -0,0 +1,612 @@
+// Package index keeps an inverted index of library tracks for fast,
+// typo-tolerant search.
+//
+// Words are folded with query.Fold, so "bjork" finds "Björk". A word of a
+// query matches the indexed words it equals, those it starts ("beet" finds
+// "Beethoven"), and, for words of four letters or more, those within one or
+// two typos of it ("beethovn"). Matches are ranked by how closely the words
+// matched and by the field they matched in, titles and artists first.
+package index
+
+import (
+	"cmp"
+	"container/heap"
+	"math"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hoppxi/bpv/internal/metadata"
+	"github.com/hoppxi/bpv/internal/query"
+)
+
+// The fields words are indexed from, in order of weight.
+const (
+	fieldTitle = iota
+	fieldArtist
+	fieldAlbumArtist
+	fieldAlbum
+	fieldComposer
+	fieldGenre
+	fieldYear
+	fieldComment
+	numFields
+)
+
+var fieldWeights = [numFields]float64{1.0, 0.9, 0.8, 0.7, 0.6, 0.5, 0.5, 0.3}
+
+type Index struct {
+	mu       sync.RWMutex
+	docs     []*doc // by doc number; nil for free slots
+	free     []int32
+	byPath   map[string]int32
+	postings map[string][]posting
+	vocab    []string // the words in postings, sorted
+	// vocabStale is set when words are added to or gone from postings.
+	vocabStale bool
+	// Typo matching looks words up by length, and by the pairs of letters
+	// they contain, to try only words that could be close enough. Both hold
+	// positions in vocab.
+	byLen [][]int32
+	grams map[uint64][]int32
+	// epoch counts calls to Update; docs carry the last one that listed
+	// them.
+	epoch uint32
+}
+
+type doc struct {
+	file  metadata.AudioFile
+	text  [numFields]string // folded
+	words []string          // distinct words, to unindex the doc
+	epoch uint32
+}
+
+type posting struct {
+	doc    int32
+	fields uint16 // a bit per field the word is in
+}
+
+func New() *Index {
+	return &Index{
+		byPath:   make(map[string]int32),
+		postings: make(map[string][]posting),
+	}
+}
+
+// Len returns the number of tracks in the index.
+func (x *Index) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.byPath)
+}
+
+// Update brings the index in line with files, every track there is to
+// search. Tracks that are new are added and tracks no longer in files
+// removed; of the rest, only those whose searchable text changed are indexed
+// again. A path listed twice is indexed once.
+func (x *Index) Update(files []metadata.AudioFile) (added, changed, removed int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.epoch++
+	for _, f := range files {
+		id, ok := x.byPath[f.FilePath]
+		if ok && x.docs[id].epoch == x.epoch {
+			continue
+		}
+		if ok && sameText(&x.docs[id].file, &f) {
+			x.docs[id].file = f
+			x.docs[id].epoch = x.epoch
+			continue
+		}
+		text := fieldText(&f)
+		switch {
+		case !ok:
+			x.add(f, text)
+			added++
+		case x.docs[id].text == text:
+			// Nothing to search changed, but results return the new file.
+			x.docs[id].file = f
+			x.docs[id].epoch = x.epoch
+		default:
+			x.unindex(id)
+			x.index(id, f, text)
+			changed++
+		}
+	}
+	for path, id := range x.byPath {
+		if x.docs[id].epoch != x.epoch {
+			x.unindex(id)
+			x.docs[id] = nil
+			x.free = append(x.free, id)
+			delete(x.byPath, path)
+			removed++
+		}
+	}
+
+	if x.vocabStale {
+		x.rebuildVocab()
+	}
+	return added, changed, removed
+}
+
+func (x *Index) rebuildVocab() {
+	x.vocab = x.vocab[:0]
+	for w := range x.postings {
+		x.vocab = append(x.vocab, w)
+	}
+	sort.Strings(x.vocab)
+
+	x.byLen = x.byLen[:0]
+	x.grams = make(map[uint64][]int32)
+	var buf []uint64
+	for i, w := range x.vocab {
+		n := utf8.RuneCountInString(w)
+		for len(x.byLen) <= n {
+			x.byLen = append(x.byLen, nil)
+		}
+		x.byLen[n] = append(x.byLen[n], int32(i))
+		buf = grams(w, buf[:0])
+		for _, g := range buf {
+			x.grams[g] = append(x.grams[g], int32(i))
+		}
+	}
+	x.vocabStale = false
+}
+
+// grams appends to buf the distinct pairs of neighbouring letters in w, each
+// packed into a number, with its start and end marked so they count too:
+// "abba" has ^a, ab, bb, ba and a$.
+func grams(w string, buf []uint64) []uint64 {
+	const edge = utf8.MaxRune + 1
+	prev := rune(edge)
+	add := func(r rune) {
+		if g := uint64(prev)<<32 | uint64(r); !slices.Contains(buf, g) {
+			buf = append(buf, g)
+		}
+		prev = r
+	}
+	for _, r := range w {
+		add(r)
+	}
+	add(edge)
+	return buf
+}
+
+func (x *Index) add(f metadata.AudioFile, text [numFields]string) {
+	var id int32
+	if n := len(x.free); n > 0 {
+		id, x.free = x.free[n-1], x.free[:n-1]
+	} else {
+		id = int32(len(x.docs))
+		x.docs = append(x.docs, nil)
+	}
+	x.byPath[f.FilePath] = id
+	x.index(id, f, text)
+}
+
+func (x *Index) index(id int32, f metadata.AudioFile, text [numFields]string) {
+	fields := make(map[string]uint16)
+	var words []string
+	for field, s := range text {
+		for _, w := range Words(s) {
+			if _, ok := fields[w]; !ok {
+				words = append(words, w)
+			}
+			fields[w] |= 1 << field
+		}
+	}
+	for _, w := range words {
+		if _, ok := x.postings[w]; !ok {
+			x.vocabStale = true
+		}
+		x.postings[w] = append(x.postings[w], posting{doc: id, fields: fields[w]})
+	}
+	x.docs[id] = &doc{file: f, text: text, words: words, epoch: x.epoch}
+}
+
+func (x *Index) unindex(id int32) {
+	for _, w := range x.docs[id].words {
+		list := slices.DeleteFunc(x.postings[w], func(p posting) bool { return p.doc == id })
+		if len(list) == 0 {
+			delete(x.postings, w)
+			x.vocabStale = true
+		} else {
+			x.postings[w] = list
+		}
+	}
+}
+
+// sameText reports whether a and b have the same text to search, which
+// spares folding it again for tracks that did not change.
+func sameText(a, b *metadata.AudioFile) bool {
+	return a.Title == b.Title && a.Artist == b.Artist && a.AlbumArtist == b.AlbumArtist &&
+		a.Album == b.Album && a.Composer == b.Composer && a.Genre == b.Genre &&
+		a.Year == b.Year && a.Comment == b.Comment
+}
+
+func fieldText(f *metadata.AudioFile) [numFields]string {
+	var text [numFields]string
+	text[fieldTitle] = query.Fold(f.Title)
+	text[fieldArtist] = query.Fold(f.Artist)
+	text[fieldAlbumArtist] = query.Fold(f.AlbumArtist)
+	text[fieldAlbum] = query.Fold(f.Album)
+	text[fieldComposer] = query.Fold(f.Composer)
+	text[fieldGenre] = query.Fold(f.Genre)
+	if f.Year > 0 {
+		text[fieldYear] = strconv.Itoa(f.Year)
+	}
+	text[fieldComment] = query.Fold(f.Comment)
+	return text
+}
+
+// Words splits folded text into the words the index holds: runs of letters
+// and digits, with apostrophes dropped so "don't" is one word.
+func Words(s string) []string {
+	s = apostrophes.Replace(s)
+	return strings.FieldsFunc(s, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+}
+
+var apostrophes = strings.NewReplacer("'", "", "’", "")
+
+// Options narrow a search.
+type Options struct {
+	Offset int
+	Limit  int // 0 for no limit
+	// Under keeps only tracks inside this directory, if set.
+	Under string
+}
+
+// Search returns the tracks matching q, best match first, as picked by opts,
+// and how many tracks match in all. Bare words and phrases in q match
+// through the index; field terms such as year:1960..1969 filter as they do
+// in query.Query.Match.
+func (x *Index) Search(q *query.Query, opts Options) ([]metadata.AudioFile, int) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	// Typos are only forgiven in terms that make tracks match; "-live"
+	// should not drop tracks about love.
+	terms := q.Terms()
+	scores := make(map[string]map[int32]float64)
+	termScores := func(term string) map[int32]float64 {
+		s, ok := scores[term]
+		if !ok {
+			s = x.termScores(term, slices.Contains(terms, term))
+			scores[term] = s
+		}
+		return s
+	}
+	matchText := func(f *metadata.AudioFile, term string) bool {
+		_, ok := termScores(term)[x.byPath[f.FilePath]]
+		return ok
+	}
+
+	// Tracks that miss a required term cannot match, so when there is one,
+	// only the tracks matching its rarest term need the whole query.
+	var candidates []int32
+	if required := q.RequiredTerms(); len(required) > 0 {
+		rarest := termScores(required[0])
+		for _, term := range required[1:] {
+			if s := termScores(term); len(s) < len(rarest) {
+				rarest = s
+			}
+		}
+		candidates = make([]int32, 0, len(rarest))
+		for id := range rarest {
+			candidates = append(candidates, id)
+		}
+	} else {
+		candidates = make([]int32, 0, len(x.byPath))
+		for id, d := range x.docs {
+			if d != nil {
+				candidates = append(candidates, int32(id))
+			}
+		}
+	}
+
+	var prefix string
+	if opts.Under != "" {
+		prefix = strings.TrimSuffix(opts.Under, string(filepath.Separator)) + string(filepath.Separator)
+	}
+	var hits []hit
+	for _, id := range candidates {
+		d := x.docs[id]
+		if prefix != "" && !strings.HasPrefix(d.file.FilePath, prefix) {
+			continue
+		}
+		if !q.MatchText(&d.file, matchText) {
+			continue
+		}
+		var score float64
+		for _, term := range terms {
+			score += termScores(term)[id]
+		}
+		hits = append(hits, hit{doc: d, score: score})
+	}
+
+	total := len(hits)
+	start := min(max(opts.Offset, 0), total)
+	end := total
+	if opts.Limit > 0 {
+		end = min(start+opts.Limit, total)
+	}
+	if end < total {
+		hits = best(hits, end)
+	}
+	slices.SortFunc(hits, compareHits)
+
+	results := make([]metadata.AudioFile, 0, end-start)
+	for _, h := range hits[start:end] {
+		results = append(results, h.doc.file)
+	}
+	return results, total
+}
+
+type hit struct {
+	doc   *doc
+	score float64
+}
+
+// compareHits orders hits best first, and equally good ones by artist,
+// album, disc and track.
+func compareHits(a, b hit) int {
+	if c := cmp.Compare(b.score, a.score); c != 0 {
+		return c
+	}
+	return compareDocs(a.doc, b.doc)
+}
+
+// best returns the k best of hits, in no particular order, without sorting
+// them all.
+func best(hits []hit, k int) []hit {
+	h := make(worstFirst, 0, k)
+	for _, x := range hits {
+		switch {
+		case len(h) < k:
+			heap.Push(&h, x)
+		case compareHits(x, h[0]) < 0:
+			h[0] = x
+			heap.Fix(&h, 0)
+		}
+	}
+	return h
+}
+
+// worstFirst is a heap of hits with the worst on top.
+type worstFirst []hit
+
+func (h worstFirst) Len() int           { return len(h) }
+func (h worstFirst) Less(i, j int) bool { return compareHits(h[i], h[j]) > 0 }
+func (h worstFirst) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *worstFirst) Push(x any)        { *h = append(*h, x.(hit)) }
+func (h *worstFirst) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// compareDocs orders documents by artist, album, disc and track.
+func compareDocs(a, b *doc) int {
+	if c := strings.Compare(a.text[fieldArtist], b.text[fieldArtist]); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.text[fieldAlbum], b.text[fieldAlbum]); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.file.Disc, b.file.Disc); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.file.Track, b.file.Track); c != 0 {
+		return c
+	}
+	return strings.Compare(a.file.FilePath, b.file.FilePath)
+}
+
+// termScores returns the tracks a bare word or phrase matches and how well,
+// forgiving typos if fuzzy is set. Every word of a phrase must match, and a
+// phrase that appears as written scores higher.
+func (x *Index) termScores(term string, fuzzy bool) map[int32]float64 {
+	words := Words(term)
+	if len(words) == 0 {
+		// Punctuation alone, such as "-", has no words to look up.
+		out := make(map[int32]float64)
+		for id, d := range x.docs {
+			if d != nil && slices.ContainsFunc(d.text[:], func(s string) bool { return strings.Contains(s, term) }) {
+				out[int32(id)] = 1
+			}
+		}
+		return out
+	}
+
+	out := x.wordScores(words[0], fuzzy)
+	for _, w := range words[1:] {
+		next := x.wordScores(w, fuzzy)
+		for id, s := range out {
+			if ns, ok := next[id]; ok {
+				out[id] = s + ns
+			} else {
+				delete(out, id)
+			}
+		}
+	}
+	if len(words) > 1 {
+		for id := range out {
+			if slices.ContainsFunc(x.docs[id].text[:], func(s string) bool { return strings.Contains(s, term) }) {
+				out[id] *= 1.5
+			}
+		}
+	}
+	return out
+}
+
+// wordScores returns the tracks with a word matching w, scored by the best
+// such word: an exact match scores 1, a word w starts less, the less so the
+// longer it is, and a word with typos, if fuzzy allows them, less again.
+func (x *Index) wordScores(w string, fuzzy bool) map[int32]float64 {
+	out := make(map[int32]float64)
+	add := func(word string, quality float64) {
+		for _, p := range x.postings[word] {
+			if s := quality * weight(p.fields); s > out[p.doc] {
+				out[p.doc] = s
+			}
+		}
+	}
+
+	// The words starting with w are a run of the sorted vocabulary.
+	for i := sort.SearchStrings(x.vocab, w); i < len(x.vocab) && strings.HasPrefix(x.vocab[i], w); i++ {
+		if v := x.vocab[i]; v == w {
+			add(v, 1)
+		} else {
+			add(v, 0.5+0.3*float64(len(w))/float64(len(v)))
+		}
+	}
+
+	if k := maxTypos(w); fuzzy && k > 0 {
+		sc := scratchPool.Get().(*scratch)
+		defer scratchPool.Put(sc)
+		sc.a = append(sc.a[:0], []rune(w)...)
+		for _, i := range x.fuzzyCandidates(w, len(sc.a), k, sc) {
+			v := x.vocab[i]
+			if strings.HasPrefix(v, w) {
+				continue
+			}
+			sc.b = append(sc.b[:0], []rune(v)...)
+			if d := sc.distance(k); d <= k {
+				add(v, 0.5/float64(d+1))
+			}
+		}
+	}
+	return out
+}
+
+// fuzzyCandidates returns the positions in vocab of the words that may be
+// within k typos of w, which has n letters. An edit changes at most three
+// of the letter pairs grams lists (a swap of two letters changes three), so
+// such a word shares all but 3k of them with w. When that leaves nothing to
+// go on, every word of about the right length is a candidate.
+func (x *Index) fuzzyCandidates(w string, n, k int, sc *scratch) []int32 {
+	sc.grams = grams(w, sc.grams[:0])
+	need := len(sc.grams) - 3*k
+	sc.found = sc.found[:0]
+	if need <= 0 {
+		for l := max(n-k, 0); l <= n+k && l < len(x.byLen); l++ {
+			sc.found = append(sc.found, x.byLen[l]...)
+		}
+		return sc.found
+	}
+
+	if len(sc.counts) < len(x.vocab) {
+		sc.counts = make([]uint8, len(x.vocab))
+	}
+	for _, g := range sc.grams {
+		for _, i := range x.grams[g] {
+			switch sc.counts[i] {
+			case 0:
+				sc.touched = append(sc.touched, i)
+			case math.MaxUint8:
+				continue
+			}
+			sc.counts[i]++
+		}
+	}
+	for _, i := range sc.touched {
+		if int(sc.counts[i]) >= need {
+			if l := utf8.RuneCountInString(x.vocab[i]); l >= n-k && l <= n+k {
+				sc.found = append(sc.found, i)
+			}
+		}
+		sc.counts[i] = 0
+	}
+	sc.touched = sc.touched[:0]
+	return sc.found
+}
+
+// scratch holds the buffers typo matching needs, reused between searches.
+type scratch struct {
+	a, b             []rune
+	grams            []uint64
+	counts           []uint8 // shared letter pairs, by position in vocab
+	touched, found   []int32
+	prev2, prev, cur []int
+}
+
+var scratchPool = sync.Pool{New: func() any { return new(scratch) }}
+
+// weight is the weight of the heaviest field in fields.
+func weight(fields uint16) float64 {
+	for f := range numFields {
+		if fields&(1<<f) != 0 {
+			return fieldWeights[f]
+		}
+	}
+	return 0
+}
+
+// maxTypos is how many typos a word of a query may have and still match:
+// none for short words, which would match too much, or numbers, where 1959
+// is not a typo for 1995; one from four letters and two from eight.
+func maxTypos(w string) int {
+	if strings.ContainsFunc(w, unicode.IsDigit) {
+		return 0
+	}
+	switch n := len([]rune(w)); {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	}
+	return 0
+}
+
+// distance returns the edit distance between sc.a and sc.b, counting an
+// insertion, deletion, substitution or swap of neighbouring letters as one
+// edit. It gives up and returns k+1 as soon as the distance must exceed k.
+func (sc *scratch) distance(k int) int {
+	a, b := sc.a, sc.b
+	if abs(len(a)-len(b)) > k {
+		return k + 1
+	}
+	if cap(sc.cur) < len(b)+1 {
+		sc.prev2 = make([]int, len(b)+1)
+		sc.prev = make([]int, len(b)+1)
+		sc.cur = make([]int, len(b)+1)
+	}
+	prev2, prev, cur := sc.prev2[:len(b)+1], sc.prev[:len(b)+1], sc.cur[:len(b)+1]
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > k {
+			return k + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
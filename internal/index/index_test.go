@@ -0,0 +1,240 @@
+package index
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hoppxi/bpv/internal/metadata"
+	"github.com/hoppxi/bpv/internal/query"
+)
+
+// benchTracks is the size of the synthetic library searched by the
+// benchmarks, that of a large collection.
+const benchTracks = 100_000
+
+var syllables = strings.Fields("ba be bi bo bu da de di do du ka ke ki ko ku la le li lo lu " +
+	"ma me mi mo mu na ne ni no nu ra re ri ro ru sa se si so su ta te ti to tu " +
+	"vor mar ben tho ven gar son ell ing ton ster ber mann ley wood")
+
+// syntheticLibrary returns n tracks with made-up names, spread over artists
+// and albums the way a real library is, plus a few well-known names the
+// benchmarks look for.
+func syntheticLibrary(n int) []metadata.AudioFile {
+	r := rand.New(rand.NewPCG(1, 2))
+	word := func() string {
+		var b strings.Builder
+		for range 2 + r.IntN(3) {
+			b.WriteString(syllables[r.IntN(len(syllables))])
+		}
+		return b.String()
+	}
+	words := func(k int) string {
+		w := make([]string, k)
+		for i := range w {
+			w[i] = word()
+		}
+		return strings.Join(w, " ")
+	}
+
+	files := make([]metadata.AudioFile, 0, n)
+	for len(files) < n {
+		artist, album := words(1+r.IntN(2)), words(1+r.IntN(3))
+		year, genre := 1950+r.IntN(75), word()
+		for track := 1; track <= 12 && len(files) < n; track++ {
+			f := metadata.AudioFile{
+				FilePath: fmt.Sprintf("/music/%s/%s/%02d.flac", artist, album, track),
+				Title:    words(1 + r.IntN(4)),
+				Artist:   artist,
+				Album:    album,
+				Genre:    genre,
+				Year:     year,
+				Track:    track,
+			}
+			if len(files)%1000 == 0 {
+				f.Artist, f.Title = "Ludwig van Beethoven", "Symphony No. 5"
+			}
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+func titles(files []metadata.AudioFile) []string {
+	out := []string{}
+	for _, f := range files {
+		out = append(out, f.Title)
+	}
+	return out
+}
+
+var library = []metadata.AudioFile{
+	{FilePath: "/music/classical/01.flac", Title: "Symphony No. 5", Artist: "Ludwig van Beethoven", Album: "Symphonies", Year: 1808, Track: 1},
+	{FilePath: "/music/classical/02.flac", Title: "Für Elise", Artist: "Ludwig van Beethoven", Album: "Bagatelles", Year: 1810, Track: 2},
+	{FilePath: "/music/classical/03.flac", Title: "Moonlight Sonata", Artist: "Beethoven Tribute Band", Album: "Covers", Year: 1999, Track: 1},
+	{FilePath: "/music/pop/01.flac", Title: "Jóga", Artist: "Björk", Album: "Homogenic", Genre: "Electronic", Year: 1997, Track: 1},
+	{FilePath: "/music/pop/02.flac", Title: "All Is Full of Love", Artist: "Björk", Album: "Homogenic", Genre: "Electronic", Year: 1997, Track: 2},
+	{FilePath: "/music/pop/03.flac", Title: "Hunter", Artist: "Björk", Album: "Homogenic", Genre: "Electronic", Year: 1997, Track: 3,
+		Comment: "live"},
+	{FilePath: "/music/pop/04.flac", Title: "Cars", Artist: "Gary Numan", Album: "The Pleasure Principle", Year: 1979, Track: 1},
+	{FilePath: "/music/pop/05.flac", Title: "Don't Stop Me Now", Artist: "Queen", Album: "Jazz", Year: 1978, Track: 1},
+}
+
+func TestSearch(t *testing.T) {
+	x := New()
+	x.Update(library)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// Equally good matches come by artist, album and track.
+		{"beethoven", []string{"Moonlight Sonata", "Für Elise", "Symphony No. 5"}},
+		{"beet", []string{"Moonlight Sonata", "Für Elise", "Symphony No. 5"}},
+		{"beethovn", []string{"Moonlight Sonata", "Für Elise", "Symphony No. 5"}},
+		{"bethovenn", []string{"Moonlight Sonata", "Für Elise", "Symphony No. 5"}},
+		{"ludwig beehtoven", []string{"Für Elise", "Symphony No. 5"}},
+		{"symphny", []string{"Symphony No. 5"}},
+		{"bjork", []string{"Jóga", "All Is Full of Love", "Hunter"}},
+		{"BJÖRK joga", []string{"Jóga"}},
+		{"fur elise", []string{"Für Elise"}},
+		{"dont stop", []string{"Don't Stop Me Now"}},
+		// Words shorter than four letters must match exactly or as a
+		// prefix.
+		{"car", []string{"Cars"}},
+		{"cat", []string{}},
+		// Titles rank above the other fields, and exact matches above typos.
+		{"love", []string{"All Is Full of Love", "Hunter"}},
+		{"live", []string{"Hunter", "All Is Full of Love"}},
+		{"jazz", []string{"Don't Stop Me Now"}},
+		// Typos are not forgiven in exclusions.
+		{"bjork -live", []string{"Jóga", "All Is Full of Love"}},
+		{"bjork OR queen", []string{"Jóga", "All Is Full of Love", "Hunter", "Don't Stop Me Now"}},
+		{"homogenic year:1997 -track:2", []string{"Jóga", "Hunter"}},
+		{"year:..1900", []string{"Für Elise", "Symphony No. 5"}},
+		{"1979", []string{"Cars"}},
+		{"1978", []string{"Don't Stop Me Now"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := query.Parse(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, total := x.Search(q, Options{})
+			if !slices.Equal(titles(got), tt.want) {
+				t.Errorf("got %q, want %q", titles(got), tt.want)
+			}
+			if total != len(tt.want) {
+				t.Errorf("total = %d, want %d", total, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestSearchOptions(t *testing.T) {
+	x := New()
+	x.Update(library)
+	q, err := query.Parse("bjork")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		opts  Options
+		want  []string
+		total int
+	}{
+		{"limit", Options{Limit: 2}, []string{"Jóga", "All Is Full of Love"}, 3},
+		{"offset", Options{Offset: 1, Limit: 1}, []string{"All Is Full of Love"}, 3},
+		{"past the end", Options{Offset: 5}, []string{}, 3},
+		{"under", Options{Under: "/music/pop/"}, []string{"Jóga", "All Is Full of Love", "Hunter"}, 3},
+		{"under elsewhere", Options{Under: "/music/classical"}, []string{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, total := x.Search(q, tt.opts)
+			if !slices.Equal(titles(got), tt.want) || total != tt.total {
+				t.Errorf("got %q of %d, want %q of %d", titles(got), total, tt.want, tt.total)
+			}
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	x := New()
+	if added, changed, removed := x.Update(library); added != len(library) || changed != 0 || removed != 0 {
+		t.Fatalf("first Update = %d, %d, %d; want %d, 0, 0", added, changed, removed, len(library))
+	}
+
+	files := slices.Clone(library[:len(library)-1])
+	files[0].Title = "Eroica"
+	files[1].Bitrate = 320
+	if added, changed, removed := x.Update(files); added != 0 || changed != 1 || removed != 1 {
+		t.Fatalf("second Update = %d, %d, %d; want 0, 1, 1", added, changed, removed)
+	}
+	if x.Len() != len(files) {
+		t.Errorf("Len() = %d, want %d", x.Len(), len(files))
+	}
+
+	for text, want := range map[string][]string{
+		"eroica":  {"Eroica"},
+		"symphny": {},
+		"queen":   {},
+	} {
+		q, err := query.Parse(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := x.Search(q, Options{}); !slices.Equal(titles(got), want) {
+			t.Errorf("%s: got %q, want %q", text, titles(got), want)
+		}
+	}
+}
+
+func BenchmarkUpdate(b *testing.B) {
+	files := syntheticLibrary(benchTracks)
+	b.ReportAllocs()
+	for b.Loop() {
+		New().Update(files)
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	x := New()
+	x.Update(syntheticLibrary(benchTracks))
+	b.Logf("%d tracks, %d distinct words", x.Len(), len(x.vocab))
+
+	for _, bench := range []struct {
+		text      string
+		beethoven bool // whether the Beethoven tracks must come first
+	}{
+		{"beethoven", true},
+		{"beet", true},
+		{"beethovn", true},
+		{"symphny beethovn", true},
+		{`"symphony no"`, true},
+		{"marbenson", false},
+		{"year:1960..1969 tholu", false},
+	} {
+		q, err := query.Parse(bench.text)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if bench.beethoven {
+			results, total := x.Search(q, Options{Limit: 50})
+			if total < benchTracks/1000 || len(results) == 0 || results[0].Artist != "Ludwig van Beethoven" {
+				b.Fatalf("%s: %d results, first %+v; want the Beethoven tracks first", bench.text, total, results[:min(1, len(results))])
+			}
+		}
+		b.Run(bench.text, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				x.Search(q, Options{Limit: 50})
+			}
+		})
+	}
+}
@@ -1,14 +1,15 @@
 package library
 
 import (
+	"context"
 	"fmt"
 	"maps"
 	"slices"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/hoppxi/bpv/internal/metadata"
+	"github.com/hoppxi/bpv/internal/query"
 	"github.com/hoppxi/bpv/internal/scanner"
 )
 
@@ -24,7 +25,7 @@ type Library struct {
 
 func ScanDirectory(musicDir string) (*Library, error) {
 	sc := scanner.NewScanner()
-	result, err := sc.ScanLibrary(musicDir)
+	result, err := sc.ScanLibrary(context.Background(), musicDir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to scan library: %w", err)
 	}
@@ -103,18 +104,10 @@ func (l *Library) GenreTracks(genre string) []metadata.AudioFile {
 	return tracks
 }
 
-func (l *Library) Search(query string) []metadata.AudioFile {
-	q := strings.ToLower(query)
-	var results []metadata.AudioFile
-	for _, f := range l.Files() {
-		if strings.Contains(strings.ToLower(f.Title), q) ||
-			strings.Contains(strings.ToLower(f.Artist), q) ||
-			strings.Contains(strings.ToLower(f.Album), q) ||
-			strings.Contains(strings.ToLower(f.Genre), q) {
-			results = append(results, f)
-		}
-	}
-	return results
+// Search returns the tracks matching a query such as
+// `artist:"miles davis" year:1955..1965`; see package query.
+func (l *Library) Search(text string) ([]metadata.AudioFile, error) {
+	return query.Search(l.Files(), text)
 }
 
 func (l *Library) TotalTracks() int {
@@ -2,59 +2,69 @@ package metadata
 
 import (
 	"bytes"
-	"encoding/base64"
 	"fmt"
 	"image"
-	"image/jpeg"
+	_ "image/jpeg"
+	_ "image/png"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dhowden/tag"
+	"github.com/hoppxi/bpv/internal/artwork"
 	"github.com/hoppxi/bpv/internal/logger"
 )
 
 type AudioFile struct {
-	FilePath     string         `json:"file_path"`
-	FileName     string         `json:"file_name"`
-	FileSize     int64          `json:"file_size"`
-	FileType     string         `json:"file_type"`
-	Modified     time.Time      `json:"modified"`
-	Title        string         `json:"title"`
-	Artist       string         `json:"artist"`
-	Album        string         `json:"album"`
-	AlbumArtist  string         `json:"album_artist"`
-	Composer     string         `json:"composer"`
-	Genre        string         `json:"genre"`
-	Year         int            `json:"year"`
-	Track        int            `json:"track"`
-	TotalTracks  int            `json:"total_tracks"`
-	Disc         int            `json:"disc"`
-	TotalDiscs   int            `json:"total_discs"`
-	Duration     time.Duration  `json:"duration"`
-	Bitrate      int            `json:"bitrate"`
-	SampleRate   int            `json:"sample_rate"`
-	Channels     int            `json:"channels"`
-	Comment      string         `json:"comment"`
-	Lyrics       string         `json:"lyrics"`
-	BPM          int            `json:"bpm"`
-	CoverArt     string         `json:"cover_art,omitempty"` // Base64 encoded
-	CoverArtMime string         `json:"cover_art_mime,omitempty"`
-	RawMetadata  map[string]any `json:"raw_metadata,omitempty"`
-	Error        string         `json:"error,omitempty"`
+	ID          string         `json:"id,omitempty"` // Stable across renames and moves; see trackID
+	FilePath    string         `json:"file_path"`
+	FileName    string         `json:"file_name"`
+	FileSize    int64          `json:"file_size"`
+	FileType    string         `json:"file_type"`
+	Modified    time.Time      `json:"modified"`
+	Added       time.Time      `json:"added,omitempty"` // When a scan first found the file
+	Title       string         `json:"title"`
+	Artist      string         `json:"artist"`
+	Album       string         `json:"album"`
+	AlbumArtist string         `json:"album_artist"`
+	Composer    string         `json:"composer"`
+	Genre       string         `json:"genre"`
+	Year        int            `json:"year"`
+	Track       int            `json:"track"`
+	TotalTracks int            `json:"total_tracks"`
+	Disc        int            `json:"disc"`
+	TotalDiscs  int            `json:"total_discs"`
+	Duration    time.Duration  `json:"duration"`
+	Bitrate     int            `json:"bitrate"`
+	SampleRate  int            `json:"sample_rate"`
+	Channels    int            `json:"channels"`
+	Comment     string         `json:"comment"`
+	Lyrics      string         `json:"lyrics"`
+	BPM         int            `json:"bpm"`
+	CoverID     string         `json:"cover_id,omitempty"`     // Key in the artwork store
+	CoverSource string         `json:"cover_source,omitempty"` // CoverEmbedded or CoverFolder
+	CoverFile   string         `json:"cover_file,omitempty"`   // Folder image the cover came from
+	RawMetadata map[string]any `json:"raw_metadata,omitempty"`
+	Error       string         `json:"error,omitempty"`
 }
 
 type Extractor struct {
 	extractCoverArt bool
-	maxCoverSize    int
+	artwork         *artwork.Store
+
+	folderArt []string
+	folderMu  sync.Mutex
+	folders   map[string]folderCover
 }
 
 func NewExtractor() *Extractor {
 	return &Extractor{
 		extractCoverArt: true,
-		maxCoverSize:    300, // max dimension for cover art
+		folderArt:       DefaultFolderArt,
+		folders:         make(map[string]folderCover),
 	}
 }
 
@@ -99,13 +109,23 @@ func (e *Extractor) ExtractFromFile(filePath string) (*AudioFile, error) {
 			logger.Log.Warn("Failed to extract metadata from %s: %v", filePath, err)
 		}
 		audioFile.Error = fmt.Sprintf("Metadata extraction error: %v", err)
+		audioFile.ID = trackID(file, info.Size(), nil)
+		if e.extractCoverArt {
+			e.useFolderCover(audioFile)
+		}
 		return audioFile, nil
 	}
 
 	e.populateBasicMetadata(audioFile, metadata)
+	audioFile.ID = trackID(file, info.Size(), metadata)
 
-	if e.extractCoverArt && metadata != nil {
-		e.extractCoverArtData(audioFile, metadata)
+	if e.extractCoverArt {
+		if metadata != nil {
+			e.extractCoverArtData(audioFile, metadata)
+		}
+		if audioFile.CoverID == "" {
+			e.useFolderCover(audioFile)
+		}
 	}
 
 	// Reset file pointer again for technical metadata extraction
@@ -194,8 +214,11 @@ func (e *Extractor) populateBasicMetadata(audioFile *AudioFile, metadata tag.Met
 	}
 }
 
+// extractCoverArtData stores the embedded picture in the artwork store and
+// records its ID. The picture is kept as embedded; the store renders the
+// sizes clients ask for.
 func (e *Extractor) extractCoverArtData(audioFile *AudioFile, metadata tag.Metadata) {
-	if metadata == nil {
+	if metadata == nil || e.artwork == nil {
 		return
 	}
 
@@ -208,84 +231,26 @@ func (e *Extractor) extractCoverArtData(audioFile *AudioFile, metadata tag.Metad
 		return
 	}
 
-	audioFile.CoverArtMime = picture.MIMEType
-
-	// For non-JPEG images, we'll convert to JPEG
-	if picture.MIMEType != "image/jpeg" {
-		img, _, err := image.Decode(bytes.NewReader(picture.Data))
-		if err != nil {
-			if logger.Log.IsVerbose() {
-				logger.Log.Warn("Failed to decode cover art: %v", err)
-			}
-			return
-		}
-
-		img = e.resizeImage(img)
-
-		var buf bytes.Buffer
-		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
-		if err != nil {
-			if logger.Log.IsVerbose() {
-				logger.Log.Warn("Failed to encode cover art as JPEG: %v", err)
-			}
-			return
-		}
-
-		audioFile.CoverArt = base64.StdEncoding.EncodeToString(buf.Bytes())
-		audioFile.CoverArtMime = "image/jpeg"
-	} else {
-		// For JPEG, we can use the original data but may need to resize
-		img, _, err := image.Decode(bytes.NewReader(picture.Data))
-		if err != nil {
-			if logger.Log.IsVerbose() {
-				logger.Log.Warn("Failed to decode JPEG cover art: %v", err)
-			}
-			return
-		}
-
-		img = e.resizeImage(img)
-
-		var buf bytes.Buffer
-		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
-		if err != nil {
-			if logger.Log.IsVerbose() {
-				logger.Log.Warn("Failed to re-encode JPEG cover art: %v", err)
-			}
-			return
-		}
-
-		audioFile.CoverArt = base64.StdEncoding.EncodeToString(buf.Bytes())
-	}
-}
-
-func (e *Extractor) resizeImage(img image.Image) image.Image {
-	bounds := img.Bounds()
-	width, height := bounds.Dx(), bounds.Dy()
-
-	if width <= e.maxCoverSize && height <= e.maxCoverSize {
-		return img
-	}
-
-	var newWidth, newHeight int
-	if width > height {
-		newWidth = e.maxCoverSize
-		newHeight = int(float64(height) * float64(e.maxCoverSize) / float64(width))
-	} else {
-		newHeight = e.maxCoverSize
-		newWidth = int(float64(width) * float64(e.maxCoverSize) / float64(height))
+	id := artwork.ID(picture.Data)
+	if e.artwork.Has(id) {
+		audioFile.CoverID = id
+		audioFile.CoverSource = CoverEmbedded
+		return
 	}
 
-	resized := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
-
-	for y := 0; y < newHeight; y++ {
-		for x := 0; x < newWidth; x++ {
-			srcX := x * width / newWidth
-			srcY := y * height / newHeight
-			resized.Set(x, y, img.At(srcX, srcY))
+	if _, _, err := image.DecodeConfig(bytes.NewReader(picture.Data)); err != nil {
+		if logger.Log.IsVerbose() {
+			logger.Log.Warn("Failed to decode cover art (%s): %v", picture.MIMEType, err)
 		}
+		return
 	}
 
-	return resized
+	if err := e.artwork.Put(id, picture.Data); err != nil {
+		logger.Log.Warn("Failed to store cover art for %s: %v", audioFile.FileName, err)
+		return
+	}
+	audioFile.CoverID = id
+	audioFile.CoverSource = CoverEmbedded
 }
 
 func (e *Extractor) populateTechnicalMetadata(audioFile *AudioFile, _ *os.File, metadata tag.Metadata) {
@@ -369,14 +334,14 @@ func (e *Extractor) populateTechnicalMetadata(audioFile *AudioFile, _ *os.File,
 	}
 }
 
-func (e *Extractor) SetExtractCoverArt(extract bool) {
-	e.extractCoverArt = extract
+// SetArtworkStore makes the extractor save embedded pictures to store. An
+// extractor without a store leaves CoverID empty.
+func (e *Extractor) SetArtworkStore(store *artwork.Store) {
+	e.artwork = store
 }
 
-func (e *Extractor) SetMaxCoverSize(size int) {
-	if size > 0 {
-		e.maxCoverSize = size
-	}
+func (e *Extractor) SetExtractCoverArt(extract bool) {
+	e.extractCoverArt = extract
 }
 
 func (e *Extractor) ExtractFromFiles(filePaths []string) ([]*AudioFile, []error) {
@@ -0,0 +1,126 @@
+package metadata
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hoppxi/bpv/internal/artwork"
+	"github.com/hoppxi/bpv/internal/logger"
+)
+
+// Where a track's cover art came from.
+const (
+	CoverEmbedded = "embedded"
+	CoverFolder   = "folder"
+)
+
+// DefaultFolderArt lists the images looked for next to a track without
+// embedded art, best first. Patterns use filepath.Match syntax and are
+// matched case-insensitively.
+var DefaultFolderArt = []string{"cover.*", "folder.*", "front.*", "album.*", "albumart*.*"}
+
+var imageExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+}
+
+// IsImageFile reports whether name has an extension folder art can have.
+func IsImageFile(name string) bool {
+	return imageExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+type folderCover struct {
+	id   string
+	file string
+}
+
+// SetFolderArt sets the folder image patterns, best first. An empty list
+// means DefaultFolderArt.
+func (e *Extractor) SetFolderArt(patterns []string) {
+	if len(patterns) == 0 {
+		patterns = DefaultFolderArt
+	}
+	e.folderMu.Lock()
+	e.folderArt = patterns
+	clear(e.folders)
+	e.folderMu.Unlock()
+}
+
+// FolderCover returns the cover ID and path of the folder image for tracks
+// in dir, or empty strings when there is none. Each directory is looked at
+// once per extractor.
+func (e *Extractor) FolderCover(dir string) (id, file string) {
+	if e.artwork == nil {
+		return "", ""
+	}
+
+	e.folderMu.Lock()
+	cover, ok := e.folders[dir]
+	patterns := e.folderArt
+	e.folderMu.Unlock()
+	if ok {
+		return cover.id, cover.file
+	}
+
+	cover = e.findFolderCover(dir, patterns)
+	e.folderMu.Lock()
+	e.folders[dir] = cover
+	e.folderMu.Unlock()
+	return cover.id, cover.file
+}
+
+func (e *Extractor) findFolderCover(dir string, patterns []string) folderCover {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return folderCover{}
+	}
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		for _, entry := range entries {
+			if entry.IsDir() || !IsImageFile(entry.Name()) {
+				continue
+			}
+			if ok, _ := filepath.Match(pattern, strings.ToLower(entry.Name())); !ok {
+				continue
+			}
+
+			path := filepath.Join(dir, entry.Name())
+			data, err := os.ReadFile(path)
+			if err != nil {
+				continue
+			}
+			if _, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil {
+				if logger.Log.IsVerbose() {
+					logger.Log.Warn("Skipping unreadable folder art %s: %v", path, err)
+				}
+				continue
+			}
+
+			id := artwork.ID(data)
+			if !e.artwork.Has(id) {
+				if err := e.artwork.Put(id, data); err != nil {
+					logger.Log.Warn("Failed to store folder art %s: %v", path, err)
+					continue
+				}
+			}
+			return folderCover{id: id, file: path}
+		}
+	}
+	return folderCover{}
+}
+
+// useFolderCover gives audioFile the folder image from its directory.
+func (e *Extractor) useFolderCover(audioFile *AudioFile) {
+	id, file := e.FolderCover(filepath.Dir(audioFile.FilePath))
+	if id == "" {
+		return
+	}
+	audioFile.CoverID = id
+	audioFile.CoverSource = CoverFolder
+	audioFile.CoverFile = file
+}
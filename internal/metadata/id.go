@@ -0,0 +1,281 @@
+package metadata
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/dhowden/tag"
+	"github.com/dhowden/tag/mbz"
+)
+
+// Track IDs are prefixed with where they came from.
+const (
+	idMusicBrainz = "mb:"
+	idAudio       = "au:"
+)
+
+// idSample is how much audio is hashed from each end of a file. The whole
+// stream is not read, so an ID costs two small reads however long the track.
+const idSample = 64 << 10
+
+// TrackID returns the stable ID of the audio file at path, or "" when it
+// cannot be read. ExtractFromFile fills in AudioFile.ID the same way.
+func TrackID(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return ""
+	}
+	m, _ := tag.ReadFrom(f)
+	return trackID(f, info.Size(), m)
+}
+
+// trackID returns a stable ID for the open file f. Tracks tagged by
+// MusicBrainz Picard use their release track ID, or recording and release
+// IDs together, so retagging them by hand keeps their ID. Anything else is
+// identified by a hash of its audio, which tag edits, renames and moves do
+// not change. Tagging a file with MusicBrainz IDs for the first time does
+// change its ID; Store.Relink follows it by path when that happens.
+func trackID(f *os.File, size int64, m tag.Metadata) string {
+	if m != nil {
+		info := mbz.Extract(m)
+		if id := info.Get(mbz.Track); id != "" {
+			return idMusicBrainz + strings.ToLower(id)
+		}
+		if rec, rel := info.Get(mbz.Recording), info.Get(mbz.Album); rec != "" && rel != "" {
+			return idMusicBrainz + strings.ToLower(rec) + "/" + strings.ToLower(rel)
+		}
+	}
+
+	start, end, ogg, err := audioRange(f, size)
+	if err != nil || end <= start {
+		return ""
+	}
+
+	// Ogg pages are hashed without their headers, whose sequence numbers
+	// shift when a bigger or smaller comment header takes more or fewer
+	// pages.
+	hash := hashRange
+	if ogg {
+		hash = hashOggPages
+	}
+
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, end-start)
+	if err := hash(h, f, start, min(end, start+idSample)); err != nil {
+		return ""
+	}
+	if tail := max(start+idSample, end-idSample); tail < end {
+		if ogg {
+			tail, err = nextOggPage(f, tail, end)
+			if err != nil {
+				return ""
+			}
+		}
+		if err := hash(h, f, tail, end); err != nil {
+			return ""
+		}
+	}
+	return idAudio + hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+func hashRange(w io.Writer, f *os.File, start, end int64) error {
+	_, err := io.Copy(w, io.NewSectionReader(f, start, end-start))
+	return err
+}
+
+// audioRange returns where the audio of f starts and ends, leaving out
+// ID3v2, FLAC metadata blocks, MP4 atoms other than mdat, WAV and AIFF
+// chunks other than the sound data, the header packets of Ogg Vorbis and
+// Opus and a trailing ID3v1 tag. ogg is set when the range is made of Ogg
+// pages. Other Ogg streams are hashed whole, so their tags are part of the
+// ID.
+func audioRange(f *os.File, size int64) (start, end int64, ogg bool, err error) {
+	head := make([]byte, 12)
+	if _, err := f.ReadAt(head, 0); err != nil {
+		return 0, 0, false, err
+	}
+
+	switch {
+	case string(head[:4]) == "fLaC":
+		start, end, err := flacAudioRange(f, size)
+		return start, end, false, err
+	case string(head[4:8]) == "ftyp":
+		start, length, ok, err := findAtom(f, 0, size, "mdat")
+		if err != nil || !ok {
+			return 0, 0, false, err
+		}
+		return start, start + length, false, nil
+	case string(head[:4]) == "RIFF" && string(head[8:12]) == "WAVE":
+		start, end := chunkRange(f, size, "data", binary.LittleEndian)
+		return start, end, false, nil
+	case string(head[:4]) == "FORM" && (string(head[8:12]) == "AIFF" || string(head[8:12]) == "AIFC"):
+		start, end := chunkRange(f, size, "SSND", binary.BigEndian)
+		return start, end, false, nil
+	case string(head[:4]) == "OggS":
+		if start, ok, err := oggAudioStart(f, size); err == nil && ok {
+			return start, size, true, nil
+		}
+		return 0, size, false, nil
+	}
+
+	if string(head[:3]) == "ID3" {
+		// Syncsafe size, plus a footer when flagged.
+		start = 10 + (int64(head[6])<<21 | int64(head[7])<<14 | int64(head[8])<<7 | int64(head[9]))
+		if head[5]&0x10 != 0 {
+			start += 10
+		}
+	}
+
+	end = size
+	if size >= 128 {
+		tail := make([]byte, 3)
+		if _, err := f.ReadAt(tail, size-128); err == nil && string(tail) == "TAG" {
+			end -= 128
+		}
+	}
+	return start, end, false, nil
+}
+
+// chunkRange returns the body of the chunk named id in a RIFF or IFF file,
+// or the whole file when there is none.
+func chunkRange(f *os.File, size int64, id string, order binary.ByteOrder) (int64, int64) {
+	header := make([]byte, 8)
+	for offset := int64(12); offset+8 <= size; {
+		if _, err := f.ReadAt(header, offset); err != nil {
+			break
+		}
+		length := int64(order.Uint32(header[4:]))
+		if string(header[:4]) == id {
+			return offset + 8, min(offset+8+length, size)
+		}
+		offset += 8 + length + length&1
+	}
+	return 0, size
+}
+
+// oggPageHeader is the fixed part of an Ogg page header, before the table
+// of segment lengths.
+const oggPageHeader = 27
+
+// oggPage reads the header of the Ogg page at offset and returns where its
+// body starts, its segment lengths and the body length.
+func oggPage(f *os.File, offset int64) (body int64, segments []byte, length int64, err error) {
+	header := make([]byte, oggPageHeader)
+	if _, err := f.ReadAt(header, offset); err != nil {
+		return 0, nil, 0, err
+	}
+	if string(header[:4]) != "OggS" {
+		return 0, nil, 0, errors.New("lost Ogg page sync")
+	}
+	segments = make([]byte, header[26])
+	if _, err := f.ReadAt(segments, offset+oggPageHeader); err != nil {
+		return 0, nil, 0, err
+	}
+	for _, n := range segments {
+		length += int64(n)
+	}
+	return offset + oggPageHeader + int64(len(segments)), segments, length, nil
+}
+
+// oggAudioStart returns where the first audio page of an Ogg Vorbis or Opus
+// stream starts, after its identification, comment and, for Vorbis, setup
+// headers. Both formats start audio on a fresh page. ok is false for other
+// codecs.
+func oggAudioStart(f *os.File, size int64) (start int64, ok bool, err error) {
+	var serial []byte
+	headers, packets := 0, 0
+	for offset := int64(0); offset+oggPageHeader <= size; {
+		body, segments, length, err := oggPage(f, offset)
+		if err != nil {
+			return 0, false, err
+		}
+		id := make([]byte, 4)
+		if _, err := f.ReadAt(id, offset+14); err != nil {
+			return 0, false, err
+		}
+
+		if serial == nil {
+			serial = id
+			magic := make([]byte, 8)
+			if _, err := f.ReadAt(magic, body); err != nil {
+				return 0, false, err
+			}
+			switch {
+			case string(magic[:7]) == "\x01vorbis":
+				headers = 3
+			case string(magic) == "OpusHead":
+				headers = 2
+			default:
+				return 0, false, nil
+			}
+		}
+		// Pages of other streams multiplexed in the same file are skipped.
+		if string(id) == string(serial) {
+			for _, n := range segments {
+				if n < 255 {
+					packets++
+				}
+			}
+		}
+
+		offset = body + length
+		if packets >= headers {
+			return offset, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// hashOggPages writes the bodies of the Ogg pages from start up to end to w.
+func hashOggPages(w io.Writer, f *os.File, start, end int64) error {
+	for offset := start; offset+oggPageHeader <= end; {
+		body, _, length, err := oggPage(f, offset)
+		if err != nil {
+			return err
+		}
+		if err := hashRange(w, f, body, min(body+length, end)); err != nil {
+			return err
+		}
+		offset = body + length
+	}
+	return nil
+}
+
+// nextOggPage returns where the first Ogg page at or after offset starts.
+func nextOggPage(f *os.File, offset, end int64) (int64, error) {
+	buf := make([]byte, end-offset)
+	if _, err := f.ReadAt(buf, offset); err != nil {
+		return 0, err
+	}
+	i := bytes.Index(buf, []byte("OggS"))
+	if i < 0 {
+		return end, nil
+	}
+	return offset + int64(i), nil
+}
+
+func flacAudioRange(f *os.File, size int64) (int64, int64, error) {
+	offset := int64(4)
+	header := make([]byte, 4)
+	for {
+		if _, err := f.ReadAt(header, offset); err != nil {
+			return 0, 0, err
+		}
+		offset += 4 + (int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3]))
+		if header[0]&0x80 != 0 {
+			return offset, size, nil
+		}
+	}
+}
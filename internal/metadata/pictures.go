@@ -0,0 +1,373 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/dhowden/tag"
+	"github.com/hoppxi/bpv/internal/artwork"
+	"github.com/hoppxi/bpv/internal/logger"
+)
+
+// Picture is one image embedded in an audio file.
+type Picture struct {
+	ID          string `json:"id,omitempty"` // Key in the artwork store
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	Mime        string `json:"mime"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Size        int    `json:"size"`
+	Data        []byte `json:"-"`
+}
+
+// Picture types as numbered by ID3v2 APIC and FLAC PICTURE, named the way
+// dhowden/tag names them.
+var pictureTypes = map[uint32]string{
+	0x00: "Other",
+	0x01: "32x32 pixels 'file icon' (PNG only)",
+	0x02: "Other file icon",
+	0x03: "Cover (front)",
+	0x04: "Cover (back)",
+	0x05: "Leaflet page",
+	0x06: "Media (e.g. lable side of CD)",
+	0x07: "Lead artist/lead performer/soloist",
+	0x08: "Artist/performer",
+	0x09: "Conductor",
+	0x0A: "Band/Orchestra",
+	0x0B: "Composer",
+	0x0C: "Lyricist/text writer",
+	0x0D: "Recording Location",
+	0x0E: "During recording",
+	0x0F: "During performance",
+	0x10: "Movie/video screen capture",
+	0x11: "A bright coloured fish",
+	0x12: "Illustration",
+	0x13: "Band/artist logotype",
+	0x14: "Publisher/Studio logotype",
+}
+
+// PictureFront is the type of a front cover.
+const PictureFront = "Cover (front)"
+
+// ReadPictures returns every picture embedded in the file at path, in the
+// order they are stored. Pictures that do not decode as an image are left
+// out. tag.Metadata.Picture only exposes one picture, so FLAC and MP4 are
+// read here; ID3v2 pictures come from the raw frames.
+func ReadPictures(path string) ([]Picture, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(f, head); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var pictures []Picture
+	switch {
+	case string(head[:4]) == "fLaC":
+		pictures, err = readFLACPictures(f)
+	case string(head[4:8]) == "ftyp":
+		pictures, err = readMP4Pictures(f)
+	default:
+		pictures, err = readTagPictures(f)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	valid := pictures[:0]
+	for _, p := range pictures {
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(p.Data))
+		if err != nil {
+			if logger.Log.IsVerbose() {
+				logger.Log.Warn("Skipping unreadable %s picture in %s: %v", p.Type, path, err)
+			}
+			continue
+		}
+		p.Width, p.Height, p.Size = cfg.Width, cfg.Height, len(p.Data)
+		if p.Mime == "" {
+			p.Mime = "image/" + format
+		}
+		valid = append(valid, p)
+	}
+	return valid, nil
+}
+
+// Pictures returns every picture embedded in the file at filePath, each
+// saved to the artwork store so it can be fetched by ID.
+func (e *Extractor) Pictures(filePath string) ([]Picture, error) {
+	pictures, err := ReadPictures(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if e.artwork == nil {
+		return pictures, nil
+	}
+
+	for i := range pictures {
+		p := &pictures[i]
+		id := artwork.ID(p.Data)
+		if !e.artwork.Has(id) {
+			if err := e.artwork.Put(id, p.Data); err != nil {
+				logger.Log.Warn("Failed to store %s picture of %s: %v", p.Type, filePath, err)
+				continue
+			}
+		}
+		p.ID = id
+	}
+	return pictures, nil
+}
+
+// readTagPictures collects the pictures dhowden/tag found. ID3v2 keeps
+// repeated frames as APIC, APIC_0, APIC_1 and so on in Raw.
+func readTagPictures(r io.ReadSeeker) ([]Picture, error) {
+	m, err := tag.ReadFrom(r)
+	if err != nil {
+		if errors.Is(err, tag.ErrNoTagsFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	raw := m.Raw()
+	var keys []string
+	for k, v := range raw {
+		if _, ok := v.(*tag.Picture); ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) < len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+
+	var pictures []Picture
+	for _, k := range keys {
+		pictures = append(pictures, fromTagPicture(raw[k].(*tag.Picture)))
+	}
+	if len(pictures) == 0 {
+		// Vorbis comments and MP4 tags keep their picture outside Raw.
+		if p := m.Picture(); p != nil {
+			pictures = append(pictures, fromTagPicture(p))
+		}
+	}
+	return pictures, nil
+}
+
+func fromTagPicture(p *tag.Picture) Picture {
+	typ := p.Type
+	if typ == "" {
+		typ = PictureFront
+	}
+	return Picture{Type: typ, Description: p.Description, Mime: p.MIMEType, Data: p.Data}
+}
+
+// readFLACPictures reads every PICTURE block of a FLAC stream.
+func readFLACPictures(r io.ReadSeeker) ([]Picture, error) {
+	if _, err := r.Seek(4, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var pictures []Picture
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, fmt.Errorf("failed to read FLAC block: %w", err)
+		}
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7f
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+
+		if blockType == 6 {
+			block := make([]byte, length)
+			if _, err := io.ReadFull(r, block); err != nil {
+				return nil, fmt.Errorf("failed to read FLAC picture: %w", err)
+			}
+			p, err := parseFLACPicture(block)
+			if err != nil {
+				return nil, err
+			}
+			pictures = append(pictures, p)
+		} else if _, err := r.Seek(length, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+
+		if last {
+			return pictures, nil
+		}
+	}
+}
+
+// parseFLACPicture decodes the body of a FLAC PICTURE block.
+func parseFLACPicture(b []byte) (Picture, error) {
+	errBad := errors.New("invalid FLAC picture block")
+	next := func(n int) ([]byte, error) {
+		if n < 0 || len(b) < n {
+			return nil, errBad
+		}
+		v := b[:n]
+		b = b[n:]
+		return v, nil
+	}
+	u32 := func() (uint32, error) {
+		v, err := next(4)
+		if err != nil {
+			return 0, err
+		}
+		return binary.BigEndian.Uint32(v), nil
+	}
+
+	typ, err := u32()
+	if err != nil {
+		return Picture{}, err
+	}
+	mimeLen, err := u32()
+	if err != nil {
+		return Picture{}, err
+	}
+	mime, err := next(int(mimeLen))
+	if err != nil {
+		return Picture{}, err
+	}
+	descLen, err := u32()
+	if err != nil {
+		return Picture{}, err
+	}
+	desc, err := next(int(descLen))
+	if err != nil {
+		return Picture{}, err
+	}
+	// Width, height, colour depth and palette size; the image itself is
+	// measured instead, since taggers often leave these zero.
+	if _, err := next(16); err != nil {
+		return Picture{}, err
+	}
+	dataLen, err := u32()
+	if err != nil {
+		return Picture{}, err
+	}
+	data, err := next(int(dataLen))
+	if err != nil {
+		return Picture{}, err
+	}
+
+	name, ok := pictureTypes[typ]
+	if !ok {
+		name = pictureTypes[0]
+	}
+	return Picture{Type: name, Description: string(desc), Mime: string(mime), Data: data}, nil
+}
+
+// readMP4Pictures reads every data atom under moov.udta.meta.ilst.covr.
+// MP4 has no picture types, so the first image is taken as the front cover.
+func readMP4Pictures(r io.ReadSeeker) ([]Picture, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	start, size := int64(0), end
+	for _, name := range []string{"moov", "udta", "meta", "ilst", "covr"} {
+		var ok bool
+		start, size, ok, err = findAtom(r, start, size, name)
+		if err != nil || !ok {
+			return nil, err
+		}
+		if name == "meta" {
+			// meta is a full box: version and flags come before its children.
+			start, size = start+4, size-4
+		}
+	}
+
+	var pictures []Picture
+	for size > 0 {
+		name, body, atomSize, err := readAtomAt(r, start, size)
+		if err != nil {
+			return nil, err
+		}
+		if name == "data" && body > 8 {
+			// Type indicator (version and class) and locale precede the image.
+			buf := make([]byte, body)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return nil, err
+			}
+			p := Picture{Type: "Other", Data: buf[8:]}
+			if len(pictures) == 0 {
+				p.Type = PictureFront
+			}
+			switch buf[3] {
+			case 13:
+				p.Mime = "image/jpeg"
+			case 14:
+				p.Mime = "image/png"
+			}
+			pictures = append(pictures, p)
+		}
+		start, size = start+atomSize, size-atomSize
+	}
+	return pictures, nil
+}
+
+// findAtom looks for the atom called name among the atoms in the size bytes
+// at start, and returns where its body starts and how long it is.
+func findAtom(r io.ReadSeeker, start, size int64, name string) (int64, int64, bool, error) {
+	for size > 0 {
+		atomName, body, atomSize, err := readAtomAt(r, start, size)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		if atomName == name {
+			return start + atomSize - body, body, true, nil
+		}
+		start, size = start+atomSize, size-atomSize
+	}
+	return 0, 0, false, nil
+}
+
+// readAtomAt reads the atom header at start, leaving r at the atom's body.
+// It returns the atom's name, body length and total length.
+func readAtomAt(r io.ReadSeeker, start, avail int64) (string, int64, int64, error) {
+	errBad := errors.New("invalid MP4 atom")
+	if avail < 8 {
+		return "", 0, 0, errBad
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return "", 0, 0, err
+	}
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return "", 0, 0, err
+	}
+	size := int64(binary.BigEndian.Uint32(header[:4]))
+	headerLen := int64(8)
+	switch size {
+	case 0:
+		size = avail
+	case 1:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return "", 0, 0, err
+		}
+		size = int64(binary.BigEndian.Uint64(ext[:]))
+		headerLen = 16
+	}
+	if size < headerLen || size > avail {
+		return "", 0, 0, errBad
+	}
+	return string(header[4:8]), size - headerLen, size, nil
+}
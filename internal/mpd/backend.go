@@ -0,0 +1,58 @@
+package mpd
+
+import (
+	"time"
+
+	"github.com/hoppxi/bpv/internal/cache"
+	"github.com/hoppxi/bpv/internal/metadata"
+	"github.com/hoppxi/bpv/internal/playback"
+)
+
+// Subsystems reported by the idle command.
+const (
+	SubsystemDatabase = "database"
+	SubsystemPlaylist = "playlist"
+	SubsystemPlayer   = "player"
+	SubsystemMixer    = "mixer"
+	SubsystemOptions  = "options"
+)
+
+var subsystems = []string{
+	SubsystemDatabase,
+	SubsystemPlaylist,
+	SubsystemPlayer,
+	SubsystemMixer,
+	SubsystemOptions,
+}
+
+// Backend is what the MPD frontend drives. bpvd implements it on top of its
+// library cache, queue and player so MPD clients and bpv clients share the
+// same state.
+type Backend interface {
+	// Library returns the library MPD clients browse, or nil when nothing
+	// has been scanned yet. URIs are paths relative to its Dir.
+	Library() *cache.CachedLibrary
+	Status() playback.Status
+	Queue() []metadata.AudioFile
+
+	// Play starts the queue item at index; a negative index resumes or
+	// starts the current one.
+	Play(index int) error
+	SetPaused(paused bool) error
+	TogglePause() error
+	Stop() error
+	Next() error
+	Previous() error
+	Seek(pos time.Duration) error
+	SetVolume(pct int) error
+	SetShuffle(on bool) error
+	SetRepeat(mode playback.RepeatMode) error
+
+	// Add appends a file, or every track inside a directory, to the queue.
+	Add(path string) error
+	Clear() error
+
+	// Watch streams the names of subsystems as they change until cancel is
+	// called.
+	Watch() (changes <-chan string, cancel func())
+}
@@ -0,0 +1,636 @@
+package mpd
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hoppxi/bpv/internal/cache"
+	"github.com/hoppxi/bpv/internal/metadata"
+	"github.com/hoppxi/bpv/internal/playback"
+)
+
+type command struct {
+	min, max int // argument count; max -1 means unbounded
+	fn       func(c *client, args []string) error
+}
+
+var commandTable map[string]command
+
+func init() {
+	commandTable = map[string]command{
+		"ping":               {0, 0, func(*client, []string) error { return nil }},
+		"password":           {1, 1, func(*client, []string) error { return nil }},
+		"commands":           {0, 0, cmdCommands},
+		"notcommands":        {0, 0, func(*client, []string) error { return nil }},
+		"tagtypes":           {0, -1, cmdTagTypes},
+		"outputs":            {0, 0, cmdOutputs},
+		"urlhandlers":        {0, 0, func(*client, []string) error { return nil }},
+		"listplaylists":      {0, 0, func(*client, []string) error { return nil }},
+		"replay_gain_status": {0, 0, cmdReplayGainStatus},
+		"stats":              {0, 0, cmdStats},
+
+		"status":       {0, 0, cmdStatus},
+		"currentsong":  {0, 0, cmdCurrentSong},
+		"playlistinfo": {0, 1, cmdPlaylistInfo},
+		"playlistid":   {0, 1, cmdPlaylistID},
+		"plchanges":    {1, 2, cmdPlChanges},
+
+		"add":   {1, 1, cmdAdd},
+		"addid": {1, 1, cmdAddID},
+		"clear": {0, 0, func(c *client, _ []string) error { return c.s.backend.Clear() }},
+
+		"play":     {0, 1, cmdPlay},
+		"playid":   {0, 1, cmdPlayID},
+		"pause":    {0, 1, cmdPause},
+		"stop":     {0, 0, func(c *client, _ []string) error { return c.s.backend.Stop() }},
+		"next":     {0, 0, func(c *client, _ []string) error { return c.s.backend.Next() }},
+		"previous": {0, 0, func(c *client, _ []string) error { return c.s.backend.Previous() }},
+		"seek":     {2, 2, cmdSeek},
+		"seekid":   {2, 2, cmdSeekID},
+		"seekcur":  {1, 1, cmdSeekCur},
+		"setvol":   {1, 1, cmdSetVol},
+		"volume":   {1, 1, cmdVolume},
+		"random":   {1, 1, cmdRandom},
+		"repeat":   {1, 1, cmdRepeat},
+		"single":   {1, 1, cmdSingle},
+
+		"find":   {1, -1, func(c *client, args []string) error { return c.find(args, false) }},
+		"search": {1, -1, func(c *client, args []string) error { return c.find(args, true) }},
+		"list":   {1, -1, cmdList},
+		"count":  {1, -1, cmdCount},
+		"lsinfo": {0, 1, cmdLsInfo},
+	}
+}
+
+// ─── Helpers ────────────────────────────────────────────────────────────────
+
+func parseInt(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, ackf(ackArg, "Integer expected: %s", s)
+	}
+	return n, nil
+}
+
+func parseBool(s string) (bool, error) {
+	switch s {
+	case "0":
+		return false, nil
+	case "1":
+		return true, nil
+	}
+	return false, ackf(ackArg, "Boolean (0/1) expected: %s", s)
+}
+
+func parseSeconds(s string) (time.Duration, error) {
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, ackf(ackArg, "Number expected: %s", s)
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// parseRange reads "POS" or "START:END" (END may be omitted) and clamps it
+// to n items.
+func parseRange(s string, n int) (int, int, error) {
+	startStr, endStr, isRange := strings.Cut(s, ":")
+	start, err := parseInt(startStr)
+	if err != nil {
+		return 0, 0, err
+	}
+	end := start + 1
+	if isRange {
+		end = n
+		if endStr != "" {
+			if end, err = parseInt(endStr); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	if start < 0 || start > n || (!isRange && start >= n) {
+		return 0, 0, ackf(ackArg, "Bad song index")
+	}
+	return start, min(end, n), nil
+}
+
+func (c *client) root() string {
+	if lib := c.s.backend.Library(); lib != nil {
+		return lib.Dir
+	}
+	return ""
+}
+
+func (c *client) writeQueue(start, end int) {
+	root := c.root()
+	queue := c.s.backend.Queue()
+	for i := start; i < end && i < len(queue); i++ {
+		writeSong(c.w, queue[i], root)
+		fmt.Fprintf(c.w, "Pos: %d\nId: %d\n", i, songID(i))
+	}
+}
+
+// ─── Server Info ────────────────────────────────────────────────────────────
+
+func cmdCommands(c *client, _ []string) error {
+	names := make([]string, 0, len(commandTable)+4)
+	for name := range commandTable {
+		names = append(names, name)
+	}
+	names = append(names, "close", "idle", "noidle", "command_list_begin")
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(c.w, "command: %s\n", name)
+	}
+	return nil
+}
+
+func cmdTagTypes(c *client, args []string) error {
+	// Subcommands such as "tagtypes all" or "tagtypes clear" only tune
+	// which tags are sent; bpv always sends every tag it has.
+	if len(args) > 0 {
+		return nil
+	}
+	names := make([]string, 0, len(tagNames))
+	for _, name := range tagNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(c.w, "tagtype: %s\n", name)
+	}
+	return nil
+}
+
+func cmdOutputs(c *client, _ []string) error {
+	c.w.WriteString("outputid: 0\noutputname: bpv\nplugin: beep\noutputenabled: 1\n")
+	return nil
+}
+
+func cmdReplayGainStatus(c *client, _ []string) error {
+	c.w.WriteString("replay_gain_mode: off\n")
+	return nil
+}
+
+func cmdStats(c *client, _ []string) error {
+	lib := c.s.backend.Library()
+	if lib == nil {
+		lib = &cache.CachedLibrary{}
+	}
+
+	var playtime time.Duration
+	for _, f := range lib.Files {
+		playtime += f.Duration
+	}
+	fmt.Fprintf(c.w, "artists: %d\n", len(lib.Artists))
+	fmt.Fprintf(c.w, "albums: %d\n", len(lib.Albums))
+	fmt.Fprintf(c.w, "songs: %d\n", len(lib.Files))
+	fmt.Fprintf(c.w, "uptime: %d\n", int(time.Since(c.s.started).Seconds()))
+	fmt.Fprintf(c.w, "db_playtime: %d\n", int(playtime.Seconds()))
+	if !lib.ScanTime.IsZero() {
+		fmt.Fprintf(c.w, "db_update: %d\n", lib.ScanTime.Unix())
+	}
+	return nil
+}
+
+// ─── Status & Queue ─────────────────────────────────────────────────────────
+
+func cmdStatus(c *client, _ []string) error {
+	st := c.s.backend.Status()
+
+	volume := st.VolumePct
+	if st.Muted {
+		volume = 0
+	}
+	state := "stop"
+	switch st.State {
+	case "playing":
+		state = "play"
+	case "paused":
+		state = "pause"
+	}
+
+	fmt.Fprintf(c.w, "volume: %d\n", volume)
+	fmt.Fprintf(c.w, "repeat: %d\n", boolInt(st.Repeat != playback.RepeatOff))
+	fmt.Fprintf(c.w, "random: %d\n", boolInt(st.Shuffle))
+	fmt.Fprintf(c.w, "single: %d\n", boolInt(st.Repeat == playback.RepeatOne))
+	c.w.WriteString("consume: 0\n")
+	fmt.Fprintf(c.w, "playlist: %d\n", st.QueueVersion)
+	fmt.Fprintf(c.w, "playlistlength: %d\n", st.QueueLen)
+	fmt.Fprintf(c.w, "state: %s\n", state)
+	if st.QueueIndex >= 0 {
+		fmt.Fprintf(c.w, "song: %d\nsongid: %d\n", st.QueueIndex, songID(st.QueueIndex))
+	}
+	if st.Track != nil {
+		fmt.Fprintf(c.w, "time: %d:%d\n", int(st.Position.Seconds()), int(st.Duration.Seconds()))
+		fmt.Fprintf(c.w, "elapsed: %.3f\n", st.Position.Seconds())
+		fmt.Fprintf(c.w, "duration: %.3f\n", st.Duration.Seconds())
+		if st.Track.Bitrate > 0 {
+			fmt.Fprintf(c.w, "bitrate: %d\n", st.Track.Bitrate)
+		}
+	}
+	return nil
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func cmdCurrentSong(c *client, _ []string) error {
+	st := c.s.backend.Status()
+	if st.Track == nil {
+		return nil
+	}
+	writeSong(c.w, *st.Track, c.root())
+	if st.QueueIndex >= 0 {
+		fmt.Fprintf(c.w, "Pos: %d\nId: %d\n", st.QueueIndex, songID(st.QueueIndex))
+	}
+	return nil
+}
+
+func cmdPlaylistInfo(c *client, args []string) error {
+	n := len(c.s.backend.Queue())
+	start, end := 0, n
+	if len(args) == 1 {
+		var err error
+		if start, end, err = parseRange(args[0], n); err != nil {
+			return err
+		}
+	}
+	c.writeQueue(start, end)
+	return nil
+}
+
+func cmdPlaylistID(c *client, args []string) error {
+	n := len(c.s.backend.Queue())
+	if len(args) == 0 {
+		c.writeQueue(0, n)
+		return nil
+	}
+	id, err := parseInt(args[0])
+	if err != nil {
+		return err
+	}
+	if id < 1 || id > n {
+		return ackf(ackNoExist, "No such song")
+	}
+	c.writeQueue(id-1, id)
+	return nil
+}
+
+// cmdPlChanges reports the whole queue whenever its version differs, since
+// bpv does not track per-entry changes.
+func cmdPlChanges(c *client, args []string) error {
+	version, err := parseInt(args[0])
+	if err != nil {
+		return err
+	}
+	if version != c.s.backend.Status().QueueVersion {
+		c.writeQueue(0, len(c.s.backend.Queue()))
+	}
+	return nil
+}
+
+func cmdAdd(c *client, args []string) error {
+	if err := c.s.backend.Add(resolve(c.root(), args[0])); err != nil {
+		return ackf(ackNoExist, "%v", err)
+	}
+	return nil
+}
+
+func cmdAddID(c *client, args []string) error {
+	if err := cmdAdd(c, args); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.w, "Id: %d\n", songID(len(c.s.backend.Queue())-1))
+	return nil
+}
+
+// ─── Playback ───────────────────────────────────────────────────────────────
+
+func cmdPlay(c *client, args []string) error {
+	if len(args) == 0 {
+		return c.s.backend.Play(-1)
+	}
+	pos, err := parseInt(args[0])
+	if err != nil {
+		return err
+	}
+	if pos < 0 {
+		return c.s.backend.Play(-1)
+	}
+	if pos >= len(c.s.backend.Queue()) {
+		return ackf(ackArg, "Bad song index")
+	}
+	return c.s.backend.Play(pos)
+}
+
+func cmdPlayID(c *client, args []string) error {
+	if len(args) == 0 {
+		return c.s.backend.Play(-1)
+	}
+	id, err := parseInt(args[0])
+	if err != nil {
+		return err
+	}
+	if id < 1 || id > len(c.s.backend.Queue()) {
+		return ackf(ackNoExist, "No such song")
+	}
+	return c.s.backend.Play(id - 1)
+}
+
+func cmdPause(c *client, args []string) error {
+	if len(args) == 0 {
+		return c.s.backend.TogglePause()
+	}
+	paused, err := parseBool(args[0])
+	if err != nil {
+		return err
+	}
+	return c.s.backend.SetPaused(paused)
+}
+
+// seekTo plays the queue item at pos if it is not already current, then
+// seeks within it.
+func (c *client) seekTo(pos int, at time.Duration) error {
+	if pos < 0 || pos >= len(c.s.backend.Queue()) {
+		return ackf(ackArg, "Bad song index")
+	}
+	if st := c.s.backend.Status(); st.QueueIndex != pos || st.Track == nil {
+		if err := c.s.backend.Play(pos); err != nil {
+			return err
+		}
+	}
+	return c.s.backend.Seek(at)
+}
+
+func cmdSeek(c *client, args []string) error {
+	pos, err := parseInt(args[0])
+	if err != nil {
+		return err
+	}
+	at, err := parseSeconds(args[1])
+	if err != nil {
+		return err
+	}
+	return c.seekTo(pos, at)
+}
+
+func cmdSeekID(c *client, args []string) error {
+	id, err := parseInt(args[0])
+	if err != nil {
+		return err
+	}
+	at, err := parseSeconds(args[1])
+	if err != nil {
+		return err
+	}
+	return c.seekTo(id-1, at)
+}
+
+func cmdSeekCur(c *client, args []string) error {
+	at, err := parseSeconds(strings.TrimPrefix(args[0], "+"))
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(args[0], "+") || strings.HasPrefix(args[0], "-") {
+		at += c.s.backend.Status().Position
+	}
+	return c.s.backend.Seek(max(0, at))
+}
+
+func cmdSetVol(c *client, args []string) error {
+	pct, err := parseInt(args[0])
+	if err != nil {
+		return err
+	}
+	if pct < 0 || pct > 100 {
+		return ackf(ackArg, "Invalid volume value")
+	}
+	return c.s.backend.SetVolume(pct)
+}
+
+func cmdVolume(c *client, args []string) error {
+	delta, err := parseInt(strings.TrimPrefix(args[0], "+"))
+	if err != nil {
+		return err
+	}
+	pct := c.s.backend.Status().VolumePct + delta
+	return c.s.backend.SetVolume(max(0, min(100, pct)))
+}
+
+func cmdRandom(c *client, args []string) error {
+	on, err := parseBool(args[0])
+	if err != nil {
+		return err
+	}
+	return c.s.backend.SetShuffle(on)
+}
+
+// bpv has a single repeat setting, so MPD's repeat and single flags are
+// folded into it: single implies repeating the current track.
+func cmdRepeat(c *client, args []string) error {
+	on, err := parseBool(args[0])
+	if err != nil {
+		return err
+	}
+	current := c.s.backend.Status().Repeat
+	switch {
+	case !on:
+		return c.s.backend.SetRepeat(playback.RepeatOff)
+	case current == playback.RepeatOff:
+		return c.s.backend.SetRepeat(playback.RepeatAll)
+	}
+	return nil
+}
+
+func cmdSingle(c *client, args []string) error {
+	on := args[0] == "oneshot"
+	if !on {
+		var err error
+		if on, err = parseBool(args[0]); err != nil {
+			return err
+		}
+	}
+	current := c.s.backend.Status().Repeat
+	switch {
+	case on:
+		return c.s.backend.SetRepeat(playback.RepeatOne)
+	case current == playback.RepeatOne:
+		return c.s.backend.SetRepeat(playback.RepeatAll)
+	}
+	return nil
+}
+
+// ─── Database ───────────────────────────────────────────────────────────────
+
+// matching returns the library songs accepted by f along with their URIs.
+func (c *client) matching(f filter) ([]metadata.AudioFile, []string) {
+	lib := c.s.backend.Library()
+	if lib == nil {
+		return nil, nil
+	}
+	var songs []metadata.AudioFile
+	var uris []string
+	for _, song := range lib.Files {
+		u := uri(lib.Dir, song.FilePath)
+		if f(song, u) {
+			songs = append(songs, song)
+			uris = append(uris, u)
+		}
+	}
+	return songs, uris
+}
+
+func (c *client) find(args []string, fold bool) error {
+	f, err := parseFilter(args, fold)
+	if err != nil {
+		return err
+	}
+	songs, _ := c.matching(f)
+	root := c.root()
+	for _, song := range songs {
+		writeSong(c.w, song, root)
+	}
+	return nil
+}
+
+func cmdCount(c *client, args []string) error {
+	f, err := parseFilter(args, false)
+	if err != nil {
+		return err
+	}
+	songs, _ := c.matching(f)
+	var playtime time.Duration
+	for _, song := range songs {
+		playtime += song.Duration
+	}
+	fmt.Fprintf(c.w, "songs: %d\nplaytime: %d\n", len(songs), int(playtime.Seconds()))
+	return nil
+}
+
+// cmdList prints the distinct values of a tag, optionally filtered and
+// grouped by one other tag. The legacy form "list album ARTIST" filters by
+// artist.
+func cmdList(c *client, args []string) error {
+	tag := strings.ToLower(args[0])
+	if tag != "file" && tagNames[tag] == "" {
+		return ackf(ackArg, "Unknown tag type: %s", args[0])
+	}
+	args = args[1:]
+
+	group := ""
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == "group" {
+			group = strings.ToLower(args[i+1])
+			if tagNames[group] == "" {
+				return ackf(ackArg, "Unknown tag type: %s", args[i+1])
+			}
+			args = append(args[:i:i], args[i+2:]...)
+			break
+		}
+	}
+	if tag == "album" && len(args) == 1 && !strings.HasPrefix(args[0], "(") {
+		args = []string{"artist", args[0]}
+	}
+
+	f, err := parseFilter(args, false)
+	if err != nil {
+		return err
+	}
+	songs, uris := c.matching(f)
+
+	// values[groupValue] is the set of tag values within that group.
+	values := make(map[string]map[string]bool)
+	for i, song := range songs {
+		g := ""
+		if group != "" {
+			g = tagValue(song, group, uris[i])
+		}
+		v := tagValue(song, tag, uris[i])
+		if v == "" {
+			continue
+		}
+		if values[g] == nil {
+			values[g] = make(map[string]bool)
+		}
+		values[g][v] = true
+	}
+
+	name := tagNames[tag]
+	if tag == "file" {
+		name = "file"
+	}
+	for _, g := range sortedKeys(values) {
+		if group != "" {
+			fmt.Fprintf(c.w, "%s: %s\n", tagNames[group], g)
+		}
+		for _, v := range sortedKeys(values[g]) {
+			fmt.Fprintf(c.w, "%s: %s\n", name, v)
+		}
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// cmdLsInfo lists the directories and songs directly inside a library
+// directory, or a single song.
+func cmdLsInfo(c *client, args []string) error {
+	lib := c.s.backend.Library()
+	if lib == nil {
+		return nil
+	}
+
+	dir := ""
+	if len(args) == 1 {
+		dir = strings.Trim(args[0], "/")
+	}
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+
+	dirs := make(map[string]bool)
+	var songs []metadata.AudioFile
+	for _, song := range lib.Files {
+		u := uri(lib.Dir, song.FilePath)
+		if u == dir {
+			writeSong(c.w, song, lib.Dir)
+			return nil
+		}
+		rest, ok := strings.CutPrefix(u, prefix)
+		if !ok || filepath.IsAbs(u) {
+			continue
+		}
+		if sub, _, nested := strings.Cut(rest, "/"); nested {
+			dirs[prefix+sub] = true
+		} else {
+			songs = append(songs, song)
+		}
+	}
+	if dir != "" && len(dirs) == 0 && len(songs) == 0 {
+		return ackf(ackNoExist, "No such directory")
+	}
+
+	for _, d := range sortedKeys(dirs) {
+		fmt.Fprintf(c.w, "directory: %s\n", d)
+	}
+	for _, song := range songs {
+		writeSong(c.w, song, lib.Dir)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package mpd
+
+import (
+	"strings"
+
+	"github.com/hoppxi/bpv/internal/metadata"
+)
+
+// filter decides whether a song matches a find, search, list or count
+// request.
+type filter func(f metadata.AudioFile, file string) bool
+
+func matchAll(metadata.AudioFile, string) bool { return true }
+
+// parseFilter accepts both the legacy "TYPE VALUE ..." pairs and a single
+// filter expression such as (artist == "Foo") or
+// ((album contains "live") AND (date == "1999")). fold makes comparisons
+// case-insensitive, as search does. Trailing "sort" and "window" arguments
+// are accepted and ignored.
+func parseFilter(args []string, fold bool) (filter, error) {
+	for i := 0; i+1 < len(args); i += 2 {
+		if args[i] == "sort" || args[i] == "window" {
+			args = args[:i]
+			break
+		}
+	}
+	if len(args) == 0 {
+		return matchAll, nil
+	}
+
+	if strings.HasPrefix(args[0], "(") {
+		if len(args) != 1 {
+			return nil, ackf(ackArg, "unexpected argument after filter expression")
+		}
+		p := &exprParser{src: args[0], fold: fold}
+		f, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.pos != len(p.src) {
+			return nil, ackf(ackArg, "trailing garbage in filter expression")
+		}
+		return f, nil
+	}
+
+	if len(args)%2 != 0 {
+		return nil, ackf(ackArg, "incorrect arguments")
+	}
+	var parts []filter
+	for i := 0; i < len(args); i += 2 {
+		f, err := tagFilter(strings.ToLower(args[i]), "==", args[i+1], fold)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, f)
+	}
+	return and(parts), nil
+}
+
+func and(parts []filter) filter {
+	return func(f metadata.AudioFile, file string) bool {
+		for _, p := range parts {
+			if !p(f, file) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// tagFilter compares one tag. With fold set, "==" becomes a case-insensitive
+// substring match, which is what MPD's search does.
+func tagFilter(tag, op, value string, fold bool) (filter, error) {
+	if tag != "any" && tag != "file" && tag != "base" && tagNames[tag] == "" {
+		return nil, ackf(ackArg, "unknown tag type: %s", tag)
+	}
+
+	norm := func(s string) string { return s }
+	if fold {
+		norm = strings.ToLower
+		if op == "==" {
+			op = "contains"
+		}
+	}
+	want := norm(value)
+
+	var cmp func(string) bool
+	switch op {
+	case "==":
+		cmp = func(v string) bool { return norm(v) == want }
+	case "!=":
+		cmp = func(v string) bool { return norm(v) != want }
+	case "contains":
+		cmp = func(v string) bool { return strings.Contains(norm(v), want) }
+	case "starts_with":
+		cmp = func(v string) bool { return strings.HasPrefix(norm(v), want) }
+	default:
+		return nil, ackf(ackArg, "unsupported operator: %s", op)
+	}
+
+	switch tag {
+	case "base":
+		prefix := strings.TrimSuffix(value, "/") + "/"
+		return func(_ metadata.AudioFile, file string) bool {
+			return strings.HasPrefix(file, prefix)
+		}, nil
+	case "any":
+		return func(f metadata.AudioFile, file string) bool {
+			for t := range tagNames {
+				if cmp(tagValue(f, t, file)) {
+					return true
+				}
+			}
+			return cmp(file)
+		}, nil
+	}
+	return func(f metadata.AudioFile, file string) bool {
+		return cmp(tagValue(f, tag, file))
+	}, nil
+}
+
+// exprParser handles the subset of MPD filter expressions bpv supports:
+// (TAG OP "VALUE"), (!EXPR) and (EXPR AND EXPR ...).
+type exprParser struct {
+	src  string
+	pos  int
+	fold bool
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *exprParser) expect(c byte) error {
+	p.skipSpace()
+	if p.pos >= len(p.src) || p.src[p.pos] != c {
+		return ackf(ackArg, "expected '%c' in filter expression", c)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *exprParser) word() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] != ' ' && p.src[p.pos] != '(' && p.src[p.pos] != ')' {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *exprParser) quoted() (string, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) || (p.src[p.pos] != '"' && p.src[p.pos] != '\'') {
+		return "", ackf(ackArg, "expected quoted value in filter expression")
+	}
+	quote := p.src[p.pos]
+	p.pos++
+
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		p.pos++
+		switch {
+		case c == quote:
+			return b.String(), nil
+		case c == '\\' && p.pos < len(p.src):
+			b.WriteByte(p.src[p.pos])
+			p.pos++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", ackf(ackArg, "unterminated string in filter expression")
+}
+
+func (p *exprParser) parse() (filter, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+
+	if p.pos < len(p.src) && p.src[p.pos] == '!' {
+		p.pos++
+		inner, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(')'); err != nil {
+			return nil, err
+		}
+		return func(f metadata.AudioFile, file string) bool { return !inner(f, file) }, nil
+	}
+
+	if p.pos < len(p.src) && p.src[p.pos] == '(' {
+		parts := []filter{}
+		for {
+			f, err := p.parse()
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, f)
+			p.skipSpace()
+			if p.pos < len(p.src) && p.src[p.pos] == ')' {
+				p.pos++
+				return and(parts), nil
+			}
+			if w := p.word(); w != "AND" {
+				return nil, ackf(ackArg, "expected AND in filter expression, got %q", w)
+			}
+		}
+	}
+
+	tag := strings.ToLower(p.word())
+	op := p.word()
+	value, err := p.quoted()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+	return tagFilter(tag, op, value, p.fold)
+}
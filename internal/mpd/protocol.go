@@ -0,0 +1,169 @@
+package mpd
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hoppxi/bpv/internal/metadata"
+)
+
+// ProtocolVersion is the MPD protocol version announced to clients. Only the
+// commands in commandTable are implemented.
+const ProtocolVersion = "0.23.0"
+
+// ACK error codes from the MPD protocol.
+const (
+	ackNotList    = 1
+	ackArg        = 2
+	ackPassword   = 3
+	ackPermission = 4
+	ackUnknown    = 5
+	ackNoExist    = 50
+	ackSystem     = 52
+)
+
+// Ack is an error reported to the client as an ACK line.
+type Ack struct {
+	Code    int
+	Message string
+}
+
+func (a *Ack) Error() string {
+	return a.Message
+}
+
+func ackf(code int, format string, args ...any) *Ack {
+	return &Ack{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// splitArgs tokenizes a command line. Arguments are separated by spaces and
+// may be double quoted, with backslash escaping inside quotes.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	for i := 0; i < len(line); {
+		switch line[i] {
+		case ' ', '\t':
+			i++
+			continue
+		case '"':
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(line) {
+					return nil, ackf(ackArg, "missing closing '\"'")
+				}
+				c := line[i]
+				if c == '"' {
+					i++
+					break
+				}
+				if c == '\\' && i+1 < len(line) {
+					i++
+					c = line[i]
+				}
+				b.WriteByte(c)
+				i++
+			}
+			args = append(args, b.String())
+		default:
+			start := i
+			for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				i++
+			}
+			args = append(args, line[start:i])
+		}
+	}
+	return args, nil
+}
+
+// tagNames maps lower-case tag names to the spelling MPD uses in responses.
+var tagNames = map[string]string{
+	"artist":      "Artist",
+	"albumartist": "AlbumArtist",
+	"album":       "Album",
+	"title":       "Title",
+	"track":       "Track",
+	"disc":        "Disc",
+	"genre":       "Genre",
+	"date":        "Date",
+	"composer":    "Composer",
+}
+
+// tagValue returns the value of tag for f. file is the MPD URI of f.
+func tagValue(f metadata.AudioFile, tag, file string) string {
+	switch tag {
+	case "file":
+		return file
+	case "artist":
+		return f.Artist
+	case "albumartist":
+		return f.AlbumArtist
+	case "album":
+		return f.Album
+	case "title":
+		return f.Title
+	case "genre":
+		return f.Genre
+	case "composer":
+		return f.Composer
+	case "date":
+		if f.Year > 0 {
+			return strconv.Itoa(f.Year)
+		}
+	case "track":
+		if f.Track > 0 {
+			return strconv.Itoa(f.Track)
+		}
+	case "disc":
+		if f.Disc > 0 {
+			return strconv.Itoa(f.Disc)
+		}
+	}
+	return ""
+}
+
+// uri converts a file path to the URI MPD clients see: relative to the
+// library root when inside it, absolute otherwise.
+func uri(root, path string) string {
+	if root != "" {
+		if rel, err := filepath.Rel(root, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return path
+}
+
+// resolve turns a client URI back into a file path.
+func resolve(root, u string) string {
+	if filepath.IsAbs(u) || root == "" {
+		return filepath.Clean(u)
+	}
+	return filepath.Join(root, filepath.FromSlash(u))
+}
+
+func writeSong(w *bufio.Writer, f metadata.AudioFile, root string) {
+	file := uri(root, f.FilePath)
+	fmt.Fprintf(w, "file: %s\n", file)
+	if !f.Modified.IsZero() {
+		fmt.Fprintf(w, "Last-Modified: %s\n", f.Modified.UTC().Format(time.RFC3339))
+	}
+	for _, tag := range []string{"artist", "albumartist", "title", "album", "track", "disc", "date", "genre", "composer"} {
+		if v := tagValue(f, tag, file); v != "" {
+			fmt.Fprintf(w, "%s: %s\n", tagNames[tag], v)
+		}
+	}
+	if f.Duration > 0 {
+		fmt.Fprintf(w, "Time: %d\n", int(f.Duration.Seconds()))
+		fmt.Fprintf(w, "duration: %.3f\n", f.Duration.Seconds())
+	}
+}
+
+// songID is the id reported for the queue item at pos. bpv has no stable
+// per-entry ids, so ids are positions offset by one (MPD reserves 0).
+func songID(pos int) int {
+	return pos + 1
+}
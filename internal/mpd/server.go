@@ -0,0 +1,268 @@
+package mpd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hoppxi/bpv/internal/logger"
+)
+
+// Server accepts MPD protocol connections and drives a Backend, so MPD
+// clients such as mpc or ncmpcpp can control bpv.
+type Server struct {
+	backend Backend
+	started time.Time
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+}
+
+func NewServer(backend Backend) *Server {
+	return &Server{
+		backend: backend,
+		started: time.Now(),
+		conns:   make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve accepts connections on ln until Close is called.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	s.listener = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops accepting connections and hangs up on connected clients.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+// client is the state of one connection.
+type client struct {
+	s *Server
+	w *bufio.Writer
+
+	// pending collects subsystems that changed since the client last idled.
+	pending map[string]bool
+	// idle is the set of subsystems the client is waiting on, nil when it
+	// is not idling. An empty set means any subsystem.
+	idle map[string]bool
+
+	list   []string
+	inList bool
+	listOK bool
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	s.mu.Lock()
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	changes, cancel := s.backend.Watch()
+	defer cancel()
+
+	// Lines are read on their own goroutine so idle can wait for either a
+	// change or noidle.
+	done := make(chan struct{})
+	defer close(done)
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		sc := bufio.NewScanner(conn)
+		sc.Buffer(make([]byte, 0), 1024*1024)
+		for sc.Scan() {
+			select {
+			case lines <- strings.TrimRight(sc.Text(), "\r"):
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	c := &client{s: s, w: bufio.NewWriter(conn), pending: make(map[string]bool)}
+	fmt.Fprintf(c.w, "OK MPD %s\n", ProtocolVersion)
+
+	for {
+		if err := c.w.Flush(); err != nil {
+			return
+		}
+		select {
+		case sub, ok := <-changes:
+			if !ok {
+				changes = nil
+				continue
+			}
+			c.pending[sub] = true
+			if c.idle != nil {
+				c.flushIdle(false)
+			}
+		case line, ok := <-lines:
+			if !ok || !c.handleLine(line) {
+				c.w.Flush()
+				return
+			}
+		}
+	}
+}
+
+// handleLine processes one line from the client. It returns false when the
+// connection should be closed.
+func (c *client) handleLine(line string) bool {
+	if c.idle != nil {
+		// noidle is the only command allowed while idling.
+		if line != "noidle" {
+			return false
+		}
+		c.flushIdle(true)
+		return true
+	}
+
+	if c.inList {
+		if line == "command_list_end" {
+			c.runList()
+		} else {
+			c.list = append(c.list, line)
+		}
+		return true
+	}
+
+	switch line {
+	case "command_list_begin", "command_list_ok_begin":
+		c.inList, c.listOK, c.list = true, line == "command_list_ok_begin", nil
+		return true
+	case "close":
+		return false
+	case "noidle":
+		// A noidle that crossed an idle reply has nothing left to cancel.
+		return true
+	}
+
+	args, err := splitArgs(line)
+	if err != nil {
+		c.ack(err, 0, "")
+		return true
+	}
+	if len(args) == 0 {
+		c.ack(ackf(ackUnknown, "No command given"), 0, "")
+		return true
+	}
+
+	if strings.ToLower(args[0]) == "idle" {
+		c.idle = make(map[string]bool)
+		for _, sub := range args[1:] {
+			c.idle[strings.ToLower(sub)] = true
+		}
+		c.flushIdle(false)
+		return true
+	}
+
+	if err := c.run(args); err != nil {
+		c.ack(err, 0, args[0])
+		return true
+	}
+	c.w.WriteString("OK\n")
+	return true
+}
+
+func (c *client) runList() {
+	list := c.list
+	c.inList, c.list = false, nil
+
+	for i, line := range list {
+		args, err := splitArgs(line)
+		if err == nil && len(args) == 0 {
+			err = ackf(ackUnknown, "No command given")
+		}
+		name := ""
+		if len(args) > 0 {
+			name = args[0]
+		}
+		if err == nil {
+			err = c.run(args)
+		}
+		if err != nil {
+			c.ack(err, i, name)
+			return
+		}
+		if c.listOK {
+			c.w.WriteString("list_OK\n")
+		}
+	}
+	c.w.WriteString("OK\n")
+}
+
+func (c *client) run(args []string) error {
+	name := strings.ToLower(args[0])
+	cmd, ok := commandTable[name]
+	if !ok {
+		return ackf(ackUnknown, "unknown command %q", args[0])
+	}
+	if n := len(args) - 1; n < cmd.min || (cmd.max >= 0 && n > cmd.max) {
+		return ackf(ackArg, "wrong number of arguments for %q", args[0])
+	}
+	return cmd.fn(c, args[1:])
+}
+
+func (c *client) ack(err error, index int, command string) {
+	var a *Ack
+	if !errors.As(err, &a) {
+		logger.Log.Debug("MPD command %s failed: %v", command, err)
+		a = &Ack{Code: ackSystem, Message: err.Error()}
+	}
+	fmt.Fprintf(c.w, "ACK [%d@%d] {%s} %s\n", a.Code, index, command, a.Message)
+}
+
+// flushIdle answers a pending idle with the subsystems it was waiting for.
+// Unless force is set (noidle), nothing is written until one has changed.
+func (c *client) flushIdle(force bool) {
+	var changed []string
+	for sub := range c.pending {
+		if len(c.idle) == 0 || c.idle[sub] {
+			changed = append(changed, sub)
+		}
+	}
+	if len(changed) == 0 && !force {
+		return
+	}
+
+	sort.Strings(changed)
+	for _, sub := range changed {
+		delete(c.pending, sub)
+		fmt.Fprintf(c.w, "changed: %s\n", sub)
+	}
+	c.w.WriteString("OK\n")
+	c.idle = nil
+}
@@ -0,0 +1,437 @@
+package mpd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hoppxi/bpv/internal/cache"
+	"github.com/hoppxi/bpv/internal/logger"
+	"github.com/hoppxi/bpv/internal/metadata"
+	"github.com/hoppxi/bpv/internal/playback"
+)
+
+func TestMain(m *testing.M) {
+	logger.Init(false, false)
+	os.Exit(m.Run())
+}
+
+// fakeBackend is a Backend with a library, a queue and a player that only
+// keep state.
+type fakeBackend struct {
+	mu      sync.Mutex
+	lib     *cache.CachedLibrary
+	queue   []metadata.AudioFile
+	status  playback.Status
+	version int
+	changes chan string
+}
+
+func newFakeBackend() *fakeBackend {
+	root := "/music"
+	song := func(path, artist, album, title string, year, track int) metadata.AudioFile {
+		return metadata.AudioFile{FilePath: filepath.Join(root, path), Artist: artist, Album: album,
+			Title: title, Year: year, Track: track, Duration: 200 * time.Second}
+	}
+	return &fakeBackend{
+		lib: &cache.CachedLibrary{Dir: root, Files: []metadata.AudioFile{
+			song("Miles Davis/Kind of Blue/01 So What.flac", "Miles Davis", "Kind of Blue", "So What", 1959, 1),
+			song("Miles Davis/Kind of Blue/02 Freddie Freeloader.flac", "Miles Davis", "Kind of Blue", "Freddie Freeloader", 1959, 2),
+			song("Miles Davis/Bitches Brew/01 Pharaoh's Dance.flac", "Miles Davis", "Bitches Brew", "Pharaoh's Dance", 1970, 1),
+			song("John Coltrane/Giant Steps/01 Giant Steps.flac", "John Coltrane", "Giant Steps", "Giant Steps", 1960, 1),
+			song("loose.mp3", "Unknown", "", "Loose Track", 0, 0),
+		}},
+		status:  playback.Status{State: "stopped", QueueIndex: -1, VolumePct: 50},
+		changes: make(chan string, 16),
+	}
+}
+
+func (b *fakeBackend) Library() *cache.CachedLibrary { return b.lib }
+
+func (b *fakeBackend) Status() playback.Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := b.status
+	st.QueueLen, st.QueueVersion = len(b.queue), b.version
+	if st.QueueIndex >= 0 && st.State != "stopped" {
+		st.Track = &b.queue[st.QueueIndex]
+	}
+	return st
+}
+
+func (b *fakeBackend) Queue() []metadata.AudioFile {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.queue)
+}
+
+// update changes the backend's state and reports the change to idling
+// clients.
+func (b *fakeBackend) update(sub string, change func()) error {
+	b.mu.Lock()
+	change()
+	b.mu.Unlock()
+	b.changes <- sub
+	return nil
+}
+
+func (b *fakeBackend) Play(index int) error {
+	return b.update(SubsystemPlayer, func() {
+		if index >= 0 {
+			b.status.QueueIndex = index
+		}
+		b.status.State = "playing"
+	})
+}
+
+func (b *fakeBackend) SetPaused(paused bool) error {
+	return b.update(SubsystemPlayer, func() {
+		if paused {
+			b.status.State = "paused"
+		} else {
+			b.status.State = "playing"
+		}
+	})
+}
+
+func (b *fakeBackend) TogglePause() error {
+	return b.SetPaused(b.Status().State == "playing")
+}
+
+func (b *fakeBackend) Stop() error {
+	return b.update(SubsystemPlayer, func() { b.status.State = "stopped" })
+}
+
+func (b *fakeBackend) Next() error {
+	return b.update(SubsystemPlayer, func() { b.status.QueueIndex++ })
+}
+
+func (b *fakeBackend) Previous() error {
+	return b.update(SubsystemPlayer, func() { b.status.QueueIndex-- })
+}
+
+func (b *fakeBackend) Seek(pos time.Duration) error {
+	return b.update(SubsystemPlayer, func() { b.status.Position = pos })
+}
+
+func (b *fakeBackend) SetVolume(pct int) error {
+	return b.update(SubsystemMixer, func() { b.status.VolumePct = pct })
+}
+
+func (b *fakeBackend) SetShuffle(on bool) error {
+	return b.update(SubsystemOptions, func() { b.status.Shuffle = on })
+}
+
+func (b *fakeBackend) SetRepeat(mode playback.RepeatMode) error {
+	return b.update(SubsystemOptions, func() { b.status.Repeat = mode })
+}
+
+func (b *fakeBackend) Add(path string) error {
+	var add []metadata.AudioFile
+	for _, f := range b.lib.Files {
+		if f.FilePath == path || strings.HasPrefix(f.FilePath, path+"/") {
+			add = append(add, f)
+		}
+	}
+	if len(add) == 0 {
+		return errors.New("no such file or directory")
+	}
+	return b.update(SubsystemPlaylist, func() {
+		b.queue = append(b.queue, add...)
+		b.version++
+	})
+}
+
+func (b *fakeBackend) Clear() error {
+	return b.update(SubsystemPlaylist, func() {
+		b.queue = nil
+		b.status.QueueIndex = -1
+		b.version++
+	})
+}
+
+func (b *fakeBackend) Watch() (<-chan string, func()) {
+	return b.changes, func() {}
+}
+
+// mpdClient is a minimal MPD protocol client.
+type mpdClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// ack is an ACK line received in reply to a command.
+type ack string
+
+func (a ack) Error() string { return string(a) }
+
+// serve starts a Server for backend and connects a client to it.
+func serve(t *testing.T, backend Backend) *mpdClient {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(backend)
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Close() })
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	c := &mpdClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+	if greeting := c.line(); greeting != "OK MPD "+ProtocolVersion {
+		t.Fatalf("greeting = %q", greeting)
+	}
+	return c
+}
+
+func (c *mpdClient) line() string {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("reading reply: %v", err)
+	}
+	return strings.TrimSuffix(line, "\n")
+}
+
+// send writes a command line without waiting for its reply.
+func (c *mpdClient) send(line string) {
+	c.t.Helper()
+	if _, err := fmt.Fprintf(c.conn, "%s\n", line); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// reply reads lines up to OK, or returns the ACK that ends them.
+func (c *mpdClient) reply() ([]string, error) {
+	c.t.Helper()
+	var lines []string
+	for {
+		switch line := c.line(); {
+		case line == "OK":
+			return lines, nil
+		case strings.HasPrefix(line, "ACK "):
+			return lines, ack(line)
+		default:
+			lines = append(lines, line)
+		}
+	}
+}
+
+// run sends a command and returns its reply, failing the test on an ACK.
+func (c *mpdClient) run(line string) []string {
+	c.t.Helper()
+	c.send(line)
+	lines, err := c.reply()
+	if err != nil {
+		c.t.Fatalf("%s: %v", line, err)
+	}
+	return lines
+}
+
+// values returns the values of key in reply lines.
+func values(lines []string, key string) []string {
+	var out []string
+	for _, l := range lines {
+		if v, ok := strings.CutPrefix(l, key+": "); ok {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func TestQueueAndPlayback(t *testing.T) {
+	b := newFakeBackend()
+	c := serve(t, b)
+
+	c.run(`add "Miles Davis/Kind of Blue"`)
+	if got := values(c.run(`addid "John Coltrane/Giant Steps/01 Giant Steps.flac"`), "Id"); !slices.Equal(got, []string{"3"}) {
+		t.Errorf("addid Id = %q, want [3]", got)
+	}
+
+	info := c.run("playlistinfo")
+	if got, want := values(info, "file"), []string{
+		"Miles Davis/Kind of Blue/01 So What.flac",
+		"Miles Davis/Kind of Blue/02 Freddie Freeloader.flac",
+		"John Coltrane/Giant Steps/01 Giant Steps.flac",
+	}; !slices.Equal(got, want) {
+		t.Errorf("playlistinfo files = %q, want %q", got, want)
+	}
+	if got := values(info, "Pos"); !slices.Equal(got, []string{"0", "1", "2"}) {
+		t.Errorf("playlistinfo Pos = %q", got)
+	}
+	if got := values(c.run("playlistinfo 1:"), "Title"); !slices.Equal(got, []string{"Freddie Freeloader", "Giant Steps"}) {
+		t.Errorf("playlistinfo 1: titles = %q", got)
+	}
+
+	c.run("play 1")
+	c.run("setvol 80")
+	c.run("random 1")
+	c.run("single 1")
+	status := c.run("status")
+	for key, want := range map[string]string{
+		"state": "play", "song": "1", "songid": "2", "volume": "80",
+		"random": "1", "repeat": "1", "single": "1", "playlistlength": "3",
+	} {
+		if got := values(status, key); len(got) != 1 || got[0] != want {
+			t.Errorf("status %s = %q, want %q", key, got, want)
+		}
+	}
+	if got := values(c.run("currentsong"), "Title"); !slices.Equal(got, []string{"Freddie Freeloader"}) {
+		t.Errorf("currentsong Title = %q", got)
+	}
+
+	c.run("pause 1")
+	c.run("playid 3")
+	if st := b.Status(); st.State != "playing" || st.QueueIndex != 2 {
+		t.Errorf("after playid 3: state %s at %d, want playing at 2", st.State, st.QueueIndex)
+	}
+	c.run("clear")
+	if got := values(c.run("status"), "playlistlength"); !slices.Equal(got, []string{"0"}) {
+		t.Errorf("playlistlength after clear = %q", got)
+	}
+}
+
+func TestDatabase(t *testing.T) {
+	c := serve(t, newFakeBackend())
+
+	tests := []struct {
+		command, key string
+		want         []string
+	}{
+		{`find artist "Miles Davis"`, "Title", []string{"So What", "Freddie Freeloader", "Pharaoh's Dance"}},
+		{`find artist "miles davis"`, "Title", nil},
+		{`search artist "miles davis" album blue`, "Title", []string{"So What", "Freddie Freeloader"}},
+		{`find "((artist == 'Miles Davis') AND (date == '1970'))"`, "Title", []string{"Pharaoh's Dance"}},
+		{`search "(title contains 'STEPS')"`, "file", []string{"John Coltrane/Giant Steps/01 Giant Steps.flac"}},
+		{`find "(!(artist == 'Miles Davis'))"`, "Title", []string{"Giant Steps", "Loose Track"}},
+		{"list artist", "Artist", []string{"John Coltrane", "Miles Davis", "Unknown"}},
+		{`list album "Miles Davis"`, "Album", []string{"Bitches Brew", "Kind of Blue"}},
+		{"list album group date", "Date", []string{"1959", "1960", "1970"}},
+		{`count artist "Miles Davis"`, "songs", []string{"3"}},
+		{`count artist "Miles Davis"`, "playtime", []string{"600"}},
+		{"lsinfo", "directory", []string{"John Coltrane", "Miles Davis"}},
+		{"lsinfo", "file", []string{"loose.mp3"}},
+		{`lsinfo "Miles Davis"`, "directory", []string{"Miles Davis/Bitches Brew", "Miles Davis/Kind of Blue"}},
+		{`lsinfo "Miles Davis/Kind of Blue/01 So What.flac"`, "Title", []string{"So What"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command+" "+tt.key, func(t *testing.T) {
+			if got := values(c.run(tt.command), tt.key); !slices.Equal(got, tt.want) {
+				t.Errorf("%s = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	c := serve(t, newFakeBackend())
+
+	tests := []struct {
+		command, want string
+	}{
+		{"frobnicate", `ACK [5@0] {frobnicate} unknown command "frobnicate"`},
+		{"play 0 1", `ACK [2@0] {play} wrong number of arguments for "play"`},
+		{"setvol loud", "ACK [2@0] {setvol} Integer expected: loud"},
+		{"setvol 101", "ACK [2@0] {setvol} Invalid volume value"},
+		{"play 7", "ACK [2@0] {play} Bad song index"},
+		{"playid 7", "ACK [50@0] {playid} No such song"},
+		{`add "Nobody/Nothing.flac"`, "ACK [50@0] {add} no such file or directory"},
+		{`find artist "unterminated`, `ACK [2@0] {} missing closing '"'`},
+		{`find artist`, "ACK [2@0] {find} incorrect arguments"},
+		{`lsinfo "Nobody"`, "ACK [50@0] {lsinfo} No such directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			c.send(tt.command)
+			_, err := c.reply()
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("got %v, want %s", err, tt.want)
+			}
+		})
+	}
+	// The connection is still usable after errors.
+	c.run("ping")
+}
+
+func TestCommandList(t *testing.T) {
+	b := newFakeBackend()
+	c := serve(t, b)
+
+	c.send("command_list_ok_begin")
+	c.send(`add "loose.mp3"`)
+	c.send("setvol 30")
+	c.send("status")
+	c.send("command_list_end")
+	lines, err := c.reply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines[0] != "list_OK" || lines[1] != "list_OK" || lines[len(lines)-1] != "list_OK" {
+		t.Errorf("command_list_ok reply = %q, want a list_OK after each command", lines)
+	}
+	if got := values(lines, "volume"); !slices.Equal(got, []string{"30"}) {
+		t.Errorf("volume = %q, want [30]", got)
+	}
+	if got := values(lines, "playlistlength"); !slices.Equal(got, []string{"1"}) {
+		t.Errorf("playlistlength = %q, want [1]", got)
+	}
+
+	c.send("command_list_begin")
+	c.send("ping")
+	c.send("ping")
+	c.send("command_list_end")
+	if lines, err := c.reply(); err != nil || lines != nil {
+		t.Errorf("command_list reply = %q, %v; want a bare OK", lines, err)
+	}
+
+	// An error stops the list and reports the index of the failing command.
+	c.send("command_list_begin")
+	c.send("setvol 40")
+	c.send("setvol x")
+	c.send("setvol 50")
+	c.send("command_list_end")
+	if _, err := c.reply(); err == nil || err.Error() != "ACK [2@1] {setvol} Integer expected: x" {
+		t.Errorf("got %v, want the second command to fail", err)
+	}
+	if got := b.Status().VolumePct; got != 40 {
+		t.Errorf("volume = %d, want 40", got)
+	}
+}
+
+func TestIdle(t *testing.T) {
+	b := newFakeBackend()
+	c := serve(t, b)
+
+	c.send("idle player mixer")
+	b.SetShuffle(true) // not waited for
+	b.SetVolume(70)
+	if got, err := c.reply(); err != nil || !slices.Equal(got, []string{"changed: mixer"}) {
+		t.Errorf("idle reply = %q, %v; want changed: mixer", got, err)
+	}
+
+	// Changes while not idling are kept for the next idle.
+	if got, err := func() ([]string, error) { c.send("idle"); return c.reply() }(); err != nil ||
+		!slices.Equal(got, []string{"changed: options"}) {
+		t.Errorf("idle reply = %q, %v; want changed: options", got, err)
+	}
+
+	c.send("idle database")
+	c.send("noidle")
+	if got, err := c.reply(); err != nil || got != nil {
+		t.Errorf("noidle reply = %q, %v; want a bare OK", got, err)
+	}
+	c.run("ping")
+}
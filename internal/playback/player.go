@@ -1,10 +1,11 @@
-package tui
+package playback
 
 import (
 	"fmt"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -75,14 +76,31 @@ type Player struct {
 
 	speakerInit bool
 
-	favorites map[string]bool
+	// queueVersion is bumped whenever the queue contents or order change so
+	// remote clients know when to re-fetch it.
+	queueVersion int
+}
+
+// Status is a point-in-time snapshot of the player, sent to remote clients.
+type Status struct {
+	State        string              `json:"state"` // "playing", "paused" or "stopped"
+	Track        *metadata.AudioFile `json:"track,omitempty"`
+	Position     time.Duration       `json:"position"`
+	Duration     time.Duration       `json:"duration"`
+	Volume       float64             `json:"volume"`
+	VolumePct    int                 `json:"volume_pct"`
+	Muted        bool                `json:"muted"`
+	Shuffle      bool                `json:"shuffle"`
+	Repeat       RepeatMode          `json:"repeat"`
+	QueueIndex   int                 `json:"queue_index"`
+	QueueLen     int                 `json:"queue_len"`
+	QueueVersion int                 `json:"queue_version"`
 }
 
 func NewPlayer() *Player {
 	return &Player{
-		repeat:    RepeatOff,
-		volLevel:  0,
-		favorites: make(map[string]bool),
+		repeat:   RepeatOff,
+		volLevel: 0,
 	}
 }
 
@@ -93,6 +111,7 @@ func (p *Player) SetQueue(tracks []metadata.AudioFile, startIndex int) {
 	p.queue = make([]metadata.AudioFile, len(tracks))
 	copy(p.queue, tracks)
 	p.queueIndex = startIndex
+	p.queueVersion++
 
 	if p.shuffle {
 		p.buildShuffleOrder()
@@ -107,6 +126,44 @@ func (p *Player) Queue() []metadata.AudioFile {
 	return out
 }
 
+// ReplaceQueue swaps the tracks of the queue for tracks, which name the
+// same items in the same order, for example after files moved. The position
+// in the queue, the shuffle order and the playing track are kept.
+func (p *Player) ReplaceQueue(tracks []metadata.AudioFile) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(tracks) != len(p.queue) {
+		return
+	}
+	copy(p.queue, tracks)
+	p.queueVersion++
+}
+
+// Append adds tracks to the end of the queue. With shuffle on they are
+// slotted in at random positions after the current track.
+func (p *Player) Append(tracks ...metadata.AudioFile) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(tracks) == 0 {
+		return
+	}
+
+	start := len(p.queue)
+	p.queue = append(p.queue, tracks...)
+	p.queueVersion++
+
+	if p.shuffle && len(p.shuffleOrder) > 0 {
+		for i := start; i < len(p.queue); i++ {
+			at := p.queueIndex + 1 + rand.Intn(len(p.shuffleOrder)-p.queueIndex)
+			p.shuffleOrder = append(p.shuffleOrder, 0)
+			copy(p.shuffleOrder[at+1:], p.shuffleOrder[at:])
+			p.shuffleOrder[at] = i
+		}
+	} else if p.shuffle {
+		p.buildShuffleOrder()
+	}
+}
+
 func (p *Player) MoveQueueItem(from, to int) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -120,6 +177,7 @@ func (p *Player) MoveQueueItem(from, to int) {
 	newQueue = append(newQueue, item)
 	newQueue = append(newQueue, p.queue[to:]...)
 	p.queue = newQueue
+	p.queueVersion++
 
 	// Adjust current index.
 	if p.queueIndex == from {
@@ -138,6 +196,7 @@ func (p *Player) RemoveFromQueue(index int) {
 		return
 	}
 	p.queue = append(p.queue[:index], p.queue[index+1:]...)
+	p.queueVersion++
 	if p.queueIndex > index {
 		p.queueIndex--
 	}
@@ -167,6 +226,24 @@ func (p *Player) PlayCurrent() error {
 	return p.playFile(track)
 }
 
+// PlayIndex starts playing the queue item at index, an index into Queue()
+// regardless of shuffle.
+func (p *Player) PlayIndex(index int) error {
+	p.mu.Lock()
+	if index < 0 || index >= len(p.queue) {
+		p.mu.Unlock()
+		return fmt.Errorf("queue index %d out of range", index)
+	}
+	p.queueIndex = index
+	if p.shuffle {
+		if pos := slices.Index(p.shuffleOrder, index); pos >= 0 {
+			p.queueIndex = pos
+		}
+	}
+	p.mu.Unlock()
+	return p.PlayCurrent()
+}
+
 func (p *Player) playFile(track metadata.AudioFile) error {
 	p.stopInternal()
 
@@ -294,6 +371,18 @@ func (p *Player) TogglePause() {
 	speaker.Unlock()
 }
 
+func (p *Player) SetPaused(paused bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ctrl == nil {
+		return
+	}
+	speaker.Lock()
+	p.ctrl.Paused = paused
+	p.paused = paused
+	speaker.Unlock()
+}
+
 // ─── Track Navigation ───────────────────────────────────────────────────────
 
 func (p *Player) Next() error {
@@ -413,6 +502,26 @@ func (p *Player) VolumeDown() {
 	speaker.Unlock()
 }
 
+// SetVolume sets the volume level, clamped to the -5..5 range used by the
+// volume effect.
+func (p *Player) SetVolume(level float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	level = max(-5, min(5, level))
+	p.volLevel = level
+	if p.volume == nil {
+		return
+	}
+	speaker.Lock()
+	p.volume.Volume = level
+	speaker.Unlock()
+}
+
+// SetVolumePercent is SetVolume on the 0-100 scale reported by VolumePercent.
+func (p *Player) SetVolumePercent(pct int) {
+	p.SetVolume(float64(pct)/10 - 5)
+}
+
 func (p *Player) ToggleMute() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -426,6 +535,25 @@ func (p *Player) ToggleMute() {
 
 // ─── Seeking ────────────────────────────────────────────────────────────────
 
+// Seek jumps to an absolute position in the current track.
+func (p *Player) Seek(pos time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.streamer == nil {
+		return
+	}
+	speaker.Lock()
+	n := p.format.SampleRate.N(pos)
+	if n < 0 {
+		n = 0
+	}
+	if n >= p.streamer.Len() {
+		n = p.streamer.Len() - 1
+	}
+	_ = p.streamer.Seek(n)
+	speaker.Unlock()
+}
+
 func (p *Player) SeekForward(d time.Duration) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -464,6 +592,7 @@ func (p *Player) ToggleShuffle() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	p.shuffle = !p.shuffle
+	p.queueVersion++
 	if p.shuffle {
 		p.buildShuffleOrder()
 	}
@@ -475,50 +604,6 @@ func (p *Player) CycleRepeat() {
 	p.repeat = (p.repeat + 1) % 3
 }
 
-// ─── Favorites ──────────────────────────────────────────────────────────────
-
-func (p *Player) ToggleFavorite(filePath string) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	if p.favorites[filePath] {
-		delete(p.favorites, filePath)
-	} else {
-		p.favorites[filePath] = true
-	}
-}
-
-func (p *Player) IsFavorite(filePath string) bool {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	return p.favorites[filePath]
-}
-
-func (p *Player) FavoriteCount() int {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	return len(p.favorites)
-}
-
-func (p *Player) FavoritePaths() []string {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	out := make([]string, 0, len(p.favorites))
-	for k := range p.favorites {
-		out = append(out, k)
-	}
-	return out
-}
-
-// SetFavoritePaths bulk-sets the favorites from daemon-loaded data.
-func (p *Player) SetFavoritePaths(paths []string) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.favorites = make(map[string]bool, len(paths))
-	for _, path := range paths {
-		p.favorites[path] = true
-	}
-}
-
 // ─── State Getters ──────────────────────────────────────────────────────────
 
 func (p *Player) positionUnsafe() time.Duration {
@@ -636,6 +721,7 @@ func (p *Player) SetShuffle(enabled bool) {
 		idx := p.resolveIndex()
 		p.shuffle = false
 		p.shuffleOrder = nil
+		p.queueVersion++
 		if idx >= 0 && idx < len(p.queue) {
 			p.queueIndex = idx
 		}
@@ -643,6 +729,7 @@ func (p *Player) SetShuffle(enabled bool) {
 	}
 
 	p.shuffle = true
+	p.queueVersion++
 	if len(p.queue) > 0 {
 		p.buildShuffleOrder()
 	}
@@ -673,14 +760,41 @@ func (p *Player) Progress() float64 {
 func (p *Player) VolumePercent() int {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	pct := int((p.volLevel + 5) * 10)
-	if pct < 0 {
-		pct = 0
+	return volumePercent(p.volLevel)
+}
+
+// Status returns a snapshot of the player state for remote clients.
+func (p *Player) Status() Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	st := Status{
+		State:        "stopped",
+		Duration:     p.duration,
+		Volume:       p.volLevel,
+		VolumePct:    volumePercent(p.volLevel),
+		Shuffle:      p.shuffle,
+		Repeat:       p.repeat,
+		QueueIndex:   -1,
+		QueueLen:     len(p.queue),
+		QueueVersion: p.queueVersion,
 	}
-	if pct > 100 {
-		pct = 100
+	if p.volume != nil {
+		st.Muted = p.volume.Silent
 	}
-	return pct
+	if len(p.queue) > 0 {
+		st.QueueIndex = p.resolveIndex()
+	}
+	if p.currentTrack != nil && (p.playing || p.paused) {
+		st.State = "playing"
+		if p.paused {
+			st.State = "paused"
+		}
+		track := *p.currentTrack
+		st.Track = &track
+		st.Position = p.positionUnsafe()
+	}
+	return st
 }
 
 func (p *Player) Close() {
@@ -689,6 +803,17 @@ func (p *Player) Close() {
 
 // ─── Internal ───────────────────────────────────────────────────────────────
 
+func volumePercent(level float64) int {
+	pct := int((level + 5) * 10)
+	if pct < 0 {
+		pct = 0
+	}
+	if pct > 100 {
+		pct = 100
+	}
+	return pct
+}
+
 func (p *Player) resolveIndex() int {
 	if p.shuffle && len(p.shuffleOrder) > 0 {
 		if p.queueIndex < len(p.shuffleOrder) {
@@ -0,0 +1,282 @@
+// Package playlist reads and writes M3U, M3U8 and PLS playlist files.
+package playlist
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type Format string
+
+const (
+	M3U  Format = "m3u"
+	M3U8 Format = "m3u8"
+	PLS  Format = "pls"
+)
+
+// FormatOf returns the format of the playlist file at path, judged by its
+// extension.
+func FormatOf(path string) (Format, bool) {
+	switch f := Format(strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")); f {
+	case M3U, M3U8, PLS:
+		return f, true
+	}
+	return "", false
+}
+
+func IsPlaylistFile(path string) bool {
+	_, ok := FormatOf(path)
+	return ok
+}
+
+// File is a playlist file found on disk.
+type File struct {
+	Path     string    `json:"path"`
+	Name     string    `json:"name"`
+	Format   Format    `json:"format"`
+	Modified time.Time `json:"modified"`
+	Entries  []Entry   `json:"entries"`
+}
+
+// Entry is one track in a playlist file.
+type Entry struct {
+	Location string        `json:"location"`       // As written in the file
+	Path     string        `json:"path,omitempty"` // Absolute path it refers to; empty for URLs
+	Title    string        `json:"title,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// Load reads the playlist file at path and resolves its entries against the
+// directory it is in.
+func Load(path string) (*File, error) {
+	format, ok := FormatOf(path)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an M3U, M3U8 or PLS file", filepath.Base(path))
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := Read(f, format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+	}
+
+	dir := filepath.Dir(path)
+	for i := range entries {
+		entries[i].Path = Resolve(entries[i].Location, dir)
+	}
+	return &File{
+		Path:     path,
+		Name:     strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		Format:   format,
+		Modified: info.ModTime(),
+		Entries:  entries,
+	}, nil
+}
+
+// Read parses a playlist in the given format. Entry paths are left for the
+// caller to resolve.
+func Read(r io.Reader, format Format) ([]Entry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	// Plain .m3u files predate UTF-8 and are usually Latin-1.
+	if format == M3U && !utf8.Valid(data) {
+		data = latin1ToUTF8(data)
+	}
+
+	if format == PLS {
+		return readPLS(data)
+	}
+	return readM3U(data)
+}
+
+func readM3U(data []byte) ([]Entry, error) {
+	var entries []Entry
+	var pending Entry
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			// #EXTINF:<seconds>[ attributes],<title>
+			info, title, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			secs, _, _ := strings.Cut(strings.TrimSpace(info), " ")
+			pending.Title = strings.TrimSpace(title)
+			if n, err := strconv.ParseFloat(secs, 64); err == nil && n > 0 {
+				pending.Duration = time.Duration(n * float64(time.Second))
+			}
+		case strings.HasPrefix(line, "#"):
+		default:
+			pending.Location = line
+			entries = append(entries, pending)
+			pending = Entry{}
+		}
+	}
+	return entries, sc.Err()
+}
+
+// readPLS parses the [playlist] section of a PLS file. Entries are numbered
+// File1, File2 and so on, with optional TitleN and LengthN.
+func readPLS(data []byte) ([]Entry, error) {
+	byNumber := make(map[int]*Entry)
+	inPlaylist := false
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			inPlaylist = strings.EqualFold(line, "[playlist]")
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !inPlaylist || !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		var field string
+		for _, f := range []string{"file", "title", "length"} {
+			if strings.HasPrefix(key, f) {
+				field = f
+				break
+			}
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(key, field))
+		if field == "" || err != nil {
+			continue
+		}
+		e := byNumber[n]
+		if e == nil {
+			e = &Entry{}
+			byNumber[n] = e
+		}
+		switch field {
+		case "file":
+			e.Location = value
+		case "title":
+			e.Title = value
+		case "length":
+			if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+				e.Duration = time.Duration(secs) * time.Second
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	numbers := make([]int, 0, len(byNumber))
+	for n, e := range byNumber {
+		if e.Location != "" {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+
+	entries := make([]Entry, len(numbers))
+	for i, n := range numbers {
+		entries[i] = *byNumber[n]
+	}
+	return entries, nil
+}
+
+// Resolve returns the absolute, cleaned path that location refers to, with
+// relative locations taken from dir. file:// URLs are turned into paths;
+// other URLs resolve to "". Backslashes are read as separators, since
+// playlists written on Windows use them.
+func Resolve(location, dir string) string {
+	switch {
+	case strings.HasPrefix(strings.ToLower(location), "file://"):
+		u, err := url.Parse(location)
+		if err != nil {
+			return ""
+		}
+		location = u.Path
+	case strings.Contains(location, "://"):
+		return ""
+	}
+
+	if filepath.Separator == '/' {
+		location = strings.ReplaceAll(location, `\`, "/")
+	}
+	if !filepath.IsAbs(location) {
+		location = filepath.Join(dir, location)
+	}
+	return filepath.Clean(location)
+}
+
+// Write writes entries as a playlist in the given format, using each
+// entry's Location as written. M3U is written as UTF-8, like M3U8.
+func Write(w io.Writer, format Format, entries []Entry) error {
+	bw := bufio.NewWriter(w)
+	switch format {
+	case M3U, M3U8:
+		fmt.Fprintln(bw, "#EXTM3U")
+		for _, e := range entries {
+			if e.Title != "" || e.Duration > 0 {
+				fmt.Fprintf(bw, "#EXTINF:%d,%s\n", seconds(e.Duration), e.Title)
+			}
+			fmt.Fprintln(bw, e.Location)
+		}
+	case PLS:
+		fmt.Fprintln(bw, "[playlist]")
+		for i, e := range entries {
+			fmt.Fprintf(bw, "File%d=%s\n", i+1, e.Location)
+			if e.Title != "" {
+				fmt.Fprintf(bw, "Title%d=%s\n", i+1, e.Title)
+			}
+			fmt.Fprintf(bw, "Length%d=%d\n", i+1, seconds(e.Duration))
+		}
+		fmt.Fprintf(bw, "NumberOfEntries=%d\n", len(entries))
+		fmt.Fprintln(bw, "Version=2")
+	default:
+		return fmt.Errorf("unknown playlist format %q", format)
+	}
+	return bw.Flush()
+}
+
+// seconds is d in whole seconds, or -1 when unknown, as both formats write
+// lengths.
+func seconds(d time.Duration) int {
+	if d <= 0 {
+		return -1
+	}
+	return int(d.Round(time.Second) / time.Second)
+}
+
+func latin1ToUTF8(b []byte) []byte {
+	out := make([]rune, len(b))
+	for i, c := range b {
+		out[i] = rune(c)
+	}
+	return []byte(string(out))
+}
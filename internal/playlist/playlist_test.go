@@ -0,0 +1,141 @@
+package playlist
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		data   string
+		want   []Entry
+	}{
+		{
+			name:   "extended m3u",
+			format: M3U8,
+			data: "#EXTM3U\n#EXTINF:322,Miles Davis - So What\nKind of Blue/01 So What.flac\n\n" +
+				"# a comment\n#EXTINF:-1 tvg-id=\"x\",Radio\nhttp://radio.example/stream\n",
+			want: []Entry{
+				{Location: "Kind of Blue/01 So What.flac", Title: "Miles Davis - So What", Duration: 322 * time.Second},
+				{Location: "http://radio.example/stream", Title: "Radio"},
+			},
+		},
+		{
+			name:   "plain m3u with CRLF and BOM",
+			format: M3U8,
+			data:   "\xef\xbb\xbfa.mp3\r\nb.mp3\r\n",
+			want:   []Entry{{Location: "a.mp3"}, {Location: "b.mp3"}},
+		},
+		{
+			name:   "latin-1 m3u",
+			format: M3U,
+			data:   "#EXTINF:305,Bj\xf6rk - J\xf3ga\nBj\xf6rk/J\xf3ga.mp3\n",
+			want:   []Entry{{Location: "Björk/Jóga.mp3", Title: "Björk - Jóga", Duration: 305 * time.Second}},
+		},
+		{
+			name:   "utf-8 m3u",
+			format: M3U,
+			data:   "Björk/Jóga.mp3\n",
+			want:   []Entry{{Location: "Björk/Jóga.mp3"}},
+		},
+		{
+			name:   "pls",
+			format: PLS,
+			data: "[playlist]\nFile2=b.mp3\nTitle2=Second\nLength2=-1\n; comment\nFile1 = a.mp3\n" +
+				"Length1=185\nTitle3=No file\nNumberOfEntries=2\nVersion=2\n[other]\nFile9=ignored.mp3\n",
+			want: []Entry{
+				{Location: "a.mp3", Duration: 185 * time.Second},
+				{Location: "b.mp3", Title: "Second"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Read(strings.NewReader(tt.data), tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		location, dir, want string
+	}{
+		{"01 So What.flac", "/music/lists", "/music/lists/01 So What.flac"},
+		{"../Jazz/So What.flac", "/music/lists", "/music/Jazz/So What.flac"},
+		{"/music/Jazz/So What.flac", "/elsewhere", "/music/Jazz/So What.flac"},
+		{`..\Jazz\So What.flac`, "/music/lists", "/music/Jazz/So What.flac"},
+		{"file:///music/Jazz/So%20What.flac", "/elsewhere", "/music/Jazz/So What.flac"},
+		{"FILE:///music/Bj%C3%B6rk/J%C3%B3ga.mp3", "/elsewhere", "/music/Björk/Jóga.mp3"},
+		{"http://radio.example/stream", "/music", ""},
+		{"rtsp://radio.example/stream", "/music", ""},
+	}
+	for _, tt := range tests {
+		if got := Resolve(tt.location, tt.dir); got != tt.want {
+			t.Errorf("Resolve(%q, %q) = %q, want %q", tt.location, tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	entries := []Entry{
+		{Location: "/music/a.flac", Title: "First", Duration: 185 * time.Second},
+		{Location: "/music/Björk/Jóga.mp3"},
+	}
+	for _, format := range []Format{M3U, M3U8, PLS} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, format, entries); err != nil {
+				t.Fatal(err)
+			}
+			got, err := Read(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, entries) {
+				t.Errorf("got %+v\nwant %+v", got, entries)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Road Trip.M3U")
+	if err := os.WriteFile(path, []byte("#EXTM3U\nsongs/a.mp3\n/abs/b.mp3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name != "Road Trip" || f.Format != M3U {
+		t.Errorf("Name, Format = %q, %q; want %q, %q", f.Name, f.Format, "Road Trip", M3U)
+	}
+	want := []string{filepath.Join(dir, "songs", "a.mp3"), "/abs/b.mp3"}
+	for i, e := range f.Entries {
+		if i >= len(want) || e.Path != want[i] {
+			t.Errorf("entry %d resolves to %q, want %q", i, e.Path, want[min(i, len(want)-1)])
+		}
+	}
+	if len(f.Entries) != len(want) {
+		t.Errorf("got %d entries, want %d", len(f.Entries), len(want))
+	}
+
+	if _, err := Load(filepath.Join(dir, "notes.txt")); err == nil {
+		t.Error("Load of a .txt file succeeded")
+	}
+}
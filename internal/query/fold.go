@@ -0,0 +1,75 @@
+package query
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Fold lower-cases s and strips diacritics from Latin letters, so "Björk",
+// "BJORK" and "bjork" all fold to "bjork". Letters that are not accented
+// forms of ASCII letters are only lower-cased, except for ligatures and a
+// few others, such as ß, that fold to their usual ASCII spelling.
+func Fold(s string) string {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return strings.ToLower(s)
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		r = unicode.ToLower(r)
+		if r < utf8.RuneSelf {
+			b.WriteRune(r)
+			continue
+		}
+		if folded, ok := folds[r]; ok {
+			b.WriteString(folded)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// folds maps lower-case accented Latin letters to ASCII.
+var folds = func() map[rune]string {
+	m := make(map[rune]string)
+	for base, letters := range map[string]string{
+		"a":  "àáâãäåāăąǎǻạảấầẩẫậắằẳẵặ",
+		"c":  "çćĉċč",
+		"d":  "ďđð",
+		"e":  "èéêëēĕėęěẹẻẽếềểễệ",
+		"g":  "ĝğġģ",
+		"h":  "ĥħ",
+		"i":  "ìíîïĩīĭįıǐỉị",
+		"j":  "ĵ",
+		"k":  "ķ",
+		"l":  "ĺļľŀł",
+		"n":  "ñńņňŉ",
+		"o":  "òóôõöøōŏőǒǿọỏốồổỗộớờởỡợơ",
+		"r":  "ŕŗř",
+		"s":  "śŝşšș",
+		"t":  "ţťŧț",
+		"u":  "ùúûüũūŭůűųǔǖǘǚǜụủứừửữựư",
+		"w":  "ŵẁẃẅ",
+		"y":  "ýÿŷỳỵỷỹ",
+		"z":  "źżž",
+		"ae": "æǽ",
+		"oe": "œ",
+		"ss": "ß",
+		"th": "þ",
+	} {
+		for _, r := range letters {
+			m[r] = base
+		}
+	}
+	return m
+}()
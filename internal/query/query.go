@@ -0,0 +1,588 @@
+// Package query parses and evaluates library search queries, such as
+//
+//	artist:"miles davis" year:1955..1965 -genre:live format:flac
+//
+// Terms separated by spaces must all match; OR (or |) between terms
+// matches either side and binds looser than the spaces, so
+// "coltrane OR davis blue" is coltrane, or davis and blue. Parentheses group
+// terms and a leading - excludes what a term matches.
+//
+// A bare word or "quoted phrase" matches tracks whose title, artist, album,
+// album artist, composer, genre or comment contain it, or whose year it is.
+// field:value matches one field:
+//
+//	title artist album albumartist composer genre comment lyrics path file
+//	                          contain the value, without regard to case
+//	                          or diacritics
+//	format                    is the file type, e.g. flac or mp3
+//	year track disc bpm bitrate samplerate channels
+//	                          a number, a range such as 1955..1965 (either
+//	                          end may be left open), or >N, >=N, <N, <=N
+//	duration                  as numbers, in seconds or m:ss
+package query
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/hoppxi/bpv/internal/metadata"
+)
+
+// Query is a parsed search query.
+type Query struct {
+	text string
+	root node // nil matches everything
+}
+
+func (q *Query) String() string { return q.text }
+
+// Match reports whether f satisfies the query.
+func (q *Query) Match(f *metadata.AudioFile) bool {
+	return q.MatchText(f, ContainsText)
+}
+
+// SyntaxError is a query that cannot be parsed. Offset is the byte offset
+// in the query where the problem was found.
+type SyntaxError struct {
+	Offset int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s (at position %d)", e.Msg, e.Offset+1)
+}
+
+// Search returns the files that match the query text, in order.
+func Search(files []metadata.AudioFile, text string) ([]metadata.AudioFile, error) {
+	q, err := Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return q.Filter(files), nil
+}
+
+// Filter returns the files that match q, in order.
+func (q *Query) Filter(files []metadata.AudioFile) []metadata.AudioFile {
+	var results []metadata.AudioFile
+	for i := range files {
+		if q.Match(&files[i]) {
+			results = append(results, files[i])
+		}
+	}
+	return results
+}
+
+// ─── Fields ─────────────────────────────────────────────────────────────────
+
+type kind int
+
+const (
+	kindText kind = iota
+	kindFormat
+	kindNumber
+	kindDuration
+)
+
+type field struct {
+	name   string
+	kind   kind
+	text   func(*metadata.AudioFile) string
+	number func(*metadata.AudioFile) float64
+}
+
+func textField(name string, get func(*metadata.AudioFile) string) *field {
+	return &field{name: name, kind: kindText, text: get}
+}
+
+func numberField(name string, get func(*metadata.AudioFile) int) *field {
+	return &field{name: name, kind: kindNumber, number: func(f *metadata.AudioFile) float64 { return float64(get(f)) }}
+}
+
+var fields = map[string]*field{
+	"title":       textField("title", func(f *metadata.AudioFile) string { return f.Title }),
+	"artist":      textField("artist", func(f *metadata.AudioFile) string { return f.Artist }),
+	"album":       textField("album", func(f *metadata.AudioFile) string { return f.Album }),
+	"albumartist": textField("albumartist", func(f *metadata.AudioFile) string { return f.AlbumArtist }),
+	"composer":    textField("composer", func(f *metadata.AudioFile) string { return f.Composer }),
+	"genre":       textField("genre", func(f *metadata.AudioFile) string { return f.Genre }),
+	"comment":     textField("comment", func(f *metadata.AudioFile) string { return f.Comment }),
+	"lyrics":      textField("lyrics", func(f *metadata.AudioFile) string { return f.Lyrics }),
+	"path":        textField("path", func(f *metadata.AudioFile) string { return f.FilePath }),
+	"file":        textField("file", func(f *metadata.AudioFile) string { return f.FileName }),
+	"format":      {name: "format", kind: kindFormat, text: func(f *metadata.AudioFile) string { return f.FileType }},
+	"year":        numberField("year", func(f *metadata.AudioFile) int { return f.Year }),
+	"track":       numberField("track", func(f *metadata.AudioFile) int { return f.Track }),
+	"disc":        numberField("disc", func(f *metadata.AudioFile) int { return f.Disc }),
+	"bpm":         numberField("bpm", func(f *metadata.AudioFile) int { return f.BPM }),
+	"bitrate":     numberField("bitrate", func(f *metadata.AudioFile) int { return f.Bitrate }),
+	"samplerate":  numberField("samplerate", func(f *metadata.AudioFile) int { return f.SampleRate }),
+	"channels":    numberField("channels", func(f *metadata.AudioFile) int { return f.Channels }),
+	"duration": {name: "duration", kind: kindDuration, number: func(f *metadata.AudioFile) float64 {
+		return f.Duration.Seconds()
+	}},
+}
+
+// aliases are other names fields go by.
+var aliases = map[string]string{
+	"album_artist": "albumartist",
+	"type":         "format",
+	"ext":          "format",
+	"filename":     "file",
+	"sample_rate":  "samplerate",
+	"length":       "duration",
+}
+
+func lookupField(name string) (*field, bool) {
+	name = strings.ToLower(name)
+	if alias, ok := aliases[name]; ok {
+		name = alias
+	}
+	f, ok := fields[name]
+	return f, ok
+}
+
+// freeText are the fields a bare word or phrase is looked for in.
+var freeText = []*field{
+	fields["title"], fields["artist"], fields["album"], fields["albumartist"],
+	fields["composer"], fields["genre"], fields["comment"],
+}
+
+// ─── Evaluation ─────────────────────────────────────────────────────────────
+
+// TextMatcher reports whether a bare word or phrase, folded by Fold, matches
+// f. It lets a search index decide what free text matches, for example to
+// forgive typos, while the query still applies its field terms.
+type TextMatcher func(f *metadata.AudioFile, term string) bool
+
+// ContainsText is the TextMatcher Match uses: the term is part of the title,
+// artist, album, album artist, composer, genre or comment, or is the year.
+func ContainsText(f *metadata.AudioFile, term string) bool {
+	if year, err := strconv.Atoi(term); err == nil && year > 0 && f.Year == year {
+		return true
+	}
+	for _, fl := range freeText {
+		if containsFold(fl.text(f), term) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchText is Match with free text matched by text.
+func (q *Query) MatchText(f *metadata.AudioFile, text TextMatcher) bool {
+	return q.root == nil || q.root.match(f, text)
+}
+
+// Terms returns the distinct bare words and phrases of the query, folded,
+// except those under an exclusion: the terms that make a track a match.
+func (q *Query) Terms() []string {
+	var terms []string
+	var walk func(n node)
+	walk = func(n node) {
+		switch n := n.(type) {
+		case andNode:
+			for _, c := range n {
+				walk(c)
+			}
+		case orNode:
+			for _, c := range n {
+				walk(c)
+			}
+		case textTerm:
+			if !slices.Contains(terms, n.text) {
+				terms = append(terms, n.text)
+			}
+		}
+	}
+	walk(q.root)
+	return terms
+}
+
+// RequiredTerms returns the bare words and phrases, folded, that every
+// matching track must match: those joined to the rest of the query by
+// spaces alone.
+func (q *Query) RequiredTerms() []string {
+	var terms []string
+	switch n := q.root.(type) {
+	case textTerm:
+		terms = append(terms, n.text)
+	case andNode:
+		for _, c := range n {
+			if t, ok := c.(textTerm); ok {
+				terms = append(terms, t.text)
+			}
+		}
+	}
+	return terms
+}
+
+type node interface {
+	match(f *metadata.AudioFile, text TextMatcher) bool
+}
+
+type andNode []node
+type orNode []node
+type notNode struct{ node }
+
+func (n andNode) match(f *metadata.AudioFile, text TextMatcher) bool {
+	for _, c := range n {
+		if !c.match(f, text) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n orNode) match(f *metadata.AudioFile, text TextMatcher) bool {
+	for _, c := range n {
+		if c.match(f, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n notNode) match(f *metadata.AudioFile, text TextMatcher) bool { return !n.node.match(f, text) }
+
+// textTerm matches a bare word or phrase.
+type textTerm struct {
+	text string // folded
+}
+
+func (t textTerm) match(f *metadata.AudioFile, text TextMatcher) bool {
+	return text(f, t.text)
+}
+
+// fieldTerm matches field:value.
+type fieldTerm struct {
+	field  *field
+	text   string  // folded, for text and format fields
+	lo, hi float64 // inclusive bounds, for numbers
+}
+
+func (t fieldTerm) match(f *metadata.AudioFile, _ TextMatcher) bool {
+	switch t.field.kind {
+	case kindText:
+		return containsFold(t.field.text(f), t.text)
+	case kindFormat:
+		return strings.EqualFold(t.field.text(f), t.text)
+	default:
+		n := t.field.number(f)
+		return n >= t.lo && n <= t.hi
+	}
+}
+
+// containsFold reports whether s contains sub, which is already folded,
+// ignoring case and diacritics.
+func containsFold(s, sub string) bool {
+	return strings.Contains(Fold(s), sub)
+}
+
+// ─── Parsing ────────────────────────────────────────────────────────────────
+
+type parser struct {
+	s   string
+	pos int
+}
+
+// Parse parses a query. A blank query matches everything. The returned
+// error is a *SyntaxError.
+func Parse(text string) (*Query, error) {
+	p := &parser{s: text}
+	p.skipSpace()
+	if p.done() {
+		return &Query{text: strings.TrimSpace(text)}, nil
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		// parseOr only stops early at a closing parenthesis.
+		return nil, p.errorf(p.pos, "unmatched )")
+	}
+	return &Query{text: strings.TrimSpace(text), root: root}, nil
+}
+
+func (p *parser) done() bool { return p.pos >= len(p.s) }
+
+func (p *parser) skipSpace() {
+	for !p.done() && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *parser) errorf(offset int, format string, args ...any) error {
+	return &SyntaxError{Offset: offset, Msg: fmt.Sprintf(format, args...)}
+}
+
+// atOr reports whether the next word is the OR operator.
+func (p *parser) atOr() bool {
+	rest := p.s[p.pos:]
+	if strings.HasPrefix(rest, "|") {
+		return true
+	}
+	return strings.HasPrefix(rest, "OR") && (len(rest) == 2 || isBoundary(rest[2]))
+}
+
+func (p *parser) skipOr() {
+	if p.s[p.pos] == '|' {
+		p.pos++
+	} else {
+		p.pos += 2
+	}
+}
+
+func isBoundary(c byte) bool {
+	return c == '(' || c == ')' || c == '"' || unicode.IsSpace(rune(c))
+}
+
+func (p *parser) parseOr() (node, error) {
+	var alts orNode
+	for {
+		start := p.pos
+		if p.atOr() {
+			return nil, p.errorf(start, "OR needs a term on both sides")
+		}
+		n, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		alts = append(alts, n)
+
+		p.skipSpace()
+		if p.done() || !p.atOr() {
+			break
+		}
+		orAt := p.pos
+		p.skipOr()
+		p.skipSpace()
+		if p.done() || p.s[p.pos] == ')' {
+			return nil, p.errorf(orAt, "OR needs a term on both sides")
+		}
+	}
+	if len(alts) == 1 {
+		return alts[0], nil
+	}
+	return alts, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	var terms andNode
+	for {
+		p.skipSpace()
+		if p.done() || p.s[p.pos] == ')' || p.atOr() {
+			break
+		}
+		n, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, n)
+	}
+	if len(terms) == 0 {
+		if p.done() {
+			return nil, p.errorf(p.pos, "expected a search term at the end")
+		}
+		return nil, p.errorf(p.pos, "expected a search term before %q", p.s[p.pos:p.pos+1])
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return terms, nil
+}
+
+func (p *parser) parseTerm() (node, error) {
+	// A - on its own is a word, not an empty exclusion.
+	if p.s[p.pos] == '-' && p.pos+1 < len(p.s) && !unicode.IsSpace(rune(p.s[p.pos+1])) && p.s[p.pos+1] != ')' {
+		p.pos++
+		n, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+
+	switch p.s[p.pos] {
+	case '(':
+		open := p.pos
+		p.pos++
+		p.skipSpace()
+		if !p.done() && p.s[p.pos] == ')' {
+			return nil, p.errorf(open, "empty parentheses")
+		}
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.done() {
+			return nil, p.errorf(open, "unmatched (")
+		}
+		p.pos++ // )
+		return n, nil
+	case '"':
+		start := p.pos
+		phrase, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(phrase) == "" {
+			return nil, p.errorf(start, "empty phrase")
+		}
+		return newTextTerm(phrase), nil
+	}
+
+	start := p.pos
+	for !p.done() && !isBoundary(p.s[p.pos]) {
+		p.pos++
+	}
+	word := p.s[start:p.pos]
+
+	// Only a word before the colon can be a field, so times such as 3:05
+	// are searched for as text.
+	name, value, ok := strings.Cut(word, ":")
+	if !ok || name == "" || strings.IndexFunc(name, func(r rune) bool { return !unicode.IsLetter(r) && r != '_' }) >= 0 {
+		return newTextTerm(word), nil
+	}
+	f, ok := lookupField(name)
+	if !ok {
+		return nil, p.errorf(start, "unknown field %q; put the term in quotes to search for it as text", name)
+	}
+	valueAt := start + len(name) + 1
+	if value == "" && !p.done() && p.s[p.pos] == '"' {
+		var err error
+		if value, err = p.parseQuoted(); err != nil {
+			return nil, err
+		}
+	}
+	if strings.TrimSpace(value) == "" {
+		return nil, p.errorf(start, "%s: needs a value", f.name)
+	}
+	return newFieldTerm(f, value, valueAt)
+}
+
+// parseQuoted reads a double-quoted string starting at the quote. \" and
+// \\ stand for themselves inside it.
+func (p *parser) parseQuoted() (string, error) {
+	open := p.pos
+	p.pos++
+	var b strings.Builder
+	for !p.done() {
+		c := p.s[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			return b.String(), nil
+		case c == '\\' && p.pos+1 < len(p.s) && (p.s[p.pos+1] == '"' || p.s[p.pos+1] == '\\'):
+			b.WriteByte(p.s[p.pos+1])
+			p.pos += 2
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+	return "", p.errorf(open, "unterminated quote")
+}
+
+func newTextTerm(text string) node {
+	return textTerm{text: Fold(text)}
+}
+
+func newFieldTerm(f *field, value string, offset int) (node, error) {
+	t := fieldTerm{field: f}
+	switch f.kind {
+	case kindText:
+		t.text = Fold(value)
+		return t, nil
+	case kindFormat:
+		t.text = strings.TrimPrefix(strings.ToLower(value), ".")
+		return t, nil
+	}
+
+	parse := func(s string, at int) (float64, error) {
+		if f.kind == kindDuration {
+			if d, ok := parseDuration(s); ok {
+				return d, nil
+			}
+			return 0, &SyntaxError{Offset: at, Msg: fmt.Sprintf("%s needs seconds or m:ss, not %q", f.name, s)}
+		}
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, &SyntaxError{Offset: at,
+				Msg: fmt.Sprintf("%s needs a number or a range such as 1955..1965, not %q", f.name, s)}
+		}
+		return n, nil
+	}
+
+	t.lo, t.hi = negInf, posInf
+	var err error
+	switch {
+	case strings.Contains(value, ".."):
+		lo, hi, _ := strings.Cut(value, "..")
+		if lo == "" && hi == "" {
+			return nil, &SyntaxError{Offset: offset, Msg: fmt.Sprintf("%s: a range needs at least one end", f.name)}
+		}
+		if lo != "" {
+			if t.lo, err = parse(lo, offset); err != nil {
+				return nil, err
+			}
+		}
+		if hi != "" {
+			if t.hi, err = parse(hi, offset+len(lo)+2); err != nil {
+				return nil, err
+			}
+		}
+		if t.lo > t.hi {
+			return nil, &SyntaxError{Offset: offset, Msg: fmt.Sprintf("%s: range %s is backwards", f.name, value)}
+		}
+	case strings.HasPrefix(value, ">="):
+		t.lo, err = parse(value[2:], offset+2)
+	case strings.HasPrefix(value, "<="):
+		t.hi, err = parse(value[2:], offset+2)
+	case strings.HasPrefix(value, ">"):
+		t.lo, err = parse(value[1:], offset+1)
+		t.lo += epsilon
+	case strings.HasPrefix(value, "<"):
+		t.hi, err = parse(value[1:], offset+1)
+		t.hi -= epsilon
+	default:
+		t.lo, err = parse(value, offset)
+		t.hi = t.lo
+		if f.kind == kindDuration {
+			// Durations are never whole seconds; 3:05 means 3:05 to 3:06.
+			t.hi = t.lo + 1 - epsilon
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+const (
+	negInf = -1e308
+	posInf = 1e308
+	// epsilon turns the strict bounds of > and < into inclusive ones.
+	epsilon = 1e-9
+)
+
+// parseDuration reads seconds ("185") or m:ss ("3:05", also h:mm:ss) as
+// seconds.
+func parseDuration(s string) (float64, bool) {
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return n, n >= 0
+	}
+	var secs float64
+	for part := range strings.SplitSeq(s, ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		secs = secs*60 + float64(n)
+	}
+	return secs, true
+}
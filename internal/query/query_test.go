@@ -0,0 +1,160 @@
+package query
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/hoppxi/bpv/internal/metadata"
+)
+
+var library = []metadata.AudioFile{
+	{Title: "So What", Artist: "Miles Davis", Album: "Kind of Blue", Genre: "Jazz", Year: 1959, Track: 1,
+		Duration: 9*time.Minute + 22*time.Second, FileType: "flac", FilePath: "/music/Miles Davis/Kind of Blue/01 So What.flac"},
+	{Title: "Blue in Green", Artist: "Miles Davis", Album: "Kind of Blue", Genre: "Jazz", Year: 1959, Track: 3,
+		Duration: 5*time.Minute + 37*time.Second, FileType: "flac", FilePath: "/music/Miles Davis/Kind of Blue/03 Blue in Green.flac"},
+	{Title: "Giant Steps", Artist: "John Coltrane", Album: "Giant Steps", Genre: "Jazz", Year: 1960, Track: 1,
+		Duration: 4*time.Minute + 43*time.Second, FileType: "mp3", FilePath: "/music/John Coltrane/Giant Steps/01 Giant Steps.mp3"},
+	{Title: "Jóga", Artist: "Björk", Album: "Homogenic", Genre: "Electronic", Year: 1997, Track: 2,
+		Duration: 5*time.Minute + 5*time.Second, FileType: "mp3", FilePath: "/music/Björk/Homogenic/02 Jóga.mp3"},
+	{Title: "Bachelorette", Artist: "Björk", Album: "Homogenic", Genre: "Electronic", Year: 1997, Track: 4,
+		Duration: 5*time.Minute + 12*time.Second, FileType: "ogg", Comment: "live at Cambridge", FilePath: "/music/Björk/Homogenic/04 Bachelorette.ogg"},
+}
+
+func titles(files []metadata.AudioFile) []string {
+	out := []string{}
+	for _, f := range files {
+		out = append(out, f.Title)
+	}
+	return out
+}
+
+func TestSearch(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"So What", "Blue in Green", "Giant Steps", "Jóga", "Bachelorette"}},
+		{"blue", []string{"So What", "Blue in Green"}},
+		{"BLUE green", []string{"Blue in Green"}},
+		{`"kind of blue"`, []string{"So What", "Blue in Green"}},
+		{"1960", []string{"Giant Steps"}},
+		{"bjork", []string{"Jóga", "Bachelorette"}},
+		{"joga", []string{"Jóga"}},
+		{"title:JÓGA", []string{"Jóga"}},
+		{`artist:"miles davis"`, []string{"So What", "Blue in Green"}},
+		{"coltrane OR bjork", []string{"Giant Steps", "Jóga", "Bachelorette"}},
+		{"coltrane | title:what", []string{"So What", "Giant Steps"}},
+		{"coltrane OR davis green", []string{"Blue in Green", "Giant Steps"}},
+		{"(coltrane OR davis) -green", []string{"So What", "Giant Steps"}},
+		{"-genre:jazz", []string{"Jóga", "Bachelorette"}},
+		{"-(jazz OR live)", []string{"Jóga"}},
+		{"year:1959..1960", []string{"So What", "Blue in Green", "Giant Steps"}},
+		{"year:1960..", []string{"Giant Steps", "Jóga", "Bachelorette"}},
+		{"year:..1959", []string{"So What", "Blue in Green"}},
+		{"year:>1960", []string{"Jóga", "Bachelorette"}},
+		{"year:>=1960 year:<1997", []string{"Giant Steps"}},
+		{"track:<=2 format:mp3", []string{"Giant Steps", "Jóga"}},
+		{"format:.FLAC", []string{"So What", "Blue in Green"}},
+		{"ext:ogg", []string{"Bachelorette"}},
+		{"duration:5:05", []string{"Jóga"}},
+		{"duration:>9:00", []string{"So What"}},
+		{"duration:300..320", []string{"Jóga", "Bachelorette"}},
+		{"comment:cambridge", []string{"Bachelorette"}},
+		{"path:coltrane", []string{"Giant Steps"}},
+		{"3:05", []string{}},
+		{"- steps", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := Search(library, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(titles(got), tt.want) {
+				t.Errorf("got %q, want %q", titles(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query  string
+		offset int
+	}{
+		{"(blue", 0},
+		{"blue)", 4},
+		{"blue ()", 5},
+		{`artist:"miles`, 7},
+		{`""`, 0},
+		{"OR blue", 0},
+		{"blue OR", 5},
+		{"blue | )", 5},
+		{"mood:happy", 0},
+		{"blue artist:", 5},
+		{"year:abc", 5},
+		{"year:1960..x", 11},
+		{"year:>=x", 7},
+		{"year:..", 5},
+		{"year:1970..1960", 5},
+		{"duration:3:6x", 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := Parse(tt.query)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("err = %v, want a *SyntaxError", err)
+			}
+			if syntaxErr.Offset != tt.offset {
+				t.Errorf("offset = %d, want %d (%v)", syntaxErr.Offset, tt.offset, err)
+			}
+		})
+	}
+}
+
+func TestTerms(t *testing.T) {
+	tests := []struct {
+		query    string
+		terms    []string
+		required []string
+	}{
+		{"Björk joga", []string{"bjork", "joga"}, []string{"bjork", "joga"}},
+		{"davis OR coltrane", []string{"davis", "coltrane"}, nil},
+		{"blue -green year:1959", []string{"blue"}, []string{"blue"}},
+		{`"Kind of Blue" kind`, []string{"kind of blue", "kind"}, []string{"kind of blue", "kind"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := Parse(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := q.Terms(); !slices.Equal(got, tt.terms) {
+				t.Errorf("Terms() = %q, want %q", got, tt.terms)
+			}
+			if got := q.RequiredTerms(); !slices.Equal(got, tt.required) {
+				t.Errorf("RequiredTerms() = %q, want %q", got, tt.required)
+			}
+		})
+	}
+}
+
+func TestFold(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Björk", "bjork"},
+		{"BJORK", "bjork"},
+		{"Sigur Rós", "sigur ros"},
+		{"Straße", "strasse"},
+		{"Æon Œuvre", "aeon oeuvre"},
+		{"Dvořák", "dvorak"},
+		{"Чайковский", "чайковский"},
+	}
+	for _, tt := range tests {
+		if got := Fold(tt.in); got != tt.want {
+			t.Errorf("Fold(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -1,15 +1,16 @@
 package scanner
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/hoppxi/bpv/internal/logger"
+	"github.com/hoppxi/bpv/internal/playlist"
 )
 
 type FileWalker struct {
@@ -36,39 +37,18 @@ func NewFileWalker(progressChan chan<- ScanProgress) *FileWalker {
 	}
 }
 
-func (fw *FileWalker) WalkDirectory(rootPath string) ([]string, error) {
+// Walk calls fn with each audio and playlist file under rootPath as soon as
+// it is found, in a single pass over the tree. Hidden directories are skipped. It stops
+// early with ctx.Err() when ctx is cancelled, or with the first error fn
+// returns.
+func (fw *FileWalker) Walk(ctx context.Context, rootPath string, fn func(path string) error) error {
 	logger.Log.Debug("Starting directory walk: %s", rootPath)
 
-	var audioFiles []string
-	var mu sync.Mutex
-	var totalFiles int
-
+	found := 0
 	err := filepath.WalkDir(rootPath, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			if os.IsPermission(err) {
-				logger.Log.Error("Permission denied: %s", path)
-				return nil
-			}
-			logger.Log.Error("Error accessing %s: %v", path, err)
-			return err
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
 		}
-		if !d.IsDir() {
-			totalFiles++
-		}
-		return nil
-	})
-
-	if err != nil {
-		return nil, fmt.Errorf("error counting files: %v", err)
-	}
-
-	logger.Log.Debug("Total files to scan: %d", totalFiles)
-	fw.sendProgress(0, totalFiles, "Scanning files...")
-
-	startTime := time.Now()
-	processedFiles := 0
-
-	err = filepath.WalkDir(rootPath, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			if os.IsPermission(err) {
 				logger.Log.Error("Permission denied: %s", path)
@@ -86,31 +66,51 @@ func (fw *FileWalker) WalkDirectory(rootPath string) ([]string, error) {
 			return nil
 		}
 
-		ext := strings.ToLower(filepath.Ext(path))
-		if fw.audioExtensions[ext] {
-			mu.Lock()
-			audioFiles = append(audioFiles, path)
-			mu.Unlock()
-			logger.Log.Debug("Found audio file: %s", path)
+		if playlist.IsPlaylistFile(path) {
+			logger.Log.Debug("Found playlist: %s", path)
+			return fn(path)
 		}
-
-		processedFiles++
-		if processedFiles%100 == 0 || processedFiles == totalFiles {
-			elapsed := time.Since(startTime)
-			logger.Log.Debug("Scan progress: %d/%d files", processedFiles, totalFiles)
-			fw.sendProgress(processedFiles, totalFiles,
-				fmt.Sprintf("Scanned %d/%d files (%v elapsed)", processedFiles, totalFiles, elapsed.Round(time.Second)))
+		if !fw.IsAudioFile(path) {
+			return nil
 		}
+		logger.Log.Debug("Found audio file: %s", path)
+		found++
+		return fn(path)
+	})
 
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil {
+		return fmt.Errorf("error walking directory: %v", err)
+	}
+
+	logger.Log.Debug("Directory walk completed: found %d audio files", found)
+	return nil
+}
+
+// WalkDirectory returns the audio files under rootPath. It stops early with
+// ctx.Err() when ctx is cancelled.
+func (fw *FileWalker) WalkDirectory(ctx context.Context, rootPath string) ([]string, error) {
+	startTime := time.Now()
+
+	var audioFiles []string
+	err := fw.Walk(ctx, rootPath, func(path string) error {
+		if !fw.IsAudioFile(path) {
+			return nil
+		}
+		audioFiles = append(audioFiles, path)
+		if n := len(audioFiles); n%100 == 0 {
+			fw.sendProgress(n, n,
+				fmt.Sprintf("Found %d audio files (%v elapsed)", n, time.Since(startTime).Round(time.Second)))
+		}
 		return nil
 	})
-
 	if err != nil {
-		return nil, fmt.Errorf("error walking directory: %v", err)
+		return nil, err
 	}
 
-	logger.Log.Debug("Directory walk completed: found %d audio files", len(audioFiles))
-	fw.sendProgress(totalFiles, totalFiles, "Scan completed")
+	fw.sendProgress(len(audioFiles), len(audioFiles), "Scan completed")
 	return audioFiles, nil
 }
 
@@ -1,14 +1,20 @@
 package scanner
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/hoppxi/bpv/internal/artwork"
+	"github.com/hoppxi/bpv/internal/logger"
 	"github.com/hoppxi/bpv/internal/metadata"
+	"github.com/hoppxi/bpv/internal/playlist"
 )
 
 type ScanProgress struct {
@@ -26,14 +32,41 @@ type ScanResult struct {
 	Genres     map[string]int       `json:"genres"`
 	Composers  map[string]int       `json:"composers"`
 	Files      []metadata.AudioFile `json:"files"`
+	Playlists  []playlist.File      `json:"playlists"`
 	Duration   time.Duration        `json:"duration"`
 	Errors     []string             `json:"errors"`
+
+	// Set by Rescan: files extracted for the first time, re-extracted
+	// because their size or mtime changed, and dropped because they are gone.
+	Added   int `json:"added"`
+	Changed int `json:"changed"`
+	Removed int `json:"removed"`
+}
+
+// add records f in the result and its tag counts.
+func (r *ScanResult) add(f metadata.AudioFile) {
+	r.Files = append(r.Files, f)
+
+	if f.Artist != "" && f.Artist != "Unknown Artist" {
+		r.Artists[f.Artist]++
+	}
+	if f.Album != "" && f.Album != "Unknown Album" {
+		r.Albums[f.Album]++
+	}
+	if f.Genre != "" && f.Genre != "Unknown Genre" {
+		r.Genres[f.Genre]++
+	}
+	if f.Composer != "" && f.Composer != "Unknown Composer" {
+		r.Composers[f.Composer]++
+	}
 }
 
 type Scanner struct {
 	fileWalker        *FileWalker
 	metadataExtractor *metadata.Extractor
 	progressChan      chan ScanProgress
+	workers           int
+	artwork           *artwork.Store
 }
 
 func NewScanner() *Scanner {
@@ -42,82 +75,297 @@ func NewScanner() *Scanner {
 		fileWalker:        NewFileWalker(progressChan),
 		metadataExtractor: metadata.NewExtractor(),
 		progressChan:      progressChan,
+		workers:           DefaultWorkers(),
 	}
 }
 
-func (s *Scanner) ScanLibrary(rootPath string) (*ScanResult, error) {
+// DefaultWorkers is how many files a scanner reads at once unless told
+// otherwise: one per CPU the Go runtime may use.
+func DefaultWorkers() int {
+	return runtime.GOMAXPROCS(0)
+}
+
+// SetArtworkStore makes the scanner save cover art to store, so scanned
+// files carry a CoverID.
+func (s *Scanner) SetArtworkStore(store *artwork.Store) {
+	s.artwork = store
+	s.metadataExtractor.SetArtworkStore(store)
+}
+
+// SetFolderArt sets the image names looked for next to tracks without
+// embedded art. See metadata.DefaultFolderArt.
+func (s *Scanner) SetFolderArt(patterns []string) {
+	s.metadataExtractor.SetFolderArt(patterns)
+}
+
+// SetWorkers sets how many files are read at once. Values below one mean
+// DefaultWorkers.
+func (s *Scanner) SetWorkers(n int) {
+	if n < 1 {
+		n = DefaultWorkers()
+	}
+	s.workers = n
+}
+
+// ScanLibrary walks rootPath and extracts metadata from every audio file.
+// When ctx is cancelled it stops starting new extractions and returns
+// ctx.Err().
+func (s *Scanner) ScanLibrary(ctx context.Context, rootPath string) (*ScanResult, error) {
+	return s.Rescan(ctx, rootPath, nil)
+}
+
+// Rescan is ScanLibrary for a directory scanned before. Entries in previous
+// whose path, size and modification time still match the file on disk are
+// reused as they are; only new and changed files are read. Playlist files
+// are always read again.
+func (s *Scanner) Rescan(ctx context.Context, rootPath string, previous []metadata.AudioFile) (*ScanResult, error) {
 	startTime := time.Now()
+	return s.sync(ctx, startTime, nil, nil, previous, func(yield func(string) error) error {
+		return s.fileWalker.Walk(ctx, rootPath, yield)
+	})
+}
 
-	audioFilePaths, err := s.fileWalker.WalkDirectory(rootPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to walk directory: %v", err)
+// Update applies filesystem changes to a previous scan without walking the
+// whole library. Each path is a file or directory that was created, changed
+// or removed; whatever previous and playlists held at or below it is
+// replaced by what is on disk now. Everything else is kept untouched.
+func (s *Scanner) Update(ctx context.Context, previous []metadata.AudioFile, playlists []playlist.File, paths []string) (*ScanResult, error) {
+	startTime := time.Now()
+
+	// A folder image affects every track next to it.
+	dirs := make([]string, len(paths))
+	for i, path := range paths {
+		if metadata.IsImageFile(path) {
+			path = filepath.Dir(path)
+		}
+		dirs[i] = path
 	}
+	paths = outermost(dirs)
 
-	s.sendProgress(len(audioFilePaths), len(audioFilePaths), "Extracting metadata...")
+	var kept, stale []metadata.AudioFile
+	for _, f := range previous {
+		if within(f.FilePath, paths) {
+			stale = append(stale, f)
+		} else {
+			kept = append(kept, f)
+		}
+	}
+	var keptPlaylists []playlist.File
+	for _, p := range playlists {
+		if !within(p.Path, paths) {
+			keptPlaylists = append(keptPlaylists, p)
+		}
+	}
+
+	return s.sync(ctx, startTime, kept, keptPlaylists, stale, func(yield func(string) error) error {
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			switch {
+			case err != nil:
+				// Gone, so everything that was under it is removed.
+			case info.IsDir():
+				if err := s.fileWalker.Walk(ctx, path, yield); err != nil {
+					return err
+				}
+			case s.fileWalker.IsAudioFile(path), playlist.IsPlaylistFile(path):
+				if err := yield(path); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
 
+// outermost drops paths that are inside another path in the list.
+func outermost(paths []string) []string {
+	sorted := slices.Clone(paths)
+	slices.Sort(sorted)
+	var out []string
+	for _, p := range sorted {
+		if !within(p, out) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// within reports whether path is one of roots or below one of them.
+func within(path string, roots []string) bool {
+	for _, root := range roots {
+		if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sync builds a result from kept and keptPlaylists, which are taken as they
+// are, and the files that walk yields. An audio file is read only when
+// previous has no entry for it with the same size and modification time;
+// playlist files are parsed as they come, without going to the workers.
+//
+// Files are handed to a fixed pool of workers while the walk is still going,
+// so at most s.workers files are open at once however large the library is.
+func (s *Scanner) sync(ctx context.Context, startTime time.Time, kept []metadata.AudioFile, keptPlaylists []playlist.File, previous []metadata.AudioFile, walk func(yield func(string) error) error) (*ScanResult, error) {
 	result := &ScanResult{
 		Artists:   make(map[string]int),
 		Albums:    make(map[string]int),
 		Genres:    make(map[string]int),
 		Composers: make(map[string]int),
+		Playlists: slices.Clone(keptPlaylists),
 		Errors:    []string{},
 	}
+	for _, f := range kept {
+		result.add(f)
+	}
 
-	extractor := metadata.NewExtractor()
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-
-	for i, filePath := range audioFilePaths {
-		wg.Add(1)
+	known := make(map[string]metadata.AudioFile, len(previous))
+	for _, f := range previous {
+		known[f.FilePath] = f
+	}
 
-		go func(path string, index int) {
-			defer wg.Done()
+	var mu sync.Mutex
+	var found, processed int
 
-			audioFile, err := extractor.ExtractFromFile(path)
-			if err != nil {
+	process := func(path string) {
+		old, ok := known[path]
+		if ok {
+			info, err := os.Stat(path)
+			if err == nil && info.Size() == old.FileSize && info.ModTime().Equal(old.Modified) {
+				f, changed := s.refreshFolderCover(old)
 				mu.Lock()
-				result.Errors = append(result.Errors,
-					fmt.Sprintf("Failed to extract metadata from %s: %v", filepath.Base(path), err))
+				if changed {
+					result.Changed++
+				}
+				result.add(f)
 				mu.Unlock()
 				return
 			}
+		}
 
-			mu.Lock()
-			result.Files = append(result.Files, *audioFile)
+		audioFile, err := s.metadataExtractor.ExtractFromFile(path)
 
-			if audioFile.Artist != "" && audioFile.Artist != "Unknown Artist" {
-				result.Artists[audioFile.Artist]++
-			}
-			if audioFile.Album != "" && audioFile.Album != "Unknown Album" {
-				result.Albums[audioFile.Album]++
-			}
-			if audioFile.Genre != "" && audioFile.Genre != "Unknown Genre" {
-				result.Genres[audioFile.Genre]++
-			}
-			if audioFile.Composer != "" && audioFile.Composer != "Unknown Composer" {
-				result.Composers[audioFile.Composer]++
+		mu.Lock()
+		defer mu.Unlock()
+		if ok {
+			result.Changed++
+		} else {
+			result.Added++
+		}
+		if err != nil {
+			result.Errors = append(result.Errors,
+				fmt.Sprintf("Failed to extract metadata from %s: %v", filepath.Base(path), err))
+			return
+		}
+		audioFile.Added = time.Now()
+		if ok {
+			audioFile.Added = old.Added
+			if audioFile.Added.IsZero() {
+				audioFile.Added = old.Modified
 			}
-			mu.Unlock()
+		}
+		result.add(*audioFile)
+	}
+
+	paths := make(chan string, s.workers)
+	var wg sync.WaitGroup
+	for range s.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				if ctx.Err() != nil {
+					continue
+				}
+				process(path)
 
-			if index%10 == 0 || index == len(audioFilePaths)-1 {
-				s.sendProgress(index+1, len(audioFilePaths),
-					fmt.Sprintf("Processed %d/%d files", index+1, len(audioFilePaths)))
+				mu.Lock()
+				processed++
+				current, total := processed, found
+				mu.Unlock()
+				if current%10 == 0 {
+					s.sendProgress(current, total, fmt.Sprintf("Processed %d/%d files", current, total))
+				}
 			}
-		}(filePath, i)
+		}()
 	}
 
+	s.sendProgress(0, 0, "Scanning files...")
+	seen := make(map[string]bool)
+	walkErr := walk(func(path string) error {
+		if playlist.IsPlaylistFile(path) {
+			p, err := playlist.Load(path)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("Failed to read playlist: %v", err))
+				return nil
+			}
+			result.Playlists = append(result.Playlists, *p)
+			return nil
+		}
+
+		seen[path] = true
+		mu.Lock()
+		found++
+		mu.Unlock()
+		select {
+		case paths <- path:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(paths)
 	wg.Wait()
 
-	result.TotalFiles = len(audioFilePaths)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if walkErr != nil {
+		return nil, fmt.Errorf("failed to walk directory: %v", walkErr)
+	}
+
+	for path := range known {
+		if !seen[path] {
+			result.Removed++
+		}
+	}
+	if len(previous) > 0 {
+		logger.Log.Debug("Rescan: %d unchanged, %d added, %d changed, %d removed",
+			len(seen)-result.Added-result.Changed, result.Added, result.Changed, result.Removed)
+	}
+
+	slices.SortFunc(result.Playlists, func(a, b playlist.File) int { return strings.Compare(a.Path, b.Path) })
+	result.TotalFiles = len(kept) + len(seen)
 	result.AudioFiles = len(result.Files)
 	result.Duration = time.Since(startTime)
 
-	s.sendProgress(len(audioFilePaths), len(audioFilePaths),
+	s.sendProgress(len(seen), len(seen),
 		fmt.Sprintf("Scan completed: %d audio files found in %v", result.AudioFiles, result.Duration.Round(time.Millisecond)))
 
 	return result, nil
 }
 
+// refreshFolderCover looks for folder art again for an unchanged file
+// without embedded art, since images can come and go without the audio file
+// being touched.
+func (s *Scanner) refreshFolderCover(f metadata.AudioFile) (metadata.AudioFile, bool) {
+	if s.artwork == nil || (f.CoverID != "" && f.CoverSource != metadata.CoverFolder) {
+		return f, false
+	}
+	id, file := s.metadataExtractor.FolderCover(filepath.Dir(f.FilePath))
+	if id == f.CoverID && file == f.CoverFile {
+		return f, false
+	}
+	f.CoverID, f.CoverFile, f.CoverSource = id, file, ""
+	if id != "" {
+		f.CoverSource = metadata.CoverFolder
+	}
+	return f, true
+}
+
 func (s *Scanner) GetProgressChannel() <-chan ScanProgress {
 	return s.progressChan
 }
@@ -134,8 +382,8 @@ func (s *Scanner) sendProgress(current, total int, message string) {
 	}
 }
 
-func (s *Scanner) QuickScan(rootPath string) ([]metadata.AudioFile, error) {
-	audioFilePaths, err := s.fileWalker.WalkDirectory(rootPath)
+func (s *Scanner) QuickScan(ctx context.Context, rootPath string) ([]metadata.AudioFile, error) {
+	audioFilePaths, err := s.fileWalker.WalkDirectory(ctx, rootPath)
 	if err != nil {
 		return nil, err
 	}
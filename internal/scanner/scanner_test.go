@@ -0,0 +1,126 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/metrics"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hoppxi/bpv/internal/logger"
+)
+
+// benchFiles is the size of the synthetic library the scan benchmarks walk.
+const benchFiles = 50_000
+
+// syntheticTree writes n small MP3 files with ID3v2 tags under dir, ten to
+// an album and a hundred albums to an artist.
+func syntheticTree(tb testing.TB, dir string, n int) {
+	audio := make([]byte, 2048)
+	for i := 0; i < len(audio); i += 4 {
+		copy(audio[i:], []byte{0xff, 0xfb, 0x90, 0x64}) // MPEG-1 layer III frame header
+	}
+	for i := range n {
+		artist, album, track := i/1000, i/10%100, i%10+1
+		path := filepath.Join(dir, fmt.Sprintf("Artist %03d", artist), fmt.Sprintf("Album %02d", album),
+			fmt.Sprintf("%02d Track.mp3", track))
+		if track == 1 {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				tb.Fatal(err)
+			}
+		}
+		tag := id3v2(map[string]string{
+			"TIT2": fmt.Sprintf("Track %d", i),
+			"TPE1": fmt.Sprintf("Artist %03d", artist),
+			"TALB": fmt.Sprintf("Album %02d", album),
+			"TRCK": fmt.Sprint(track),
+		})
+		if err := os.WriteFile(path, append(tag, audio...), 0644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+// id3v2 encodes text frames as an ID3v2.3 tag.
+func id3v2(frames map[string]string) []byte {
+	var body []byte
+	for id, text := range frames {
+		frame := make([]byte, 10, 11+len(text))
+		copy(frame, id)
+		binary.BigEndian.PutUint32(frame[4:], uint32(1+len(text)))
+		frame = append(append(frame, 0), text...) // ISO-8859-1
+		body = append(body, frame...)
+	}
+	n := len(body)
+	header := []byte{'I', 'D', '3', 3, 0, 0, byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+	return append(header, body...)
+}
+
+// peaks samples the open file descriptors and live heap of the process
+// until stopped, to show that a scan holds neither more files open nor more
+// memory as the library grows.
+type peaks struct {
+	stop chan struct{}
+	wg   sync.WaitGroup
+	fds  int
+	heap uint64
+}
+
+func watchPeaks() *peaks {
+	p := &peaks{stop: make(chan struct{})}
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		tick := time.NewTicker(time.Millisecond)
+		defer tick.Stop()
+		for {
+			if entries, err := os.ReadDir("/proc/self/fd"); err == nil {
+				p.fds = max(p.fds, len(entries))
+			}
+			metrics.Read(sample)
+			p.heap = max(p.heap, sample[0].Value.Uint64())
+			select {
+			case <-p.stop:
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+	return p
+}
+
+func (p *peaks) done() {
+	close(p.stop)
+	p.wg.Wait()
+}
+
+func BenchmarkScanLibrary(b *testing.B) {
+	logger.Init(false, false)
+	dir := b.TempDir()
+	syntheticTree(b, dir, benchFiles)
+
+	var fds int
+	var heap uint64
+	b.ReportAllocs()
+	for b.Loop() {
+		p := watchPeaks()
+		result, err := NewScanner().ScanLibrary(context.Background(), dir)
+		p.done()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if result.AudioFiles != benchFiles {
+			b.Fatalf("scanned %d files, want %d", result.AudioFiles, benchFiles)
+		}
+		fds, heap = max(fds, p.fds), max(heap, p.heap)
+	}
+	if fds > 0 {
+		b.ReportMetric(float64(fds), "peak-fds")
+	}
+	b.ReportMetric(float64(heap)/(1<<20), "peak-heap-MB")
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hoppxi/bpv/internal/daemon"
+)
+
+// sseKeepAlive is how often an idle event stream sends a comment so proxies
+// and browsers do not drop it.
+const sseKeepAlive = 15 * time.Second
+
+// eventHub fans daemon events out to the browsers connected to /api/events.
+// Slow browsers miss events rather than holding up the others.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan daemon.Event]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[chan daemon.Event]struct{})}
+}
+
+func (h *eventHub) subscribe() chan daemon.Event {
+	ch := make(chan daemon.Event, 64)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan daemon.Event) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+func (h *eventHub) publish(ev daemon.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// relevant drops events about other music directories.
+func (s *Server) relevant(ev daemon.Event) bool {
+	return ev.Dir == "" || ev.Dir == s.musicDir
+}
+
+// handleEvents streams daemon events as server-sent events. Each event is
+// named after its type, so a browser can use addEventListener("favorites-changed", ...).
+// library-updated is sent after the server has swapped in the new library.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.streamEvents(w, r, nil, func(daemon.Event) bool { return true })
+}
+
+// handleScanProgress streams scan-progress and scan-finished events for the
+// music directory. A scan already running when the stream opens is reported
+// first, so the page can show it straight away.
+func (s *Server) handleScanProgress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var initial []daemon.Event
+	if jobs, err := s.client.ScanStatus(r.Context(), ""); err == nil {
+		for _, job := range jobs {
+			if job.Dir == s.musicDir && !job.Finished() {
+				initial = append(initial, daemon.Event{
+					Type:     daemon.EventScanProgress,
+					Dir:      job.Dir,
+					Progress: job.Progress,
+					Job:      &job,
+				})
+			}
+		}
+	}
+
+	s.streamEvents(w, r, initial, func(ev daemon.Event) bool {
+		return ev.Type == daemon.EventScanProgress || ev.Type == daemon.EventScanFinished
+	})
+}
+
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, initial []daemon.Event, want func(daemon.Event) bool) {
+	ch := s.events.subscribe()
+	defer s.events.unsubscribe(ch)
+
+	// The stream outlives the server's write timeout.
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+
+	for _, ev := range initial {
+		if writeSSE(w, ev) != nil {
+			return
+		}
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case ev := <-ch:
+			if !s.relevant(ev) || !want(ev) {
+				continue
+			}
+			if writeSSE(w, ev) != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, ev daemon.Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+	return err
+}
@@ -2,16 +2,21 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/hoppxi/bpv/internal/daemon"
 	"github.com/hoppxi/bpv/internal/logger"
 	"github.com/hoppxi/bpv/internal/metadata"
+	"github.com/hoppxi/bpv/internal/playback"
+	"github.com/hoppxi/bpv/internal/query"
 	"github.com/hoppxi/bpv/internal/store"
 )
 
@@ -20,6 +25,7 @@ type HealthResponse struct {
 	Port     int    `json:"port"`
 	MusicDir string `json:"music_dir"`
 	Version  string `json:"version"`
+	Daemon   string `json:"daemon"`
 }
 
 type LibraryResponse struct {
@@ -41,6 +47,11 @@ type MetadataResponse struct {
 	File   metadata.AudioFile `json:"file"`
 }
 
+type PicturesResponse struct {
+	Status   string             `json:"status"`
+	Pictures []metadata.Picture `json:"pictures"`
+}
+
 type ScanRequest struct {
 	FullScan      bool `json:"full_scan"`
 	ExtractCovers bool `json:"extract_covers"`
@@ -67,6 +78,13 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 		Port:     s.port,
 		MusicDir: s.musicDir,
 		Version:  "0.3.0",
+		Daemon:   daemon.StateClosed.String(),
+	}
+	if s.client != nil {
+		response.Daemon = s.client.State().String()
+	}
+	if response.Daemon != daemon.StateConnected.String() {
+		response.Status = "degraded"
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -86,34 +104,36 @@ func (s *Server) handleLibrary(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if s.lib == nil {
+	lib := s.library()
+	if lib == nil {
 		if s.client != nil {
-			lib, err := s.client.GetLibrary(s.musicDir)
+			loaded, err := s.client.GetLibrary(r.Context(), s.musicDir)
 			if err != nil {
 				http.Error(w, fmt.Sprintf("Failed to load library: %v", err), http.StatusInternalServerError)
 				return
 			}
-			s.lib = lib
+			s.setLibrary(loaded)
+			lib = loaded
 		} else {
 			http.Error(w, "Library not available", http.StatusServiceUnavailable)
 			return
 		}
 	}
 
-	files := s.getFiles()
+	files := lib.Files
 
 	response := LibraryResponse{
 		Status:     "ok",
 		MusicDir:   s.musicDir,
-		TotalFiles: s.lib.FileCount,
-		AudioFiles: s.lib.FileCount,
-		Artists:    s.lib.Artists,
-		Albums:     s.lib.Albums,
-		Genres:     s.lib.Genres,
-		Composers:  s.lib.Composers,
+		TotalFiles: lib.FileCount,
+		AudioFiles: lib.FileCount,
+		Artists:    lib.Artists,
+		Albums:     lib.Albums,
+		Genres:     lib.Genres,
+		Composers:  lib.Composers,
 		Files:      files,
-		ScanTime:   s.lib.ScanTime.Format(time.RFC3339),
-		Errors:     s.lib.Errors,
+		ScanTime:   lib.ScanTime.Format(time.RFC3339),
+		Errors:     lib.Errors,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -150,28 +170,54 @@ func (s *Server) handleMetadata(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// handlePictures lists the pictures embedded in /api/pictures/<path>. Each
+// one is served by /api/cover/<id>.
+func (s *Server) handlePictures(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filePath := strings.TrimPrefix(r.URL.Path, "/api/pictures/")
+	if filePath == "" {
+		http.Error(w, "Filename required", http.StatusBadRequest)
+		return
+	}
+
+	pictures, err := s.client.GetPictures(r.Context(), filepath.Join(s.musicDir, filePath))
+	if err != nil {
+		logger.Log.ErrorP("Server", "%s", err)
+		http.Error(w, "Failed to read pictures", http.StatusInternalServerError)
+		return
+	}
+	if pictures == nil {
+		pictures = []metadata.Picture{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PicturesResponse{Status: "ok", Pictures: pictures})
+}
+
 func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	go func() {
-		if s.client != nil {
-			lib, err := s.client.Scan(s.musicDir)
-			if err != nil {
-				logger.Log.Error("Scan failed: %v", err)
-				return
-			}
-			s.lib = lib
-			logger.Log.Success("Scan completed: %d audio files found", lib.FileCount)
-		}
-	}()
+	// The daemon scans in the background; watchEvents reloads the library
+	// when it finishes.
+	job, err := s.client.Scan(r.Context(), s.musicDir)
+	if err != nil {
+		logger.Log.Error("Scan failed: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to start scan: %v", err), http.StatusBadGateway)
+		return
+	}
 
 	response := map[string]any{
 		"status":  "started",
 		"message": "Library scan initiated via daemon",
 		"path":    s.musicDir,
+		"job":     job,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -179,34 +225,15 @@ func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func (s *Server) handleScanProgress(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-
-	data, _ := json.Marshal(map[string]any{
-		"current": 100,
-		"total":   100,
-		"message": "Scan complete (via daemon cache)",
-	})
-	fmt.Fprintf(w, "data: %s\n\n", data)
-	w.(http.Flusher).Flush()
-}
-
 func (s *Server) handleArtists(w http.ResponseWriter, r *http.Request) {
-	if s.lib == nil {
+	lib := s.library()
+	if lib == nil {
 		http.Error(w, "Library not scanned yet", http.StatusNotFound)
 		return
 	}
 
-	artists := make([]map[string]any, 0, len(s.lib.Artists))
-	for artist, count := range s.lib.Artists {
+	artists := make([]map[string]any, 0, len(lib.Artists))
+	for artist, count := range lib.Artists {
 		artists = append(artists, map[string]any{
 			"name":  artist,
 			"count": count,
@@ -246,13 +273,14 @@ func (s *Server) handleArtist(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleAlbums(w http.ResponseWriter, r *http.Request) {
-	if s.lib == nil {
+	lib := s.library()
+	if lib == nil {
 		http.Error(w, "Library not scanned yet", http.StatusNotFound)
 		return
 	}
 
-	albums := make([]map[string]any, 0, len(s.lib.Albums))
-	for album, count := range s.lib.Albums {
+	albums := make([]map[string]any, 0, len(lib.Albums))
+	for album, count := range lib.Albums {
 		albums = append(albums, map[string]any{
 			"name":  album,
 			"count": count,
@@ -292,13 +320,14 @@ func (s *Server) handleAlbum(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleGenres(w http.ResponseWriter, r *http.Request) {
-	if s.lib == nil {
+	lib := s.library()
+	if lib == nil {
 		http.Error(w, "Library not scanned yet", http.StatusNotFound)
 		return
 	}
 
-	genres := make([]map[string]any, 0, len(s.lib.Genres))
-	for genre, count := range s.lib.Genres {
+	genres := make([]map[string]any, 0, len(lib.Genres))
+	for genre, count := range lib.Genres {
 		genres = append(genres, map[string]any{
 			"name":  genre,
 			"count": count,
@@ -338,13 +367,14 @@ func (s *Server) handleGenre(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleComposers(w http.ResponseWriter, r *http.Request) {
-	if s.lib == nil {
+	lib := s.library()
+	if lib == nil {
 		http.Error(w, "Library not scanned yet", http.StatusNotFound)
 		return
 	}
 
-	composers := make([]map[string]any, 0, len(s.lib.Composers))
-	for composer, count := range s.lib.Composers {
+	composers := make([]map[string]any, 0, len(lib.Composers))
+	for composer, count := range lib.Composers {
 		composers = append(composers, map[string]any{
 			"name":  composer,
 			"count": count,
@@ -384,39 +414,90 @@ func (s *Server) handleComposer(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
-	if s.lib == nil {
+	lib := s.library()
+	if lib == nil {
 		http.Error(w, "Library not scanned yet", http.StatusNotFound)
 		return
 	}
 
-	query := r.URL.Query().Get("q")
-	if query == "" {
+	text := r.URL.Query().Get("q")
+	if text == "" {
 		http.Error(w, "Search query required", http.StatusBadRequest)
 		return
 	}
 
-	query = strings.ToLower(query)
-	files := s.getFiles()
-	var results []metadata.AudioFile
+	q, err := query.Parse(text)
+	if err != nil {
+		response := map[string]any{
+			"status": "error",
+			"error":  err.Error(),
+		}
+		var syntaxErr *query.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			response["offset"] = syntaxErr.Offset
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
 
-	for _, file := range files {
-		if strings.Contains(strings.ToLower(file.Title), query) ||
-			strings.Contains(strings.ToLower(file.Artist), query) ||
-			strings.Contains(strings.ToLower(file.Album), query) ||
-			strings.Contains(strings.ToLower(file.Genre), query) {
-			results = append(results, file)
+	offset, limit, err := pageParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The daemon's index also matches prefixes and typos and ranks the
+	// results; the plain filter is only a fallback for when it is away.
+	results, total, err := s.client.Search(r.Context(), s.musicDir, text, offset, limit)
+	if daemon.IsConnError(err) {
+		results = q.Filter(lib.Files)
+		total = len(results)
+		results = results[min(offset, len(results)):]
+		if limit > 0 {
+			results = results[:min(limit, len(results))]
 		}
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("Search failed: %v", err), http.StatusBadGateway)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]any{
 		"status":  "ok",
-		"query":   query,
+		"query":   text,
 		"results": results,
 		"count":   len(results),
+		"total":   total,
 	})
 }
 
+// pageParams reads the offset and limit query parameters of a paged
+// request. Missing ones are 0, which for limit means no limit.
+func pageParams(r *http.Request) (offset, limit int, err error) {
+	if offset, err = countParam(r, "offset"); err != nil {
+		return 0, 0, err
+	}
+	if limit, err = countParam(r, "limit"); err != nil {
+		return 0, 0, err
+	}
+	return offset, limit, nil
+}
+
+// countParam reads a non-negative integer query parameter, 0 if missing.
+func countParam(r *http.Request, name string) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return n, nil
+}
+
 func (s *Server) handleDebug(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -457,21 +538,18 @@ func (s *Server) handleScanSimple(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	go func() {
-		if s.client != nil {
-			lib, err := s.client.Scan(s.musicDir)
-			if err != nil {
-				logger.Log.Error("Simple scan failed: %v", err)
-				return
-			}
-			s.lib = lib
-		}
-	}()
+	job, err := s.client.Scan(r.Context(), s.musicDir)
+	if err != nil {
+		logger.Log.Error("Simple scan failed: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to start scan: %v", err), http.StatusBadGateway)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]any{
 		"status":  "success",
 		"message": "Library scan initiated via daemon",
+		"job":     job,
 	})
 }
 
@@ -491,31 +569,25 @@ func (s *Server) handleBaseFilePath(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleFavorites(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	if s.client == nil {
+		http.Error(w, "Daemon not connected", http.StatusServiceUnavailable)
+		return
+	}
 
 	switch r.Method {
 	case http.MethodGet:
-		if s.client != nil {
-			favs, err := s.client.GetFavorites()
-			if err != nil {
-				json.NewEncoder(w).Encode(map[string]any{
-					"status":    "ok",
-					"favorites": []string{},
-				})
-				return
-			}
-			json.NewEncoder(w).Encode(map[string]any{
-				"status":    "ok",
-				"favorites": favs,
-			})
-		} else {
-			json.NewEncoder(w).Encode(map[string]any{
-				"status":    "ok",
-				"favorites": []string{},
-			})
+		favs, err := s.client.GetFavorites(r.Context())
+		if err != nil {
+			writeDaemonError(w, err)
+			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"status":    "ok",
+			"favorites": favs,
+		})
 
-	case http.MethodPost:
+	case http.MethodPost, http.MethodDelete:
 		var req struct {
 			FilePath string `json:"file_path"`
 		}
@@ -523,22 +595,17 @@ func (s *Server) handleFavorites(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Invalid request", http.StatusBadRequest)
 			return
 		}
-		if s.client != nil {
-			s.client.AddFavorite(req.FilePath)
-		}
-		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
-
-	case http.MethodDelete:
-		var req struct {
-			FilePath string `json:"file_path"`
+		var err error
+		if r.Method == http.MethodPost {
+			err = s.client.AddFavorite(r.Context(), req.FilePath)
+		} else {
+			err = s.client.RemoveFavorite(r.Context(), req.FilePath)
 		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.FilePath == "" {
-			http.Error(w, "Invalid request", http.StatusBadRequest)
+		if err != nil {
+			writeDaemonError(w, err)
 			return
 		}
-		if s.client != nil {
-			s.client.RemoveFavorite(req.FilePath)
-		}
+		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 
 	default:
@@ -551,6 +618,10 @@ func (s *Server) handleRecordPlay(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if s.client == nil {
+		http.Error(w, "Daemon not connected", http.StatusServiceUnavailable)
+		return
+	}
 
 	var req struct {
 		FilePath string `json:"file_path"`
@@ -560,8 +631,9 @@ func (s *Server) handleRecordPlay(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if s.client != nil {
-		s.client.RecordPlay(req.FilePath)
+	if err := s.client.RecordPlay(r.Context(), req.FilePath); err != nil {
+		writeDaemonError(w, err)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -573,16 +645,15 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if s.client == nil {
+		http.Error(w, "Daemon not connected", http.StatusServiceUnavailable)
+		return
+	}
 
-	var stats map[string]int
-	if s.client != nil {
-		var err error
-		stats, err = s.client.GetStats()
-		if err != nil {
-			stats = make(map[string]int)
-		}
-	} else {
-		stats = make(map[string]int)
+	stats, err := s.client.GetStats(r.Context())
+	if err != nil {
+		writeDaemonError(w, err)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -593,29 +664,23 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleQueue(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	if s.client == nil {
+		http.Error(w, "Daemon not connected", http.StatusServiceUnavailable)
+		return
+	}
 
 	switch r.Method {
 	case http.MethodGet:
-		if s.client != nil {
-			q, err := s.client.GetQueue()
-			if err != nil {
-				json.NewEncoder(w).Encode(map[string]any{
-					"status": "ok",
-					"queue":  store.QueueState{},
-				})
-				return
-			}
-			json.NewEncoder(w).Encode(map[string]any{
-				"status": "ok",
-				"queue":  q,
-			})
-		} else {
-			json.NewEncoder(w).Encode(map[string]any{
-				"status": "ok",
-				"queue":  store.QueueState{},
-			})
+		q, err := s.client.GetQueue(r.Context())
+		if err != nil {
+			writeDaemonError(w, err)
+			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"status": "ok",
+			"queue":  q,
+		})
 
 	case http.MethodPost:
 		body, err := io.ReadAll(r.Body)
@@ -628,9 +693,11 @@ func (s *Server) handleQueue(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Invalid queue JSON", http.StatusBadRequest)
 			return
 		}
-		if s.client != nil {
-			s.client.SaveQueue(&q)
+		if err := s.client.SaveQueue(r.Context(), &q); err != nil {
+			writeDaemonError(w, err)
+			return
 		}
+		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 
 	default:
@@ -638,30 +705,145 @@ func (s *Server) handleQueue(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *Server) handleSettingsAPI(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+// handlePlaylists serves /api/playlists and
+// /api/playlists/<id>[/tracks|/move|/rule]. Playlists are addressed by ID or
+// name. Posting a rule to /api/playlists creates a smart playlist.
+func (s *Server) handlePlaylists(w http.ResponseWriter, r *http.Request) {
+	if s.client == nil {
+		http.Error(w, "Daemon not connected", http.StatusServiceUnavailable)
+		return
+	}
 
-	switch r.Method {
-	case http.MethodGet:
-		if s.client != nil {
-			settings, err := s.client.GetSettings()
-			if err != nil {
-				json.NewEncoder(w).Encode(map[string]any{
-					"status":   "ok",
-					"settings": store.Settings{},
-				})
-				return
-			}
-			json.NewEncoder(w).Encode(map[string]any{
-				"status":   "ok",
-				"settings": settings,
-			})
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/playlists"), "/")
+	ref, sub, _ := strings.Cut(rest, "/")
+
+	var req struct {
+		Name      string   `json:"name"`
+		FilePaths []string `json:"file_paths"`
+		Positions []int    `json:"positions"`
+		From      int      `json:"from"`
+		To        int      `json:"to"`
+		Rule      string   `json:"rule"`
+		Sort      string   `json:"sort"`
+		Limit     int      `json:"limit"`
+	}
+	if r.Method != http.MethodGet && r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var p *daemon.Playlist
+	var err error
+
+	switch {
+	case ref == "" && r.Method == http.MethodGet:
+		lists, err := s.client.Playlists(r.Context())
+		if err != nil {
+			writePlaylistError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"status":    "ok",
+			"playlists": lists,
+		})
+		return
+	case ref == "" && r.Method == http.MethodPost:
+		if req.Rule != "" {
+			p, err = s.client.CreateSmartPlaylist(r.Context(), req.Name, req.Rule, req.Sort, req.Limit)
 		} else {
+			p, err = s.client.CreatePlaylist(r.Context(), req.Name, req.FilePaths)
+		}
+		if err == nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
 			json.NewEncoder(w).Encode(map[string]any{
 				"status":   "ok",
-				"settings": store.Settings{},
+				"playlist": p,
 			})
+			return
+		}
+	case ref != "" && sub == "" && r.Method == http.MethodGet:
+		p, err = s.client.GetPlaylist(r.Context(), ref)
+	case ref != "" && sub == "" && r.Method == http.MethodPut:
+		p, err = s.client.RenamePlaylist(r.Context(), ref, req.Name)
+	case ref != "" && sub == "" && r.Method == http.MethodDelete:
+		err = s.client.DeletePlaylist(r.Context(), ref)
+	case ref != "" && sub == "tracks" && r.Method == http.MethodPost:
+		p, err = s.client.AddToPlaylist(r.Context(), ref, req.FilePaths)
+	case ref != "" && sub == "tracks" && r.Method == http.MethodDelete:
+		p, err = s.client.RemoveFromPlaylist(r.Context(), ref, req.Positions)
+	case ref != "" && sub == "move" && r.Method == http.MethodPost:
+		p, err = s.client.MoveInPlaylist(r.Context(), ref, req.From, req.To)
+	case ref != "" && sub == "rule" && r.Method == http.MethodPut:
+		p, err = s.client.SetPlaylistRule(r.Context(), ref, req.Rule, req.Sort, req.Limit)
+	case ref == "" || sub == "" || sub == "tracks" || sub == "move" || sub == "rule":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		writePlaylistError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if p == nil {
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":   "ok",
+		"playlist": p,
+	})
+}
+
+// writeDaemonError reports that the daemon did not carry out a request, so
+// that a lost write is not mistaken for a saved one.
+func writeDaemonError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "error",
+		"error":  err.Error(),
+	})
+}
+
+func writePlaylistError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if errors.Is(err, daemon.ErrNotFound) {
+		status = http.StatusNotFound
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "error",
+		"error":  err.Error(),
+	})
+}
+
+func (s *Server) handleSettingsAPI(w http.ResponseWriter, r *http.Request) {
+	if s.client == nil {
+		http.Error(w, "Daemon not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		settings, err := s.client.GetSettings(r.Context())
+		if err != nil {
+			writeDaemonError(w, err)
+			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"status":   "ok",
+			"settings": settings,
+		})
 
 	case http.MethodPut:
 		body, err := io.ReadAll(r.Body)
@@ -674,12 +856,92 @@ func (s *Server) handleSettingsAPI(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Invalid settings JSON", http.StatusBadRequest)
 			return
 		}
-		if s.client != nil {
-			s.client.SaveSettings(&settings)
+		if err := s.client.SaveSettings(r.Context(), &settings); err != nil {
+			writeDaemonError(w, err)
+			return
 		}
+		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
+
+// handlePlayer exposes the daemon's shared player. GET /api/player returns
+// the status; POST /api/player/<action> runs play, pause, toggle, stop,
+// next, prev, seek, set-volume, mute, shuffle or repeat with an optional
+// {"value": "..."} body.
+func (s *Server) handlePlayer(w http.ResponseWriter, r *http.Request) {
+	if s.client == nil {
+		http.Error(w, "Daemon not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	var st *playback.Status
+	var err error
+
+	switch r.Method {
+	case http.MethodGet:
+		st, err = s.client.PlayerStatus(r.Context())
+
+	case http.MethodPost:
+		var req struct {
+			Value string            `json:"value"`
+			Queue *store.QueueState `json:"queue"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request", http.StatusBadRequest)
+				return
+			}
+		}
+
+		switch action := strings.TrimPrefix(r.URL.Path, "/api/player/"); action {
+		case "play":
+			st, err = s.client.Play(r.Context(), req.Queue)
+		case "pause":
+			st, err = s.client.Pause(r.Context())
+		case "toggle":
+			st, err = s.client.TogglePause(r.Context())
+		case "stop":
+			st, err = s.client.Stop(r.Context())
+		case "next":
+			st, err = s.client.Next(r.Context())
+		case "prev":
+			st, err = s.client.Previous(r.Context())
+		case "seek":
+			st, err = s.client.Seek(r.Context(), req.Value)
+		case "set-volume":
+			st, err = s.client.SetVolume(r.Context(), req.Value)
+		case "mute":
+			st, err = s.client.ToggleMute(r.Context())
+		case "shuffle":
+			st, err = s.client.SetShuffle(r.Context(), req.Value)
+		case "repeat":
+			st, err = s.client.SetRepeat(r.Context(), req.Value)
+		default:
+			http.Error(w, "Unknown player action", http.StatusNotFound)
+			return
+		}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(map[string]any{
+			"status": "error",
+			"error":  err.Error(),
+			"player": st,
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"status": "ok",
+		"player": st,
+	})
+}
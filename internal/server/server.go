@@ -1,14 +1,17 @@
 package server
 
 import (
-	"encoding/base64"
+	"bytes"
+	"context"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/hoppxi/bpv/internal/artwork"
 	"github.com/hoppxi/bpv/internal/cache"
 	"github.com/hoppxi/bpv/internal/daemon"
 	"github.com/hoppxi/bpv/internal/logger"
@@ -21,8 +24,10 @@ type Server struct {
 	musicDir  string
 	server    *http.Server
 	client    *daemon.Client
-	lib       *cache.CachedLibrary
+	lib       atomic.Pointer[cache.CachedLibrary]
 	startTime time.Time
+	unsub     context.CancelFunc
+	events    *eventHub
 }
 
 func NewServer(port int, musicDir string) *Server {
@@ -30,6 +35,7 @@ func NewServer(port int, musicDir string) *Server {
 		port:      port,
 		musicDir:  musicDir,
 		startTime: time.Now(),
+		events:    newEventHub(),
 	}
 }
 
@@ -39,12 +45,24 @@ func (s *Server) Start() error {
 		return fmt.Errorf("failed to connect to daemon: %w", err)
 	}
 	s.client = client
+	client.OnStateChange(func(state daemon.ConnState) {
+		switch state {
+		case daemon.StateReconnecting:
+			logger.Log.Warn("Lost connection to daemon, reconnecting")
+		case daemon.StateConnected:
+			logger.Log.Success("Reconnected to daemon")
+		}
+	})
 
-	lib, err := client.GetLibrary(s.musicDir)
+	lib, err := client.GetLibrary(context.Background(), s.musicDir)
 	if err != nil {
 		return fmt.Errorf("failed to load library: %w", err)
 	}
-	s.lib = lib
+	s.setLibrary(lib)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.unsub = cancel
+	go s.watchEvents(client.Subscribe(ctx))
 
 	mux := http.NewServeMux()
 	s.setupRoutes(mux)
@@ -62,6 +80,9 @@ func (s *Server) Start() error {
 }
 
 func (s *Server) Stop() error {
+	if s.unsub != nil {
+		s.unsub()
+	}
 	if s.client != nil {
 		s.client.Close()
 	}
@@ -71,6 +92,30 @@ func (s *Server) Stop() error {
 	return nil
 }
 
+// watchEvents reloads the library when another client rescans it, or after
+// a reconnect in case a rescan was missed, and passes every event on to
+// browsers. The reload happens first so a browser that refetches on
+// library-updated sees the new library.
+func (s *Server) watchEvents(events <-chan daemon.Event) {
+	for ev := range events {
+		switch {
+		case ev.Type == daemon.EventResubscribed:
+		case ev.Type == daemon.EventLibraryUpdated && ev.Dir == s.musicDir:
+		default:
+			s.events.publish(ev)
+			continue
+		}
+		lib, err := s.client.GetLibrary(context.Background(), s.musicDir)
+		if err != nil {
+			logger.Log.Error("Failed to reload library: %v", err)
+		} else {
+			s.setLibrary(lib)
+			logger.Log.Debug("Library reloaded: %d audio files", lib.FileCount)
+		}
+		s.events.publish(ev)
+	}
+}
+
 func resolveWebDir() string {
 	if dir := os.Getenv("BPV_WEB_DIR"); dir != "" {
 		return dir
@@ -102,6 +147,7 @@ func (s *Server) setupRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/api/scan", s.handleScan)
 	mux.HandleFunc("/api/scan-simple", s.handleScanSimple)
 	mux.HandleFunc("/api/scan/progress", s.handleScanProgress)
+	mux.HandleFunc("/api/events", s.handleEvents)
 	mux.HandleFunc("/api/artists", s.handleArtists)
 	mux.HandleFunc("/api/artist/", s.handleArtist)
 	mux.HandleFunc("/api/albums", s.handleAlbums)
@@ -114,11 +160,16 @@ func (s *Server) setupRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/api/base-path", s.handleBaseFilePath)
 	mux.HandleFunc("/api/debug", s.handleDebug)
 	mux.HandleFunc("/api/cover/", s.handleCoverArt)
+	mux.HandleFunc("/api/pictures/", s.handlePictures)
 	mux.HandleFunc("/api/favorites", s.handleFavorites)
+	mux.HandleFunc("/api/playlists", s.handlePlaylists)
+	mux.HandleFunc("/api/playlists/", s.handlePlaylists)
 	mux.HandleFunc("/api/stats/play", s.handleRecordPlay)
 	mux.HandleFunc("/api/stats", s.handleStats)
 	mux.HandleFunc("/api/queue", s.handleQueue)
 	mux.HandleFunc("/api/settings", s.handleSettingsAPI)
+	mux.HandleFunc("/api/player", s.handlePlayer)
+	mux.HandleFunc("/api/player/", s.handlePlayer)
 }
 
 func (s *Server) serveWebApp(mux *http.ServeMux, webDir string) bool {
@@ -136,49 +187,61 @@ func (s *Server) serveWebApp(mux *http.ServeMux, webDir string) bool {
 	return true
 }
 
+// handleCoverArt serves /api/cover/<cover id>, or /api/cover/<path> for a
+// file relative to the music directory, from the daemon's artwork store.
+// ?size= picks 64, 300 (the default), 600 or original. Responses carry an
+// ETag and Last-Modified so browsers can revalidate with a 304.
 func (s *Server) handleCoverArt(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	filePath := strings.TrimPrefix(r.URL.Path, "/api/cover/")
-	if filePath == "" {
-		http.Error(w, "Filename required", http.StatusBadRequest)
+	name := strings.TrimPrefix(r.URL.Path, "/api/cover/")
+	if name == "" {
+		http.Error(w, "Cover ID or filename required", http.StatusBadRequest)
+		return
+	}
+	size, err := artwork.ParseSize(r.URL.Query().Get("size"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	fullPath := filepath.Join(s.musicDir, filePath)
-
-	var coverArt, coverMime string
-	if s.client != nil {
-		var err error
-		coverArt, coverMime, err = s.client.GetCoverArt(fullPath)
-		if err != nil || coverArt == "" {
-			logger.Log.ErrorP("Server", "%s", err)
-			http.Error(w, "No cover art found", http.StatusNotFound)
+	var cover *daemon.Cover
+	byID := artwork.IsID(name)
+	if byID {
+		// IDs are content hashes, so the image behind one never changes.
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		etag := coverETag(name, size)
+		if r.Header.Get("If-None-Match") == etag {
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
 			return
 		}
+		cover, err = s.client.GetCoverArtByID(r.Context(), name, size)
 	} else {
-		extractor := metadata.NewExtractor()
-		audioFile, err := extractor.ExtractFromFile(fullPath)
-		if err != nil || audioFile.CoverArt == "" {
-			http.Error(w, "No cover art found", http.StatusNotFound)
-			return
-		}
-		coverArt = audioFile.CoverArt
-		coverMime = audioFile.CoverArtMime
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		cover, err = s.client.GetCoverArt(r.Context(), filepath.Join(s.musicDir, name), size)
 	}
-
-	coverData, err := base64.StdEncoding.DecodeString(coverArt)
 	if err != nil {
-		http.Error(w, "Invalid cover art", http.StatusInternalServerError)
+		logger.Log.ErrorP("Server", "%s", err)
+		http.Error(w, "No cover art found", http.StatusNotFound)
+		return
+	}
+	if cover == nil {
+		http.Error(w, "No cover art found", http.StatusNotFound)
 		return
 	}
 
-	w.Header().Set("Content-Type", coverMime)
-	w.Header().Set("Cache-Control", "public, max-age=3600")
-	w.Write(coverData)
+	w.Header().Set("Content-Type", cover.Mime)
+	w.Header().Set("ETag", coverETag(cover.ID, size))
+	http.ServeContent(w, r, "", cover.Modified, bytes.NewReader(cover.Data))
+}
+
+// coverETag names one size of one picture, which never changes.
+func coverETag(id string, size int) string {
+	return `"` + id + "-" + artwork.FormatSize(size) + `"`
 }
 
 func (s *Server) enableCORS(next http.Handler) http.Handler {
@@ -196,9 +259,20 @@ func (s *Server) enableCORS(next http.Handler) http.Handler {
 	})
 }
 
+// library returns the current library. Handlers should call it once and use
+// the result, since a rescan can swap it at any time.
+func (s *Server) library() *cache.CachedLibrary {
+	return s.lib.Load()
+}
+
+func (s *Server) setLibrary(lib *cache.CachedLibrary) {
+	s.lib.Store(lib)
+}
+
 func (s *Server) getFiles() []metadata.AudioFile {
-	if s.lib == nil {
+	lib := s.library()
+	if lib == nil {
 		return nil
 	}
-	return s.lib.Files
+	return lib.Files
 }
@@ -0,0 +1,115 @@
+package smart
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hoppxi/bpv/internal/metadata"
+)
+
+// Order is how the tracks of a smart playlist are sorted.
+type Order struct {
+	field  *field
+	desc   bool
+	random bool
+}
+
+// ParseOrder parses an order such as "year", "play count desc", "-added" or
+// "random". An empty order keeps library order.
+func ParseOrder(s string) (Order, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Order{}, nil
+	}
+	if strings.EqualFold(s, "random") || strings.EqualFold(s, "shuffle") {
+		return Order{random: true}, nil
+	}
+
+	var o Order
+	if rest, ok := strings.CutPrefix(s, "-"); ok {
+		s, o.desc = rest, true
+	}
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		return Order{}, fmt.Errorf(`"-" needs a field to sort by`)
+	}
+	switch last := strings.ToLower(words[len(words)-1]); {
+	case len(words) > 1 && last == "desc":
+		o.desc = true
+		words = words[:len(words)-1]
+	case len(words) > 1 && last == "asc":
+		words = words[:len(words)-1]
+	}
+
+	f, ok := fieldsByKey[fieldKey(strings.Join(words, ""))]
+	if !ok {
+		return Order{}, fmt.Errorf("cannot sort by %q", strings.Join(words, " "))
+	}
+	o.field = f
+	return o, nil
+}
+
+// Evaluate returns the tracks that match rule, sorted by order and cut to
+// limit tracks when limit is above zero. Random order is stable for a given
+// seed, so a shuffled playlist does not reshuffle every time it is
+// re-evaluated.
+func Evaluate(rule *Rule, order Order, limit int, tracks []Track, seed string, now time.Time) []metadata.AudioFile {
+	var matched []*Track
+	for i := range tracks {
+		if rule.Match(&tracks[i], now) {
+			matched = append(matched, &tracks[i])
+		}
+	}
+
+	switch {
+	case order.random:
+		keys := make(map[*Track]uint64, len(matched))
+		for _, t := range matched {
+			h := sha256.Sum256([]byte(seed + "\x00" + t.FilePath))
+			keys[t] = binary.BigEndian.Uint64(h[:8])
+		}
+		slices.SortFunc(matched, func(a, b *Track) int { return cmp.Compare(keys[a], keys[b]) })
+	case order.field != nil:
+		slices.SortStableFunc(matched, func(a, b *Track) int {
+			c := order.field.compare(a, b)
+			if order.desc {
+				c = -c
+			}
+			return c
+		})
+	}
+
+	if limit > 0 && len(matched) > limit {
+		matched = matched[:limit]
+	}
+	files := make([]metadata.AudioFile, len(matched))
+	for i, t := range matched {
+		files[i] = t.AudioFile
+	}
+	return files
+}
+
+func (f *field) compare(a, b *Track) int {
+	switch f.kind {
+	case kindText:
+		return strings.Compare(strings.ToLower(f.text(a)), strings.ToLower(f.text(b)))
+	case kindNumber, kindDuration:
+		return cmp.Compare(f.number(a), f.number(b))
+	case kindTime:
+		return f.time(a).Compare(f.time(b))
+	case kindBool:
+		switch x, y := f.bool(a), f.bool(b); {
+		case x == y:
+			return 0
+		case x:
+			return 1
+		}
+		return -1
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+package smart
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEvaluateOrder(t *testing.T) {
+	all, err := Parse("year > 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		order string
+		limit int
+		want  []string
+	}{
+		{"", 0, []string{"So What", "Giant Steps", "Rapper's Delight", "Jóga"}},
+		{"year", 0, []string{"So What", "Giant Steps", "Rapper's Delight", "Jóga"}},
+		{"year desc", 2, []string{"Jóga", "Rapper's Delight"}},
+		{"-play count", 0, []string{"So What", "Jóga", "Giant Steps", "Rapper's Delight"}},
+		{"plays asc", 1, []string{"Rapper's Delight"}},
+		{"title", 0, []string{"Giant Steps", "Jóga", "Rapper's Delight", "So What"}},
+		{"duration", 0, []string{"Giant Steps", "Jóga", "So What", "Rapper's Delight"}},
+		{"favorite desc", 0, []string{"So What", "Jóga", "Giant Steps", "Rapper's Delight"}},
+		{"last played", 0, []string{"Rapper's Delight", "Jóga", "Giant Steps", "So What"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			o, err := ParseOrder(tt.order)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := Evaluate(all, o, tt.limit, tracks, "", now)
+			if !slices.Equal(titles(got), tt.want) {
+				t.Errorf("got %q, want %q", titles(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateRandom(t *testing.T) {
+	all, err := Parse("year > 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := ParseOrder("random")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := titles(Evaluate(all, o, 0, tracks, "seed", now))
+	if again := titles(Evaluate(all, o, 0, tracks, "seed", now)); !slices.Equal(again, first) {
+		t.Errorf("same seed shuffled %q, then %q", first, again)
+	}
+	if len(first) != len(tracks) {
+		t.Errorf("got %d tracks, want %d", len(first), len(tracks))
+	}
+}
+
+func TestParseOrderErrors(t *testing.T) {
+	for _, order := range []string{"mood", "year sideways", "-"} {
+		if _, err := ParseOrder(order); err == nil {
+			t.Errorf("ParseOrder(%q) succeeded", order)
+		}
+	}
+}
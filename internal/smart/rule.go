@@ -0,0 +1,636 @@
+// Package smart parses and evaluates the rules of smart playlists, such as
+// "genre = Jazz AND year < 1970", "play count = 0 AND added in last 30 days"
+// or "favorite AND bitrate >= 900".
+//
+// A rule is conditions joined by AND, OR and NOT, with parentheses for
+// grouping; AND binds tighter than OR. A condition is a field, an operator
+// and a value:
+//
+//	title, artist, album, album artist, composer, genre, comment, format, path
+//	    = != contains ~          text, compared without regard to case
+//	year, track, disc, bitrate, sample rate, channels, bpm, play count
+//	    = != < <= > >=           numbers
+//	duration
+//	    = != < <= > >=           seconds or m:ss
+//	added, modified, last played
+//	    before after < > =       a date, YYYY-MM-DD
+//	    in last N days           also hours, weeks, months and years
+//	favorite                     on its own, or = yes / no
+//
+// Values may be double-quoted; unquoted values run to the next AND, OR or
+// closing parenthesis, so "genre = Hip Hop" works as written.
+package smart
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hoppxi/bpv/internal/metadata"
+)
+
+// Track is a library track with the user data rules can test.
+type Track struct {
+	metadata.AudioFile
+	PlayCount  int
+	LastPlayed time.Time
+	Favorite   bool
+}
+
+// Rule is a parsed rule.
+type Rule struct {
+	text string
+	root node
+}
+
+func (r *Rule) String() string { return r.text }
+
+// Match reports whether t satisfies the rule. now is the time relative
+// conditions such as "in last 30 days" count back from.
+func (r *Rule) Match(t *Track, now time.Time) bool {
+	return r.root.match(t, now)
+}
+
+// SyntaxError is a rule that cannot be parsed. Offset is the byte offset in
+// the rule where the problem was found.
+type SyntaxError struct {
+	Offset int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s (at position %d)", e.Msg, e.Offset+1)
+}
+
+// ─── Fields ─────────────────────────────────────────────────────────────────
+
+type kind int
+
+const (
+	kindText kind = iota
+	kindNumber
+	kindDuration
+	kindTime
+	kindBool
+)
+
+type field struct {
+	name   string
+	kind   kind
+	text   func(*Track) string
+	number func(*Track) float64
+	time   func(*Track) time.Time
+	bool   func(*Track) bool
+}
+
+func textField(name string, get func(*Track) string) *field {
+	return &field{name: name, kind: kindText, text: get}
+}
+
+func numberField(name string, get func(*Track) int) *field {
+	return &field{name: name, kind: kindNumber, number: func(t *Track) float64 { return float64(get(t)) }}
+}
+
+func timeField(name string, get func(*Track) time.Time) *field {
+	return &field{name: name, kind: kindTime, time: get}
+}
+
+var fields = []*field{
+	textField("title", func(t *Track) string { return t.Title }),
+	textField("artist", func(t *Track) string { return t.Artist }),
+	textField("album", func(t *Track) string { return t.Album }),
+	textField("album artist", func(t *Track) string { return t.AlbumArtist }),
+	textField("composer", func(t *Track) string { return t.Composer }),
+	textField("genre", func(t *Track) string { return t.Genre }),
+	textField("comment", func(t *Track) string { return t.Comment }),
+	textField("format", func(t *Track) string { return t.FileType }),
+	textField("path", func(t *Track) string { return t.FilePath }),
+	numberField("year", func(t *Track) int { return t.Year }),
+	numberField("track", func(t *Track) int { return t.Track }),
+	numberField("disc", func(t *Track) int { return t.Disc }),
+	numberField("bitrate", func(t *Track) int { return t.Bitrate }),
+	numberField("sample rate", func(t *Track) int { return t.SampleRate }),
+	numberField("channels", func(t *Track) int { return t.Channels }),
+	numberField("bpm", func(t *Track) int { return t.BPM }),
+	numberField("play count", func(t *Track) int { return t.PlayCount }),
+	{name: "duration", kind: kindDuration, number: func(t *Track) float64 { return t.Duration.Seconds() }},
+	timeField("added", func(t *Track) time.Time {
+		// Tracks scanned before Added was recorded count from their mtime.
+		if t.Added.IsZero() {
+			return t.Modified
+		}
+		return t.Added
+	}),
+	timeField("modified", func(t *Track) time.Time { return t.Modified }),
+	timeField("last played", func(t *Track) time.Time { return t.LastPlayed }),
+	{name: "favorite", kind: kindBool, bool: func(t *Track) bool { return t.Favorite }},
+}
+
+// fieldKey folds the ways a field name may be written, "play count",
+// "play_count" and "PlayCount", to one key.
+func fieldKey(s string) string {
+	return strings.NewReplacer(" ", "", "_", "", "-", "").Replace(strings.ToLower(s))
+}
+
+var fieldsByKey = func() map[string]*field {
+	m := make(map[string]*field, len(fields))
+	for _, f := range fields {
+		m[fieldKey(f.name)] = f
+	}
+	m["plays"] = m["playcount"]
+	m["favourite"] = m["favorite"]
+	return m
+}()
+
+// ─── Conditions ─────────────────────────────────────────────────────────────
+
+type node interface {
+	match(t *Track, now time.Time) bool
+}
+
+type andNode struct{ left, right node }
+type orNode struct{ left, right node }
+type notNode struct{ node node }
+
+func (n andNode) match(t *Track, now time.Time) bool {
+	return n.left.match(t, now) && n.right.match(t, now)
+}
+
+func (n orNode) match(t *Track, now time.Time) bool {
+	return n.left.match(t, now) || n.right.match(t, now)
+}
+
+func (n notNode) match(t *Track, now time.Time) bool {
+	return !n.node.match(t, now)
+}
+
+type op int
+
+const (
+	opEq op = iota
+	opNe
+	opLt
+	opLe
+	opGt
+	opGe
+	opContains
+	opWithin // in last
+)
+
+type cond struct {
+	field  *field
+	op     op
+	text   string // lower case
+	number float64
+	time   time.Time // start of the day for dates
+	within time.Duration
+	bool   bool
+}
+
+func (c *cond) match(t *Track, now time.Time) bool {
+	switch c.field.kind {
+	case kindText:
+		v := strings.ToLower(c.field.text(t))
+		switch c.op {
+		case opEq:
+			return v == c.text
+		case opNe:
+			return v != c.text
+		case opContains:
+			return strings.Contains(v, c.text)
+		}
+	case kindNumber, kindDuration:
+		return compare(c.op, c.field.number(t), c.number)
+	case kindTime:
+		v := c.field.time(t)
+		if v.IsZero() {
+			return false
+		}
+		switch c.op {
+		case opWithin:
+			return !v.Before(now.Add(-c.within))
+		case opEq:
+			return !v.Before(c.time) && v.Before(c.time.AddDate(0, 0, 1))
+		case opNe:
+			return v.Before(c.time) || !v.Before(c.time.AddDate(0, 0, 1))
+		case opLt:
+			return v.Before(c.time)
+		case opLe:
+			return v.Before(c.time.AddDate(0, 0, 1))
+		case opGt:
+			return !v.Before(c.time.AddDate(0, 0, 1))
+		case opGe:
+			return !v.Before(c.time)
+		}
+	case kindBool:
+		return c.field.bool(t) == c.bool
+	}
+	return false
+}
+
+func compare(o op, a, b float64) bool {
+	switch o {
+	case opEq:
+		return a == b
+	case opNe:
+		return a != b
+	case opLt:
+		return a < b
+	case opLe:
+		return a <= b
+	case opGt:
+		return a > b
+	case opGe:
+		return a >= b
+	}
+	return false
+}
+
+// ─── Parser ─────────────────────────────────────────────────────────────────
+
+type token struct {
+	text   string
+	quoted bool
+	offset int
+}
+
+func (t token) is(words ...string) bool {
+	if t.quoted {
+		return false
+	}
+	for _, w := range words {
+		if strings.EqualFold(t.text, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenize splits a rule into words, quoted strings, operators and
+// parentheses.
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == '~':
+			tokens = append(tokens, token{text: s[i : i+1], offset: i})
+			i++
+		case c == '=' || c == '!' || c == '<' || c == '>':
+			n := 1
+			if i+1 < len(s) && s[i+1] == '=' {
+				n = 2
+			}
+			if c == '!' && n == 1 {
+				return nil, &SyntaxError{Offset: i, Msg: `"!" must be followed by "="`}
+			}
+			tokens = append(tokens, token{text: s[i : i+n], offset: i})
+			i += n
+		case c == '"':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, &SyntaxError{Offset: i, Msg: "unterminated quote"}
+			}
+			tokens = append(tokens, token{text: s[i+1 : i+1+end], quoted: true, offset: i})
+			i += end + 2
+		default:
+			start := i
+			for i < len(s) && !strings.ContainsRune(" \t\n\r()~=!<>\"", rune(s[i])) {
+				i++
+			}
+			tokens = append(tokens, token{text: s[start:i], offset: start})
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	text   string
+	tokens []token
+	pos    int
+}
+
+// Parse parses a rule. The returned error is a *SyntaxError.
+func Parse(text string) (*Rule, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, &SyntaxError{Offset: 0, Msg: "rule is empty"}
+	}
+	p := &parser{text: text, tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok, ok := p.peek(); ok {
+		return nil, p.errorAt(tok, fmt.Sprintf("unexpected %q", tok.text))
+	}
+	return &Rule{text: strings.TrimSpace(text), root: root}, nil
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *parser) next() (token, bool) {
+	tok, ok := p.peek()
+	if ok {
+		p.pos++
+	}
+	return tok, ok
+}
+
+func (p *parser) errorAt(tok token, msg string) error {
+	return &SyntaxError{Offset: tok.offset, Msg: msg}
+}
+
+func (p *parser) errorAtEnd(msg string) error {
+	return &SyntaxError{Offset: len(p.text), Msg: msg}
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.peek()
+		if !ok || !tok.is("or") {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.peek()
+		if !ok || !tok.is("and") {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+}
+
+func (p *parser) parseNot() (node, error) {
+	tok, ok := p.peek()
+	if ok && tok.is("not") {
+		p.pos++
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return nil, p.errorAtEnd("rule ends where a condition was expected")
+	}
+	if tok.text == "(" && !tok.quoted {
+		p.pos++
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		closing, ok := p.next()
+		if !ok {
+			return nil, p.errorAt(tok, "missing closing parenthesis")
+		}
+		if closing.text != ")" || closing.quoted {
+			return nil, p.errorAt(closing, fmt.Sprintf("expected \")\", found %q", closing.text))
+		}
+		return n, nil
+	}
+	return p.parseCond()
+}
+
+// parseField reads a field name of one or two words.
+func (p *parser) parseField() (*field, error) {
+	tok, _ := p.next()
+	if tok.quoted {
+		return nil, p.errorAt(tok, fmt.Sprintf("expected a field name, found %q", tok.text))
+	}
+	if next, ok := p.peek(); ok && !next.quoted {
+		if f, ok := fieldsByKey[fieldKey(tok.text+next.text)]; ok {
+			p.pos++
+			return f, nil
+		}
+	}
+	if f, ok := fieldsByKey[fieldKey(tok.text)]; ok {
+		return f, nil
+	}
+	return nil, p.errorAt(tok, fmt.Sprintf("unknown field %q", tok.text))
+}
+
+func (p *parser) parseCond() (node, error) {
+	fieldTok, _ := p.peek()
+	f, err := p.parseField()
+	if err != nil {
+		return nil, err
+	}
+
+	opTok, ok := p.peek()
+	if f.kind == kindBool && (!ok || opTok.is("and", "or", ")")) {
+		return &cond{field: f, bool: true}, nil
+	}
+	if !ok {
+		return nil, p.errorAtEnd(fmt.Sprintf("%s needs an operator and a value", f.name))
+	}
+	p.pos++
+
+	c := &cond{field: f}
+	switch {
+	case opTok.is("=", "==", "is"):
+		c.op = opEq
+		if next, ok := p.peek(); ok && opTok.is("is") && next.is("not") {
+			p.pos++
+			c.op = opNe
+		}
+	case opTok.is("!="):
+		c.op = opNe
+	case opTok.is("<", "before"):
+		c.op = opLt
+	case opTok.is("<="):
+		c.op = opLe
+	case opTok.is(">", "after"):
+		c.op = opGt
+	case opTok.is(">="):
+		c.op = opGe
+	case opTok.is("~", "contains"):
+		c.op = opContains
+	case opTok.is("in"):
+		return p.parseWithin(c, opTok)
+	default:
+		return nil, p.errorAt(opTok, fmt.Sprintf("expected an operator after %s, found %q", f.name, opTok.text))
+	}
+
+	if !allowed(f.kind, c.op) {
+		return nil, p.errorAt(opTok, fmt.Sprintf("%s cannot be compared with %q", f.name, opTok.text))
+	}
+
+	valueTok, value, ok := p.parseValue()
+	if !ok {
+		return nil, p.errorAt(opTok, fmt.Sprintf("%s %s needs a value", fieldTok.text, opTok.text))
+	}
+	if err := c.setValue(value); err != nil {
+		return nil, p.errorAt(valueTok, err.Error())
+	}
+	return c, nil
+}
+
+func allowed(k kind, o op) bool {
+	switch k {
+	case kindText:
+		return o == opEq || o == opNe || o == opContains
+	case kindBool:
+		return o == opEq || o == opNe
+	default:
+		return o != opContains
+	}
+}
+
+// parseWithin reads "in [the] last N unit" after a time field.
+func (p *parser) parseWithin(c *cond, in token) (node, error) {
+	if c.field.kind != kindTime {
+		return nil, p.errorAt(in, fmt.Sprintf(`"in last" needs a date field, not %s`, c.field.name))
+	}
+	if tok, ok := p.peek(); ok && tok.is("the") {
+		p.pos++
+	}
+	if tok, ok := p.next(); !ok || !tok.is("last", "past") {
+		return nil, p.errorAt(in, `expected "in last N days"`)
+	}
+
+	numTok, ok := p.next()
+	if !ok {
+		return nil, p.errorAtEnd(`expected "in last N days"`)
+	}
+	n, err := strconv.Atoi(numTok.text)
+	if err != nil || n < 0 {
+		return nil, p.errorAt(numTok, fmt.Sprintf("expected a number of days, found %q", numTok.text))
+	}
+
+	unit := 24 * time.Hour
+	if tok, ok := p.peek(); ok && !tok.is("and", "or", ")") {
+		p.pos++
+		switch strings.TrimSuffix(strings.ToLower(tok.text), "s") {
+		case "hour":
+			unit = time.Hour
+		case "day":
+		case "week":
+			unit = 7 * 24 * time.Hour
+		case "month":
+			unit = 30 * 24 * time.Hour
+		case "year":
+			unit = 365 * 24 * time.Hour
+		default:
+			return nil, p.errorAt(tok, fmt.Sprintf("unknown unit %q, use hours, days, weeks, months or years", tok.text))
+		}
+	}
+	c.op = opWithin
+	c.within = time.Duration(n) * unit
+	return c, nil
+}
+
+// parseValue reads a quoted value, or unquoted words up to the next AND, OR
+// or closing parenthesis.
+func (p *parser) parseValue() (token, string, bool) {
+	first, ok := p.peek()
+	if !ok {
+		return token{}, "", false
+	}
+	if first.quoted {
+		p.pos++
+		return first, first.text, true
+	}
+
+	start, end := first.offset, first.offset
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.quoted || tok.is("and", "or", ")", "(") {
+			break
+		}
+		p.pos++
+		end = tok.offset + len(tok.text)
+	}
+	if end == start {
+		return first, "", false
+	}
+	return first, p.text[start:end], true
+}
+
+func (c *cond) setValue(value string) error {
+	switch c.field.kind {
+	case kindText:
+		c.text = strings.ToLower(value)
+	case kindNumber:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%s needs a number, not %q", c.field.name, value)
+		}
+		c.number = n
+	case kindDuration:
+		d, err := parseSeconds(value)
+		if err != nil {
+			return fmt.Errorf("%s needs seconds or m:ss, not %q", c.field.name, value)
+		}
+		c.number = d
+	case kindTime:
+		t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			return fmt.Errorf("%s needs a date like 2024-01-31, not %q", c.field.name, value)
+		}
+		c.time = t
+	case kindBool:
+		switch strings.ToLower(value) {
+		case "yes", "true", "1":
+			c.bool = true
+		case "no", "false", "0":
+		default:
+			return fmt.Errorf("%s is yes or no, not %q", c.field.name, value)
+		}
+		if c.op == opNe {
+			c.op, c.bool = opEq, !c.bool
+		}
+	}
+	return nil
+}
+
+// parseSeconds reads "210", "3:30" or "1:02:03" as seconds.
+func parseSeconds(s string) (float64, error) {
+	var secs float64
+	for i, part := range strings.Split(s, ":") {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 || (i > 0 && n >= 60) || i > 2 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		secs = secs*60 + n
+	}
+	return secs, nil
+}
@@ -0,0 +1,129 @@
+package smart
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/hoppxi/bpv/internal/metadata"
+)
+
+var now = time.Date(2024, 6, 15, 12, 0, 0, 0, time.Local)
+
+var tracks = []Track{
+	{AudioFile: metadata.AudioFile{Title: "So What", Artist: "Miles Davis", Album: "Kind of Blue", Genre: "Jazz",
+		Year: 1959, Track: 1, Bitrate: 1000, Duration: 9*time.Minute + 22*time.Second, FileType: "flac",
+		FilePath: "/music/davis/01.flac", Added: now.AddDate(0, 0, -3)},
+		PlayCount: 12, LastPlayed: now.Add(-2 * time.Hour), Favorite: true},
+	{AudioFile: metadata.AudioFile{Title: "Giant Steps", Artist: "John Coltrane", Album: "Giant Steps", Genre: "Jazz",
+		Year: 1960, Track: 1, Bitrate: 320, Duration: 4*time.Minute + 43*time.Second, FileType: "mp3",
+		FilePath: "/music/coltrane/01.mp3", Added: now.AddDate(0, -2, 0)},
+		PlayCount: 3, LastPlayed: time.Date(2024, 1, 31, 20, 0, 0, 0, time.Local)},
+	{AudioFile: metadata.AudioFile{Title: "Rapper's Delight", Artist: "The Sugarhill Gang", Genre: "Hip Hop",
+		Year: 1979, Track: 1, Bitrate: 256, Duration: 14*time.Minute + 35*time.Second, FileType: "mp3",
+		FilePath: "/music/sugarhill/01.mp3", Modified: now.AddDate(0, 0, -10)}},
+	{AudioFile: metadata.AudioFile{Title: "Jóga", Artist: "Björk", Album: "Homogenic", Genre: "Electronic",
+		Year: 1997, Track: 2, Bitrate: 900, Duration: 5*time.Minute + 5*time.Second, FileType: "flac",
+		FilePath: "/music/bjork/02.flac", Added: now.AddDate(-1, 0, 0)},
+		PlayCount: 7, Favorite: true},
+}
+
+func titles(files []metadata.AudioFile) []string {
+	out := []string{}
+	for _, f := range files {
+		out = append(out, f.Title)
+	}
+	return out
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		rule string
+		want []string
+	}{
+		{"genre = Jazz", []string{"So What", "Giant Steps"}},
+		{"genre = jazz AND year < 1960", []string{"So What"}},
+		{"genre is not jazz", []string{"Rapper's Delight", "Jóga"}},
+		{"genre != Jazz", []string{"Rapper's Delight", "Jóga"}},
+		{"genre = Hip Hop", []string{"Rapper's Delight"}},
+		{`artist contains "davis"`, []string{"So What"}},
+		{"title ~ step", []string{"Giant Steps"}},
+		{"album artist = \"\"", []string{"So What", "Giant Steps", "Rapper's Delight", "Jóga"}},
+		{"format = FLAC", []string{"So What", "Jóga"}},
+		{"year >= 1960 AND year <= 1979", []string{"Giant Steps", "Rapper's Delight"}},
+		{"genre = jazz OR genre = electronic AND favorite", []string{"So What", "Giant Steps", "Jóga"}},
+		{"(genre = jazz OR genre = electronic) AND favorite", []string{"So What", "Jóga"}},
+		{"NOT favorite", []string{"Giant Steps", "Rapper's Delight"}},
+		{"favourite = no", []string{"Giant Steps", "Rapper's Delight"}},
+		{"favorite != no", []string{"So What", "Jóga"}},
+		{"favorite AND bitrate >= 900", []string{"So What", "Jóga"}},
+		{"play count = 0", []string{"Rapper's Delight"}},
+		{"plays > 5", []string{"So What", "Jóga"}},
+		{"PlayCount >= 3 AND play_count < 12", []string{"Giant Steps", "Jóga"}},
+		{"duration > 9:00", []string{"So What", "Rapper's Delight"}},
+		{"duration <= 300", []string{"Giant Steps"}},
+		{"duration < 1:00:00", []string{"So What", "Giant Steps", "Rapper's Delight", "Jóga"}},
+		{"added in last 30 days", []string{"So What", "Rapper's Delight"}},
+		{"added in the last 2 weeks", []string{"So What", "Rapper's Delight"}},
+		{"added in last 2 years", []string{"So What", "Giant Steps", "Rapper's Delight", "Jóga"}},
+		{"added before 2024-06-01", []string{"Giant Steps", "Jóga"}},
+		{"modified after 2024-06-01", []string{"Rapper's Delight"}},
+		{"last played = 2024-01-31", []string{"Giant Steps"}},
+		{"last played in last 3 hours", []string{"So What"}},
+		{"last played < 2025-01-01", []string{"So What", "Giant Steps"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			r, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := Evaluate(r, Order{}, 0, tracks, "", now)
+			if !slices.Equal(titles(got), tt.want) {
+				t.Errorf("got %q, want %q", titles(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		rule   string
+		offset int
+	}{
+		{"", 0},
+		{"   ", 0},
+		{"mood = happy", 0},
+		{"genre", 5},
+		{"genre jazz", 6},
+		{"genre =", 6},
+		{"genre ! jazz", 6},
+		{`genre = "jazz`, 8},
+		{"year ~ 1960", 5},
+		{"year = nineteen", 7},
+		{"duration > 3:75", 11},
+		{"added > 31/01/2024", 8},
+		{"year in last 3 days", 5},
+		{"added in 3 days", 6},
+		{"added in last x days", 14},
+		{"added in last 3 fortnights", 16},
+		{"favorite = maybe", 11},
+		{"(genre = jazz", 0},
+		{"genre = jazz)", 12},
+		{"genre = jazz AND", 16},
+		{"NOT", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			_, err := Parse(tt.rule)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("err = %v, want a *SyntaxError", err)
+			}
+			if syntaxErr.Offset != tt.offset {
+				t.Errorf("offset = %d, want %d (%v)", syntaxErr.Offset, tt.offset, err)
+			}
+		})
+	}
+}
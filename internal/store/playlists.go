@@ -0,0 +1,265 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Playlist is a named, ordered list of tracks. A track may appear more than
+// once, so entries are addressed by position.
+//
+// A smart playlist has a Smart rule instead of tracks; its tracks are
+// whatever in the library matches the rule at the time.
+type Playlist struct {
+	ID       string     `json:"id"`
+	Name     string     `json:"name"`
+	Tracks   []TrackRef `json:"tracks"`
+	Smart    *SmartRule `json:"smart,omitempty"`
+	Created  time.Time  `json:"created"`
+	Modified time.Time  `json:"modified"`
+}
+
+// SmartRule is what a smart playlist holds: a rule tracks must match, the
+// order to sort them in and at most how many to keep (0 for all). The store
+// keeps them as text; the smart package parses them.
+type SmartRule struct {
+	Rule  string `json:"rule"`
+	Sort  string `json:"sort,omitempty"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+var (
+	ErrPlaylistNotFound = errors.New("playlist not found")
+	ErrPlaylistExists   = errors.New("a playlist with that name already exists")
+	ErrSmartPlaylist    = errors.New("the tracks of a smart playlist come from its rule")
+	ErrNotSmartPlaylist = errors.New("not a smart playlist")
+)
+
+func (s *Store) playlistsPath() string {
+	return filepath.Join(s.dir, "playlists.json")
+}
+
+// GetPlaylists returns every playlist in the order they were created.
+func (s *Store) GetPlaylists() ([]Playlist, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.readPlaylists()
+}
+
+// GetPlaylist returns the playlist with the given ID or, failing that, name.
+func (s *Store) GetPlaylist(ref string) (*Playlist, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lists, err := s.readPlaylists()
+	if err != nil {
+		return nil, err
+	}
+	i := findPlaylist(lists, ref)
+	if i < 0 {
+		return nil, ErrPlaylistNotFound
+	}
+	return &lists[i], nil
+}
+
+func (s *Store) CreatePlaylist(name string, tracks []TrackRef) (*Playlist, error) {
+	return s.createPlaylist(name, tracks, nil)
+}
+
+func (s *Store) CreateSmartPlaylist(name string, rule SmartRule) (*Playlist, error) {
+	return s.createPlaylist(name, nil, &rule)
+}
+
+func (s *Store) createPlaylist(name string, tracks []TrackRef, rule *SmartRule) (*Playlist, error) {
+	name, err := playlistName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lists, err := s.readPlaylists()
+	if err != nil {
+		return nil, err
+	}
+	if slices.ContainsFunc(lists, func(p Playlist) bool { return p.Name == name }) {
+		return nil, ErrPlaylistExists
+	}
+
+	now := time.Now()
+	p := Playlist{
+		ID:       newPlaylistID(),
+		Name:     name,
+		Tracks:   slices.Clone(tracks),
+		Smart:    rule,
+		Created:  now,
+		Modified: now,
+	}
+	if p.Tracks == nil {
+		p.Tracks = []TrackRef{}
+	}
+	lists = append(lists, p)
+	if err := s.writeJSON(s.playlistsPath(), lists); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *Store) RenamePlaylist(ref, name string) error {
+	name, err := playlistName(name)
+	if err != nil {
+		return err
+	}
+	return s.editPlaylist(ref, func(lists []Playlist, p *Playlist) error {
+		if slices.ContainsFunc(lists, func(o Playlist) bool { return o.Name == name && o.ID != p.ID }) {
+			return ErrPlaylistExists
+		}
+		p.Name = name
+		return nil
+	})
+}
+
+// SetPlaylistRule replaces the rule of a smart playlist.
+func (s *Store) SetPlaylistRule(ref string, rule SmartRule) error {
+	return s.editPlaylist(ref, func(_ []Playlist, p *Playlist) error {
+		if p.Smart == nil {
+			return ErrNotSmartPlaylist
+		}
+		p.Smart = &rule
+		return nil
+	})
+}
+
+func (s *Store) DeletePlaylist(ref string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lists, err := s.readPlaylists()
+	if err != nil {
+		return err
+	}
+	i := findPlaylist(lists, ref)
+	if i < 0 {
+		return ErrPlaylistNotFound
+	}
+	return s.writeJSON(s.playlistsPath(), slices.Delete(lists, i, i+1))
+}
+
+// AddToPlaylist appends tracks to the end of a playlist.
+func (s *Store) AddToPlaylist(ref string, tracks []TrackRef) error {
+	return s.editPlaylist(ref, func(_ []Playlist, p *Playlist) error {
+		if p.Smart != nil {
+			return ErrSmartPlaylist
+		}
+		p.Tracks = append(p.Tracks, tracks...)
+		return nil
+	})
+}
+
+// RemoveFromPlaylist removes the entries at the given positions.
+func (s *Store) RemoveFromPlaylist(ref string, positions []int) error {
+	return s.editPlaylist(ref, func(_ []Playlist, p *Playlist) error {
+		if p.Smart != nil {
+			return ErrSmartPlaylist
+		}
+		remove := make(map[int]bool, len(positions))
+		for _, i := range positions {
+			if i < 0 || i >= len(p.Tracks) {
+				return fmt.Errorf("position %d out of range", i)
+			}
+			remove[i] = true
+		}
+		kept := p.Tracks[:0]
+		for i, t := range p.Tracks {
+			if !remove[i] {
+				kept = append(kept, t)
+			}
+		}
+		p.Tracks = kept
+		return nil
+	})
+}
+
+// MoveInPlaylist moves the entry at position from so it ends up at to,
+// shifting the entries in between.
+func (s *Store) MoveInPlaylist(ref string, from, to int) error {
+	return s.editPlaylist(ref, func(_ []Playlist, p *Playlist) error {
+		if p.Smart != nil {
+			return ErrSmartPlaylist
+		}
+		if from < 0 || from >= len(p.Tracks) || to < 0 || to >= len(p.Tracks) {
+			return fmt.Errorf("cannot move %d to %d in a playlist of %d tracks", from, to, len(p.Tracks))
+		}
+		t := p.Tracks[from]
+		p.Tracks = slices.Insert(slices.Delete(p.Tracks, from, from+1), to, t)
+		return nil
+	})
+}
+
+// editPlaylist applies edit to one playlist and saves it if edit succeeds.
+func (s *Store) editPlaylist(ref string, edit func(lists []Playlist, p *Playlist) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lists, err := s.readPlaylists()
+	if err != nil {
+		return err
+	}
+	i := findPlaylist(lists, ref)
+	if i < 0 {
+		return ErrPlaylistNotFound
+	}
+	if err := edit(lists, &lists[i]); err != nil {
+		return err
+	}
+	lists[i].Modified = time.Now()
+	return s.writeJSON(s.playlistsPath(), lists)
+}
+
+func (s *Store) readPlaylists() ([]Playlist, error) {
+	data, err := os.ReadFile(s.playlistsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var lists []Playlist
+	if err := json.Unmarshal(data, &lists); err != nil {
+		return nil, err
+	}
+	return lists, nil
+}
+
+// findPlaylist returns the index of the playlist with ID ref, or else the
+// one named ref, or -1.
+func findPlaylist(lists []Playlist, ref string) int {
+	if i := slices.IndexFunc(lists, func(p Playlist) bool { return p.ID == ref }); i >= 0 {
+		return i
+	}
+	return slices.IndexFunc(lists, func(p Playlist) bool { return p.Name == ref })
+}
+
+func playlistName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("playlist name is required")
+	}
+	return name, nil
+}
+
+func newPlaylistID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -2,10 +2,12 @@ package store
 
 import (
 	"encoding/json"
+	"maps"
 	"os"
 	"path/filepath"
 	"slices"
 	"sync"
+	"time"
 
 	"github.com/hoppxi/bpv/internal/xdg"
 )
@@ -33,12 +35,40 @@ type Settings struct {
 }
 
 type QueueState struct {
-	FilePaths    []string `json:"file_paths"`
+	FilePaths []string `json:"file_paths"`
+	// TrackIDs holds the stable ID of each entry in FilePaths, so Relink
+	// can follow queued files that move.
+	TrackIDs     []string `json:"track_ids,omitempty"`
 	CurrentIndex int      `json:"current_index"`
 	Shuffle      bool     `json:"shuffle"`
 	Repeat       int      `json:"repeat"`
 }
 
+// TrackRef names a track by its stable ID (see metadata.AudioFile.ID) and
+// the path it was last seen at. Entries saved before tracks had IDs have
+// only a path until Relink finds the file.
+type TrackRef struct {
+	ID   string `json:"id,omitempty"`
+	Path string `json:"path"`
+}
+
+// Matches reports whether r and other name the same track: by ID when both
+// have one, by path otherwise.
+func (r TrackRef) Matches(other TrackRef) bool {
+	if r.ID != "" && other.ID != "" {
+		return r.ID == other.ID
+	}
+	return r.Path == other.Path
+}
+
+// PlayCount is how many times a track has been played, and when it was
+// last played.
+type PlayCount struct {
+	TrackRef
+	Count      int       `json:"count"`
+	LastPlayed time.Time `json:"last_played,omitempty"`
+}
+
 func NewStore() (*Store, error) {
 	dir := xdg.DataDir()
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -62,54 +92,76 @@ func (s *Store) favPath() string {
 	return filepath.Join(s.dir, "favorites.json")
 }
 
-func (s *Store) GetFavorites() ([]string, error) {
+func (s *Store) GetFavorites() ([]TrackRef, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.readStringSlice(s.favPath())
+	return s.readFavorites()
 }
 
-func (s *Store) SetFavorites(favs []string) error {
+func (s *Store) SetFavorites(favs []TrackRef) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	return s.writeJSON(s.favPath(), favs)
 }
 
-func (s *Store) AddFavorite(filePath string) error {
+func (s *Store) AddFavorite(track TrackRef) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	favs, _ := s.readStringSlice(s.favPath())
-	if slices.Contains(favs, filePath) {
+	favs, _ := s.readFavorites()
+	if slices.ContainsFunc(favs, track.Matches) {
 		return nil
 	}
 
-	favs = append(favs, filePath)
+	favs = append(favs, track)
 	return s.writeJSON(s.favPath(), favs)
 }
 
-func (s *Store) RemoveFavorite(filePath string) error {
+func (s *Store) RemoveFavorite(track TrackRef) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	favs, _ := s.readStringSlice(s.favPath())
-	for i, f := range favs {
-		if f == filePath {
-			favs = append(favs[:i], favs[i+1:]...)
-			return s.writeJSON(s.favPath(), favs)
-		}
+	favs, _ := s.readFavorites()
+	n := len(favs)
+	favs = slices.DeleteFunc(favs, track.Matches)
+	if len(favs) == n {
+		return nil
 	}
-	return nil
+	return s.writeJSON(s.favPath(), favs)
 }
 
-func (s *Store) IsFavorite(filePath string) (bool, error) {
+func (s *Store) IsFavorite(track TrackRef) (bool, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	favs, _ := s.readStringSlice(s.favPath())
-	if slices.Contains(favs, filePath) {
-		return true, nil
+	favs, _ := s.readFavorites()
+	return slices.ContainsFunc(favs, track.Matches), nil
+}
+
+// readFavorites also reads the list of paths favorites.json held before
+// tracks had IDs.
+func (s *Store) readFavorites() ([]TrackRef, error) {
+	data, err := os.ReadFile(s.favPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
 	}
-	return false, nil
+
+	var favs []TrackRef
+	if err := json.Unmarshal(data, &favs); err == nil {
+		return favs, nil
+	}
+	var paths []string
+	if err := json.Unmarshal(data, &paths); err != nil {
+		return nil, err
+	}
+	favs = make([]TrackRef, 0, len(paths))
+	for _, p := range paths {
+		favs = append(favs, TrackRef{Path: p})
+	}
+	return favs, nil
 }
 
 func (s *Store) settingsPath() string {
@@ -153,7 +205,10 @@ func (s *Store) queuePath() string {
 func (s *Store) GetQueue() (*QueueState, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	return s.readQueue()
+}
 
+func (s *Store) readQueue() (*QueueState, error) {
 	data, err := os.ReadFile(s.queuePath())
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -179,52 +234,194 @@ func (s *Store) statsPath() string {
 	return filepath.Join(s.dir, "playstats.json")
 }
 
-func (s *Store) GetPlayStats() (map[string]int, error) {
+func (s *Store) GetPlayStats() ([]PlayCount, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	return s.readPlayStats()
+}
+
+// RecordPlay counts a play of track, and remembers the path it was played
+// from.
+func (s *Store) RecordPlay(track TrackRef) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
+	stats, _ := s.readPlayStats()
+	i := slices.IndexFunc(stats, func(c PlayCount) bool { return track.Matches(c.TrackRef) })
+	if i < 0 {
+		stats = append(stats, PlayCount{TrackRef: track})
+		i = len(stats) - 1
+	}
+	stats[i].Count++
+	stats[i].LastPlayed = time.Now()
+	if track.ID != "" {
+		stats[i].ID = track.ID
+	}
+	stats[i].Path = track.Path
+	return s.writeJSON(s.statsPath(), stats)
+}
+
+// readPlayStats also reads the map of path to count playstats.json held
+// before tracks had IDs. A file that cannot be parsed counts as empty, as it
+// always has.
+func (s *Store) readPlayStats() ([]PlayCount, error) {
 	data, err := os.ReadFile(s.statsPath())
 	if err != nil {
 		if os.IsNotExist(err) {
-			return make(map[string]int), nil
+			return nil, nil
 		}
 		return nil, err
 	}
 
-	var stats map[string]int
-	if err := json.Unmarshal(data, &stats); err != nil {
-		return make(map[string]int), nil
+	var stats []PlayCount
+	if err := json.Unmarshal(data, &stats); err == nil {
+		return stats, nil
+	}
+	var legacy map[string]int
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return nil, nil
+	}
+	stats = make([]PlayCount, 0, len(legacy))
+	for _, path := range slices.Sorted(maps.Keys(legacy)) {
+		stats = append(stats, PlayCount{TrackRef: TrackRef{Path: path}, Count: legacy[path]})
 	}
 	return stats, nil
 }
 
-func (s *Store) RecordPlay(filePath string) error {
+// Relink points favorites, play counts, playlists and the saved queue at
+// where tracks are now. tracks are the files of a freshly scanned library:
+// an entry still at a file with its ID is left alone, and one whose file is
+// gone follows its ID to wherever the file is now, so moving or renaming
+// files loses nothing. An entry saved before tracks had IDs, or whose ID is
+// not among them while its path is, takes the ID of the file at its path,
+// so a file whose ID changed is still followed. Favorites and play counts
+// that end up naming the same file are merged. Relink reports whether
+// anything changed.
+func (s *Store) Relink(tracks []TrackRef) (bool, error) {
+	byID := make(map[string]string, len(tracks))
+	byPath := make(map[string]string, len(tracks))
+	for _, t := range tracks {
+		if t.ID != "" {
+			byID[t.ID] = t.Path
+			byPath[t.Path] = t.ID
+		}
+	}
+	relink := func(ref *TrackRef) bool {
+		if ref.ID == "" {
+			id, ok := byPath[ref.Path]
+			ref.ID = id
+			return ok
+		}
+		// Bit-identical copies share an ID, so the entry's own file is
+		// preferred over any other file with that ID.
+		if byPath[ref.Path] == ref.ID {
+			return false
+		}
+		if path, ok := byID[ref.ID]; ok {
+			ref.Path = path
+			return true
+		}
+		// The file at the entry's path has a different ID than before, for
+		// example because it was tagged with MusicBrainz IDs since.
+		if id, ok := byPath[ref.Path]; ok {
+			ref.ID = id
+			return true
+		}
+		return false
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	data, _ := os.ReadFile(s.statsPath())
-	stats := make(map[string]int)
-	if data != nil {
-		json.Unmarshal(data, &stats)
+	changed := false
+
+	favs, err := s.readFavorites()
+	if err != nil {
+		return false, err
+	}
+	favsChanged := false
+	for i := range favs {
+		favsChanged = relink(&favs[i]) || favsChanged
+	}
+	if favsChanged {
+		var merged []TrackRef
+		for _, f := range favs {
+			if !slices.Contains(merged, f) {
+				merged = append(merged, f)
+			}
+		}
+		if err := s.writeJSON(s.favPath(), merged); err != nil {
+			return false, err
+		}
+		changed = true
 	}
-	stats[filePath]++
-	return s.writeJSON(s.statsPath(), stats)
-}
 
-func (s *Store) readStringSlice(path string) ([]string, error) {
-	data, err := os.ReadFile(path)
+	stats, err := s.readPlayStats()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil
+		return changed, err
+	}
+	statsChanged := false
+	for i := range stats {
+		statsChanged = relink(&stats[i].TrackRef) || statsChanged
+	}
+	if statsChanged {
+		var merged []PlayCount
+		for _, c := range stats {
+			j := slices.IndexFunc(merged, func(m PlayCount) bool { return m.TrackRef == c.TrackRef })
+			if j < 0 {
+				merged = append(merged, c)
+			} else {
+				merged[j].Count += c.Count
+				if c.LastPlayed.After(merged[j].LastPlayed) {
+					merged[j].LastPlayed = c.LastPlayed
+				}
+			}
 		}
-		return nil, err
+		if err := s.writeJSON(s.statsPath(), merged); err != nil {
+			return changed, err
+		}
+		changed = true
 	}
 
-	var result []string
-	if err := json.Unmarshal(data, &result); err != nil {
-		return nil, err
+	lists, err := s.readPlaylists()
+	if err != nil {
+		return changed, err
+	}
+	listsChanged := false
+	for i := range lists {
+		for j := range lists[i].Tracks {
+			listsChanged = relink(&lists[i].Tracks[j]) || listsChanged
+		}
+	}
+	if listsChanged {
+		if err := s.writeJSON(s.playlistsPath(), lists); err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+
+	q, err := s.readQueue()
+	if err != nil || q == nil {
+		return changed, err
+	}
+	queueChanged := false
+	if len(q.TrackIDs) != len(q.FilePaths) {
+		q.TrackIDs = make([]string, len(q.FilePaths))
+	}
+	for i := range q.FilePaths {
+		ref := TrackRef{ID: q.TrackIDs[i], Path: q.FilePaths[i]}
+		if relink(&ref) {
+			q.TrackIDs[i], q.FilePaths[i] = ref.ID, ref.Path
+			queueChanged = true
+		}
+	}
+	if queueChanged {
+		if err := s.writeJSON(s.queuePath(), q); err != nil {
+			return changed, err
+		}
+		changed = true
 	}
-	return result, nil
+	return changed, nil
 }
 
 func (s *Store) writeJSON(path string, v any) error {
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRelink(t *testing.T) {
+	tests := []struct {
+		name    string
+		favs    []TrackRef
+		tracks  []TrackRef
+		want    []TrackRef
+		changed bool
+	}{
+		{
+			name:    "unchanged",
+			favs:    []TrackRef{{ID: "au:1", Path: "/a.flac"}},
+			tracks:  []TrackRef{{ID: "au:1", Path: "/a.flac"}},
+			want:    []TrackRef{{ID: "au:1", Path: "/a.flac"}},
+			changed: false,
+		},
+		{
+			name:    "moved",
+			favs:    []TrackRef{{ID: "au:1", Path: "/a.flac"}},
+			tracks:  []TrackRef{{ID: "au:1", Path: "/b.flac"}},
+			want:    []TrackRef{{ID: "au:1", Path: "/b.flac"}},
+			changed: true,
+		},
+		{
+			name: "copies stay apart",
+			favs: []TrackRef{{ID: "au:1", Path: "/a.flac"}, {ID: "au:1", Path: "/b.flac"}},
+			tracks: []TrackRef{
+				{ID: "au:1", Path: "/a.flac"},
+				{ID: "au:1", Path: "/b.flac"},
+				{ID: "au:2", Path: "/c.flac"},
+			},
+			want:    []TrackRef{{ID: "au:1", Path: "/a.flac"}, {ID: "au:1", Path: "/b.flac"}},
+			changed: false,
+		},
+		{
+			name: "copy kept while another moves",
+			favs: []TrackRef{{ID: "au:1", Path: "/a.flac"}, {ID: "au:2", Path: "/c.flac"}},
+			tracks: []TrackRef{
+				{ID: "au:1", Path: "/a.flac"},
+				{ID: "au:1", Path: "/b.flac"},
+				{ID: "au:2", Path: "/d.flac"},
+			},
+			want:    []TrackRef{{ID: "au:1", Path: "/a.flac"}, {ID: "au:2", Path: "/d.flac"}},
+			changed: true,
+		},
+		{
+			name:    "ID adopted by path",
+			favs:    []TrackRef{{Path: "/a.flac"}, {ID: "au:1", Path: "/b.flac"}},
+			tracks:  []TrackRef{{ID: "mb:x", Path: "/a.flac"}, {ID: "mb:y", Path: "/b.flac"}},
+			want:    []TrackRef{{ID: "mb:x", Path: "/a.flac"}, {ID: "mb:y", Path: "/b.flac"}},
+			changed: true,
+		},
+		{
+			name:    "duplicates merged",
+			favs:    []TrackRef{{Path: "/b.flac"}, {ID: "au:1", Path: "/a.flac"}},
+			tracks:  []TrackRef{{ID: "au:1", Path: "/b.flac"}},
+			want:    []TrackRef{{ID: "au:1", Path: "/b.flac"}},
+			changed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewStoreAt(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.SetFavorites(tt.favs); err != nil {
+				t.Fatal(err)
+			}
+			changed, err := s.Relink(tt.tracks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tt.changed {
+				t.Errorf("changed = %v, want %v", changed, tt.changed)
+			}
+			got, err := s.GetFavorites()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("favorites = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
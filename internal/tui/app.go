@@ -1,6 +1,7 @@
 package tui
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
@@ -12,7 +13,8 @@ import (
 	"github.com/hoppxi/bpv/internal/cache"
 	"github.com/hoppxi/bpv/internal/daemon"
 	"github.com/hoppxi/bpv/internal/metadata"
-	"github.com/hoppxi/bpv/internal/store"
+	"github.com/hoppxi/bpv/internal/query"
+	"github.com/hoppxi/bpv/internal/scanner"
 )
 
 type viewKind int
@@ -28,36 +30,84 @@ const (
 	viewNowPlaying
 	viewQueue
 	viewFavorites
+	viewPlaylists
 )
 
-var tabNames = []string{"Dashboard", "Artists", "Albums", "Genres", "Songs", "Now Playing", "Queue", "Favorites"}
+var tabNames = []string{"Dashboard", "Artists", "Albums", "Genres", "Songs", "Now Playing", "Queue", "Favorites", "Playlists"}
 
 type libraryScanDone struct {
 	lib *cache.CachedLibrary
 	err error
 }
 
+type scanStarted struct {
+	err error
+}
+
 type favoritesLoaded struct {
 	favs []string
 	err  error
 }
 
-type queueLoaded struct {
-	queue *store.QueueState
+type playlistsLoaded struct {
+	lists []daemon.Playlist
 	err   error
 }
 
+// playlistLoaded carries a playlist and its tracks, to be opened in the song
+// list or, when play is set, played from the top.
+type playlistLoaded struct {
+	playlist *daemon.Playlist
+	play     bool
+	err      error
+}
+
+// playlistSaved reports the result of saving the queue as a playlist.
+type playlistSaved struct {
+	playlist *daemon.Playlist
+	err      error
+}
+
+// picturesLoaded carries the embedded pictures of the track in the detail
+// view.
+type picturesLoaded struct {
+	path     string
+	pictures []metadata.Picture
+	err      error
+}
+
+// searchDone carries the results of searching the library for text.
+type searchDone struct {
+	text    string
+	results []metadata.AudioFile
+	err     error
+}
+
+// searchLimit caps how many results a search brings back from the daemon.
+const searchLimit = 500
+
+type playerSynced struct{}
+
+// daemonEvent carries one event from the daemon subscription; closed is set
+// once the stream ends.
+type daemonEvent struct {
+	event  daemon.Event
+	closed bool
+}
+
 type tickMsg time.Time
 type spinnerTick time.Time
 
 type Model struct {
 	client   *daemon.Client
+	events   <-chan daemon.Event
+	unsub    context.CancelFunc
 	lib      *cache.CachedLibrary
 	musicDir string
 	keys     KeyMap
 	width    int
 	height   int
-	player   *Player
+	player   *Remote
 
 	activeView   viewKind
 	activeTab    int
@@ -67,6 +117,13 @@ type Model struct {
 	searchInput  textinput.Model
 	err          error
 
+	// promptActive is set while the name of a new playlist is typed.
+	promptActive bool
+	promptInput  textinput.Model
+	// notice is a one-off message shown in the status bar until the next
+	// key press.
+	notice string
+
 	artistCursor int
 	albumCursor  int
 	genreCursor  int
@@ -74,22 +131,30 @@ type Model struct {
 	searchCursor int
 	queueCursor  int
 	favCursor    int
+	listCursor   int
 
 	artistList []listEntry
 	albumList  []listEntry
 	genreList  []listEntry
 	songList   []metadata.AudioFile
 	searchRes  []metadata.AudioFile
+	searchErr  error // why the query in the search bar does not parse
 	favTracks  []metadata.AudioFile
+	playlists  []daemon.Playlist
 
 	allFiles []metadata.AudioFile
 
 	detailTrack metadata.AudioFile
-	filterLabel string
+	// detailPictures are the detail track's embedded pictures, paged
+	// through with [ and ].
+	detailPictures []metadata.Picture
+	detailPicture  int
+	filterLabel    string
 
 	viewStack []viewKind
 
-	spinnerIdx int
+	spinnerIdx   int
+	scanProgress *scanner.ScanProgress
 }
 
 func New(musicDir string) Model {
@@ -98,7 +163,12 @@ func New(musicDir string) Model {
 	ti.CharLimit = 100
 	ti.Width = 40
 
-	p := NewPlayer()
+	pi := textinput.New()
+	pi.Placeholder = "Playlist name"
+	pi.CharLimit = 100
+	pi.Width = 30
+
+	p := NewRemote()
 
 	return Model{
 		musicDir:    musicDir,
@@ -107,6 +177,7 @@ func New(musicDir string) Model {
 		activeTab:   0,
 		scanning:    true,
 		searchInput: ti,
+		promptInput: pi,
 		viewStack:   []viewKind{},
 		player:      p,
 	}
@@ -127,19 +198,31 @@ func (m Model) connectAndLoad() tea.Cmd {
 			return libraryScanDone{err: fmt.Errorf("daemon: %w", err)}
 		}
 
-		lib, err := client.GetLibrary(m.musicDir)
+		lib, err := client.GetLibrary(context.Background(), m.musicDir)
 		if err != nil {
 			client.Close()
 			return libraryScanDone{err: fmt.Errorf("library: %w", err)}
 		}
 
-		return daemonConnected{client: client, lib: lib}
+		ctx, cancel := context.WithCancel(context.Background())
+		events := client.Subscribe(ctx)
+
+		return daemonConnected{client: client, lib: lib, events: events, unsub: cancel}
 	}
 }
 
 type daemonConnected struct {
 	client *daemon.Client
 	lib    *cache.CachedLibrary
+	events <-chan daemon.Event
+	unsub  context.CancelFunc
+}
+
+func waitForEvent(events <-chan daemon.Event) tea.Cmd {
+	return func() tea.Msg {
+		ev, ok := <-events
+		return daemonEvent{event: ev, closed: !ok}
+	}
 }
 
 func tickCmd() tea.Cmd {
@@ -165,13 +248,23 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case daemonConnected:
 		m.scanning = false
 		m.client = msg.client
+		m.events = msg.events
+		m.unsub = msg.unsub
+		m.player.SetClient(msg.client)
 		m.lib = msg.lib
 		m.rebuildCaches()
 
-		return m, tea.Batch(m.loadFavorites(), m.loadQueue())
+		return m, tea.Batch(m.loadFavorites(), m.loadPlaylists(), m.syncPlayer(), waitForEvent(m.events))
+
+	case daemonEvent:
+		if msg.closed {
+			return m, nil
+		}
+		return m, tea.Batch(m.handleEvent(msg.event), waitForEvent(m.events))
 
 	case libraryScanDone:
 		m.scanning = false
+		m.scanProgress = nil
 		if msg.err != nil {
 			m.err = msg.err
 			return m, nil
@@ -180,6 +273,12 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.rebuildCaches()
 		return m, nil
 
+	case scanStarted:
+		if msg.err != nil {
+			m.err = msg.err
+		}
+		return m, nil
+
 	case favoritesLoaded:
 		if msg.err == nil && msg.favs != nil {
 			m.player.SetFavoritePaths(msg.favs)
@@ -187,39 +286,79 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		return m, nil
 
-	case queueLoaded:
-		if msg.err == nil && msg.queue != nil && len(msg.queue.FilePaths) > 0 && len(m.allFiles) > 0 {
-			byPath := make(map[string]metadata.AudioFile, len(m.allFiles))
-			for _, f := range m.allFiles {
-				byPath[f.FilePath] = f
-			}
-			tracks := make([]metadata.AudioFile, 0, len(msg.queue.FilePaths))
-			for _, p := range msg.queue.FilePaths {
-				if t, ok := byPath[p]; ok {
-					tracks = append(tracks, t)
-				}
-			}
-			if len(tracks) > 0 {
-				start := msg.queue.CurrentIndex
-				if start < 0 {
-					start = 0
-				}
-				if start >= len(tracks) {
-					start = len(tracks) - 1
-				}
-				m.player.SetQueue(tracks, start)
-				m.player.SetRepeat(RepeatMode(msg.queue.Repeat))
-				m.player.SetShuffle(msg.queue.Shuffle)
+	case playlistsLoaded:
+		if msg.err == nil {
+			m.setPlaylists(msg.lists)
+		}
+		return m, nil
+
+	case playlistLoaded:
+		if msg.err != nil {
+			m.notice = "⚠ " + msg.err.Error()
+			return m, nil
+		}
+		if msg.play {
+			if len(msg.playlist.Tracks) > 0 {
+				return m, m.player.PlayQueue(msg.playlist.Tracks, 0)
 			}
+			return m, nil
+		}
+		if m.activeView == viewPlaylists {
+			m.songList = msg.playlist.Tracks
+			m.filterLabel = "Playlist: " + msg.playlist.Name
+			m.songCursor = 0
+			m.pushView(viewSongs)
 		}
 		return m, nil
 
-	case tickMsg:
+	case playlistSaved:
+		if msg.err != nil {
+			m.notice = "⚠ " + msg.err.Error()
+		} else {
+			m.notice = fmt.Sprintf("Saved %d tracks to %s", msg.playlist.TrackCount, msg.playlist.Name)
+		}
+		return m, nil
+
+	case picturesLoaded:
+		if msg.err == nil && msg.path == m.detailTrack.FilePath {
+			m.detailPictures = msg.pictures
+			m.detailPicture = 0
+		}
+		return m, nil
+
+	case searchDone:
+		// Results for what the search bar held a few keys ago are stale.
+		if msg.text != m.searchInput.Value() {
+			return m, nil
+		}
+		if msg.err != nil {
+			m.searchErr = msg.err
+			return m, nil
+		}
+		m.searchRes = msg.results
+		if m.searchCursor >= len(m.searchRes) {
+			m.searchCursor = max(0, len(m.searchRes)-1)
+		}
+		return m, nil
+
+	case playerSynced:
+		return m, nil
 
-		if m.player.CheckTrackEnd() {
-			m.persistQueue()
+	case playerResult:
+		m.player.Apply(msg)
+		if msg.err != nil {
+			m.notice = "⚠ " + msg.err.Error()
 		}
-		return m, tickCmd()
+		return m, nil
+
+	case coverLoaded:
+		storeCover(msg)
+		return m, nil
+
+	case tickMsg:
+		// Redraws, so the progress bar moves, and fetches covers the last
+		// frame was missing; the player's state arrives as events.
+		return m, tea.Batch(tickCmd(), fetchCovers(m.client))
 
 	case spinnerTick:
 		if m.scanning {
@@ -229,6 +368,10 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case tea.KeyMsg:
+		m.notice = ""
+		if m.promptActive {
+			return m.updatePrompt(msg)
+		}
 		if m.searchActive {
 			return m.updateSearch(msg)
 		}
@@ -247,49 +390,133 @@ func (m Model) loadFavorites() tea.Cmd {
 		if m.client == nil {
 			return favoritesLoaded{}
 		}
-		favs, err := m.client.GetFavorites()
+		favs, err := m.client.GetFavorites(context.Background())
 		return favoritesLoaded{favs: favs, err: err}
 	}
 }
 
-func (m Model) loadQueue() tea.Cmd {
+func (m Model) loadPlaylists() tea.Cmd {
+	if m.client == nil {
+		return nil
+	}
+	client := m.client
 	return func() tea.Msg {
-		if m.client == nil {
-			return queueLoaded{}
+		lists, err := client.Playlists(context.Background())
+		return playlistsLoaded{lists: lists, err: err}
+	}
+}
+
+// openPlaylist fetches the tracks of the selected playlist, to be shown or,
+// with play set, played.
+func (m Model) openPlaylist(play bool) tea.Cmd {
+	if m.client == nil || m.listCursor >= len(m.playlists) {
+		return nil
+	}
+	client, id := m.client, m.playlists[m.listCursor].ID
+	return func() tea.Msg {
+		p, err := client.GetPlaylist(context.Background(), id)
+		return playlistLoaded{playlist: p, play: play, err: err}
+	}
+}
+
+func (m Model) deletePlaylist() tea.Cmd {
+	if m.client == nil || m.listCursor >= len(m.playlists) {
+		return nil
+	}
+	client, id := m.client, m.playlists[m.listCursor].ID
+	return func() tea.Msg {
+		if err := client.DeletePlaylist(context.Background(), id); err != nil {
+			return playlistsLoaded{err: err}
 		}
-		q, err := m.client.GetQueue()
-		return queueLoaded{queue: q, err: err}
+		lists, err := client.Playlists(context.Background())
+		return playlistsLoaded{lists: lists, err: err}
 	}
 }
 
-func (m *Model) persistQueue() {
+// saveQueue saves the tracks in the queue as a new playlist called name.
+func (m Model) saveQueue(name string) tea.Cmd {
 	if m.client == nil {
-		return
+		return nil
+	}
+	queue := m.player.Queue()
+	paths := make([]string, len(queue))
+	for i, t := range queue {
+		paths[i] = t.FilePath
+	}
+	client := m.client
+	return func() tea.Msg {
+		p, err := client.CreatePlaylist(context.Background(), name, paths)
+		return playlistSaved{playlist: p, err: err}
 	}
-	q := store.QueueState{
-		FilePaths:    []string{},
-		CurrentIndex: 0,
-		Shuffle:      m.player.Shuffle(),
-		Repeat:       int(m.player.Repeat()),
+}
+
+func (m *Model) setPlaylists(lists []daemon.Playlist) {
+	m.playlists = lists
+	if m.listCursor >= len(m.playlists) {
+		m.listCursor = max(0, len(m.playlists)-1)
 	}
-	tracks := m.player.Queue()
-	if len(tracks) > 0 {
-		q.FilePaths = make([]string, 0, len(tracks))
-		for _, t := range tracks {
-			q.FilePaths = append(q.FilePaths, t.FilePath)
+}
+
+// handleEvent applies a change pushed by the daemon, typically made by
+// another client.
+func (m *Model) handleEvent(ev daemon.Event) tea.Cmd {
+	switch ev.Type {
+	case daemon.EventFavoritesChanged:
+		m.player.SetFavoritePaths(ev.Favorites)
+		m.rebuildFavTracks()
+	case daemon.EventPlaylistsChanged:
+		m.setPlaylists(ev.Playlists)
+	case daemon.EventPlayerChanged:
+		m.player.apply(ev.Player)
+	case daemon.EventQueueChanged:
+		return m.syncPlayer()
+	case daemon.EventScanProgress:
+		if ev.Dir == m.musicDir {
+			m.scanProgress = ev.Progress
 		}
-		if idx := m.player.CurrentQueueItemIndex(); idx >= 0 {
-			q.CurrentIndex = idx
+	case daemon.EventScanFinished:
+		if ev.Dir == m.musicDir {
+			m.scanProgress = nil
+			if ev.Job != nil && ev.Job.State == daemon.ScanFailed {
+				m.err = fmt.Errorf("scan: %s", ev.Job.Error)
+			}
+		}
+	case daemon.EventResubscribed:
+		// The daemon may have restarted; anything could have changed.
+		m.scanProgress = nil
+		return tea.Batch(m.loadFavorites(), m.loadPlaylists(), m.syncPlayer(), m.reloadLibrary())
+	case daemon.EventLibraryUpdated:
+		if ev.Dir == m.musicDir {
+			return m.reloadLibrary()
 		}
 	}
+	return nil
+}
 
-	go m.client.SaveQueue(&q)
+func (m Model) reloadLibrary() tea.Cmd {
+	if m.client == nil {
+		return nil
+	}
+	client, dir := m.client, m.musicDir
+	return func() tea.Msg {
+		lib, err := client.GetLibrary(context.Background(), dir)
+		return libraryScanDone{lib: lib, err: err}
+	}
+}
+
+func (m Model) syncPlayer() tea.Cmd {
+	return func() tea.Msg {
+		_ = m.player.Sync()
+		return playerSynced{}
+	}
 }
 
 func (m Model) updateNormal(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch {
 	case matchKey(msg, m.keys.Quit):
-		m.player.Close()
+		if m.unsub != nil {
+			m.unsub()
+		}
 		if m.client != nil {
 			m.client.Close()
 		}
@@ -302,6 +529,7 @@ func (m Model) updateNormal(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		m.searchActive = true
 		m.searchInput.Focus()
 		m.searchRes = nil
+		m.searchErr = nil
 		m.searchCursor = 0
 		m.pushView(viewSearch)
 		return m, textinput.Blink
@@ -330,6 +558,9 @@ func (m Model) updateNormal(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	case matchKey(msg, m.keys.Tab8):
 		m.activeTab = 7
 		m.switchToTab(7)
+	case matchKey(msg, m.keys.Tab9):
+		m.activeTab = 8
+		m.switchToTab(8)
 
 	case matchKey(msg, m.keys.Tab):
 		m.activeTab = (m.activeTab + 1) % len(tabNames)
@@ -344,38 +575,33 @@ func (m Model) updateNormal(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 
 	case matchKey(msg, m.keys.PlayPause):
 		if m.player.HasTrack() {
-			m.player.TogglePause()
-		} else {
-			m.playCurrentSong()
+			return m, m.player.TogglePause()
 		}
+		return m, m.playCurrentSong()
 
 	case matchKey(msg, m.keys.Stop):
-		m.player.Stop()
+		return m, m.player.Stop()
 
 	case matchKey(msg, m.keys.NextTrack):
-		_ = m.player.Next()
-		m.persistQueue()
+		return m, m.player.Next()
 
 	case matchKey(msg, m.keys.PrevTrack):
-		_ = m.player.Previous()
-		m.persistQueue()
+		return m, m.player.Previous()
 
 	case matchKey(msg, m.keys.VolumeUp):
-		m.player.VolumeUp()
+		return m, m.player.VolumeUp()
 
 	case matchKey(msg, m.keys.VolumeDown):
-		m.player.VolumeDown()
+		return m, m.player.VolumeDown()
 
 	case matchKey(msg, m.keys.Mute):
-		m.player.ToggleMute()
+		return m, m.player.ToggleMute()
 
 	case matchKey(msg, m.keys.ShuffleTog):
-		m.player.ToggleShuffle()
-		m.persistQueue()
+		return m, m.player.ToggleShuffle()
 
 	case matchKey(msg, m.keys.RepeatTog):
-		m.player.CycleRepeat()
-		m.persistQueue()
+		return m, m.player.CycleRepeat()
 
 	case matchKey(msg, m.keys.NowPlaying):
 		if m.player.HasTrack() {
@@ -384,16 +610,19 @@ func (m Model) updateNormal(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		}
 
 	case matchKey(msg, m.keys.PlayAll):
-		m.playAllFromCursor()
+		if m.activeView == viewPlaylists {
+			return m, m.openPlaylist(true)
+		}
+		return m, m.playAllFromCursor()
 
 	case matchKey(msg, m.keys.SeekFwd):
 		if m.player.HasTrack() {
-			m.player.SeekForward(5 * time.Second)
+			return m, m.player.SeekForward(5 * time.Second)
 		}
 
 	case matchKey(msg, m.keys.SeekBack):
 		if m.player.HasTrack() {
-			m.player.SeekBackward(5 * time.Second)
+			return m, m.player.SeekBackward(5 * time.Second)
 		}
 
 	case matchKey(msg, m.keys.Favorite):
@@ -403,8 +632,37 @@ func (m Model) updateNormal(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		m.activeTab = 6
 		m.pushView(viewQueue)
 
+	case matchKey(msg, m.keys.SaveQueue):
+		if m.client != nil && m.player.QueueLen() > 0 {
+			m.promptActive = true
+			m.promptInput.SetValue("")
+			m.promptInput.Focus()
+			return m, textinput.Blink
+		}
+
+	case matchKey(msg, m.keys.Delete):
+		if m.activeView == viewPlaylists && m.listCursor < len(m.playlists) {
+			if p := m.playlists[m.listCursor]; p.ReadOnly {
+				m.notice = "⚠ " + p.Name + " is a playlist file: " + p.Path
+				return m, nil
+			}
+			return m, m.deletePlaylist()
+		}
+
 	case matchKey(msg, m.keys.Detail):
-		m.showDetail()
+		if m.showDetail() {
+			return m, m.loadPictures()
+		}
+
+	case matchKey(msg, m.keys.NextPicture):
+		if m.activeView == viewTrackDetail && len(m.detailPictures) > 0 {
+			m.detailPicture = (m.detailPicture + 1) % len(m.detailPictures)
+		}
+
+	case matchKey(msg, m.keys.PrevPicture):
+		if m.activeView == viewTrackDetail && len(m.detailPictures) > 0 {
+			m.detailPicture = (m.detailPicture - 1 + len(m.detailPictures)) % len(m.detailPictures)
+		}
 
 	case matchKey(msg, m.keys.Home):
 		m.setCursor(0)
@@ -428,21 +686,20 @@ func (m Model) updateNormal(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		m.moveCursor(10)
 
 	case matchKey(msg, m.keys.Enter):
-		m.handleEnter()
+		if m.activeView == viewPlaylists {
+			return m, m.openPlaylist(false)
+		}
+		return m, m.handleEnter()
 
 	case matchKey(msg, m.keys.Refresh):
-		if m.lib != nil {
-			m.scanning = true
-			return m, tea.Batch(
-				func() tea.Msg {
-					if m.client != nil {
-						lib, err := m.client.Scan(m.musicDir)
-						return libraryScanDone{lib: lib, err: err}
-					}
-					return libraryScanDone{err: fmt.Errorf("no daemon connection")}
-				},
-				spinnerCmd(),
-			)
+		// The daemon rescans in the background and the library keeps
+		// working; progress shows in the status bar.
+		if m.lib != nil && m.client != nil {
+			client, dir := m.client, m.musicDir
+			return m, func() tea.Msg {
+				_, err := client.Scan(context.Background(), dir)
+				return scanStarted{err: err}
+			}
 		}
 	}
 
@@ -459,11 +716,9 @@ func (m *Model) updateSearch(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 
 	case matchKey(msg, m.keys.Enter):
 		if len(m.searchRes) > 0 && m.searchCursor < len(m.searchRes) {
-			m.player.SetQueue(m.searchRes, m.searchCursor)
-			_ = m.player.PlayCurrent()
-			m.persistQueue()
 			m.searchActive = false
 			m.searchInput.Blur()
+			return m, m.player.PlayQueue(m.searchRes, m.searchCursor)
 		}
 		return m, nil
 
@@ -483,31 +738,61 @@ func (m *Model) updateSearch(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 	m.searchInput, cmd = m.searchInput.Update(msg)
 
-	if m.searchInput.Value() != "" && len(m.allFiles) > 0 {
-		m.searchRes = searchFiles(m.allFiles, m.searchInput.Value())
-		if m.searchCursor >= len(m.searchRes) {
-			m.searchCursor = max(0, len(m.searchRes)-1)
-		}
-	} else {
+	m.searchErr = nil
+	text := m.searchInput.Value()
+	if text == "" || len(m.allFiles) == 0 {
 		m.searchRes = nil
 		m.searchCursor = 0
+		return m, cmd
 	}
+	// A query that does not parse, often one still being typed, keeps the
+	// last results on screen under the error.
+	q, err := query.Parse(text)
+	if err != nil {
+		m.searchErr = err
+		return m, cmd
+	}
+	return m, tea.Batch(cmd, m.search(text, q))
+}
 
-	return m, cmd
+// search looks text up in the daemon's search index, which also matches
+// prefixes and typos and ranks the results. Without a daemon the library is
+// filtered for q here instead.
+func (m Model) search(text string, q *query.Query) tea.Cmd {
+	client, dir, files := m.client, m.musicDir, m.allFiles
+	return func() tea.Msg {
+		if client != nil {
+			results, _, err := client.Search(context.Background(), dir, text, 0, searchLimit)
+			if !daemon.IsConnError(err) {
+				return searchDone{text: text, results: results, err: err}
+			}
+		}
+		return searchDone{text: text, results: q.Filter(files)}
+	}
 }
 
-func searchFiles(files []metadata.AudioFile, query string) []metadata.AudioFile {
-	q := strings.ToLower(query)
-	var results []metadata.AudioFile
-	for _, f := range files {
-		if strings.Contains(strings.ToLower(f.Title), q) ||
-			strings.Contains(strings.ToLower(f.Artist), q) ||
-			strings.Contains(strings.ToLower(f.Album), q) ||
-			strings.Contains(strings.ToLower(f.Genre), q) {
-			results = append(results, f)
+// updatePrompt handles keys while a playlist name is typed; enter saves the
+// queue under that name.
+func (m Model) updatePrompt(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case matchKey(msg, m.keys.Escape):
+		m.promptActive = false
+		m.promptInput.Blur()
+		return m, nil
+
+	case matchKey(msg, m.keys.Enter):
+		name := strings.TrimSpace(m.promptInput.Value())
+		if name == "" {
+			return m, nil
 		}
+		m.promptActive = false
+		m.promptInput.Blur()
+		return m, m.saveQueue(name)
 	}
-	return results
+
+	var cmd tea.Cmd
+	m.promptInput, cmd = m.promptInput.Update(msg)
+	return m, cmd
 }
 
 func (m Model) View() string {
@@ -566,7 +851,7 @@ func (m Model) View() string {
 	case viewSongs:
 		content = renderSongList(m.songList, m.songCursor, m.filterLabel, m.width, innerContentHeight, currentPath, m.player)
 	case viewTrackDetail:
-		content = renderTrackDetail(m.detailTrack, m.player, m.width, innerContentHeight)
+		content = renderTrackDetail(m.detailTrack, m.detailPictures, m.detailPicture, m.player, m.width, innerContentHeight)
 	case viewNowPlaying:
 
 		content = renderNowPlaying(m.player, m.width, innerContentHeight)
@@ -574,10 +859,12 @@ func (m Model) View() string {
 		content = renderQueue(m.player, m.queueCursor, m.width, innerContentHeight)
 	case viewFavorites:
 		content = renderFavorites(m.favTracks, m.favCursor, m.width, innerContentHeight, currentPath)
+	case viewPlaylists:
+		content = renderPlaylists(m.playlists, m.listCursor, m.width, innerContentHeight)
 	case viewSearch:
 		searchBar := m.searchInput.View()
 		if len(m.searchRes) > 0 || m.searchInput.Value() != "" {
-			content = searchBar + "\n\n" + renderSearchResults(m.searchRes, m.searchCursor, m.searchInput.Value(), m.width, innerContentHeight-3, currentPath, m.player)
+			content = searchBar + "\n\n" + renderSearchResults(m.searchRes, m.searchCursor, m.searchInput.Value(), m.searchErr, m.width, innerContentHeight-3, currentPath, m.player)
 		} else {
 			content = searchBar + "\n\n" + DimStyle.Render("  Type to search your library…")
 		}
@@ -589,6 +876,9 @@ func (m Model) View() string {
 		Render(content)
 
 	statusLeft := m.statusLine()
+	if m.promptActive {
+		statusLeft = " Save queue as: " + m.promptInput.View()
+	}
 	statusRight := DimStyle.Render("? help  space play  / search  q quit")
 
 	wLeft := lipgloss.Width(statusLeft)
@@ -611,30 +901,25 @@ func (m Model) View() string {
 	return lipgloss.JoinVertical(lipgloss.Left, parts...)
 }
 
-func (m *Model) playCurrentSong() {
+func (m *Model) playCurrentSong() tea.Cmd {
 	if m.activeView == viewSongs && m.songCursor < len(m.songList) {
-		m.player.SetQueue(m.songList, m.songCursor)
-		_ = m.player.PlayCurrent()
-		m.persistQueue()
+		return m.player.PlayQueue(m.songList, m.songCursor)
 	}
+	return nil
 }
 
-func (m *Model) playAllFromCursor() {
+func (m *Model) playAllFromCursor() tea.Cmd {
 	switch m.activeView {
 	case viewSongs:
 		if len(m.songList) > 0 {
-			m.player.SetQueue(m.songList, m.songCursor)
-			_ = m.player.PlayCurrent()
-			m.persistQueue()
+			return m.player.PlayQueue(m.songList, m.songCursor)
 		}
 	case viewArtists:
 		if m.artistCursor < len(m.artistList) {
 			entry := m.artistList[m.artistCursor]
 			tracks := m.artistTracks(entry.name)
 			if len(tracks) > 0 {
-				m.player.SetQueue(tracks, 0)
-				_ = m.player.PlayCurrent()
-				m.persistQueue()
+				return m.player.PlayQueue(tracks, 0)
 			}
 		}
 	case viewAlbums:
@@ -642,9 +927,7 @@ func (m *Model) playAllFromCursor() {
 			entry := m.albumList[m.albumCursor]
 			tracks := m.albumTracks(entry.name)
 			if len(tracks) > 0 {
-				m.player.SetQueue(tracks, 0)
-				_ = m.player.PlayCurrent()
-				m.persistQueue()
+				return m.player.PlayQueue(tracks, 0)
 			}
 		}
 	case viewGenres:
@@ -652,24 +935,19 @@ func (m *Model) playAllFromCursor() {
 			entry := m.genreList[m.genreCursor]
 			tracks := m.genreTracks(entry.name)
 			if len(tracks) > 0 {
-				m.player.SetQueue(tracks, 0)
-				_ = m.player.PlayCurrent()
-				m.persistQueue()
+				return m.player.PlayQueue(tracks, 0)
 			}
 		}
 	case viewDashboard:
 		if len(m.allFiles) > 0 {
-			m.player.SetQueue(m.allFiles, 0)
-			_ = m.player.PlayCurrent()
-			m.persistQueue()
+			return m.player.PlayQueue(m.allFiles, 0)
 		}
 	case viewFavorites:
 		if len(m.favTracks) > 0 {
-			m.player.SetQueue(m.favTracks, m.favCursor)
-			_ = m.player.PlayCurrent()
-			m.persistQueue()
+			return m.player.PlayQueue(m.favTracks, m.favCursor)
 		}
 	}
+	return nil
 }
 
 func (m *Model) handleFavorite() {
@@ -709,9 +987,9 @@ func (m *Model) handleFavorite() {
 
 	if m.client != nil {
 		if m.player.IsFavorite(filePath) {
-			go m.client.AddFavorite(filePath)
+			go m.client.AddFavorite(context.Background(), filePath)
 		} else {
-			go m.client.RemoveFavorite(filePath)
+			go m.client.RemoveFavorite(context.Background(), filePath)
 		}
 	}
 
@@ -720,30 +998,48 @@ func (m *Model) handleFavorite() {
 	}
 }
 
-func (m *Model) showDetail() {
+// showDetail opens the detail view for the selected track and reports
+// whether it did.
+func (m *Model) showDetail() bool {
+	var track *metadata.AudioFile
 	switch m.activeView {
 	case viewSongs:
 		if m.songCursor < len(m.songList) {
-			m.detailTrack = m.songList[m.songCursor]
-			m.pushView(viewTrackDetail)
+			track = &m.songList[m.songCursor]
 		}
 	case viewSearch:
 		if m.searchCursor < len(m.searchRes) {
-			m.detailTrack = m.searchRes[m.searchCursor]
-			m.pushView(viewTrackDetail)
+			track = &m.searchRes[m.searchCursor]
 		}
 	case viewNowPlaying:
-		if track := m.player.CurrentTrack(); track != nil {
-			m.detailTrack = *track
-			m.pushView(viewTrackDetail)
-		}
+		track = m.player.CurrentTrack()
 	case viewQueue:
 		queue := m.player.Queue()
 		if m.queueCursor < len(queue) {
-			m.detailTrack = queue[m.queueCursor]
-			m.pushView(viewTrackDetail)
+			track = &queue[m.queueCursor]
 		}
 	}
+	if track == nil {
+		return false
+	}
+
+	m.detailTrack = *track
+	m.detailPictures = nil
+	m.detailPicture = 0
+	m.pushView(viewTrackDetail)
+	return true
+}
+
+// loadPictures asks the daemon for the detail track's embedded pictures.
+func (m Model) loadPictures() tea.Cmd {
+	if m.client == nil {
+		return nil
+	}
+	client, path := m.client, m.detailTrack.FilePath
+	return func() tea.Msg {
+		pictures, err := client.GetPictures(context.Background(), path)
+		return picturesLoaded{path: path, pictures: pictures, err: err}
+	}
 }
 
 func (m *Model) rebuildCaches() {
@@ -752,6 +1048,7 @@ func (m *Model) rebuildCaches() {
 	}
 
 	m.allFiles = m.lib.Files
+	m.player.SetLibrary(m.allFiles)
 	m.artistList = mapToSortedEntries(m.lib.Artists)
 	m.albumList = mapToSortedEntries(m.lib.Albums)
 	m.genreList = mapToSortedEntries(m.lib.Genres)
@@ -835,6 +1132,9 @@ func (m *Model) switchToTab(tab int) {
 	case 7:
 		m.activeView = viewFavorites
 		m.favCursor = 0
+	case 8:
+		m.activeView = viewPlaylists
+		m.listCursor = 0
 	}
 }
 
@@ -850,7 +1150,7 @@ func (m *Model) goBack() {
 	}
 }
 
-func (m *Model) handleEnter() {
+func (m *Model) handleEnter() tea.Cmd {
 	switch m.activeView {
 	case viewArtists:
 		if m.artistCursor < len(m.artistList) {
@@ -878,31 +1178,24 @@ func (m *Model) handleEnter() {
 		}
 	case viewSongs:
 		if m.songCursor < len(m.songList) {
-			m.player.SetQueue(m.songList, m.songCursor)
-			_ = m.player.PlayCurrent()
-			m.persistQueue()
+			return m.player.PlayQueue(m.songList, m.songCursor)
 		}
 	case viewSearch:
 		if m.searchCursor < len(m.searchRes) {
-			m.player.SetQueue(m.searchRes, m.searchCursor)
-			_ = m.player.PlayCurrent()
-			m.persistQueue()
 			m.searchActive = false
 			m.searchInput.Blur()
+			return m.player.PlayQueue(m.searchRes, m.searchCursor)
 		}
 	case viewQueue:
 		if m.queueCursor < len(m.player.Queue()) {
-			m.player.SetQueue(m.player.Queue(), m.queueCursor)
-			_ = m.player.PlayCurrent()
-			m.persistQueue()
+			return m.player.PlayQueue(m.player.Queue(), m.queueCursor)
 		}
 	case viewFavorites:
 		if m.favCursor < len(m.favTracks) {
-			m.player.SetQueue(m.favTracks, m.favCursor)
-			_ = m.player.PlayCurrent()
-			m.persistQueue()
+			return m.player.PlayQueue(m.favTracks, m.favCursor)
 		}
 	}
+	return nil
 }
 
 func (m *Model) moveCursor(delta int) {
@@ -937,6 +1230,8 @@ func (m *Model) currentCursor() int {
 		return m.queueCursor
 	case viewFavorites:
 		return m.favCursor
+	case viewPlaylists:
+		return m.listCursor
 	}
 	return 0
 }
@@ -957,6 +1252,8 @@ func (m *Model) currentListLen() int {
 		return m.player.QueueLen()
 	case viewFavorites:
 		return len(m.favTracks)
+	case viewPlaylists:
+		return len(m.playlists)
 	}
 	return 0
 }
@@ -980,6 +1277,8 @@ func (m *Model) setCursor(v int) {
 		m.queueCursor = v
 	case viewFavorites:
 		m.favCursor = v
+	case viewPlaylists:
+		m.listCursor = v
 	}
 }
 
@@ -994,6 +1293,18 @@ func (m Model) statusLine() string {
 		len(m.lib.Albums),
 	)
 
+	if p := m.scanProgress; p != nil {
+		status += fmt.Sprintf("  │  ⟳ scanning %d/%d", p.Current, p.Total)
+	}
+
+	if m.client != nil && m.client.State() == daemon.StateReconnecting {
+		status += "  │  ⚠ daemon reconnecting"
+	}
+
+	if m.notice != "" {
+		status += "  │  " + m.notice
+	}
+
 	if m.player.HasTrack() {
 		track := m.player.CurrentTrack()
 		state := "▮▮"
@@ -2,83 +2,82 @@ package tui
 
 import (
 	"bytes"
-	"encoding/base64"
+	"context"
 	"fmt"
 	"image"
 	_ "image/jpeg"
 	_ "image/png"
 	"strings"
 	"sync"
+	"time"
 
+	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/hoppxi/bpv/internal/artwork"
+	"github.com/hoppxi/bpv/internal/daemon"
 )
 
+// Covers come from the daemon's cover-art action. Rendering never waits for
+// one: a cover not fetched yet shows the placeholder and is asked for on the
+// next tick, and shows up once it arrives.
 var (
-	coverMu    sync.Mutex
+	coverMu sync.Mutex
+	// coverCache holds covers rendered at a given cell size.
 	coverCache = make(map[string]string)
+	// coverImages holds fetched covers by ID and size, coverPending the ones
+	// being fetched and coverWanted the ones to fetch next.
+	coverImages  = make(map[string]image.Image)
+	coverPending = make(map[string]bool)
+	coverWanted  = make(map[string]coverRequest)
+	// coverFailed records when fetching a cover last failed, so it is tried
+	// again later rather than on every tick.
+	coverFailed = make(map[string]time.Time)
 )
 
-func coverCacheKey(b64 string, w, h int) string {
-	l := len(b64)
-	if l == 0 {
-		return ""
-	}
-
-	// Create a robust key using length + start + mid + end segments.
-	// Just using the prefix is insufficient because many images share the same
-	// file headers (magic bytes).
-	start := 50
-	if start > l {
-		start = l
-	}
+// coverRetry is how long a cover that could not be fetched shows the
+// placeholder before it is tried again.
+const coverRetry = 10 * time.Second
 
-	end := 50
-	if l < 50 {
-		end = 0
-	}
-
-	midStart := l / 2
-	midEnd := midStart + 50
-	if midEnd > l {
-		midEnd = l
-	}
+type coverRequest struct {
+	id   string
+	size int
+}
 
-	prefix := b64[:start]
-	suffix := ""
-	if end > 0 {
-		suffix = b64[l-end:]
-	}
-	mid := b64[midStart:midEnd]
+func (r coverRequest) key() string {
+	return fmt.Sprintf("%s:%d", r.id, r.size)
+}
 
-	return fmt.Sprintf("%d:%s:%s:%s:%d:%d", l, prefix, mid, suffix, w, h)
+// coverLoaded carries a cover fetched from the daemon.
+type coverLoaded struct {
+	req coverRequest
+	img image.Image
+	err error
 }
 
-func renderCoverArt(coverB64 string, targetWidth, targetHeight int) string {
-	if coverB64 == "" || targetWidth <= 0 || targetHeight <= 0 {
+func renderCoverArt(coverID string, targetWidth, targetHeight int) string {
+	if coverID == "" || targetWidth <= 0 || targetHeight <= 0 {
 		return renderPlaceholderArt(targetWidth, targetHeight)
 	}
 
-	key := coverCacheKey(coverB64, targetWidth, targetHeight)
+	key := fmt.Sprintf("%s:%d:%d", coverID, targetWidth, targetHeight)
 	coverMu.Lock()
+	defer coverMu.Unlock()
 	if cached, ok := coverCache[key]; ok {
-		coverMu.Unlock()
 		return cached
 	}
-	coverMu.Unlock()
 
-	data, err := base64.StdEncoding.DecodeString(coverB64)
-	if err != nil {
-		return renderPlaceholderArt(targetWidth, targetHeight)
-	}
-
-	img, _, err := image.Decode(bytes.NewReader(data))
-	if err != nil {
+	// Each cell shows two pixels stacked, so the grid is targetWidth by
+	// targetHeight*2 pixels; ask for the smallest size that covers it.
+	req := coverRequest{id: coverID, size: artwork.Fit(max(targetWidth, targetHeight*2))}
+	img, ok := coverImages[req.key()]
+	if !ok {
+		if !coverPending[req.key()] && time.Since(coverFailed[req.key()]) > coverRetry {
+			coverWanted[req.key()] = req
+		}
 		return renderPlaceholderArt(targetWidth, targetHeight)
 	}
 
-	result := renderImageToBlocks(img, targetWidth, targetHeight)
-
-	coverMu.Lock()
+	result := renderImageToBlocks(artwork.Scale(img, targetWidth, targetHeight*2), targetWidth, targetHeight)
 	// Prevent unbounded growth
 	if len(coverCache) > 50 {
 		// Simple eviction: clear map.
@@ -87,11 +86,58 @@ func renderCoverArt(coverB64 string, targetWidth, targetHeight int) string {
 		coverCache = make(map[string]string)
 	}
 	coverCache[key] = result
-	coverMu.Unlock()
-
 	return result
 }
 
+// fetchCovers asks the daemon for the covers rendered with the placeholder
+// since the last call.
+func fetchCovers(c *daemon.Client) tea.Cmd {
+	if c == nil {
+		return nil
+	}
+	coverMu.Lock()
+	defer coverMu.Unlock()
+
+	var cmds []tea.Cmd
+	for key, req := range coverWanted {
+		delete(coverWanted, key)
+		coverPending[key] = true
+		cmds = append(cmds, func() tea.Msg {
+			ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+			defer cancel()
+			cover, err := c.GetCoverArtByID(ctx, req.id, req.size)
+			if err != nil {
+				return coverLoaded{req: req, err: err}
+			}
+			if cover == nil {
+				return coverLoaded{req: req, err: fmt.Errorf("no cover %s", req.id)}
+			}
+			img, _, err := image.Decode(bytes.NewReader(cover.Data))
+			return coverLoaded{req: req, img: img, err: err}
+		})
+	}
+	return tea.Batch(cmds...)
+}
+
+// storeCover keeps a fetched cover for renderCoverArt. A failure is only
+// remembered for coverRetry.
+func storeCover(msg coverLoaded) {
+	coverMu.Lock()
+	defer coverMu.Unlock()
+
+	key := msg.req.key()
+	delete(coverPending, key)
+	if msg.err != nil {
+		coverFailed[key] = time.Now()
+		return
+	}
+	delete(coverFailed, key)
+	if len(coverImages) > 50 {
+		coverImages = make(map[string]image.Image)
+	}
+	coverImages[key] = msg.img
+}
+
 func renderImageToBlocks(img image.Image, targetWidth, targetHeight int) string {
 	pixelRows := targetHeight * 2
 	pixelCols := targetWidth
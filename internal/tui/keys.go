@@ -39,6 +39,14 @@ type KeyMap struct {
 	Queue    key.Binding
 	Detail   key.Binding
 
+	// Playlists
+	SaveQueue key.Binding
+	Delete    key.Binding
+
+	// Track detail
+	NextPicture key.Binding
+	PrevPicture key.Binding
+
 	// Direct tabs
 	Tab1 key.Binding
 	Tab2 key.Binding
@@ -48,6 +56,7 @@ type KeyMap struct {
 	Tab6 key.Binding
 	Tab7 key.Binding
 	Tab8 key.Binding
+	Tab9 key.Binding
 }
 
 func DefaultKeyMap() KeyMap {
@@ -181,6 +190,26 @@ func DefaultKeyMap() KeyMap {
 			key.WithHelp("d", "track details"),
 		),
 
+		// Playlists
+		SaveQueue: key.NewBinding(
+			key.WithKeys("w"),
+			key.WithHelp("w", "save queue as playlist"),
+		),
+		Delete: key.NewBinding(
+			key.WithKeys("delete"),
+			key.WithHelp("del", "delete playlist"),
+		),
+
+		// Track detail
+		NextPicture: key.NewBinding(
+			key.WithKeys("]"),
+			key.WithHelp("]", "next picture"),
+		),
+		PrevPicture: key.NewBinding(
+			key.WithKeys("["),
+			key.WithHelp("[", "prev picture"),
+		),
+
 		// Direct tab access
 		Tab1: key.NewBinding(key.WithKeys("1"), key.WithHelp("1", "dashboard")),
 		Tab2: key.NewBinding(key.WithKeys("2"), key.WithHelp("2", "artists")),
@@ -190,6 +219,7 @@ func DefaultKeyMap() KeyMap {
 		Tab6: key.NewBinding(key.WithKeys("6"), key.WithHelp("6", "now playing")),
 		Tab7: key.NewBinding(key.WithKeys("7"), key.WithHelp("7", "queue")),
 		Tab8: key.NewBinding(key.WithKeys("8"), key.WithHelp("8", "favorites")),
+		Tab9: key.NewBinding(key.WithKeys("9"), key.WithHelp("9", "playlists")),
 	}
 }
 
@@ -205,8 +235,8 @@ func (k KeyMap) FullHelp() [][]key.Binding {
 		{k.VolumeUp, k.VolumeDown, k.Mute},
 		{k.SeekFwd, k.SeekBack},
 		{k.ShuffleTog, k.RepeatTog, k.PlayAll, k.NowPlaying},
-		{k.Favorite, k.Queue, k.Detail},
+		{k.Favorite, k.Queue, k.Detail, k.PrevPicture, k.NextPicture, k.SaveQueue, k.Delete},
 		{k.Search, k.Refresh, k.Help, k.Quit},
-		{k.Tab1, k.Tab2, k.Tab3, k.Tab4, k.Tab5, k.Tab6, k.Tab7, k.Tab8},
+		{k.Tab1, k.Tab2, k.Tab3, k.Tab4, k.Tab5, k.Tab6, k.Tab7, k.Tab8, k.Tab9},
 	}
 }
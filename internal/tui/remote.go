@@ -0,0 +1,387 @@
+package tui
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/hoppxi/bpv/internal/daemon"
+	"github.com/hoppxi/bpv/internal/metadata"
+	"github.com/hoppxi/bpv/internal/playback"
+	"github.com/hoppxi/bpv/internal/store"
+)
+
+// Remote controls the player that runs inside bpvd. It keeps the last status
+// reported by the daemon so views can render without a round trip, and
+// tracks favorites locally for quick lookups while rendering lists.
+type Remote struct {
+	mu sync.Mutex
+
+	client *daemon.Client
+	status playback.Status
+	synced time.Time
+
+	queue        []metadata.AudioFile
+	queueVersion int
+	byPath       map[string]metadata.AudioFile
+
+	favorites map[string]bool
+}
+
+// callTimeout bounds each player call so a busy daemon cannot freeze the UI.
+const callTimeout = 3 * time.Second
+
+func NewRemote() *Remote {
+	return &Remote{
+		status:       playback.Status{State: "stopped", QueueIndex: -1},
+		queueVersion: -1,
+		favorites:    make(map[string]bool),
+	}
+}
+
+func (r *Remote) SetClient(c *daemon.Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.client = c
+}
+
+// SetLibrary indexes the library files so queue paths reported by the daemon
+// can be mapped back to full track metadata.
+func (r *Remote) SetLibrary(files []metadata.AudioFile) {
+	byPath := make(map[string]metadata.AudioFile, len(files))
+	for _, f := range files {
+		byPath[f.FilePath] = f
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byPath = byPath
+}
+
+// Sync fetches the daemon's player status, and the queue when it has changed
+// since the last sync. It is called when connecting and when the daemon
+// reports a new queue; other changes arrive as player-changed events.
+func (r *Remote) Sync() error {
+	r.mu.Lock()
+	c := r.client
+	r.mu.Unlock()
+	if c == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+
+	st, err := c.PlayerStatus(ctx)
+	if err != nil {
+		return err
+	}
+	r.apply(st)
+
+	r.mu.Lock()
+	stale := st.QueueVersion != r.queueVersion
+	r.mu.Unlock()
+	if !stale {
+		return nil
+	}
+
+	q, err := c.GetQueue(ctx)
+	if err != nil {
+		return err
+	}
+	r.setQueue(q, st.QueueVersion)
+	return nil
+}
+
+func (r *Remote) apply(st *playback.Status) {
+	if st == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = *st
+	r.synced = time.Now()
+}
+
+func (r *Remote) setQueue(q *store.QueueState, version int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tracks := make([]metadata.AudioFile, 0, len(q.FilePaths))
+	for _, p := range q.FilePaths {
+		if f, ok := r.byPath[p]; ok {
+			tracks = append(tracks, f)
+			continue
+		}
+		name := filepath.Base(p)
+		tracks = append(tracks, metadata.AudioFile{
+			FilePath: p,
+			FileName: name,
+			Title:    strings.TrimSuffix(name, filepath.Ext(name)),
+		})
+	}
+	r.queue = tracks
+	r.queueVersion = version
+}
+
+// playerResult is what a player command sent by the Remote came back with.
+// queue is set when the command replaced the queue.
+type playerResult struct {
+	status *playback.Status
+	queue  *store.QueueState
+	err    error
+}
+
+// command returns a tea.Cmd that runs a playback command against the
+// daemon, so a slow daemon never holds up key handling. Its result arrives
+// as a playerResult for Update to pass to Apply.
+func (r *Remote) command(call func(c *daemon.Client, ctx context.Context) (*playback.Status, error)) tea.Cmd {
+	r.mu.Lock()
+	c := r.client
+	r.mu.Unlock()
+	if c == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+		defer cancel()
+		st, err := call(c, ctx)
+		return playerResult{status: st, err: err}
+	}
+}
+
+// Apply records the status and queue a player command came back with.
+func (r *Remote) Apply(res playerResult) {
+	r.apply(res.status)
+	if res.queue != nil && res.status != nil {
+		r.setQueue(res.queue, res.status.QueueVersion)
+	}
+}
+
+// ─── Playback ───────────────────────────────────────────────────────────────
+
+// PlayQueue replaces the daemon's queue with tracks and starts playing at
+// startIndex. The daemon leaves out files it cannot read, so the queue is
+// taken from its reply rather than from tracks.
+func (r *Remote) PlayQueue(tracks []metadata.AudioFile, startIndex int) tea.Cmd {
+	r.mu.Lock()
+	c := r.client
+	r.mu.Unlock()
+	if c == nil {
+		return nil
+	}
+
+	q := &store.QueueState{
+		FilePaths:    make([]string, 0, len(tracks)),
+		CurrentIndex: startIndex,
+	}
+	for _, t := range tracks {
+		q.FilePaths = append(q.FilePaths, t.FilePath)
+	}
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+		defer cancel()
+		st, queue, err := c.PlayQueue(ctx, q)
+		return playerResult{status: st, queue: queue, err: err}
+	}
+}
+
+func (r *Remote) TogglePause() tea.Cmd {
+	return r.command((*daemon.Client).TogglePause)
+}
+
+func (r *Remote) Stop() tea.Cmd {
+	return r.command((*daemon.Client).Stop)
+}
+
+func (r *Remote) Next() tea.Cmd {
+	return r.command((*daemon.Client).Next)
+}
+
+func (r *Remote) Previous() tea.Cmd {
+	return r.command((*daemon.Client).Previous)
+}
+
+func (r *Remote) VolumeUp() tea.Cmd {
+	return r.command(func(c *daemon.Client, ctx context.Context) (*playback.Status, error) { return c.SetVolume(ctx, "+5") })
+}
+
+func (r *Remote) VolumeDown() tea.Cmd {
+	return r.command(func(c *daemon.Client, ctx context.Context) (*playback.Status, error) { return c.SetVolume(ctx, "-5") })
+}
+
+func (r *Remote) ToggleMute() tea.Cmd {
+	return r.command((*daemon.Client).ToggleMute)
+}
+
+func (r *Remote) SeekForward(d time.Duration) tea.Cmd {
+	return r.command(func(c *daemon.Client, ctx context.Context) (*playback.Status, error) {
+		return c.Seek(ctx, "+"+formatSeconds(d))
+	})
+}
+
+func (r *Remote) SeekBackward(d time.Duration) tea.Cmd {
+	return r.command(func(c *daemon.Client, ctx context.Context) (*playback.Status, error) {
+		return c.Seek(ctx, "-"+formatSeconds(d))
+	})
+}
+
+func (r *Remote) ToggleShuffle() tea.Cmd {
+	return r.command(func(c *daemon.Client, ctx context.Context) (*playback.Status, error) { return c.SetShuffle(ctx, "") })
+}
+
+func (r *Remote) CycleRepeat() tea.Cmd {
+	return r.command(func(c *daemon.Client, ctx context.Context) (*playback.Status, error) { return c.SetRepeat(ctx, "") })
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+// ─── Favorites ──────────────────────────────────────────────────────────────
+
+func (r *Remote) ToggleFavorite(filePath string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.favorites[filePath] {
+		delete(r.favorites, filePath)
+	} else {
+		r.favorites[filePath] = true
+	}
+}
+
+func (r *Remote) IsFavorite(filePath string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.favorites[filePath]
+}
+
+func (r *Remote) FavoritePaths() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]string, 0, len(r.favorites))
+	for k := range r.favorites {
+		out = append(out, k)
+	}
+	return out
+}
+
+// SetFavoritePaths bulk-sets the favorites from daemon-loaded data.
+func (r *Remote) SetFavoritePaths(paths []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.favorites = make(map[string]bool, len(paths))
+	for _, path := range paths {
+		r.favorites[path] = true
+	}
+}
+
+// ─── State Getters ──────────────────────────────────────────────────────────
+
+// Position extrapolates from the last reported position while playing so the
+// progress bar moves smoothly between updates from the daemon.
+func (r *Remote) Position() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pos := r.status.Position
+	if r.status.State == "playing" {
+		pos += time.Since(r.synced)
+	}
+	if r.status.Duration > 0 && pos > r.status.Duration {
+		pos = r.status.Duration
+	}
+	return pos
+}
+
+func (r *Remote) Duration() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.Duration
+}
+
+func (r *Remote) Progress() float64 {
+	dur := r.Duration()
+	if dur == 0 {
+		return 0
+	}
+	prog := float64(r.Position()) / float64(dur)
+	if prog > 1 {
+		prog = 1
+	}
+	if prog < 0 {
+		prog = 0
+	}
+	return prog
+}
+
+func (r *Remote) IsPlaying() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.State == "playing"
+}
+
+func (r *Remote) IsPaused() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.State == "paused"
+}
+
+func (r *Remote) HasTrack() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.Track != nil && r.status.State != "stopped"
+}
+
+func (r *Remote) CurrentTrack() *metadata.AudioFile {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.Track
+}
+
+func (r *Remote) IsMuted() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.Muted
+}
+
+func (r *Remote) VolumePercent() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.VolumePct
+}
+
+func (r *Remote) Shuffle() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.Shuffle
+}
+
+func (r *Remote) Repeat() playback.RepeatMode {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.Repeat
+}
+
+func (r *Remote) Queue() []metadata.AudioFile {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]metadata.AudioFile, len(r.queue))
+	copy(out, r.queue)
+	return out
+}
+
+func (r *Remote) QueueLen() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.queue)
+}
+
+// QueueIndex returns the index into Queue() of the current item, or -1.
+func (r *Remote) QueueIndex() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status.QueueIndex
+}
@@ -3,13 +3,16 @@ package tui
 import (
 	"fmt"
 	"math"
+	"path/filepath"
 	"sort"
 	"strings"
 	"time"
 
 	"github.com/charmbracelet/lipgloss"
 	"github.com/hoppxi/bpv/internal/cache"
+	"github.com/hoppxi/bpv/internal/daemon"
 	"github.com/hoppxi/bpv/internal/metadata"
+	"github.com/hoppxi/bpv/internal/playback"
 )
 
 type listEntry struct {
@@ -217,7 +220,7 @@ func renderCategoryList(items []listEntry, cursor int, title string, width, heig
 
 // ─── Song List ──────────────────────────────────────────────────────────────
 
-func renderSongList(songs []metadata.AudioFile, cursor int, title string, width, height int, currentTrackPath string, player *Player) string {
+func renderSongList(songs []metadata.AudioFile, cursor int, title string, width, height int, currentTrackPath string, player *Remote) string {
 	header := SubHeaderStyle.Render(fmt.Sprintf("%s  (%d tracks)", title, len(songs)))
 
 	numW := 5
@@ -304,7 +307,7 @@ func renderSongList(songs []metadata.AudioFile, cursor int, title string, width,
 	)
 }
 
-func renderTrackDetail(track metadata.AudioFile, player *Player, width, height int) string {
+func renderTrackDetail(track metadata.AudioFile, pictures []metadata.Picture, picture int, player *Remote, width, height int) string {
 	isFav := player != nil && player.IsFavorite(track.FilePath)
 	favIcon := FavHeartEmptyStyle.Render("♡")
 	if isFav {
@@ -365,22 +368,53 @@ func renderTrackDetail(track metadata.AudioFile, player *Player, width, height i
 	rows = append(rows, "")
 	rows = append(rows, metaRow("File", DimStyle.Render(truncate(track.FileName, width-20))))
 	rows = append(rows, metaRow("Size", DimStyle.Render(formatBytes(track.FileSize))))
+	switch track.CoverSource {
+	case metadata.CoverEmbedded:
+		rows = append(rows, metaRow("Cover", DimStyle.Render("embedded")))
+	case metadata.CoverFolder:
+		rows = append(rows, metaRow("Cover", DimStyle.Render(truncate(filepath.Base(track.CoverFile), width-20))))
+	}
 
 	if track.Comment != "" {
 		rows = append(rows, "")
 		rows = append(rows, metaRow("Comment", truncate(track.Comment, width-20)))
 	}
 
-	hint := DimStyle.Render("  Press 'f' to toggle ♥  •  ↵ to play  •  esc to go back")
+	hint := "  Press 'f' to toggle ♥  •  ↵ to play  •  esc to go back"
+	if len(pictures) > 1 {
+		hint += "  •  [ ] pictures"
+	}
+
+	body := strings.Join(rows, "\n")
+	if len(pictures) > 0 && width >= 80 {
+		body = lipgloss.JoinHorizontal(lipgloss.Top,
+			lipgloss.NewStyle().Width(width-40).Render(body),
+			renderPicture(pictures, picture, 32, min(16, height-8)),
+		)
+	}
 
 	return lipgloss.JoinVertical(lipgloss.Left,
-		title, "", strings.Join(rows, "\n"), "", hint,
+		title, "", body, "", DimStyle.Render(hint),
+	)
+}
+
+// renderPicture shows one of a track's embedded pictures with its type,
+// dimensions and position among the others.
+func renderPicture(pictures []metadata.Picture, i, artWidth, artHeight int) string {
+	p := pictures[i]
+	caption := fmt.Sprintf("%s  %d×%d", p.Type, p.Width, p.Height)
+	if len(pictures) > 1 {
+		caption += fmt.Sprintf("  %d/%d", i+1, len(pictures))
+	}
+	return lipgloss.JoinVertical(lipgloss.Left,
+		renderCoverArt(p.ID, artWidth, artHeight),
+		DimStyle.Render(truncate(caption, artWidth)),
 	)
 }
 
 // ─── Now Playing View ───────────────────────────────────────────────────────
 
-func renderNowPlaying(player *Player, width, height int) string {
+func renderNowPlaying(player *Remote, width, height int) string {
 	track := player.CurrentTrack()
 	if track == nil {
 		return lipgloss.Place(width-4, height,
@@ -401,7 +435,7 @@ func renderNowPlaying(player *Player, width, height int) string {
 		artWidth = artHeight * 2
 	}
 
-	coverArt := renderCoverArt(track.CoverArt, artWidth, artHeight)
+	coverArt := renderCoverArt(track.CoverID, artWidth, artHeight)
 
 	// Fav indicator.
 	favIcon := FavHeartEmptyStyle.Render("♡")
@@ -445,7 +479,7 @@ func renderNowPlaying(player *Player, width, height int) string {
 
 	repeatIcon := player.Repeat().Icon()
 	repeatStyle := ControlStyle
-	if player.Repeat() != RepeatOff {
+	if player.Repeat() != playback.RepeatOff {
 		repeatStyle = ActiveControlStyle
 	}
 
@@ -497,7 +531,7 @@ func renderNowPlaying(player *Player, width, height int) string {
 
 // ─── Now Playing Bar (bottom bar) ───────────────────────────────────────────
 
-func renderNowPlayingBar(player *Player, width int) string {
+func renderNowPlayingBar(player *Remote, width int) string {
 	track := player.CurrentTrack()
 	if track == nil {
 		return ""
@@ -540,7 +574,7 @@ func renderNowPlayingBar(player *Player, width int) string {
 	}
 
 	repeatIcon := ControlStyle.Render(player.Repeat().Icon())
-	if player.Repeat() != RepeatOff {
+	if player.Repeat() != playback.RepeatOff {
 		repeatIcon = ActiveControlStyle.Render(player.Repeat().Icon())
 	}
 
@@ -587,7 +621,7 @@ func renderProgressBar(progress float64, width int) string {
 
 // ─── Queue View ─────────────────────────────────────────────────────────────
 
-func renderQueue(player *Player, cursor int, width, height int) string {
+func renderQueue(player *Remote, cursor int, width, height int) string {
 	queue := player.Queue()
 	currentIdx := player.QueueIndex()
 
@@ -699,18 +733,81 @@ func renderFavorites(favTracks []metadata.AudioFile, cursor int, width, height i
 	)
 }
 
+// ─── Playlists ──────────────────────────────────────────────────────────────
+
+func renderPlaylists(lists []daemon.Playlist, cursor int, width, height int) string {
+	if len(lists) == 0 {
+		return lipgloss.Place(width-4, height,
+			lipgloss.Center, lipgloss.Center,
+			DimStyle.Render("No playlists yet. Press 'w' to save the queue as one."),
+		)
+	}
+
+	header := SubHeaderStyle.Render(fmt.Sprintf("Playlists  (%d)", len(lists)))
+	scrollInfo := DimStyle.Render(fmt.Sprintf("  %d/%d  •  ↵ open  •  'a' play  •  del delete", cursor+1, len(lists)))
+
+	// Overhead: header(1) + spacer(1) + footerSpacer(1) + footer(1) = 4 lines
+	overhead := 4
+	visibleLines := height - overhead
+	if visibleLines < 1 {
+		visibleLines = 1
+	}
+
+	start, end := scrollWindow(cursor, len(lists), visibleLines)
+
+	var lines []string
+	for i := start; i < end; i++ {
+		p := lists[i]
+		prefix := "  "
+		style := NormalItemStyle
+		if i%2 == 1 {
+			style = NormalItemAltStyle
+		}
+		if i == cursor {
+			prefix = "▸ "
+			style = SelectedItemStyle
+		}
+
+		badge := CountBadgeStyle.Render(fmt.Sprintf("%d", p.TrackCount))
+		name := style.Render(truncate(p.Name, width-26))
+		line := fmt.Sprintf("%s%s  %s", prefix, name, badge)
+		switch {
+		case p.ReadOnly:
+			line += DimStyle.Render("  " + strings.TrimPrefix(filepath.Ext(p.Path), "."))
+		case p.Smart != nil:
+			line += DimStyle.Render("  " + truncate(p.Smart.Rule, max(10, width/2)))
+		}
+		lines = append(lines, line)
+	}
+
+	for len(lines) < visibleLines {
+		lines = append(lines, "")
+	}
+
+	return lipgloss.JoinVertical(lipgloss.Left,
+		header, "", strings.Join(lines, "\n"), "", scrollInfo,
+	)
+}
+
 // ─── Search Results ─────────────────────────────────────────────────────────
 
-func renderSearchResults(results []metadata.AudioFile, cursor int, query string, width, height int, currentTrackPath string, player *Player) string {
+func renderSearchResults(results []metadata.AudioFile, cursor int, query string, queryErr error, width, height int, currentTrackPath string, player *Remote) string {
 	title := fmt.Sprintf("Search: %s  (%d found)",
 		HighlightStyle.Render("\""+query+"\""),
 		len(results),
 	)
+	if queryErr != nil {
+		title = ErrorStyle.Render("  " + queryErr.Error())
+	}
 
 	if len(results) == 0 {
+		hint := "  No results found."
+		if queryErr != nil {
+			hint = `  Search fields with artist:"miles davis", year:1955..1965, -genre:live or format:flac.`
+		}
 		return lipgloss.JoinVertical(lipgloss.Left,
 			SubHeaderStyle.Render(title), "",
-			DimStyle.Render("  No results found."),
+			DimStyle.Render(hint),
 		)
 	}
 
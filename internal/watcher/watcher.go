@@ -0,0 +1,299 @@
+//go:build linux
+
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/hoppxi/bpv/internal/logger"
+	"golang.org/x/sys/unix"
+)
+
+// Debounce is how long a library has to stay quiet before its changes are
+// reported. Copying an album produces a burst of events; this turns it into
+// one Change.
+const Debounce = time.Second
+
+const watchMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_CLOSE_WRITE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF |
+	unix.IN_ONLYDIR
+
+// Change lists the paths below Root that were created, modified or removed
+// since the last Change for that root.
+type Change struct {
+	Root  string
+	Paths []string
+}
+
+// Watcher watches directory trees with inotify. Directories created inside a
+// watched tree are watched as they appear; hidden directories are skipped,
+// like the scanner does.
+type Watcher struct {
+	file *os.File
+	fd   int
+
+	mu      sync.Mutex
+	roots   []string
+	dirs    map[int]string
+	wds     map[string]int
+	pending map[string]map[string]struct{}
+	warned  bool
+
+	touched chan struct{}
+	changes chan Change
+	done    chan struct{}
+}
+
+// New starts a watcher with nothing to watch yet.
+func New() (*Watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("inotify: %w", err)
+	}
+
+	w := &Watcher{
+		// A non-blocking descriptor wrapped in os.File goes through the
+		// runtime poller, so Close wakes up the pending Read.
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		fd:      fd,
+		dirs:    make(map[int]string),
+		wds:     make(map[string]int),
+		pending: make(map[string]map[string]struct{}),
+		touched: make(chan struct{}, 1),
+		changes: make(chan Change),
+		done:    make(chan struct{}),
+	}
+	go w.readEvents()
+	go w.debounce()
+	return w, nil
+}
+
+// Changes delivers batched changes. It is closed by Close.
+func (w *Watcher) Changes() <-chan Change {
+	return w.changes
+}
+
+// Add watches root and every directory below it. Adding a root twice is a
+// no-op. Changes for it carry root exactly as given here.
+func (w *Watcher) Add(root string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, r := range w.roots {
+		if r == root {
+			return nil
+		}
+	}
+	if err := w.addTree(filepath.Clean(root)); err != nil {
+		return err
+	}
+	w.roots = append(w.roots, root)
+	return nil
+}
+
+// Close stops watching. Pending changes are dropped.
+func (w *Watcher) Close() error {
+	select {
+	case <-w.done:
+		return nil
+	default:
+	}
+	close(w.done)
+	return w.file.Close()
+}
+
+// addTree watches dir and the directories below it. The caller holds w.mu.
+func (w *Watcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			// Something vanished or is unreadable; watch the rest.
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		return w.addWatch(path)
+	})
+}
+
+func (w *Watcher) addWatch(dir string) error {
+	wd, err := unix.InotifyAddWatch(w.fd, dir, watchMask)
+	if err != nil {
+		if errors.Is(err, unix.ENOSPC) {
+			if !w.warned {
+				w.warned = true
+				logger.Log.Warn("Out of inotify watches at %s; raise fs.inotify.max_user_watches to watch the whole library", dir)
+			}
+			return filepath.SkipAll
+		}
+		if errors.Is(err, unix.ENOENT) || errors.Is(err, unix.ENOTDIR) {
+			return nil
+		}
+		return fmt.Errorf("failed to watch %s: %w", dir, err)
+	}
+	// Renaming a directory keeps its watch descriptor, so the old path may
+	// still be mapped to it.
+	if old, ok := w.dirs[wd]; ok {
+		delete(w.wds, old)
+	}
+	w.dirs[wd] = dir
+	w.wds[dir] = wd
+	return nil
+}
+
+// removeTree forgets dir and every directory below it after it was moved
+// away. The caller holds w.mu.
+func (w *Watcher) removeTree(dir string) {
+	prefix := dir + string(filepath.Separator)
+	for path, wd := range w.wds {
+		if path == dir || strings.HasPrefix(path, prefix) {
+			unix.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.wds, path)
+			delete(w.dirs, wd)
+		}
+	}
+}
+
+func (w *Watcher) readEvents() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			select {
+			case <-w.done:
+			default:
+				logger.Log.Error("Watcher stopped: %v", err)
+			}
+			return
+		}
+
+		w.mu.Lock()
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := buf[off+unix.SizeofInotifyEvent : off+unix.SizeofInotifyEvent+int(ev.Len)]
+			off += unix.SizeofInotifyEvent + int(ev.Len)
+			w.handle(ev, strings.TrimRight(string(name), "\x00"))
+		}
+		w.mu.Unlock()
+
+		select {
+		case w.touched <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// handle records one inotify event. The caller holds w.mu.
+func (w *Watcher) handle(ev *unix.InotifyEvent, name string) {
+	if ev.Mask&unix.IN_Q_OVERFLOW != 0 {
+		// Events were lost; every library has to be looked at again.
+		logger.Log.Warn("Watcher event queue overflowed; rechecking every library")
+		for _, root := range w.roots {
+			w.mark(root)
+		}
+		return
+	}
+
+	dir, ok := w.dirs[int(ev.Wd)]
+	if !ok {
+		return
+	}
+	if ev.Mask&unix.IN_IGNORED != 0 {
+		delete(w.dirs, int(ev.Wd))
+		if w.wds[dir] == int(ev.Wd) {
+			delete(w.wds, dir)
+		}
+		return
+	}
+
+	path := dir
+	if name != "" {
+		path = filepath.Join(dir, name)
+	}
+
+	isDir := ev.Mask&unix.IN_ISDIR != 0
+	switch {
+	case ev.Mask&unix.IN_MOVE_SELF != 0:
+		// The parent reports the move; the old path is no longer valid.
+		w.removeTree(dir)
+	case isDir && ev.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+		if !strings.HasPrefix(name, ".") {
+			w.addTree(path)
+		}
+	case isDir && ev.Mask&unix.IN_MOVED_FROM != 0:
+		w.removeTree(path)
+	}
+	w.mark(path)
+}
+
+// mark queues path for the root it belongs to. The caller holds w.mu.
+func (w *Watcher) mark(path string) {
+	root, best := "", ""
+	for _, r := range w.roots {
+		dir := filepath.Clean(r)
+		if (path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))) && len(dir) > len(best) {
+			root, best = r, dir
+		}
+	}
+	if best == "" {
+		return
+	}
+	if w.pending[root] == nil {
+		w.pending[root] = make(map[string]struct{})
+	}
+	w.pending[root][path] = struct{}{}
+}
+
+// debounce sends pending changes once no events have arrived for Debounce.
+func (w *Watcher) debounce() {
+	defer close(w.changes)
+
+	timer := time.NewTimer(Debounce)
+	timer.Stop()
+	for {
+		select {
+		case <-w.touched:
+			timer.Reset(Debounce)
+		case <-timer.C:
+			for _, change := range w.flush() {
+				select {
+				case w.changes <- change:
+				case <-w.done:
+					return
+				}
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *Watcher) flush() []Change {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	changes := make([]Change, 0, len(w.pending))
+	for root, paths := range w.pending {
+		change := Change{Root: root}
+		for path := range paths {
+			change.Paths = append(change.Paths, path)
+		}
+		changes = append(changes, change)
+	}
+	clear(w.pending)
+	return changes
+}
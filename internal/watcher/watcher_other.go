@@ -0,0 +1,33 @@
+//go:build !linux
+
+package watcher
+
+import (
+	"errors"
+	"time"
+)
+
+// Debounce is how long a library has to stay quiet before its changes are
+// reported.
+const Debounce = time.Second
+
+// Change lists the paths below Root that were created, modified or removed
+// since the last Change for that root.
+type Change struct {
+	Root  string
+	Paths []string
+}
+
+// Watcher is only implemented on Linux.
+type Watcher struct{}
+
+// New always fails; library watching needs inotify.
+func New() (*Watcher, error) {
+	return nil, errors.New("filesystem watching is only supported on Linux")
+}
+
+func (w *Watcher) Changes() <-chan Change { return nil }
+
+func (w *Watcher) Add(root string) error { return nil }
+
+func (w *Watcher) Close() error { return nil }
@@ -23,6 +23,12 @@ in
         default = self.packages.${system}.default;
         description = "The BPV package to use.";
       };
+
+      socketActivation = mkOption {
+        type = types.bool;
+        default = false;
+        description = "Start the daemon on demand when a client connects to its socket, instead of at login.";
+      };
     };
 
     programs.bpv = {
@@ -45,7 +51,7 @@ in
           Description = "BPV Music Daemon";
           After = [ "network.target" ];
         };
-        Install = {
+        Install = mkIf (!cfg.socketActivation) {
           WantedBy = [ "default.target" ];
         };
         Service = {
@@ -54,6 +60,20 @@ in
           Environment = "BPV_WEB_DIR=${cfg.package}/share/bpv/dist";
         };
       };
+
+      systemd.user.sockets.bpvd = mkIf cfg.socketActivation {
+        Unit = {
+          Description = "BPV Music Daemon socket";
+        };
+        Install = {
+          WantedBy = [ "sockets.target" ];
+        };
+        Socket = {
+          ListenStream = "%t/bpv/bpv.sock";
+          SocketMode = "0700";
+          DirectoryMode = "0700";
+        };
+      };
     })
 
     (mkIf progCfg.enable {
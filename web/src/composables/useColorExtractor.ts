@@ -13,7 +13,7 @@ export function useColorExtractor() {
   ]);
 
   function extractFromTrack(track: AudioFile | null) {
-    if (!track?.cover_art) {
+    if (!track?.cover_id) {
       const hash = hashString(track?.title || "default");
       const hue = hash % 360;
       dominantColor.value = `hsla(${hue}, 70%, 50%, 0.8)`;
@@ -0,0 +1,53 @@
+import { onUnmounted, getCurrentInstance } from "vue";
+
+// Events pushed by the server on /api/events as the daemon reports changes,
+// including ones made from the TUI or another browser tab.
+export interface DaemonEvent {
+    event: string;
+    dir?: string;
+    file_path?: string;
+    favorites?: string[];
+}
+
+type Handler = (ev: DaemonEvent) => void;
+
+const handlers = new Map<string, Set<Handler>>();
+let source: EventSource | null = null;
+
+function connect() {
+    if (source) return;
+    // EventSource reconnects on its own if the server restarts.
+    source = new EventSource("/api/events");
+}
+
+function listen(type: string) {
+    connect();
+    source!.addEventListener(type, (msg) => {
+        let ev: DaemonEvent;
+        try {
+            ev = JSON.parse((msg as MessageEvent).data);
+        } catch {
+            return;
+        }
+        handlers.get(type)?.forEach((fn) => fn(ev));
+    });
+}
+
+// onDaemonEvent calls fn for every event of the given type. Inside a
+// component's setup the handler is removed when the component unmounts;
+// otherwise call the returned function to remove it.
+export function onDaemonEvent(type: string, fn: Handler): () => void {
+    let set = handlers.get(type);
+    if (!set) {
+        set = new Set();
+        handlers.set(type, set);
+        listen(type);
+    }
+    set.add(fn);
+
+    const off = () => set!.delete(fn);
+    if (getCurrentInstance()) {
+        onUnmounted(off);
+    }
+    return off;
+}
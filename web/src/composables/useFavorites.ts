@@ -4,11 +4,19 @@ import {
     addFavorite as apiAddFavorite,
     removeFavorite as apiRemoveFavorite,
 } from "@/lib/api";
+import { onDaemonEvent } from "@/composables/useDaemonEvents";
 
 const favoritesData = ref<Set<string>>(new Set());
 const initialized = ref(false);
 const isLoading = ref(false);
 
+// Favorites changed in another tab or the TUI. The event carries the whole
+// list; an empty list is omitted.
+onDaemonEvent("favorites-changed", (ev) => {
+    favoritesData.value = new Set(ev.favorites ?? []);
+    initialized.value = true;
+});
+
 export function useFavorites() {
     async function loadFavorites() {
         if (initialized.value && !isLoading.value) return;
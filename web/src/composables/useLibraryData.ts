@@ -1,5 +1,6 @@
 import { ref, onMounted } from "vue";
 import { fetchLibrary, fetchBasePath } from "@/lib/api";
+import { onDaemonEvent } from "@/composables/useDaemonEvents";
 import type { LibraryResponse } from "@/types";
 
 export function useLibraryData() {
@@ -26,6 +27,19 @@ export function useLibraryData() {
         await loadLibrary();
     }
 
+    // Reload in place when the library is rescanned elsewhere, without
+    // switching back to the loading screen.
+    async function reloadQuietly() {
+        try {
+            library.value = await fetchLibrary();
+        } catch (e) {
+            console.error("Failed to reload library:", e);
+        }
+    }
+
+    onDaemonEvent("library-updated", reloadQuietly);
+    onDaemonEvent("resubscribed", reloadQuietly);
+
     onMounted(() => {
         loadLibrary();
     });
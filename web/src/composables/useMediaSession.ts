@@ -34,13 +34,15 @@ export function useMediaSession(
       }
 
       const artwork: MediaImage[] = [];
-      const coverUrl = getCoverArtUrl(track);
-      if (coverUrl) {
-        artwork.push({
-          src: coverUrl,
-          sizes: "300x300",
-          type: track.cover_art_mime || "image/jpeg",
-        });
+      for (const size of [64, 300, 600] as const) {
+        const coverUrl = getCoverArtUrl(track, size);
+        if (coverUrl) {
+          artwork.push({
+            src: coverUrl,
+            sizes: `${size}x${size}`,
+            type: "image/jpeg",
+          });
+        }
       }
 
       navigator.mediaSession.metadata = new MediaMetadata({
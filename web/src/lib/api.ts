@@ -1,4 +1,4 @@
-import type { AudioFile, LibraryResponse, HealthResponse } from "@/types";
+import type { AudioFile, LibraryResponse, HealthResponse, Picture, Playlist, SmartRule } from "@/types";
 
 const API_BASE = "/api";
 
@@ -25,15 +25,40 @@ export async function fetchMetadata(filePath: string): Promise<AudioFile> {
   return data.file;
 }
 
+export async function fetchPictures(filePath: string): Promise<Picture[]> {
+  const data = await fetchJSON<{ pictures: Picture[] }>(
+    `${API_BASE}/pictures/${encodeURIComponent(filePath)}`,
+  );
+  return data.pictures || [];
+}
+
 export async function fetchBasePath(): Promise<string> {
   const data = await fetchJSON<{ base_path: string }>(`${API_BASE}/base-path`);
   return data.base_path;
 }
 
+// QuerySyntaxError is a search query the server could not parse. offset is
+// where in the query the problem is.
+export class QuerySyntaxError extends Error {
+  constructor(
+    message: string,
+    public offset?: number,
+  ) {
+    super(message);
+    this.name = "QuerySyntaxError";
+  }
+}
+
 export async function searchLibrary(query: string): Promise<AudioFile[]> {
-  const data = await fetchJSON<{ results: AudioFile[] }>(
-    `${API_BASE}/search?q=${encodeURIComponent(query)}`,
-  );
+  const response = await fetch(`${API_BASE}/search?q=${encodeURIComponent(query)}`);
+  if (response.status === 400) {
+    const data = await response.json().catch(() => null);
+    if (data?.error) throw new QuerySyntaxError(data.error, data.offset);
+  }
+  if (!response.ok) {
+    throw new Error(`API error: ${response.status} ${response.statusText}`);
+  }
+  const data: { results: AudioFile[] } = await response.json();
   return data.results || [];
 }
 
@@ -120,6 +145,69 @@ export async function removeFavorite(filePath: string): Promise<void> {
   if (!response.ok) throw new Error("Failed to remove favorite");
 }
 
+export async function fetchPlaylists(): Promise<Playlist[]> {
+  try {
+    const data = await fetchJSON<{ playlists: Playlist[] }>(`${API_BASE}/playlists`);
+    return data.playlists || [];
+  } catch {
+    return [];
+  }
+}
+
+export async function fetchPlaylist(id: string): Promise<Playlist> {
+  const data = await fetchJSON<{ playlist: Playlist }>(
+    `${API_BASE}/playlists/${encodeURIComponent(id)}`,
+  );
+  return data.playlist;
+}
+
+async function editPlaylist(
+  path: string,
+  method: string,
+  body?: Record<string, unknown>,
+): Promise<Playlist | undefined> {
+  const response = await fetch(`${API_BASE}/playlists${path}`, {
+    method,
+    headers: { "Content-Type": "application/json" },
+    body: body ? JSON.stringify(body) : undefined,
+  });
+  const data = await response.json();
+  if (!response.ok) throw new Error(data.error || "Failed to update playlist");
+  return data.playlist;
+}
+
+export async function createPlaylist(name: string, filePaths: string[] = []): Promise<Playlist> {
+  return (await editPlaylist("", "POST", { name, file_paths: filePaths }))!;
+}
+
+export async function createSmartPlaylist(name: string, rule: SmartRule): Promise<Playlist> {
+  return (await editPlaylist("", "POST", { name, ...rule }))!;
+}
+
+export async function setPlaylistRule(id: string, rule: SmartRule): Promise<Playlist> {
+  return (await editPlaylist(`/${encodeURIComponent(id)}/rule`, "PUT", { ...rule }))!;
+}
+
+export async function renamePlaylist(id: string, name: string): Promise<Playlist> {
+  return (await editPlaylist(`/${encodeURIComponent(id)}`, "PUT", { name }))!;
+}
+
+export async function deletePlaylist(id: string): Promise<void> {
+  await editPlaylist(`/${encodeURIComponent(id)}`, "DELETE");
+}
+
+export async function addToPlaylist(id: string, filePaths: string[]): Promise<Playlist> {
+  return (await editPlaylist(`/${encodeURIComponent(id)}/tracks`, "POST", { file_paths: filePaths }))!;
+}
+
+export async function removeFromPlaylist(id: string, positions: number[]): Promise<Playlist> {
+  return (await editPlaylist(`/${encodeURIComponent(id)}/tracks`, "DELETE", { positions }))!;
+}
+
+export async function movePlaylistTrack(id: string, from: number, to: number): Promise<Playlist> {
+  return (await editPlaylist(`/${encodeURIComponent(id)}/move`, "POST", { from, to }))!;
+}
+
 export async function recordPlay(filePath: string): Promise<void> {
   try {
     await fetch(`${API_BASE}/stats/play`, {
@@ -196,9 +284,20 @@ export function getAudioUrl(filePath: string, basePath: string): string {
   return `/files/${encodeURIComponent(relativePath)}`;
 }
 
-export function getCoverArtUrl(track: AudioFile): string | null {
-  if (track.cover_art && track.cover_art_mime) {
-    return `data:${track.cover_art_mime};base64,${track.cover_art}`;
+export type CoverSize = 64 | 300 | 600 | "original";
+
+export function getCoverArtUrl(track: AudioFile, size?: CoverSize): string | null {
+  if (!track.cover_id) {
+    return null;
+  }
+  const query = size ? `?size=${size}` : "";
+  return `${API_BASE}/cover/${track.cover_id}${query}`;
+}
+
+export function getPictureUrl(picture: Picture, size?: CoverSize): string | null {
+  if (!picture.id) {
+    return null;
   }
-  return null;
+  const query = size ? `?size=${size}` : "";
+  return `${API_BASE}/cover/${picture.id}${query}`;
 }
@@ -1,4 +1,5 @@
 export interface AudioFile {
+  id?: string;
   file_path: string;
   file_name: string;
   file_size: number;
@@ -22,12 +23,42 @@ export interface AudioFile {
   comment: string;
   lyrics: string;
   bpm: number;
-  cover_art: string;
-  cover_art_mime: string;
+  cover_id?: string;
+  cover_source?: 'embedded' | 'folder';
+  cover_file?: string;
   raw_metadata: Record<string, any>;
   error: string;
 }
 
+export interface Picture {
+  id?: string;
+  type: string;
+  description?: string;
+  mime: string;
+  width: number;
+  height: number;
+  size: number;
+}
+
+export interface Playlist {
+  id: string;
+  name: string;
+  track_count: number;
+  created: string;
+  modified: string;
+  read_only?: boolean;
+  path?: string;
+  smart?: SmartRule;
+  tracks?: AudioFile[];
+  unresolved?: string[];
+}
+
+export interface SmartRule {
+  rule: string;
+  sort?: string;
+  limit?: number;
+}
+
 export interface LibraryResponse {
   status: string;
   music_dir: string;